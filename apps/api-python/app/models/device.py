@@ -1,5 +1,5 @@
 from sqlalchemy import Column, String, DateTime, Float, Boolean, Text
-from sqlalchemy.dialects.postgresql import UUID
+from sqlalchemy.dialects.postgresql import UUID, ARRAY
 from sqlalchemy.orm import declarative_base
 from sqlalchemy.sql import func
 import uuid
@@ -39,6 +39,11 @@ class Device(Base):
     # Additional metadata
     notes = Column(Text, nullable=True)
     is_monitored = Column(Boolean, nullable=False, default=True)
+    tags = Column(ARRAY(String(50)), nullable=True)  # Used by the collector for polling overrides
+
+    # Maintenance window: "pause" stops polling, "suppress" keeps polling without alerts
+    maintenance_until = Column(DateTime(timezone=True), nullable=True)
+    maintenance_mode = Column(String(20), nullable=True)
     
     def __repr__(self):
         return f"<Device(id={self.id}, ip={self.ip_address}, hostname={self.hostname})>"
@@ -58,7 +63,10 @@ class Device(Base):
             "created_at": self.created_at.isoformat() if self.created_at else None,
             "updated_at": self.updated_at.isoformat() if self.updated_at else None,
             "notes": self.notes,
-            "is_monitored": self.is_monitored
+            "is_monitored": self.is_monitored,
+            "tags": self.tags or [],
+            "maintenance_until": self.maintenance_until.isoformat() if self.maintenance_until else None,
+            "maintenance_mode": self.maintenance_mode
         }
     
     @classmethod
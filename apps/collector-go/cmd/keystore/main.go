@@ -0,0 +1,93 @@
+// Command keystore manages the encrypted keystore read by the collector's
+// "keystore:<name>" secret references.
+//
+//	keystore -path /etc/collector/keystore.json set snmp_community < value
+//	keystore -path /etc/collector/keystore.json delete snmp_community
+//	keystore -path /etc/collector/keystore.json list
+//
+// The passphrase is read from -passphrase-file or COLLECTOR_KEYSTORE_PASSPHRASE.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"collector/internal/config"
+	"collector/internal/secrets"
+)
+
+func main() {
+	path := flag.String("path", "/etc/collector/keystore.json", "keystore file")
+	passphraseFile := flag.String("passphrase-file", "", "file holding the keystore passphrase")
+	flag.Parse()
+
+	passphrase, err := secrets.KeystorePassphrase(config.KeystoreConfig{
+		PassphraseFile: *passphraseFile,
+		PassphraseEnv:  "COLLECTOR_KEYSTORE_PASSPHRASE",
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	store, err := secrets.LoadKeystore(*path, passphrase)
+	if errors.Is(err, os.ErrNotExist) {
+		store = make(map[string]string)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+
+	args := flag.Args()
+	if len(args) == 0 {
+		log.Fatal("usage: keystore [-path file] set <name> | delete <name> | list")
+	}
+
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		names := make([]string, 0, len(store))
+		for name := range store {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Println(name)
+		}
+		return
+	case args[0] == "set" && len(args) == 2:
+		value, err := readValue(os.Stdin)
+		if err != nil {
+			log.Fatal(err)
+		}
+		store[args[1]] = value
+	case args[0] == "delete" && len(args) == 2:
+		if _, ok := store[args[1]]; !ok {
+			log.Fatalf("keystore has no secret %q", args[1])
+		}
+		delete(store, args[1])
+	default:
+		log.Fatal("usage: keystore [-path file] set <name> | delete <name> | list")
+	}
+
+	if err := secrets.SaveKeystore(*path, passphrase, store); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// readValue reads a secret value from r, dropping one trailing newline
+func readValue(r io.Reader) (string, error) {
+	data, err := io.ReadAll(bufio.NewReader(r))
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret value: %w", err)
+	}
+	value := strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r")
+	if value == "" {
+		return "", fmt.Errorf("empty secret value")
+	}
+	return value, nil
+}
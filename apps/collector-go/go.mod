@@ -5,7 +5,7 @@ go 1.18
 replace collector => ./
 
 require (
-	github.com/go-ole/go-ole v1.2.6
+	github.com/Azure/go-ntlmssp v0.0.0-20221128193559-754e69321358
 	github.com/gosnmp/gosnmp v1.35.0
 	github.com/influxdata/influxdb-client-go/v2 v2.14.0
 	github.com/lib/pq v1.10.9
@@ -13,6 +13,7 @@ require (
 	github.com/spf13/viper v1.15.0
 	golang.org/x/crypto v0.21.0
 	golang.org/x/net v0.23.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -33,5 +34,4 @@ require (
 	golang.org/x/sys v0.18.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
 	gopkg.in/ini.v1 v1.67.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -3,16 +3,21 @@ package collector
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"io"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"collector/internal/config"
 	"collector/internal/influx"
 	"collector/internal/metrics"
+	"collector/internal/secrets"
 
 	"github.com/sirupsen/logrus"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 // Device represents a network device to monitor
@@ -22,39 +27,122 @@ type Device struct {
 	Hostname  string `db:"hostname"`
 	MACAddress string `db:"mac_address"`
 	DeviceType string `db:"device_type"`
+	Tags       []string `db:"tags"`
+
+	// Lifecycle
+	IsMonitored      bool       `db:"is_monitored"`
+	UpdatedAt        time.Time  `db:"updated_at"`
+	MaintenanceUntil *time.Time `db:"maintenance_until"`
+	MaintenanceMode  string     `db:"maintenance_mode"`
 }
 
 // DeviceStatus represents the current status of a device
 type DeviceStatus struct {
 	DeviceID  string
-	Status    string // "online" or "offline"
+	Status    string // "online", "offline" or "unknown"
 	LastSeen  time.Time
 	Error     string
+
+	// Debounced health states
+	Reachability HealthState
+	Collection   HealthState
+}
+
+// deviceHealth holds the state machines of a single device
+type deviceHealth struct {
+	reachability *stateMachine
+	collection   *stateMachine
+}
+
+// metricWriter writes collected metrics to the time series store
+type metricWriter interface {
+	WriteMetric(ctx context.Context, metric metrics.Metric) error
+	Close()
 }
 
 // Collector manages the metric collection process
 type Collector struct {
 	config     *config.Config
 	db         *sql.DB
-	influxDB   *influx.Client
+	influxDB   metricWriter
 	collectors map[string]metrics.MetricCollector
+
+	// PostgreSQL URL with secret references resolved, used again by the
+	// LISTEN/NOTIFY connection
+	databaseURL string
+
+	// Port probes used as an alternative reachability check, with the
+	// targets of each reachability override (nil uses the defaults)
+	portProbe           *metrics.PortProbeCollector
+	reachabilityTargets [][]metrics.PortTarget
+
+	// HTTP and TLS endpoint checks run alongside metrics collection
+	endpointChecks map[string]deviceScopedCollector
+
+	// Credential profiles assigned to devices
+	credentials *credentialStore
 	
 	// Status tracking
 	deviceStatuses map[string]*DeviceStatus
+	deviceHealth   map[string]*deviceHealth
 	statusMutex    sync.RWMutex
+
+	// State transition subscribers
+	stateHandlers []func(StateEvent)
+
+	// Worker pools keyed by protocol
+	pools map[string]*workerPool
+
+	// Optional columns present in the devices table
+	deviceColumns map[string]bool
+
+	// Cached device inventory and refresh requests from LISTEN/NOTIFY
+	devices   *deviceCache
+	refreshCh chan struct{}
+
+	// Per-device polling schedules
+	statusSchedule  *pollSchedule
+	metricsSchedule *pollSchedule
+
+	// Batched status write-back to PostgreSQL, nil when disabled
+	statusWriter *statusWriter
 	
 	// Shutdown coordination
 	wg sync.WaitGroup
 }
 
+// CollectorStats is a snapshot of the scheduler's accounting
+type CollectorStats struct {
+	Pools []PoolStats
+
+	// Polls skipped because the previous poll of the device was still running
+	StatusPollsSkipped  int64
+	MetricsPollsSkipped int64
+}
+
 // New creates a new collector instance
 func New(cfg *config.Config) (*Collector, error) {
 	if cfg == nil {
 		return nil, fmt.Errorf("config is required")
 	}
 
+	// Resolve secret references in credentials. The database URL and
+	// InfluxDB token are only read at startup.
+	resolver, err := secrets.NewResolver(cfg.Secrets)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create secret resolver: %w", err)
+	}
+	databaseURL, err := resolver.Resolve(context.Background(), cfg.PostgreSQL.URL)
+	if err != nil {
+		return nil, err
+	}
+	influxConfig := cfg.InfluxDB
+	if influxConfig.Token, err = resolver.Resolve(context.Background(), influxConfig.Token); err != nil {
+		return nil, err
+	}
+
 	// Connect to PostgreSQL for device discovery
-	db, err := sql.Open("postgres", cfg.PostgreSQL.URL)
+	db, err := sql.Open("postgres", databaseURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to PostgreSQL: %w", err)
 	}
@@ -63,8 +151,13 @@ func New(cfg *config.Config) (*Collector, error) {
 		return nil, fmt.Errorf("failed to ping PostgreSQL: %w", err)
 	}
 
+	deviceColumns, err := loadDeviceColumns(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect devices table: %w", err)
+	}
+
 	// Connect to InfluxDB
-	influxClient, err := influx.NewClient(cfg.InfluxDB)
+	influxClient, err := influx.NewClient(influxConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create InfluxDB client: %w", err)
 	}
@@ -87,12 +180,35 @@ func New(cfg *config.Config) (*Collector, error) {
 	collectors["ssh"] = sshCollector
 
 	// Ping collector for basic status
-	pingCollector, err := metrics.NewPingCollector()
+	pingCollector, err := metrics.NewPingCollector(cfg.Ping)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create ping collector: %w", err)
 	}
 	collectors["ping"] = pingCollector
 
+	// Port probe collector for hosts that drop ICMP
+	portProbe, err := metrics.NewPortProbeCollector(cfg.PortProbe)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create port probe collector: %w", err)
+	}
+
+	// HTTP collector for web UIs and APIs on devices
+	httpChecks, err := metrics.NewHTTPCollector(cfg.HTTP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP collector: %w", err)
+	}
+
+	// TLS collector for certificate expiry and chain validation
+	tlsChecks, err := metrics.NewTLSCollector(cfg.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create TLS collector: %w", err)
+	}
+
+	reachabilityTargets, err := parseReachabilityTargets(cfg.Reachability.Overrides)
+	if err != nil {
+		return nil, err
+	}
+
 	// WMI collector for Windows devices
 	wmiCollector, err := metrics.NewWMICollector(cfg.WMI)
 	if err != nil {
@@ -100,91 +216,409 @@ func New(cfg *config.Config) (*Collector, error) {
 	}
 	collectors["wmi"] = wmiCollector
 
+	// Credentials are resolved on every use so that rotated secrets are
+	// picked up without a restart
+	snmpCollector.UseSecrets(resolver)
+	sshCollector.UseSecrets(resolver)
+	wmiCollector.UseSecrets(resolver)
+	portProbe.UseSecrets(resolver)
+	httpChecks.UseSecrets(resolver)
+
+	var writer *statusWriter
+	if cfg.StatusWriteback.Enabled {
+		writer = newStatusWriter(db, cfg.StatusWriteback.HeartbeatInterval)
+	}
+
 	return &Collector{
-		config:         cfg,
-		db:             db,
-		influxDB:       influxClient,
-		collectors:     collectors,
-		deviceStatuses: make(map[string]*DeviceStatus),
+		config:              cfg,
+		db:                  db,
+		databaseURL:         databaseURL,
+		influxDB:            influxClient,
+		collectors:          collectors,
+		portProbe:           portProbe,
+		reachabilityTargets: reachabilityTargets,
+		endpointChecks:      map[string]deviceScopedCollector{"http": httpChecks, "tls": tlsChecks},
+		credentials:         newCredentialStore(cfg.Credentials),
+		deviceStatuses:      make(map[string]*DeviceStatus),
+		deviceHealth:        make(map[string]*deviceHealth),
+		deviceColumns:       deviceColumns,
+		devices:             newDeviceCache(),
+		refreshCh:           make(chan struct{}, 1),
+		pools:               newWorkerPools(cfg.Workers),
+		statusSchedule:      newPollSchedule("status", cfg.Polling.Jitter),
+		metricsSchedule:     newPollSchedule("metrics", cfg.Polling.Jitter),
+		statusWriter:        writer,
 	}, nil
 }
 
+// newWorkerPools creates one worker pool per polling protocol
+func newWorkerPools(cfg config.WorkersConfig) map[string]*workerPool {
+	sizes := map[string]int{
+		"ping": cfg.Ping,
+		"port": cfg.Port,
+		"snmp": cfg.SNMP,
+		"ssh":  cfg.SSH,
+		"wmi":  cfg.WMI,
+	}
+
+	pools := make(map[string]*workerPool, len(sizes))
+	for name, size := range sizes {
+		pools[name] = newWorkerPool(name, size, cfg.QueueSize)
+	}
+	return pools
+}
+
 // Start begins the collection process
 func (c *Collector) Start(ctx context.Context) error {
 	logrus.Info("Starting metric collection service")
 
+	// Start worker pools
+	for _, pool := range c.pools {
+		pool.start()
+	}
+
+	// Load the initial device list before scheduling
+	c.refreshDevices(ctx)
+
+	c.wg.Add(1)
+	go c.deviceRefresher(ctx)
+
+	if c.config.PostgreSQL.NotifyChannel != "" {
+		c.wg.Add(1)
+		go c.deviceListener(ctx)
+	}
+
 	// Start status polling
 	c.wg.Add(1)
-	go c.statusPoller(ctx)
+	go c.runSchedule(ctx, c.statusSchedule, c.statusProtocol, c.pollDeviceStatus)
 
 	// Start metrics polling
 	c.wg.Add(1)
-	go c.metricsPoller(ctx)
+	go c.runSchedule(ctx, c.metricsSchedule, metricsProtocol, c.pollDeviceMetrics)
+
+	// Start status write-back
+	if c.statusWriter != nil {
+		c.wg.Add(1)
+		go c.statusFlusher(ctx)
+	}
 
 	// Wait for context cancellation
 	<-ctx.Done()
 	logrus.Info("Stopping metric collection service")
 
-	// Wait for all goroutines to finish
+	// Wait for schedulers to stop dispatching
 	c.wg.Wait()
 
+	// Stop worker pools once nothing can submit to them anymore
+	for _, pool := range c.pools {
+		pool.stop()
+	}
+
+	// Write the final statuses collected before shutdown
+	if c.statusWriter != nil {
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		c.flushStatuses(flushCtx)
+		cancel()
+	}
+
 	// Close connections
+	for name, collector := range c.collectors {
+		if closer, ok := collector.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				logrus.WithError(err).WithField("collector", name).Warn("Failed to close collector")
+			}
+		}
+	}
 	c.influxDB.Close()
 	c.db.Close()
 
 	return nil
 }
 
-// statusPoller polls device status at configured intervals
-func (c *Collector) statusPoller(ctx context.Context) {
+// deviceRefresher keeps the device cache current and reconciles both
+// schedules, either on its interval or when a change notification arrives
+func (c *Collector) deviceRefresher(ctx context.Context) {
 	defer c.wg.Done()
 
-	ticker := time.NewTicker(c.config.StatusPollInterval)
-	defer ticker.Stop()
+	interval := c.config.Polling.DeviceRefreshInterval
+	if interval <= 0 {
+		interval = c.config.StatusPollInterval
+	}
 
-	// Initial status check
-	c.checkDeviceStatuses(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			c.checkDeviceStatuses(ctx)
+			c.refreshDevices(ctx)
+		case <-c.refreshCh:
+			c.refreshDevices(ctx)
 		}
 	}
 }
 
-// metricsPoller polls device metrics at configured intervals
-func (c *Collector) metricsPoller(ctx context.Context) {
+// requestRefresh asks the refresher to reload devices without waiting for
+// its next tick
+func (c *Collector) requestRefresh() {
+	select {
+	case c.refreshCh <- struct{}{}:
+	default:
+		// A refresh is already pending
+	}
+}
+
+// deviceListener waits for NOTIFY events on the configured channel, which a
+// trigger on the devices table is expected to send on every change
+func (c *Collector) deviceListener(ctx context.Context) {
 	defer c.wg.Done()
 
-	ticker := time.NewTicker(c.config.MetricsPollInterval)
-	defer ticker.Stop()
+	channel := c.config.PostgreSQL.NotifyChannel
+	listener := pq.NewListener(c.databaseURL, 10*time.Second, time.Minute,
+		func(event pq.ListenerEventType, err error) {
+			if err != nil {
+				logrus.WithError(err).WithField("channel", channel).Warn("Device change listener error")
+			}
+		})
+	defer listener.Close()
+
+	if err := listener.Listen(channel); err != nil {
+		logrus.WithError(err).WithField("channel", channel).Error("Failed to listen for device changes")
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-listener.Notify:
+			// A nil notification follows a reconnect, after which changes may
+			// have been missed; the incremental load catches up on both
+			c.requestRefresh()
+		case <-time.After(90 * time.Second):
+			go listener.Ping()
+		}
+	}
+}
+
+// refreshDevices updates the device cache and syncs pollable devices into the
+// schedules. Rows changed since the watermark are loaded incrementally, with
+// a periodic full load to drop deleted devices.
+func (c *Collector) refreshDevices(ctx context.Context) {
+	now := time.Now()
+	full := c.devices.needsFullLoad(now, c.config.Polling.FullRefreshInterval)
+
+	var since time.Time
+	if !full {
+		since = c.devices.since()
+	}
+
+	devices, err := c.loadDevices(ctx, since)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to refresh device list")
+		return
+	}
+
+	if full {
+		c.devices.replace(devices, now)
+	} else {
+		c.devices.merge(devices)
+	}
+
+	pollable := c.devices.pollable(now)
+	c.statusSchedule.sync(pollable, c.statusSettings, now)
+	c.metricsSchedule.sync(pollable, c.metricsSettings, now)
+
+	logrus.WithFields(logrus.Fields{
+		"full_load":      full,
+		"changed_count":  len(devices),
+		"device_count":   c.devices.size(),
+		"pollable_count": len(pollable),
+	}).Debug("Refreshed device schedules")
+}
+
+// statusFlusher periodically writes queued status changes to PostgreSQL
+func (c *Collector) statusFlusher(ctx context.Context) {
+	defer c.wg.Done()
 
-	// Initial metrics collection
-	c.collectMetrics(ctx)
+	ticker := time.NewTicker(c.config.StatusWriteback.FlushInterval)
+	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			c.collectMetrics(ctx)
+			c.flushStatuses(ctx)
+		}
+	}
+}
+
+// flushStatuses writes queued status changes in a single batch
+func (c *Collector) flushStatuses(ctx context.Context) {
+	written, err := c.statusWriter.flush(ctx)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to write device statuses to PostgreSQL")
+		return
+	}
+	if written > 0 {
+		logrus.WithField("device_count", written).Debug("Wrote device statuses to PostgreSQL")
+	}
+}
+
+// statusSettings resolves the status polling interval and priority for a device
+func (c *Collector) statusSettings(device Device) pollSettings {
+	class, _ := c.pollClass(device)
+	settings := pollSettings{interval: c.config.StatusPollInterval, priority: class.Priority}
+	if class.StatusInterval > 0 {
+		settings.interval = class.StatusInterval
+	}
+	return settings
+}
+
+// metricsSettings resolves the metrics polling interval and priority for a device
+func (c *Collector) metricsSettings(device Device) pollSettings {
+	class, _ := c.pollClass(device)
+	settings := pollSettings{interval: c.config.MetricsPollInterval, priority: class.Priority}
+	if class.MetricsInterval > 0 {
+		settings.interval = class.MetricsInterval
+	}
+	return settings
+}
+
+// pollClass returns the polling class of the first override matching device
+func (c *Collector) pollClass(device Device) (config.PollClass, bool) {
+	for _, override := range c.config.Polling.Overrides {
+		if override.Matches(device.ID, device.DeviceType, device.Tags) {
+			class, ok := c.config.Polling.Classes[override.Class]
+			return class, ok
 		}
 	}
+	return config.PollClass{}, false
 }
 
-// getDevices retrieves the list of devices from PostgreSQL
-func (c *Collector) getDevices(ctx context.Context) ([]Device, error) {
+// runSchedule dispatches devices from a schedule to the worker pools as they
+// become due, until ctx is cancelled
+func (c *Collector) runSchedule(ctx context.Context, schedule *pollSchedule, protocolFor func(Device) string, poll func(context.Context, Device)) {
+	defer c.wg.Done()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		for _, entry := range schedule.due(time.Now()) {
+			e := entry
+			pool, ok := c.pools[protocolFor(e.device)]
+			if !ok {
+				logrus.WithField("device_id", e.device.ID).Error("No worker pool for device protocol")
+				schedule.finish(e)
+				continue
+			}
+
+			done := func() { schedule.finish(e) }
+			if !pool.submit(ctx, func(jobCtx context.Context) { poll(jobCtx, e.device) }, done) {
+				// Context cancelled before the job could be queued
+				schedule.finish(e)
+				return
+			}
+		}
+
+		timer.Reset(c.untilNextWake(schedule))
+	}
+}
+
+// untilNextWake returns how long the scheduler may sleep. It never sleeps
+// longer than a second so that newly added devices are picked up promptly.
+func (c *Collector) untilNextWake(schedule *pollSchedule) time.Duration {
+	const maxSleep = time.Second
+
+	next, ok := schedule.nextWake()
+	if !ok {
+		return maxSleep
+	}
+
+	wait := time.Until(next)
+	if wait < 0 {
+		return 0
+	}
+	if wait > maxSleep {
+		return maxSleep
+	}
+	return wait
+}
+
+// Stats returns the current worker pool and schedule accounting
+func (c *Collector) Stats() CollectorStats {
+	stats := CollectorStats{
+		StatusPollsSkipped:  atomic.LoadInt64(&c.statusSchedule.skipped),
+		MetricsPollsSkipped: atomic.LoadInt64(&c.metricsSchedule.skipped),
+	}
+	for _, name := range []string{"ping", "port", "snmp", "ssh", "wmi"} {
+		if pool, ok := c.pools[name]; ok {
+			stats.Pools = append(stats.Pools, pool.stats())
+		}
+	}
+	return stats
+}
+
+// loadDeviceColumns returns the set of columns in the devices table, so that
+// columns added after the initial schema are only queried when present
+func loadDeviceColumns(db *sql.DB) (map[string]bool, error) {
+	rows, err := db.Query(`
+		SELECT column_name
+		FROM information_schema.columns
+		WHERE table_name = 'devices'
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+
+	return columns, rows.Err()
+}
+
+// loadDevices retrieves devices from PostgreSQL. A zero since loads every
+// row; otherwise only rows updated at or after since are returned.
+// Unmonitored devices are included so that the cache learns about them.
+func (c *Collector) loadDevices(ctx context.Context, since time.Time) ([]Device, error) {
+	tagsColumn := "'{}'::text[]"
+	if c.deviceColumns["tags"] {
+		tagsColumn = "COALESCE(tags, '{}')"
+	}
+	maintenanceColumns := "NULL::timestamptz, ''"
+	if c.deviceColumns["maintenance_until"] && c.deviceColumns["maintenance_mode"] {
+		maintenanceColumns = "maintenance_until, COALESCE(maintenance_mode, '')"
+	}
+
 	query := `
-		SELECT id, ip_address, hostname, mac_address, 
-		       COALESCE(device_type, 'unknown') as device_type
+		SELECT id, ip_address, COALESCE(hostname, ''), COALESCE(mac_address, ''),
+		       COALESCE(device_type, 'unknown') as device_type,
+		       ` + tagsColumn + ` as tags,
+		       is_monitored, updated_at,
+		       ` + maintenanceColumns + `
 		FROM devices 
+		WHERE updated_at >= $1
 		ORDER BY ip_address
 	`
 
-	rows, err := c.db.QueryContext(ctx, query)
+	rows, err := c.db.QueryContext(ctx, query, since)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query devices: %w", err)
 	}
@@ -193,40 +627,42 @@ func (c *Collector) getDevices(ctx context.Context) ([]Device, error) {
 	var devices []Device
 	for rows.Next() {
 		var device Device
+		var maintenanceUntil sql.NullTime
 		err := rows.Scan(&device.ID, &device.IPAddress, &device.Hostname, 
-			&device.MACAddress, &device.DeviceType)
+			&device.MACAddress, &device.DeviceType, pq.Array(&device.Tags),
+			&device.IsMonitored, &device.UpdatedAt, &maintenanceUntil, &device.MaintenanceMode)
 		if err != nil {
 			logrus.WithError(err).Error("Failed to scan device row")
 			continue
 		}
+		if maintenanceUntil.Valid {
+			until := maintenanceUntil.Time
+			device.MaintenanceUntil = &until
+		}
 		devices = append(devices, device)
 	}
 
-	return devices, nil
+	return devices, rows.Err()
 }
 
-// checkDeviceStatuses checks if devices are online/offline
-func (c *Collector) checkDeviceStatuses(ctx context.Context) {
-	devices, err := c.getDevices(ctx)
-	if err != nil {
-		logrus.WithError(err).Error("Failed to get devices for status check")
-		return
-	}
-
-	logrus.WithField("device_count", len(devices)).Debug("Checking device statuses")
-
-	// Use ping collector to check basic connectivity
-	pingCollector := c.collectors["ping"]
+// pollDeviceStatus checks whether a single scheduled device is online
+func (c *Collector) pollDeviceStatus(ctx context.Context, device Device) {
+	// Check basic connectivity with ping or a port probe
+	c.checkSingleDeviceStatus(ctx, device, c.reachabilityProbe(device))
+}
 
-	for _, device := range devices {
-		go func(dev Device) {
-			c.checkSingleDeviceStatus(ctx, dev, pingCollector)
-		}(device)
+// statusProtocol returns the worker pool used for status checks of a
+// device. Devices checked by port probe only use the port pool, so that
+// slow TCP and UDP probes do not hold up pings.
+func (c *Collector) statusProtocol(device Device) string {
+	if method, _ := c.reachabilityMethod(device); method == config.ReachabilityPort {
+		return "port"
 	}
+	return "ping"
 }
 
 // checkSingleDeviceStatus checks the status of a single device
-func (c *Collector) checkSingleDeviceStatus(ctx context.Context, device Device, pingCollector metrics.MetricCollector) {
+func (c *Collector) checkSingleDeviceStatus(ctx context.Context, device Device, probe metrics.MetricCollector) {
 	logger := logrus.WithFields(logrus.Fields{
 		"device_id": device.ID,
 		"ip_address": device.IPAddress,
@@ -237,27 +673,29 @@ func (c *Collector) checkSingleDeviceStatus(ctx context.Context, device Device,
 	timeoutCtx, cancel := context.WithTimeout(ctx, c.config.DeviceTimeout)
 	defer cancel()
 
-	// Check device status using ping
-	_, err := pingCollector.Collect(timeoutCtx, device.IPAddress)
+	// Check device status
+	probeMetrics, err := probe.Collect(timeoutCtx, device.IPAddress)
 	
-	status := "online"
-	errorMsg := ""
+	reason := "status check succeeded"
 	if err != nil {
-		status = "offline"
-		errorMsg = err.Error()
-		logger.WithError(err).Debug("Device is offline")
+		reason = err.Error()
+		logger.WithError(err).Debug("Status check failed")
 	} else {
-		logger.Debug("Device is online")
+		logger.Debug("Status check succeeded")
 	}
 
 	// Update device status
-	c.updateDeviceStatus(device.ID, status, errorMsg)
+	status := c.recordReachability(timeoutCtx, device, err == nil, reason, probeRTT(probeMetrics))
 
 	// Write status to InfluxDB
 	statusMetric := metrics.Metric{
 		DeviceID:  device.ID,
 		Name:      "device_status",
-		Value:     map[string]interface{}{"status": status},
+		Value: map[string]interface{}{
+			"status":       status.Status,
+			"reachability": string(status.Reachability),
+			"probe_ok":     err == nil,
+		},
 		Timestamp: time.Now(),
 		Tags: map[string]string{
 			"device_id": device.ID,
@@ -265,55 +703,250 @@ func (c *Collector) checkSingleDeviceStatus(ctx context.Context, device Device,
 		},
 	}
 
+	// Results collected during a maintenance window are flagged so that
+	// alerting can ignore them
+	if mode := device.maintenanceMode(time.Now()); mode != "" {
+		statusMetric.Tags["maintenance"] = mode
+	}
+
 	if err := c.influxDB.WriteMetric(timeoutCtx, statusMetric); err != nil {
 		logger.WithError(err).Error("Failed to write device status to InfluxDB")
 	}
+
+	// Write the probe results, such as packet loss and RTT statistics
+	c.writeDeviceMetrics(timeoutCtx, device, probeMetrics)
+}
+
+// healthFor returns the state machines of a device, creating them on first
+// use. Callers must hold statusMutex.
+func (c *Collector) healthFor(deviceID string, now time.Time) *deviceHealth {
+	health, exists := c.deviceHealth[deviceID]
+	if !exists {
+		failures, successes := c.config.State.FailuresToDown, c.config.State.SuccessesToUp
+		health = &deviceHealth{
+			reachability: newStateMachine(failures, successes, now),
+			collection:   newStateMachine(failures, successes, now),
+		}
+		c.deviceHealth[deviceID] = health
+	}
+	return health
+}
+
+// recordReachability feeds a status check result into the device's
+// reachability state machine, emits a transition event if the state changed
+// and queues the status for write-back. responseTime is the ping RTT in
+// milliseconds.
+func (c *Collector) recordReachability(ctx context.Context, device Device, success bool, reason string, responseTime *float64) DeviceStatus {
+	now := time.Now()
+
+	c.statusMutex.Lock()
+	health := c.healthFor(device.ID, now)
+	from := health.reachability.state
+	state, duration, changed := health.reachability.observe(success, now)
+
+	status := c.statusLocked(device.ID)
+	status.Reachability = state
+	status.Collection = health.collection.state
+	status.Status = legacyStatus(state)
+	if success {
+		status.LastSeen = now
+		status.Error = ""
+	} else {
+		status.Error = reason
+	}
+	snapshot := *status
+	c.statusMutex.Unlock()
+
+	if changed {
+		c.emitStateEvent(ctx, device, HealthReachability, from, state, duration, reason, now)
+	}
+
+	if c.statusWriter != nil {
+		update := statusUpdate{DeviceID: device.ID, Status: snapshot.Status}
+		if snapshot.Status == "online" {
+			update.LastSeen = snapshot.LastSeen
+			update.ResponseTime = responseTime
+		}
+		c.statusWriter.record(update, now)
+	}
+
+	return snapshot
 }
 
-// updateDeviceStatus updates the internal device status tracking
-func (c *Collector) updateDeviceStatus(deviceID, status, errorMsg string) {
+// recordCollection feeds a metric collection result into the device's
+// collection-health state machine. Collection failures never change
+// reachability.
+func (c *Collector) recordCollection(ctx context.Context, device Device, success bool, reason string) {
+	now := time.Now()
+
 	c.statusMutex.Lock()
-	defer c.statusMutex.Unlock()
+	health := c.healthFor(device.ID, now)
+	from := health.collection.state
+	state, duration, changed := health.collection.observe(success, now)
+	c.statusLocked(device.ID).Collection = state
+	c.statusMutex.Unlock()
+
+	if changed {
+		c.emitStateEvent(ctx, device, HealthCollection, from, state, duration, reason, now)
+	}
+}
 
-	c.deviceStatuses[deviceID] = &DeviceStatus{
-		DeviceID: deviceID,
-		Status:   status,
-		LastSeen: time.Now(),
-		Error:    errorMsg,
+// statusLocked returns the mutable status entry of a device, creating it on
+// first use. Callers must hold statusMutex.
+func (c *Collector) statusLocked(deviceID string) *DeviceStatus {
+	status, exists := c.deviceStatuses[deviceID]
+	if !exists {
+		status = &DeviceStatus{
+			DeviceID:     deviceID,
+			Status:       legacyStatus(StateUnknown),
+			Reachability: StateUnknown,
+			Collection:   StateUnknown,
+		}
+		c.deviceStatuses[deviceID] = status
 	}
+	return status
 }
 
-// GetDeviceStatus returns the current status of a device
+// OnStateChange registers a handler that is called for every device state
+// transition. Handlers must be registered before Start.
+func (c *Collector) OnStateChange(handler func(StateEvent)) {
+	c.stateHandlers = append(c.stateHandlers, handler)
+}
+
+// emitStateEvent logs a state transition, writes it to InfluxDB and passes it
+// to the registered handlers
+func (c *Collector) emitStateEvent(ctx context.Context, device Device, kind string, from, to HealthState, duration time.Duration, reason string, now time.Time) {
+	event := StateEvent{
+		DeviceID:   device.ID,
+		Hostname:   device.Hostname,
+		Kind:       kind,
+		From:       from,
+		To:         to,
+		Duration:   duration,
+		Reason:     reason,
+		At:         now,
+		Suppressed: device.maintenanceMode(now) == MaintenanceSuppress,
+	}
+
+	logger := logrus.WithFields(logrus.Fields{
+		"device_id":  event.DeviceID,
+		"hostname":   event.Hostname,
+		"kind":       event.Kind,
+		"from":       event.From,
+		"to":         event.To,
+		"duration":   event.Duration.String(),
+		"reason":     event.Reason,
+		"suppressed": event.Suppressed,
+	})
+	if to == StateDown && !event.Suppressed {
+		logger.Warn("Device state changed")
+	} else {
+		logger.Info("Device state changed")
+	}
+
+	if c.influxDB != nil {
+		eventMetric := metrics.Metric{
+			DeviceID: device.ID,
+			Name:     "device_state_change",
+			Value: map[string]interface{}{
+				"from":             string(from),
+				"to":               string(to),
+				"duration_seconds": duration.Seconds(),
+				"reason":           reason,
+			},
+			Timestamp: now,
+			Tags: map[string]string{
+				"device_id":  device.ID,
+				"hostname":   device.Hostname,
+				"kind":       kind,
+				"suppressed": fmt.Sprintf("%t", event.Suppressed),
+			},
+		}
+		if err := c.influxDB.WriteMetric(ctx, eventMetric); err != nil {
+			logger.WithError(err).Error("Failed to write state change to InfluxDB")
+		}
+	}
+
+	for _, handler := range c.stateHandlers {
+		handler(event)
+	}
+}
+
+// emitHostKeyChanged logs a changed SSH host key as a security event and
+// writes it to InfluxDB
+func (c *Collector) emitHostKeyChanged(ctx context.Context, device Device, keyErr *metrics.HostKeyChangedError) {
+	logrus.WithFields(logrus.Fields{
+		"device_id":   device.ID,
+		"hostname":    device.Hostname,
+		"host":        keyErr.Host,
+		"key_type":    keyErr.KeyType,
+		"fingerprint": keyErr.Fingerprint,
+		"known_keys":  strings.Join(keyErr.Known, ", "),
+	}).Error("SSH host key changed, possible man-in-the-middle attack")
+
+	if c.influxDB == nil {
+		return
+	}
+
+	eventMetric := metrics.Metric{
+		DeviceID: device.ID,
+		Name:     "security_event",
+		Value: map[string]interface{}{
+			"key_type":    keyErr.KeyType,
+			"fingerprint": keyErr.Fingerprint,
+			"known_keys":  strings.Join(keyErr.Known, ", "),
+			"message":     keyErr.Error(),
+		},
+		Timestamp: time.Now(),
+		Tags: map[string]string{
+			"device_id": device.ID,
+			"hostname":  device.Hostname,
+			"kind":      "ssh_host_key_changed",
+		},
+	}
+	if err := c.influxDB.WriteMetric(ctx, eventMetric); err != nil {
+		logrus.WithError(err).WithField("device_id", device.ID).Error("Failed to write security event to InfluxDB")
+	}
+}
+
+// GetDeviceStatus returns a copy of the current status of a device
 func (c *Collector) GetDeviceStatus(deviceID string) (*DeviceStatus, bool) {
 	c.statusMutex.RLock()
 	defer c.statusMutex.RUnlock()
 
 	status, exists := c.deviceStatuses[deviceID]
-	return status, exists
+	if !exists {
+		return nil, false
+	}
+	snapshot := *status
+	return &snapshot, true
 }
 
-// collectMetrics collects detailed metrics from all devices
-func (c *Collector) collectMetrics(ctx context.Context) {
-	devices, err := c.getDevices(ctx)
-	if err != nil {
-		logrus.WithError(err).Error("Failed to get devices for metrics collection")
+// pollDeviceMetrics collects detailed metrics from a single scheduled device
+func (c *Collector) pollDeviceMetrics(ctx context.Context, device Device) {
+	// Collect metrics from online devices only
+	status, exists := c.GetDeviceStatus(device.ID)
+	if !exists || status.Status != "online" {
+		logrus.WithField("device_id", device.ID).Debug("Skipping offline device")
 		return
 	}
 
-	logrus.WithField("device_count", len(devices)).Debug("Collecting device metrics")
-
-	// Collect metrics from online devices only
-	for _, device := range devices {
-		// Check if device is online
-		status, exists := c.GetDeviceStatus(device.ID)
-		if !exists || status.Status != "online" {
-			logrus.WithField("device_id", device.ID).Debug("Skipping offline device")
-			continue
-		}
+	c.collectSingleDeviceMetrics(ctx, device)
+	c.collectEndpointChecks(ctx, device)
+}
 
-		go func(dev Device) {
-			c.collectSingleDeviceMetrics(ctx, dev)
-		}(device)
+// metricsProtocol returns the collector used for detailed metrics of a device
+func metricsProtocol(device Device) string {
+	switch device.DeviceType {
+	case "router", "switch", "network":
+		return "snmp"
+	case "linux", "unix":
+		return "ssh"
+	case "windows":
+		return "wmi"
+	default:
+		// Try SNMP first, then SSH as fallback
+		return "snmp"
 	}
 }
 
@@ -329,31 +962,76 @@ func (c *Collector) collectSingleDeviceMetrics(ctx context.Context, device Devic
 	timeoutCtx, cancel := context.WithTimeout(ctx, c.config.CollectionTimeout)
 	defer cancel()
 
-	// Determine which collector to use based on device type
-	var collector metrics.MetricCollector
-	switch device.DeviceType {
-	case "router", "switch", "network":
-		collector = c.collectors["snmp"]
-	case "linux", "unix":
-		collector = c.collectors["ssh"]
-	case "windows":
-		collector = c.collectors["wmi"]
-	default:
-		// Try SNMP first, then SSH as fallback
-		collector = c.collectors["snmp"]
+	// Determine which collector to use based on device type. Assigned
+	// credential profiles are applied on top of the per-device scope when
+	// the scoped collector accepts credentials, such as SSH checks, and
+	// otherwise take precedence over per-device overrides.
+	protocol := metricsProtocol(device)
+	base := c.collectors[protocol]
+	collector := base
+	if scoped, ok := base.(deviceScopedCollector); ok {
+		if bound := scoped.ForDevice(device.ID, device.DeviceType, device.Hostname, device.Tags); bound != nil {
+			collector = bound
+		}
+	}
+	profileBase := collector
+	if _, ok := collector.(credentialScopedCollector); !ok {
+		profileBase = base
+	}
+	if assigned := c.credentials.collectorFor(device, protocol, profileBase); assigned != nil {
+		collector = assigned
 	}
 
 	// Collect metrics
 	deviceMetrics, err := collector.Collect(timeoutCtx, device.IPAddress)
 	if err != nil {
 		logger.WithError(err).Error("Failed to collect metrics from device")
+
+		var hostKeyErr *metrics.HostKeyChangedError
+		if errors.As(err, &hostKeyErr) {
+			c.emitHostKeyChanged(ctx, device, hostKeyErr)
+		}
 		
-		// Mark device as offline if collection fails
-		c.updateDeviceStatus(device.ID, "offline", err.Error())
+		// Collection failures only affect collection health, not reachability
+		c.recordCollection(ctx, device, false, err.Error())
 		return
 	}
+	c.recordCollection(ctx, device, true, "collection succeeded")
+
+	c.writeDeviceMetrics(timeoutCtx, device, deviceMetrics)
+}
+
+// deviceScopedCollector is a collector whose targets or settings are
+// selected per device, such as HTTP checks or SNMP credentials
+type deviceScopedCollector interface {
+	ForDevice(deviceID, deviceType, hostname string, tags []string) metrics.MetricCollector
+}
+
+// collectEndpointChecks runs the HTTP and TLS checks configured for a device
+func (c *Collector) collectEndpointChecks(ctx context.Context, device Device) {
+	for name, endpoints := range c.endpointChecks {
+		check := endpoints.ForDevice(device.ID, device.DeviceType, device.Hostname, device.Tags)
+		if check == nil {
+			continue
+		}
+
+		timeoutCtx, cancel := context.WithTimeout(ctx, c.config.CollectionTimeout)
+		checkMetrics, err := check.Collect(timeoutCtx, device.IPAddress)
+		if err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{
+				"device_id": device.ID,
+				"check":     name,
+			}).Error("Failed to run endpoint checks")
+		} else {
+			c.writeDeviceMetrics(timeoutCtx, device, checkMetrics)
+		}
+		cancel()
+	}
+}
 
-	// Write metrics to InfluxDB
+// writeDeviceMetrics tags metrics with the device they came from and
+// writes them to InfluxDB
+func (c *Collector) writeDeviceMetrics(ctx context.Context, device Device, deviceMetrics []metrics.Metric) {
 	for _, metric := range deviceMetrics {
 		// Add device information to metric
 		metric.DeviceID = device.ID
@@ -363,8 +1041,8 @@ func (c *Collector) collectSingleDeviceMetrics(ctx context.Context, device Devic
 		metric.Tags["device_id"] = device.ID
 		metric.Tags["hostname"] = device.Hostname
 
-		if err := c.influxDB.WriteMetric(timeoutCtx, metric); err != nil {
-			logger.WithError(err).Error("Failed to write metric to InfluxDB")
+		if err := c.influxDB.WriteMetric(ctx, metric); err != nil {
+			logrus.WithError(err).WithField("device_id", device.ID).Error("Failed to write metric to InfluxDB")
 		}
 	}
 }
\ No newline at end of file
@@ -2,10 +2,13 @@ package collector
 
 import (
 	"context"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"collector/internal/config"
+	"collector/internal/metrics"
 )
 
 func TestCollector_New(t *testing.T) {
@@ -201,4 +204,285 @@ func TestCollector_Start_Stop(t *testing.T) {
 	case <-time.After(5 * time.Second):
 		t.Error("Collector did not stop within timeout")
 	}
-}
\ No newline at end of file
+}
+func TestCollector_RunScheduleDispatchesDueDevices(t *testing.T) {
+	c := &Collector{
+		config: &config.Config{
+			StatusPollInterval:  time.Hour,
+			MetricsPollInterval: time.Hour,
+		},
+		pools:          newWorkerPools(config.WorkersConfig{Ping: 2}),
+		statusSchedule: newPollSchedule("status", 0),
+	}
+	for _, pool := range c.pools {
+		pool.start()
+	}
+
+	devices := []Device{{ID: "1"}, {ID: "2"}, {ID: "3"}}
+	now := time.Now()
+	c.statusSchedule.sync(devices, func(Device) pollSettings {
+		return pollSettings{interval: time.Hour}
+	}, now.Add(-time.Hour))
+
+	var polled int64
+	ctx, cancel := context.WithCancel(context.Background())
+	c.wg.Add(1)
+	go c.runSchedule(ctx, c.statusSchedule, c.statusProtocol, func(ctx context.Context, dev Device) {
+		atomic.AddInt64(&polled, 1)
+	})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt64(&polled) < int64(len(devices)) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	c.wg.Wait()
+	for _, pool := range c.pools {
+		pool.stop()
+	}
+
+	if got := atomic.LoadInt64(&polled); got != int64(len(devices)) {
+		t.Errorf("Expected %d devices polled once, got %d", len(devices), got)
+	}
+}
+
+func TestCollector_PollSettings(t *testing.T) {
+	c := &Collector{
+		config: &config.Config{
+			StatusPollInterval:  30 * time.Second,
+			MetricsPollInterval: 5 * time.Minute,
+			Polling: config.PollingConfig{
+				Classes: map[string]config.PollClass{
+					"critical": {StatusInterval: 10 * time.Second, Priority: 10},
+					"lab":      {StatusInterval: 5 * time.Minute, MetricsInterval: 30 * time.Minute},
+				},
+				Overrides: []config.PollOverride{
+					{DeviceSelector: config.DeviceSelector{DeviceIDs: []string{"core-1"}}, Class: "critical"},
+					{DeviceSelector: config.DeviceSelector{Tags: []string{"core"}}, Class: "critical"},
+					{DeviceSelector: config.DeviceSelector{DeviceTypes: []string{"printer"}}, Class: "lab"},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name            string
+		device          Device
+		statusInterval  time.Duration
+		metricsInterval time.Duration
+		priority        int
+	}{
+		{
+			name:            "override by device ID",
+			device:          Device{ID: "core-1", DeviceType: "router"},
+			statusInterval:  10 * time.Second,
+			metricsInterval: 5 * time.Minute,
+			priority:        10,
+		},
+		{
+			name:            "override by tag",
+			device:          Device{ID: "dist-1", DeviceType: "switch", Tags: []string{"core", "dc1"}},
+			statusInterval:  10 * time.Second,
+			metricsInterval: 5 * time.Minute,
+			priority:        10,
+		},
+		{
+			name:            "override by device type",
+			device:          Device{ID: "prn-1", DeviceType: "printer"},
+			statusInterval:  5 * time.Minute,
+			metricsInterval: 30 * time.Minute,
+		},
+		{
+			name:            "global defaults",
+			device:          Device{ID: "srv-1", DeviceType: "linux"},
+			statusInterval:  30 * time.Second,
+			metricsInterval: 5 * time.Minute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := c.statusSettings(tt.device)
+			metrics := c.metricsSettings(tt.device)
+			if status.interval != tt.statusInterval {
+				t.Errorf("status interval = %v, want %v", status.interval, tt.statusInterval)
+			}
+			if metrics.interval != tt.metricsInterval {
+				t.Errorf("metrics interval = %v, want %v", metrics.interval, tt.metricsInterval)
+			}
+			if status.priority != tt.priority {
+				t.Errorf("priority = %d, want %d", status.priority, tt.priority)
+			}
+		})
+	}
+}
+
+func TestMetricsProtocol(t *testing.T) {
+	tests := []struct {
+		deviceType string
+		want       string
+	}{
+		{"router", "snmp"},
+		{"switch", "snmp"},
+		{"linux", "ssh"},
+		{"windows", "wmi"},
+		{"unknown", "snmp"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.deviceType, func(t *testing.T) {
+			if got := metricsProtocol(Device{DeviceType: tt.deviceType}); got != tt.want {
+				t.Errorf("metricsProtocol() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbeRTT(t *testing.T) {
+	tests := []struct {
+		name    string
+		metrics []metrics.Metric
+		want    *float64
+	}{
+		{
+			name:    "no metrics",
+			metrics: nil,
+		},
+		{
+			name: "rtt metric present",
+			metrics: []metrics.Metric{
+				{Name: "ping_status", Value: map[string]interface{}{"status": "online"}},
+				{Name: "ping_rtt_ms", Value: map[string]interface{}{"rtt_ms": 2.5}},
+			},
+			want: func() *float64 { v := 2.5; return &v }(),
+		},
+		{
+			name: "port probe summary",
+			metrics: []metrics.Metric{
+				{Name: "port_probe", Value: map[string]interface{}{"state": "open", "response_time_ms": 1.5}},
+				{Name: "port_status", Value: map[string]interface{}{"status": "online", "rtt_ms": 1.5}},
+			},
+			want: func() *float64 { v := 1.5; return &v }(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := probeRTT(tt.metrics)
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("probeRTT() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollector_RecordReachabilityEmitsEvents(t *testing.T) {
+	c := &Collector{
+		config: &config.Config{
+			State: config.StateConfig{FailuresToDown: 2, SuccessesToUp: 1},
+		},
+		deviceStatuses: make(map[string]*DeviceStatus),
+		deviceHealth:   make(map[string]*deviceHealth),
+	}
+
+	var events []StateEvent
+	c.OnStateChange(func(event StateEvent) {
+		events = append(events, event)
+	})
+
+	device := Device{ID: "a", Hostname: "router-a"}
+	ctx := context.Background()
+	c.recordReachability(ctx, device, true, "ok", nil)
+	c.recordReachability(ctx, device, false, "timeout", nil)
+	status := c.recordReachability(ctx, device, false, "timeout", nil)
+
+	if status.Status != "offline" || status.Reachability != StateDown {
+		t.Errorf("Expected offline/down after two failures, got %s/%s", status.Status, status.Reachability)
+	}
+
+	want := []struct{ from, to HealthState }{
+		{StateUnknown, StateUp},
+		{StateUp, StateDegraded},
+		{StateDegraded, StateDown},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("Expected %d events, got %d", len(want), len(events))
+	}
+	for i, w := range want {
+		if events[i].From != w.from || events[i].To != w.to || events[i].Kind != HealthReachability {
+			t.Errorf("event %d = %s %s->%s, want reachability %s->%s", i, events[i].Kind, events[i].From, events[i].To, w.from, w.to)
+		}
+	}
+	if events[2].Reason != "timeout" {
+		t.Errorf("Expected reason 'timeout', got %q", events[2].Reason)
+	}
+
+	// Collection failures are tracked separately and do not change reachability
+	c.recordReachability(ctx, device, true, "ok", nil)
+	c.recordCollection(ctx, device, false, "snmp timeout")
+	c.recordCollection(ctx, device, false, "snmp timeout")
+
+	got, _ := c.GetDeviceStatus("a")
+	if got.Status != "online" || got.Collection != StateDown {
+		t.Errorf("Expected online with collection down, got %s with collection %s", got.Status, got.Collection)
+	}
+}
+
+// recordingWriter keeps every metric written to it
+type recordingWriter struct {
+	mu      sync.Mutex
+	written []metrics.Metric
+}
+
+func (w *recordingWriter) WriteMetric(ctx context.Context, metric metrics.Metric) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.written = append(w.written, metric)
+	return nil
+}
+
+func (w *recordingWriter) Close() {}
+
+// burstProbe returns the metrics of an answered ping burst
+type burstProbe struct{}
+
+func (burstProbe) Collect(ctx context.Context, ipAddress string) ([]metrics.Metric, error) {
+	return []metrics.Metric{
+		{Name: "ping_status", Value: map[string]interface{}{"status": "online", "packet_loss_pct": 25.0}},
+		{Name: "ping_rtt_ms", Value: map[string]interface{}{"rtt_ms": 2.0, "rtt_max_ms": 3.0, "jitter_ms": 0.5}},
+	}, nil
+}
+
+func TestCollector_CheckStatusWritesProbeMetrics(t *testing.T) {
+	writer := &recordingWriter{}
+	c := &Collector{
+		config: &config.Config{
+			DeviceTimeout: time.Second,
+			State:         config.StateConfig{FailuresToDown: 1, SuccessesToUp: 1},
+		},
+		influxDB:       writer,
+		deviceStatuses: make(map[string]*DeviceStatus),
+		deviceHealth:   make(map[string]*deviceHealth),
+	}
+
+	device := Device{ID: "a", IPAddress: "192.0.2.1", Hostname: "router-a"}
+	c.checkSingleDeviceStatus(context.Background(), device, burstProbe{})
+
+	written := make(map[string]metrics.Metric)
+	for _, metric := range writer.written {
+		written[metric.Name] = metric
+	}
+	for _, name := range []string{"device_status", "ping_status", "ping_rtt_ms"} {
+		if _, ok := written[name]; !ok {
+			t.Errorf("Expected %s to be written, got %d metrics", name, len(writer.written))
+		}
+	}
+
+	rtt := written["ping_rtt_ms"]
+	if rtt.Value["jitter_ms"] != 0.5 || rtt.Tags["device_id"] != "a" || rtt.Tags["hostname"] != "router-a" {
+		t.Errorf("Expected jitter tagged with the device, got %+v", rtt)
+	}
+	if loss := written["ping_status"].Value["packet_loss_pct"]; loss != 25.0 {
+		t.Errorf("Expected packet loss 25, got %v", loss)
+	}
+}
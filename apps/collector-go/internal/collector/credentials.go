@@ -0,0 +1,153 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"collector/internal/config"
+	"collector/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+)
+
+// credentialScopedCollector is a collector that can be bound to the
+// credentials of a profile. WithCredentials returns nil when the profile has
+// no credentials for the collector's protocol.
+type credentialScopedCollector interface {
+	WithCredentials(profile config.CredentialProfile) metrics.MetricCollector
+}
+
+// credentialStore resolves the credential profiles assigned to a device and
+// remembers which profile last worked for each device and protocol
+type credentialStore struct {
+	config config.CredentialsConfig
+
+	mu      sync.Mutex
+	working map[string]string // "<device ID>/<protocol>" -> profile name
+}
+
+// newCredentialStore creates a credential store for the configured profiles
+func newCredentialStore(cfg config.CredentialsConfig) *credentialStore {
+	return &credentialStore{config: cfg, working: make(map[string]string)}
+}
+
+// collectorFor returns a collector that tries the profiles assigned to a
+// device, or nil if no assignment matches the device or none of its profiles
+// holds credentials for base
+func (s *credentialStore) collectorFor(device Device, protocol string, base metrics.MetricCollector) metrics.MetricCollector {
+	scoped, ok := base.(credentialScopedCollector)
+	if !ok {
+		return nil
+	}
+
+	var names []string
+	for _, assignment := range s.config.Assignments {
+		if assignment.Matches(device.ID, device.DeviceType, device.IPAddress, device.Tags) {
+			names = assignment.Profiles
+			break
+		}
+	}
+
+	var candidates []profileCollector
+	for _, name := range names {
+		if bound := scoped.WithCredentials(s.config.Profiles[name]); bound != nil {
+			candidates = append(candidates, profileCollector{name: name, collector: bound})
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	return &credentialCollector{store: s, deviceID: device.ID, protocol: protocol, candidates: candidates}
+}
+
+// lastWorking returns the profile that last worked for key
+func (s *credentialStore) lastWorking(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.working[key]
+}
+
+// remember records the profile that worked for key
+func (s *credentialStore) remember(key, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.working[key] = name
+}
+
+// profileCollector is a collector bound to the credentials of a named profile
+type profileCollector struct {
+	name      string
+	collector metrics.MetricCollector
+}
+
+// credentialCollector tries credential profiles in order until one
+// succeeds, starting with the profile that worked last time. The remembered
+// profile is kept when every profile fails, as the device is more likely
+// down than its credentials changed.
+type credentialCollector struct {
+	store      *credentialStore
+	deviceID   string
+	protocol   string
+	candidates []profileCollector
+}
+
+// Collect implements metrics.MetricCollector
+func (cc *credentialCollector) Collect(ctx context.Context, ipAddress string) ([]metrics.Metric, error) {
+	key := cc.deviceID + "/" + cc.protocol
+	last := cc.store.lastWorking(key)
+
+	var errs []string
+	for _, candidate := range cc.ordered(last) {
+		if ctx.Err() != nil {
+			break
+		}
+
+		result, err := candidate.collector.Collect(ctx, ipAddress)
+		if err == nil {
+			if candidate.name != last {
+				logrus.WithFields(logrus.Fields{
+					"device_id": cc.deviceID,
+					"protocol":  cc.protocol,
+					"profile":   candidate.name,
+				}).Info("Credential profile succeeded")
+				cc.store.remember(key, candidate.name)
+			}
+			return result, nil
+		}
+		// A changed host key fails every profile alike and must surface
+		// as is
+		var hostKeyErr *metrics.HostKeyChangedError
+		if errors.As(err, &hostKeyErr) {
+			return nil, err
+		}
+		errs = append(errs, fmt.Sprintf("profile %q: %v", candidate.name, err))
+	}
+
+	if len(errs) == 0 {
+		return nil, ctx.Err()
+	}
+	return nil, fmt.Errorf("all credential profiles failed: %s", strings.Join(errs, "; "))
+}
+
+// ordered returns the candidates with the profile named first moved to the
+// front
+func (cc *credentialCollector) ordered(first string) []profileCollector {
+	ordered := make([]profileCollector, 0, len(cc.candidates))
+	for _, candidate := range cc.candidates {
+		if candidate.name == first {
+			ordered = append(ordered, candidate)
+		}
+	}
+	for _, candidate := range cc.candidates {
+		if candidate.name != first {
+			ordered = append(ordered, candidate)
+		}
+	}
+	return ordered
+}
@@ -0,0 +1,167 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"collector/internal/config"
+	"collector/internal/metrics"
+)
+
+// stubCredentialCollector binds one stub per SNMP community and records the
+// order in which they are tried
+type stubCredentialCollector struct {
+	stubCollector
+	failing map[string]bool
+	errs    map[string]error
+	tried   []string
+}
+
+func (s *stubCredentialCollector) WithCredentials(profile config.CredentialProfile) metrics.MetricCollector {
+	if !profile.SNMP.Configured() {
+		return nil
+	}
+	return &stubProfile{parent: s, community: profile.SNMP.Community}
+}
+
+type stubProfile struct {
+	parent    *stubCredentialCollector
+	community string
+}
+
+func (p *stubProfile) Collect(ctx context.Context, ipAddress string) ([]metrics.Metric, error) {
+	p.parent.tried = append(p.parent.tried, p.community)
+	if err := p.parent.errs[p.community]; err != nil {
+		return nil, err
+	}
+	if p.parent.failing[p.community] {
+		return nil, errors.New("timeout")
+	}
+	return []metrics.Metric{{Name: p.community}}, nil
+}
+
+func TestCredentialStore_CollectorFor(t *testing.T) {
+	store := newCredentialStore(config.CredentialsConfig{
+		Profiles: map[string]config.CredentialProfile{
+			"site-a": {SNMP: config.SNMPCredentials{Community: "a"}},
+			"site-b": {SNMP: config.SNMPCredentials{Community: "b"}},
+			"linux":  {SSH: config.SSHCredentials{Username: "monitor"}},
+		},
+		Assignments: []config.CredentialAssignment{
+			{Subnets: []string{"10.1.0.0/16"}, Profiles: []string{"site-a", "site-b"}},
+			{DeviceSelector: config.DeviceSelector{DeviceTypes: []string{"linux"}}, Profiles: []string{"linux"}},
+		},
+	})
+	base := &stubCredentialCollector{}
+
+	tests := []struct {
+		name   string
+		device Device
+		base   metrics.MetricCollector
+		want   []string
+	}{
+		{name: "subnet assignment", device: Device{ID: "r1", IPAddress: "10.1.0.1"}, base: base, want: []string{"site-a", "site-b"}},
+		{name: "no assignment", device: Device{ID: "r2", IPAddress: "10.2.0.1"}, base: base},
+		{name: "no profile for protocol", device: Device{ID: "l1", IPAddress: "10.2.0.2", DeviceType: "linux"}, base: base},
+		{name: "collector without credential support", device: Device{ID: "r3", IPAddress: "10.1.0.3"}, base: &stubCollector{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := store.collectorFor(tt.device, "snmp", tt.base)
+			if tt.want == nil {
+				if got != nil {
+					t.Errorf("Expected no credential collector, got %T", got)
+				}
+				return
+			}
+
+			cc, ok := got.(*credentialCollector)
+			if !ok {
+				t.Fatalf("Expected a credential collector, got %T", got)
+			}
+			var names []string
+			for _, candidate := range cc.candidates {
+				names = append(names, candidate.name)
+			}
+			if len(names) != len(tt.want) || names[0] != tt.want[0] || names[1] != tt.want[1] {
+				t.Errorf("Expected profiles %v, got %v", tt.want, names)
+			}
+		})
+	}
+}
+
+func TestCredentialCollector_RemembersWorkingProfile(t *testing.T) {
+	store := newCredentialStore(config.CredentialsConfig{
+		Profiles: map[string]config.CredentialProfile{
+			"site-a": {SNMP: config.SNMPCredentials{Community: "a"}},
+			"site-b": {SNMP: config.SNMPCredentials{Community: "b"}},
+		},
+		Assignments: []config.CredentialAssignment{
+			{Profiles: []string{"site-a", "site-b"}},
+		},
+	})
+	base := &stubCredentialCollector{failing: map[string]bool{"a": true}}
+	device := Device{ID: "r1", IPAddress: "192.0.2.1"}
+
+	result, err := store.collectorFor(device, "snmp", base).Collect(context.Background(), device.IPAddress)
+	if err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	if len(result) != 1 || result[0].Name != "b" {
+		t.Errorf("Expected the site-b result, got %+v", result)
+	}
+	if got := store.lastWorking("r1/snmp"); got != "site-b" {
+		t.Errorf("Expected site-b to be remembered, got %q", got)
+	}
+
+	// The next poll starts with the remembered profile
+	base.tried = nil
+	if _, err := store.collectorFor(device, "snmp", base).Collect(context.Background(), device.IPAddress); err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	if len(base.tried) != 1 || base.tried[0] != "b" {
+		t.Errorf("Expected only the remembered profile to be tried, got %v", base.tried)
+	}
+
+	// When every profile fails the error names each of them and the
+	// remembered profile is kept
+	base.failing["b"] = true
+	if _, err := store.collectorFor(device, "snmp", base).Collect(context.Background(), device.IPAddress); err == nil {
+		t.Error("Expected an error when every profile fails")
+	}
+	if got := store.lastWorking("r1/snmp"); got != "site-b" {
+		t.Errorf("Expected site-b to stay remembered, got %q", got)
+	}
+
+	// Other protocols of the same device are remembered separately
+	if got := store.lastWorking("r1/ssh"); got != "" {
+		t.Errorf("Expected nothing remembered for ssh, got %q", got)
+	}
+}
+
+func TestCredentialCollector_StopsOnHostKeyChange(t *testing.T) {
+	store := newCredentialStore(config.CredentialsConfig{
+		Profiles: map[string]config.CredentialProfile{
+			"site-a": {SNMP: config.SNMPCredentials{Community: "a"}},
+			"site-b": {SNMP: config.SNMPCredentials{Community: "b"}},
+		},
+		Assignments: []config.CredentialAssignment{
+			{Profiles: []string{"site-a", "site-b"}},
+		},
+	})
+	changed := &metrics.HostKeyChangedError{Host: "192.0.2.1:22"}
+	base := &stubCredentialCollector{errs: map[string]error{"a": fmt.Errorf("failed to connect: %w", changed)}}
+	device := Device{ID: "r1", IPAddress: "192.0.2.1"}
+
+	_, err := store.collectorFor(device, "ssh", base).Collect(context.Background(), device.IPAddress)
+	var keyErr *metrics.HostKeyChangedError
+	if !errors.As(err, &keyErr) {
+		t.Errorf("Expected the HostKeyChangedError to surface, got %v", err)
+	}
+	if len(base.tried) != 1 {
+		t.Errorf("Expected no other profile to be tried, tried %v", base.tried)
+	}
+}
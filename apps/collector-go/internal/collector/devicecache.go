@@ -0,0 +1,119 @@
+package collector
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Maintenance modes stored in devices.maintenance_mode
+const (
+	// MaintenancePause stops polling the device until the window ends
+	MaintenancePause = "pause"
+	// MaintenanceSuppress keeps polling but marks results as alert-suppressed
+	MaintenanceSuppress = "suppress"
+)
+
+// deviceCache holds the device inventory between refreshes. It is filled by
+// a full load and then kept current with incremental loads of rows whose
+// updated_at is at or after the watermark.
+type deviceCache struct {
+	mu        sync.RWMutex
+	devices   map[string]Device
+	watermark time.Time
+	lastFull  time.Time
+}
+
+// newDeviceCache creates an empty device cache
+func newDeviceCache() *deviceCache {
+	return &deviceCache{devices: make(map[string]Device)}
+}
+
+// replace swaps the cache contents for the result of a full load
+func (dc *deviceCache) replace(devices []Device, now time.Time) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	dc.devices = make(map[string]Device, len(devices))
+	dc.watermark = time.Time{}
+	for _, device := range devices {
+		dc.devices[device.ID] = device
+		dc.advance(device.UpdatedAt)
+	}
+	dc.lastFull = now
+}
+
+// merge applies the rows returned by an incremental load
+func (dc *deviceCache) merge(devices []Device) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	for _, device := range devices {
+		dc.devices[device.ID] = device
+		dc.advance(device.UpdatedAt)
+	}
+}
+
+// advance moves the watermark forward. Callers must hold the lock.
+func (dc *deviceCache) advance(updatedAt time.Time) {
+	if updatedAt.After(dc.watermark) {
+		dc.watermark = updatedAt
+	}
+}
+
+// since returns the watermark for the next incremental load
+func (dc *deviceCache) since() time.Time {
+	dc.mu.RLock()
+	defer dc.mu.RUnlock()
+
+	return dc.watermark
+}
+
+// needsFullLoad reports whether a full load is due. Full loads are the only
+// way deleted rows leave the cache.
+func (dc *deviceCache) needsFullLoad(now time.Time, interval time.Duration) bool {
+	dc.mu.RLock()
+	defer dc.mu.RUnlock()
+
+	return dc.lastFull.IsZero() || now.Sub(dc.lastFull) >= interval
+}
+
+// pollable returns the monitored devices that are not paused for
+// maintenance at now, ordered by IP address
+func (dc *deviceCache) pollable(now time.Time) []Device {
+	dc.mu.RLock()
+	defer dc.mu.RUnlock()
+
+	devices := make([]Device, 0, len(dc.devices))
+	for _, device := range dc.devices {
+		if !device.IsMonitored || device.maintenanceMode(now) == MaintenancePause {
+			continue
+		}
+		devices = append(devices, device)
+	}
+
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].IPAddress < devices[j].IPAddress
+	})
+	return devices
+}
+
+// size returns the number of cached devices
+func (dc *deviceCache) size() int {
+	dc.mu.RLock()
+	defer dc.mu.RUnlock()
+
+	return len(dc.devices)
+}
+
+// maintenanceMode returns the device's maintenance mode if a maintenance
+// window is active at now, or "" otherwise
+func (d Device) maintenanceMode(now time.Time) string {
+	if d.MaintenanceUntil == nil || !now.Before(*d.MaintenanceUntil) {
+		return ""
+	}
+	if d.MaintenanceMode == "" {
+		return MaintenancePause
+	}
+	return d.MaintenanceMode
+}
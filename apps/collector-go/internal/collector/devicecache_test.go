@@ -0,0 +1,109 @@
+package collector
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeviceCache_Pollable(t *testing.T) {
+	now := time.Now()
+	future := now.Add(time.Hour)
+	past := now.Add(-time.Hour)
+
+	dc := newDeviceCache()
+	dc.replace([]Device{
+		{ID: "monitored", IPAddress: "10.0.0.1", IsMonitored: true},
+		{ID: "unmonitored", IPAddress: "10.0.0.2", IsMonitored: false},
+		{ID: "paused", IPAddress: "10.0.0.3", IsMonitored: true, MaintenanceUntil: &future, MaintenanceMode: MaintenancePause},
+		{ID: "paused-default-mode", IPAddress: "10.0.0.4", IsMonitored: true, MaintenanceUntil: &future},
+		{ID: "suppressed", IPAddress: "10.0.0.5", IsMonitored: true, MaintenanceUntil: &future, MaintenanceMode: MaintenanceSuppress},
+		{ID: "window-ended", IPAddress: "10.0.0.6", IsMonitored: true, MaintenanceUntil: &past, MaintenanceMode: MaintenancePause},
+	}, now)
+
+	got := map[string]bool{}
+	for _, device := range dc.pollable(now) {
+		got[device.ID] = true
+	}
+
+	want := map[string]bool{"monitored": true, "suppressed": true, "window-ended": true}
+	if len(got) != len(want) {
+		t.Errorf("pollable() = %v, want %v", got, want)
+	}
+	for id := range want {
+		if !got[id] {
+			t.Errorf("Expected %s to be pollable", id)
+		}
+	}
+}
+
+func TestDeviceCache_IncrementalMerge(t *testing.T) {
+	now := time.Now()
+	t1 := now.Add(-2 * time.Minute)
+	t2 := now.Add(-time.Minute)
+
+	dc := newDeviceCache()
+	dc.replace([]Device{
+		{ID: "a", IPAddress: "10.0.0.1", IsMonitored: true, UpdatedAt: t1},
+		{ID: "b", IPAddress: "10.0.0.2", IsMonitored: true, UpdatedAt: t1},
+	}, now)
+
+	if !dc.since().Equal(t1) {
+		t.Errorf("Expected watermark %v, got %v", t1, dc.since())
+	}
+
+	// b was unmonitored through the API and c was added
+	dc.merge([]Device{
+		{ID: "b", IPAddress: "10.0.0.2", IsMonitored: false, UpdatedAt: t2},
+		{ID: "c", IPAddress: "10.0.0.3", IsMonitored: true, UpdatedAt: t2},
+	})
+
+	if !dc.since().Equal(t2) {
+		t.Errorf("Expected watermark to advance to %v, got %v", t2, dc.since())
+	}
+
+	pollable := dc.pollable(now)
+	if len(pollable) != 2 || pollable[0].ID != "a" || pollable[1].ID != "c" {
+		t.Errorf("Expected pollable devices [a c], got %+v", pollable)
+	}
+}
+
+func TestDeviceCache_NeedsFullLoad(t *testing.T) {
+	now := time.Now()
+	dc := newDeviceCache()
+
+	if !dc.needsFullLoad(now, 10*time.Minute) {
+		t.Error("Empty cache should need a full load")
+	}
+
+	dc.replace(nil, now)
+	if dc.needsFullLoad(now.Add(time.Minute), 10*time.Minute) {
+		t.Error("Cache should not need a full load before the interval")
+	}
+	if !dc.needsFullLoad(now.Add(10*time.Minute), 10*time.Minute) {
+		t.Error("Cache should need a full load after the interval")
+	}
+}
+
+func TestDevice_MaintenanceMode(t *testing.T) {
+	now := time.Now()
+	future := now.Add(time.Hour)
+
+	tests := []struct {
+		name   string
+		device Device
+		want   string
+	}{
+		{name: "no window", device: Device{}, want: ""},
+		{name: "window ended", device: Device{MaintenanceUntil: &now, MaintenanceMode: MaintenanceSuppress}, want: ""},
+		{name: "suppress", device: Device{MaintenanceUntil: &future, MaintenanceMode: MaintenanceSuppress}, want: MaintenanceSuppress},
+		{name: "default is pause", device: Device{MaintenanceUntil: &future}, want: MaintenancePause},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.device.maintenanceMode(now); got != tt.want {
+				t.Errorf("maintenanceMode() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
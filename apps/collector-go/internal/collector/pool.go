@@ -0,0 +1,119 @@
+package collector
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// PoolStats is a point-in-time snapshot of a worker pool's accounting
+type PoolStats struct {
+	Name      string
+	Workers   int
+	Queued    int64
+	InFlight  int64
+	Completed int64
+	Dropped   int64
+}
+
+// poolJob is a single unit of polling work queued on a worker pool
+type poolJob struct {
+	ctx  context.Context
+	run  func(context.Context)
+	done func()
+}
+
+// workerPool runs polling jobs for one protocol on a fixed number of goroutines
+// so that a large device inventory cannot exhaust sockets or sessions
+type workerPool struct {
+	name string
+	size int
+	jobs chan poolJob
+	wg   sync.WaitGroup
+
+	queued    int64
+	inFlight  int64
+	completed int64
+	dropped   int64
+}
+
+// newWorkerPool creates a pool with size workers and room for queueSize pending jobs
+func newWorkerPool(name string, size, queueSize int) *workerPool {
+	if size <= 0 {
+		size = 1
+	}
+	if queueSize < size {
+		queueSize = size
+	}
+
+	return &workerPool{
+		name: name,
+		size: size,
+		jobs: make(chan poolJob, queueSize),
+	}
+}
+
+// start launches the pool's workers
+func (p *workerPool) start() {
+	for i := 0; i < p.size; i++ {
+		p.wg.Add(1)
+		go p.worker()
+	}
+}
+
+// worker executes queued jobs until the pool is stopped
+func (p *workerPool) worker() {
+	defer p.wg.Done()
+
+	for job := range p.jobs {
+		atomic.AddInt64(&p.queued, -1)
+
+		// Jobs whose context was cancelled while queued are not worth starting
+		if job.ctx.Err() != nil {
+			atomic.AddInt64(&p.dropped, 1)
+			job.done()
+			continue
+		}
+
+		atomic.AddInt64(&p.inFlight, 1)
+		job.run(job.ctx)
+		atomic.AddInt64(&p.inFlight, -1)
+		atomic.AddInt64(&p.completed, 1)
+		job.done()
+	}
+}
+
+// submit queues run on the pool, blocking while the queue is full. It returns
+// false without queueing the job if ctx is cancelled first. done is always
+// called exactly once for a job that was queued.
+func (p *workerPool) submit(ctx context.Context, run func(context.Context), done func()) bool {
+	atomic.AddInt64(&p.queued, 1)
+
+	select {
+	case p.jobs <- poolJob{ctx: ctx, run: run, done: done}:
+		return true
+	case <-ctx.Done():
+		atomic.AddInt64(&p.queued, -1)
+		atomic.AddInt64(&p.dropped, 1)
+		return false
+	}
+}
+
+// stop closes the queue and waits for in-flight jobs to finish. No jobs may be
+// submitted after stop has been called.
+func (p *workerPool) stop() {
+	close(p.jobs)
+	p.wg.Wait()
+}
+
+// stats returns the current pool accounting
+func (p *workerPool) stats() PoolStats {
+	return PoolStats{
+		Name:      p.name,
+		Workers:   p.size,
+		Queued:    atomic.LoadInt64(&p.queued),
+		InFlight:  atomic.LoadInt64(&p.inFlight),
+		Completed: atomic.LoadInt64(&p.completed),
+		Dropped:   atomic.LoadInt64(&p.dropped),
+	}
+}
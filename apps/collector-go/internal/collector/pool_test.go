@@ -0,0 +1,107 @@
+package collector
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPool_BoundsConcurrency(t *testing.T) {
+	tests := []struct {
+		name    string
+		workers int
+		jobs    int
+	}{
+		{name: "single worker", workers: 1, jobs: 10},
+		{name: "more jobs than workers", workers: 4, jobs: 50},
+		{name: "zero workers defaults to one", workers: 0, jobs: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := newWorkerPool("test", tt.workers, 0)
+			pool.start()
+
+			var current, peak int64
+			var wg sync.WaitGroup
+			for i := 0; i < tt.jobs; i++ {
+				wg.Add(1)
+				ok := pool.submit(context.Background(), func(context.Context) {
+					n := atomic.AddInt64(&current, 1)
+					for {
+						p := atomic.LoadInt64(&peak)
+						if n <= p || atomic.CompareAndSwapInt64(&peak, p, n) {
+							break
+						}
+					}
+					time.Sleep(time.Millisecond)
+					atomic.AddInt64(&current, -1)
+				}, wg.Done)
+				if !ok {
+					t.Fatal("submit() returned false with a live context")
+				}
+			}
+			wg.Wait()
+			pool.stop()
+
+			if peak > int64(pool.size) {
+				t.Errorf("Expected at most %d concurrent jobs, got %d", pool.size, peak)
+			}
+
+			stats := pool.stats()
+			if stats.Completed != int64(tt.jobs) {
+				t.Errorf("Expected %d completed jobs, got %d", tt.jobs, stats.Completed)
+			}
+			if stats.Queued != 0 || stats.InFlight != 0 {
+				t.Errorf("Expected empty pool after stop, got queued=%d in_flight=%d", stats.Queued, stats.InFlight)
+			}
+		})
+	}
+}
+
+func TestWorkerPool_SubmitCancelled(t *testing.T) {
+	pool := newWorkerPool("test", 1, 1)
+
+	// Fill the queue without starting workers so the next submit blocks
+	pool.submit(context.Background(), func(context.Context) {}, func() {})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if pool.submit(ctx, func(context.Context) {}, func() {}) {
+		t.Error("submit() should fail once the context is cancelled")
+	}
+
+	stats := pool.stats()
+	if stats.Queued != 1 {
+		t.Errorf("Expected queue depth 1, got %d", stats.Queued)
+	}
+	if stats.Dropped != 1 {
+		t.Errorf("Expected 1 dropped job, got %d", stats.Dropped)
+	}
+
+	pool.start()
+	pool.stop()
+}
+
+func TestWorkerPool_SkipsCancelledJobs(t *testing.T) {
+	pool := newWorkerPool("test", 1, 4)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ran := false
+	done := false
+	pool.submit(ctx, func(context.Context) { ran = true }, func() { done = true })
+	cancel()
+
+	pool.start()
+	pool.stop()
+
+	if ran {
+		t.Error("Job with a cancelled context should not run")
+	}
+	if !done {
+		t.Error("done callback should still be called for skipped jobs")
+	}
+}
@@ -0,0 +1,96 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"collector/internal/config"
+	"collector/internal/metrics"
+)
+
+// fallbackCollector tries each collector in turn and returns the result of
+// the first one that succeeds
+type fallbackCollector []metrics.MetricCollector
+
+// Collect implements metrics.MetricCollector
+func (f fallbackCollector) Collect(ctx context.Context, ipAddress string) ([]metrics.Metric, error) {
+	var errs []string
+	for _, collector := range f {
+		result, err := collector.Collect(ctx, ipAddress)
+		if err == nil {
+			return result, nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return nil, fmt.Errorf("all reachability checks failed: %s", strings.Join(errs, "; "))
+}
+
+// parseReachabilityTargets resolves the port probe targets of every
+// reachability override, so that bad port lists fail at startup. Overrides
+// without their own ports get nil and use the port probe defaults.
+func parseReachabilityTargets(overrides []config.ReachabilityOverride) ([][]metrics.PortTarget, error) {
+	targets := make([][]metrics.PortTarget, len(overrides))
+	for i, override := range overrides {
+		if len(override.TCPPorts) == 0 && len(override.UDPServices) == 0 {
+			continue
+		}
+		parsed, err := metrics.ParsePortTargets(override.TCPPorts, override.UDPServices)
+		if err != nil {
+			return nil, fmt.Errorf("reachability override %d: %w", i, err)
+		}
+		targets[i] = parsed
+	}
+	return targets, nil
+}
+
+// reachabilityMethod returns the reachability check method of a device and
+// the port probe targets of its override, according to the first matching
+// reachability override or the default method. Nil targets use the port
+// probe defaults.
+func (c *Collector) reachabilityMethod(device Device) (string, []metrics.PortTarget) {
+	method := c.config.Reachability.Method
+	for i, override := range c.config.Reachability.Overrides {
+		if override.Matches(device.ID, device.DeviceType, device.Tags) {
+			if override.Method != "" {
+				method = override.Method
+			}
+			return method, c.reachabilityTargets[i]
+		}
+	}
+	return method, nil
+}
+
+// reachabilityProbe returns the collector used to check whether a device is
+// reachable
+func (c *Collector) reachabilityProbe(device Device) metrics.MetricCollector {
+	method, targets := c.reachabilityMethod(device)
+
+	var port metrics.MetricCollector = c.portProbe
+	if targets != nil {
+		port = c.portProbe.WithTargets(targets)
+	}
+
+	switch method {
+	case config.ReachabilityPort:
+		return port
+	case config.ReachabilityPingOrPort:
+		return fallbackCollector{c.collectors["ping"], port}
+	default:
+		return c.collectors["ping"]
+	}
+}
+
+// probeRTT extracts the round-trip time in milliseconds from the metrics of
+// a reachability check
+func probeRTT(probeMetrics []metrics.Metric) *float64 {
+	for _, metric := range probeMetrics {
+		if metric.Name != "ping_rtt_ms" && metric.Name != "port_status" {
+			continue
+		}
+		if rtt, ok := metric.Value["rtt_ms"].(float64); ok {
+			return &rtt
+		}
+	}
+	return nil
+}
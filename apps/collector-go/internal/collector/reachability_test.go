@@ -0,0 +1,98 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"collector/internal/config"
+	"collector/internal/metrics"
+)
+
+// stubCollector returns a fixed result and counts calls
+type stubCollector struct {
+	name  string
+	err   error
+	calls int
+}
+
+func (s *stubCollector) Collect(ctx context.Context, ipAddress string) ([]metrics.Metric, error) {
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return []metrics.Metric{{Name: s.name}}, nil
+}
+
+func TestFallbackCollector(t *testing.T) {
+	failing := &stubCollector{name: "ping", err: errors.New("timeout")}
+	working := &stubCollector{name: "port_status"}
+
+	result, err := fallbackCollector{failing, working}.Collect(context.Background(), "192.0.2.1")
+	if err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	if len(result) != 1 || result[0].Name != "port_status" {
+		t.Errorf("Expected the port probe result, got %+v", result)
+	}
+
+	working.err = errors.New("refused")
+	if _, err := (fallbackCollector{failing, working}).Collect(context.Background(), "192.0.2.1"); err == nil {
+		t.Error("Expected an error when every check fails")
+	}
+
+	// A successful first check must not run the fallback
+	failing.err = nil
+	working.calls = 0
+	if _, err := (fallbackCollector{failing, working}).Collect(context.Background(), "192.0.2.1"); err != nil || working.calls != 0 {
+		t.Errorf("Expected only the first check to run, got err=%v fallback calls=%d", err, working.calls)
+	}
+}
+
+func TestCollector_ReachabilityProbe(t *testing.T) {
+	portProbe, err := metrics.NewPortProbeCollector(config.PortProbeConfig{TCPPorts: []int{22}})
+	if err != nil {
+		t.Fatalf("Failed to create port probe collector: %v", err)
+	}
+	ping := &stubCollector{name: "ping"}
+
+	overrides := []config.ReachabilityOverride{
+		{DeviceSelector: config.DeviceSelector{DeviceTypes: []string{"windows"}}, Method: config.ReachabilityPort, TCPPorts: []int{3389}},
+		{DeviceSelector: config.DeviceSelector{Tags: []string{"firewalled"}}, Method: config.ReachabilityPingOrPort},
+	}
+	targets, err := parseReachabilityTargets(overrides)
+	if err != nil {
+		t.Fatalf("parseReachabilityTargets() error = %v", err)
+	}
+
+	c := &Collector{
+		config: &config.Config{
+			Reachability: config.ReachabilityConfig{Method: config.ReachabilityPing, Overrides: overrides},
+		},
+		collectors:          map[string]metrics.MetricCollector{"ping": ping},
+		portProbe:           portProbe,
+		reachabilityTargets: targets,
+	}
+
+	if probe := c.reachabilityProbe(Device{DeviceType: "linux"}); probe != metrics.MetricCollector(ping) {
+		t.Errorf("Expected ping for a device without override, got %T", probe)
+	}
+	if probe := c.reachabilityProbe(Device{DeviceType: "windows"}); probe == metrics.MetricCollector(portProbe) || probe == metrics.MetricCollector(ping) {
+		t.Errorf("Expected a port probe bound to the override ports, got %T", probe)
+	}
+	if _, ok := c.reachabilityProbe(Device{DeviceType: "linux", Tags: []string{"firewalled"}}).(fallbackCollector); !ok {
+		t.Error("Expected a ping-or-port fallback for firewalled devices")
+	}
+
+	// Port-only checks run on their own pool
+	if got := c.statusProtocol(Device{DeviceType: "windows"}); got != "port" {
+		t.Errorf("statusProtocol() = %s for a port-checked device, want port", got)
+	}
+	if got := c.statusProtocol(Device{DeviceType: "linux", Tags: []string{"firewalled"}}); got != "ping" {
+		t.Errorf("statusProtocol() = %s for a ping-or-port device, want ping", got)
+	}
+
+	if _, err := parseReachabilityTargets([]config.ReachabilityOverride{{UDPServices: []string{"gopher"}}}); err == nil {
+		t.Error("Expected an error for an unsupported UDP service")
+	}
+}
@@ -0,0 +1,217 @@
+package collector
+
+import (
+	"container/heap"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// pollSettings is the resolved polling interval and priority for one device
+type pollSettings struct {
+	interval time.Duration
+	priority int
+}
+
+// scheduleEntry tracks when a single device is next due for polling
+type scheduleEntry struct {
+	device   Device
+	settings pollSettings
+	nextDue  time.Time
+	running  int32
+	index    int
+}
+
+// entryHeap orders schedule entries by their next due time
+type entryHeap []*scheduleEntry
+
+func (h entryHeap) Len() int           { return len(h) }
+func (h entryHeap) Less(i, j int) bool { return h[i].nextDue.Before(h[j].nextDue) }
+func (h entryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *entryHeap) Push(x interface{}) {
+	entry := x.(*scheduleEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *entryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	entry.index = -1
+	*h = old[:n-1]
+	return entry
+}
+
+// pollSchedule gives every device its own next-due time. New devices are
+// spread evenly across their interval by a hash of the device ID, and every
+// reschedule adds random jitter so polls do not drift into lockstep.
+type pollSchedule struct {
+	name   string
+	jitter float64
+
+	mu      sync.Mutex
+	entries map[string]*scheduleEntry
+	queue   entryHeap
+	rand    *rand.Rand
+
+	skipped int64
+}
+
+// newPollSchedule creates a schedule. jitter is the fraction of the interval
+// by which each reschedule may randomly deviate.
+func newPollSchedule(name string, jitter float64) *pollSchedule {
+	if jitter < 0 {
+		jitter = 0
+	}
+	if jitter > 1 {
+		jitter = 1
+	}
+
+	return &pollSchedule{
+		name:    name,
+		jitter:  jitter,
+		entries: make(map[string]*scheduleEntry),
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// sync reconciles the schedule with the current device list. Devices that are
+// no longer present are removed, and devices whose interval changed are
+// rescheduled from now.
+func (s *pollSchedule) sync(devices []Device, settingsFor func(Device) pollSettings, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen := make(map[string]bool, len(devices))
+	for _, device := range devices {
+		seen[device.ID] = true
+		settings := settingsFor(device)
+
+		entry, exists := s.entries[device.ID]
+		if !exists {
+			entry = &scheduleEntry{
+				device:   device,
+				settings: settings,
+				nextDue:  now.Add(spreadOffset(device.ID, settings.interval)),
+			}
+			s.entries[device.ID] = entry
+			heap.Push(&s.queue, entry)
+			continue
+		}
+
+		entry.device = device
+		if entry.settings != settings {
+			entry.settings = settings
+			entry.nextDue = now.Add(spreadOffset(device.ID, settings.interval))
+			heap.Fix(&s.queue, entry.index)
+		}
+	}
+
+	for id, entry := range s.entries {
+		if !seen[id] {
+			heap.Remove(&s.queue, entry.index)
+			delete(s.entries, id)
+		}
+	}
+}
+
+// due pops every entry whose next due time has passed, reschedules it and
+// returns the ones that are not still running from a previous poll. The
+// result is ordered by priority (highest first) and then by due time.
+func (s *pollSchedule) due(now time.Time) []*scheduleEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ready []*scheduleEntry
+	for len(s.queue) > 0 && !s.queue[0].nextDue.After(now) {
+		entry := s.queue[0]
+		dueAt := entry.nextDue
+
+		entry.nextDue = s.nextDue(dueAt, entry.settings.interval, now)
+		heap.Fix(&s.queue, 0)
+
+		if !atomic.CompareAndSwapInt32(&entry.running, 0, 1) {
+			// Previous poll of this device has not finished yet
+			atomic.AddInt64(&s.skipped, 1)
+			continue
+		}
+		ready = append(ready, entry)
+	}
+
+	sort.SliceStable(ready, func(i, j int) bool {
+		return ready[i].settings.priority > ready[j].settings.priority
+	})
+
+	return ready
+}
+
+// finish marks an entry returned by due as no longer running
+func (s *pollSchedule) finish(entry *scheduleEntry) {
+	atomic.StoreInt32(&entry.running, 0)
+}
+
+// nextWake returns when the earliest entry becomes due, or false if the
+// schedule is empty
+func (s *pollSchedule) nextWake() (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.queue) == 0 {
+		return time.Time{}, false
+	}
+	return s.queue[0].nextDue, true
+}
+
+// size returns the number of scheduled devices
+func (s *pollSchedule) size() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.entries)
+}
+
+// nextDue computes the due time following dueAt. If the schedule fell more
+// than a full interval behind, it restarts from now instead of bursting.
+func (s *pollSchedule) nextDue(dueAt time.Time, interval time.Duration, now time.Time) time.Time {
+	next := dueAt.Add(interval)
+	if !next.After(now) {
+		next = now.Add(interval)
+	}
+
+	if s.jitter > 0 {
+		span := float64(interval) * s.jitter
+		next = next.Add(time.Duration((s.rand.Float64() - 0.5) * span))
+	}
+	return next
+}
+
+// spreadOffset returns a stable offset within interval derived from the
+// device ID, so that devices are spread evenly instead of polled together
+func spreadOffset(deviceID string, interval time.Duration) time.Duration {
+	if interval <= 0 {
+		return 0
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(deviceID))
+
+	// FNV alone clusters similar IDs, so finish with a 64-bit mixer
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+
+	return time.Duration(float64(x>>11) / float64(1<<53) * float64(interval))
+}
@@ -0,0 +1,125 @@
+package collector
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPollSchedule_SpreadsDevicesAcrossInterval(t *testing.T) {
+	s := newPollSchedule("status", 0)
+	interval := time.Minute
+	now := time.Now()
+
+	var devices []Device
+	for i := 0; i < 600; i++ {
+		devices = append(devices, Device{ID: fmt.Sprintf("device-%d", i)})
+	}
+	s.sync(devices, func(Device) pollSettings { return pollSettings{interval: interval} }, now)
+
+	// Count how many devices become due in each tenth of the interval
+	buckets := make([]int, 10)
+	for _, entry := range s.entries {
+		offset := entry.nextDue.Sub(now)
+		if offset < 0 || offset >= interval {
+			t.Fatalf("Offset %v outside interval %v", offset, interval)
+		}
+		buckets[int(offset*10/interval)]++
+	}
+
+	for i, count := range buckets {
+		if count < 30 || count > 90 {
+			t.Errorf("Bucket %d has %d devices, expected roughly 60", i, count)
+		}
+	}
+}
+
+func TestPollSchedule_DueOrdersByPriority(t *testing.T) {
+	s := newPollSchedule("status", 0)
+	now := time.Now()
+
+	devices := []Device{{ID: "printer"}, {ID: "core"}, {ID: "server"}}
+	priorities := map[string]int{"printer": 0, "core": 10, "server": 5}
+	s.sync(devices, func(d Device) pollSettings {
+		return pollSettings{interval: time.Minute, priority: priorities[d.ID]}
+	}, now)
+
+	due := s.due(now.Add(time.Minute))
+	if len(due) != 3 {
+		t.Fatalf("Expected 3 due devices, got %d", len(due))
+	}
+
+	want := []string{"core", "server", "printer"}
+	for i, entry := range due {
+		if entry.device.ID != want[i] {
+			t.Errorf("due()[%d] = %s, want %s", i, entry.device.ID, want[i])
+		}
+		if !entry.nextDue.After(now.Add(time.Minute)) {
+			t.Errorf("Entry %s was not rescheduled", entry.device.ID)
+		}
+	}
+}
+
+func TestPollSchedule_SkipsRunningDevices(t *testing.T) {
+	s := newPollSchedule("status", 0)
+	now := time.Now()
+	s.sync([]Device{{ID: "a"}}, func(Device) pollSettings { return pollSettings{interval: time.Second} }, now)
+
+	first := s.due(now.Add(time.Second))
+	if len(first) != 1 {
+		t.Fatalf("Expected 1 due device, got %d", len(first))
+	}
+
+	// The previous poll is still running, so the next due time is skipped
+	if second := s.due(now.Add(3 * time.Second)); len(second) != 0 {
+		t.Errorf("Expected running device to be skipped, got %d due", len(second))
+	}
+	if s.skipped != 1 {
+		t.Errorf("Expected 1 skipped poll, got %d", s.skipped)
+	}
+
+	s.finish(first[0])
+	if third := s.due(now.Add(10 * time.Second)); len(third) != 1 {
+		t.Errorf("Expected device to be due again after finishing, got %d", len(third))
+	}
+}
+
+func TestPollSchedule_SyncRemovesAndReschedules(t *testing.T) {
+	s := newPollSchedule("status", 0)
+	now := time.Now()
+	interval := map[string]time.Duration{"a": time.Minute, "b": time.Minute}
+	settings := func(d Device) pollSettings { return pollSettings{interval: interval[d.ID]} }
+
+	s.sync([]Device{{ID: "a"}, {ID: "b"}}, settings, now)
+	if s.size() != 2 {
+		t.Fatalf("Expected 2 scheduled devices, got %d", s.size())
+	}
+
+	interval["a"] = 10 * time.Second
+	s.sync([]Device{{ID: "a"}}, settings, now)
+	if s.size() != 1 {
+		t.Fatalf("Expected removed device to be unscheduled, got %d", s.size())
+	}
+
+	entry := s.entries["a"]
+	if entry.settings.interval != 10*time.Second {
+		t.Errorf("Expected updated interval 10s, got %v", entry.settings.interval)
+	}
+	if entry.nextDue.Sub(now) >= 10*time.Second {
+		t.Errorf("Expected device to be rescheduled within its new interval, got %v", entry.nextDue.Sub(now))
+	}
+}
+
+func TestPollSchedule_Jitter(t *testing.T) {
+	s := newPollSchedule("status", 0.2)
+	now := time.Now()
+	interval := time.Minute
+
+	for i := 0; i < 100; i++ {
+		next := s.nextDue(now, interval, now)
+		offset := next.Sub(now)
+		if offset < 54*time.Second || offset > 66*time.Second {
+			t.Fatalf("Jittered offset %v outside +/-10%% of %v", offset, interval)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package collector
+
+import (
+	"time"
+)
+
+// HealthState is the state of a device in the status state machine
+type HealthState string
+
+// Device health states
+const (
+	StateUnknown  HealthState = "unknown"
+	StateUp       HealthState = "up"
+	StateDegraded HealthState = "degraded"
+	StateDown     HealthState = "down"
+)
+
+// Kinds of health tracked separately for every device
+const (
+	// HealthReachability tracks whether the device answers status checks
+	HealthReachability = "reachability"
+	// HealthCollection tracks whether detailed metric collection succeeds
+	HealthCollection = "collection"
+)
+
+// StateEvent describes a single state transition of a device
+type StateEvent struct {
+	DeviceID string
+	Hostname string
+	Kind     string
+	From     HealthState
+	To       HealthState
+	// Duration is how long the device spent in the previous state
+	Duration time.Duration
+	Reason   string
+	At       time.Time
+	// Suppressed is set when the device is in a maintenance window that
+	// suppresses alerts
+	Suppressed bool
+}
+
+// stateMachine debounces probe results into a health state. A device goes
+// from up to degraded on its first failure and to down after failuresToDown
+// consecutive failures; a down device needs successesToUp consecutive
+// successes before it is up again.
+type stateMachine struct {
+	failuresToDown int
+	successesToUp  int
+
+	state     HealthState
+	since     time.Time
+	failures  int
+	successes int
+}
+
+// newStateMachine creates a state machine in the unknown state
+func newStateMachine(failuresToDown, successesToUp int, now time.Time) *stateMachine {
+	if failuresToDown < 1 {
+		failuresToDown = 1
+	}
+	if successesToUp < 1 {
+		successesToUp = 1
+	}
+
+	return &stateMachine{
+		failuresToDown: failuresToDown,
+		successesToUp:  successesToUp,
+		state:          StateUnknown,
+		since:          now,
+	}
+}
+
+// observe feeds one probe result into the state machine. It returns the new
+// state and whether a transition happened, together with the time spent in
+// the previous state.
+func (m *stateMachine) observe(success bool, now time.Time) (HealthState, time.Duration, bool) {
+	if success {
+		m.failures = 0
+		m.successes++
+	} else {
+		m.successes = 0
+		m.failures++
+	}
+
+	next := m.state
+	switch m.state {
+	case StateUnknown:
+		if success {
+			next = StateUp
+		} else if m.failures >= m.failuresToDown {
+			next = StateDown
+		}
+	case StateUp, StateDegraded:
+		if success {
+			next = StateUp
+		} else if m.failures >= m.failuresToDown {
+			next = StateDown
+		} else {
+			next = StateDegraded
+		}
+	case StateDown:
+		if success && m.successes >= m.successesToUp {
+			next = StateUp
+		}
+	}
+
+	if next == m.state {
+		return m.state, 0, false
+	}
+
+	duration := now.Sub(m.since)
+	m.state = next
+	m.since = now
+	return next, duration, true
+}
+
+// legacyStatus maps a reachability state to the online/offline status used
+// by the devices table and the device_status measurement
+func legacyStatus(state HealthState) string {
+	switch state {
+	case StateUp, StateDegraded:
+		return "online"
+	case StateDown:
+		return "offline"
+	default:
+		return "unknown"
+	}
+}
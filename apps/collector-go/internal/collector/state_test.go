@@ -0,0 +1,108 @@
+package collector
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStateMachine_Observe(t *testing.T) {
+	tests := []struct {
+		name           string
+		failuresToDown int
+		successesToUp  int
+		results        []bool
+		want           []HealthState
+	}{
+		{
+			name:           "first success is up",
+			failuresToDown: 3,
+			successesToUp:  2,
+			results:        []bool{true},
+			want:           []HealthState{StateUp},
+		},
+		{
+			name:           "single loss only degrades",
+			failuresToDown: 3,
+			successesToUp:  2,
+			results:        []bool{true, false, true},
+			want:           []HealthState{StateUp, StateDegraded, StateUp},
+		},
+		{
+			name:           "consecutive failures go down",
+			failuresToDown: 3,
+			successesToUp:  2,
+			results:        []bool{true, false, false, false},
+			want:           []HealthState{StateUp, StateDegraded, StateDegraded, StateDown},
+		},
+		{
+			name:           "recovery needs consecutive successes",
+			failuresToDown: 2,
+			successesToUp:  2,
+			results:        []bool{true, false, false, true, false, true, true},
+			want:           []HealthState{StateUp, StateDegraded, StateDown, StateDown, StateDown, StateDown, StateUp},
+		},
+		{
+			name:           "unknown goes straight to down",
+			failuresToDown: 2,
+			successesToUp:  1,
+			results:        []bool{false, false},
+			want:           []HealthState{StateUnknown, StateDown},
+		},
+		{
+			name:           "zero thresholds behave like one",
+			failuresToDown: 0,
+			successesToUp:  0,
+			results:        []bool{true, false, true},
+			want:           []HealthState{StateUp, StateDown, StateUp},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			m := newStateMachine(tt.failuresToDown, tt.successesToUp, now)
+			for i, success := range tt.results {
+				now = now.Add(time.Second)
+				got, _, _ := m.observe(success, now)
+				if got != tt.want[i] {
+					t.Errorf("step %d: state = %s, want %s", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestStateMachine_TransitionDuration(t *testing.T) {
+	start := time.Now()
+	m := newStateMachine(1, 1, start)
+
+	_, duration, changed := m.observe(true, start.Add(5*time.Second))
+	if !changed || duration != 5*time.Second {
+		t.Errorf("Expected transition after 5s, got changed=%v duration=%v", changed, duration)
+	}
+
+	_, _, changed = m.observe(true, start.Add(10*time.Second))
+	if changed {
+		t.Error("Repeated success should not be a transition")
+	}
+
+	_, duration, changed = m.observe(false, start.Add(65*time.Second))
+	if !changed || duration != time.Minute {
+		t.Errorf("Expected transition after 1m up, got changed=%v duration=%v", changed, duration)
+	}
+}
+
+func TestLegacyStatus(t *testing.T) {
+	tests := map[HealthState]string{
+		StateUnknown:  "unknown",
+		StateUp:       "online",
+		StateDegraded: "online",
+		StateDown:     "offline",
+	}
+
+	for state, want := range tests {
+		if got := legacyStatus(state); got != want {
+			t.Errorf("legacyStatus(%s) = %s, want %s", state, got, want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// statusUpdate is a pending write of collected status to the devices table
+type statusUpdate struct {
+	DeviceID     string
+	Status       string
+	LastSeen     time.Time
+	ResponseTime *float64 // milliseconds, nil when unknown or offline
+}
+
+// writtenStatus remembers what was last written for a device
+type writtenStatus struct {
+	status    string
+	writtenAt time.Time
+}
+
+// statusWriter batches status updates for the devices table. Only status
+// changes and periodic heartbeats are queued, so a stable fleet costs one
+// UPDATE statement per flush rather than one per device per poll.
+type statusWriter struct {
+	db        *sql.DB
+	heartbeat time.Duration
+
+	mu      sync.Mutex
+	pending map[string]statusUpdate
+	written map[string]writtenStatus
+}
+
+// newStatusWriter creates a status writer. heartbeat is how often last_seen
+// is refreshed for devices whose status has not changed.
+func newStatusWriter(db *sql.DB, heartbeat time.Duration) *statusWriter {
+	return &statusWriter{
+		db:        db,
+		heartbeat: heartbeat,
+		pending:   make(map[string]statusUpdate),
+		written:   make(map[string]writtenStatus),
+	}
+}
+
+// record queues update if the status changed or the heartbeat is due. It
+// reports whether the update was queued.
+func (w *statusWriter) record(update statusUpdate, now time.Time) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	last, exists := w.written[update.DeviceID]
+	changed := !exists || last.status != update.Status
+	heartbeatDue := now.Sub(last.writtenAt) >= w.heartbeat
+
+	if changed || heartbeatDue {
+		w.pending[update.DeviceID] = update
+		w.written[update.DeviceID] = writtenStatus{status: update.Status, writtenAt: now}
+		return true
+	}
+
+	// A write that has not been flushed yet still picks up the latest values
+	if _, queued := w.pending[update.DeviceID]; queued {
+		w.pending[update.DeviceID] = update
+		return true
+	}
+
+	return false
+}
+
+// takePending returns and clears the queued updates
+func (w *statusWriter) takePending() []statusUpdate {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	updates := make([]statusUpdate, 0, len(w.pending))
+	for _, update := range w.pending {
+		updates = append(updates, update)
+	}
+	w.pending = make(map[string]statusUpdate)
+	return updates
+}
+
+// requeue puts back updates that failed to write, unless newer ones are queued
+func (w *statusWriter) requeue(updates []statusUpdate) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, update := range updates {
+		if _, exists := w.pending[update.DeviceID]; !exists {
+			w.pending[update.DeviceID] = update
+		}
+	}
+}
+
+// flush writes all queued updates in a single statement. updated_at is left
+// alone on purpose: it tracks inventory edits, not monitoring results.
+func (w *statusWriter) flush(ctx context.Context) (int, error) {
+	updates := w.takePending()
+	if len(updates) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]string, len(updates))
+	statuses := make([]string, len(updates))
+	lastSeen := make([]string, len(updates))
+	responseTimes := make([]string, len(updates))
+
+	for i, update := range updates {
+		ids[i] = update.DeviceID
+		statuses[i] = update.Status
+		if !update.LastSeen.IsZero() {
+			lastSeen[i] = update.LastSeen.UTC().Format(time.RFC3339Nano)
+		}
+		if update.ResponseTime != nil {
+			responseTimes[i] = strconv.FormatFloat(*update.ResponseTime, 'f', -1, 64)
+		}
+	}
+
+	query := `
+		UPDATE devices AS d
+		SET status = u.status,
+		    last_seen = COALESCE(NULLIF(u.last_seen, '')::timestamptz, d.last_seen),
+		    last_response_time = NULLIF(u.response_time, '')::double precision
+		FROM unnest($1::text[], $2::text[], $3::text[], $4::text[])
+		     AS u(id, status, last_seen, response_time)
+		WHERE d.id = u.id::uuid
+	`
+
+	_, err := w.db.ExecContext(ctx, query,
+		pq.Array(ids), pq.Array(statuses), pq.Array(lastSeen), pq.Array(responseTimes))
+	if err != nil {
+		w.requeue(updates)
+		return 0, fmt.Errorf("failed to write device statuses: %w", err)
+	}
+
+	return len(updates), nil
+}
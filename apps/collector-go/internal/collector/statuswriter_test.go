@@ -0,0 +1,115 @@
+package collector
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStatusWriter_Record(t *testing.T) {
+	now := time.Now()
+	rtt := 1.5
+
+	tests := []struct {
+		name   string
+		prior  []statusUpdate
+		update statusUpdate
+		at     time.Time
+		want   bool
+	}{
+		{
+			name:   "first status is written",
+			update: statusUpdate{DeviceID: "a", Status: "online", LastSeen: now, ResponseTime: &rtt},
+			at:     now,
+			want:   true,
+		},
+		{
+			name:   "unchanged status before heartbeat is skipped",
+			prior:  []statusUpdate{{DeviceID: "a", Status: "online", LastSeen: now}},
+			update: statusUpdate{DeviceID: "a", Status: "online", LastSeen: now.Add(30 * time.Second)},
+			at:     now.Add(30 * time.Second),
+			want:   false,
+		},
+		{
+			name:   "status change is written",
+			prior:  []statusUpdate{{DeviceID: "a", Status: "online", LastSeen: now}},
+			update: statusUpdate{DeviceID: "a", Status: "offline"},
+			at:     now.Add(30 * time.Second),
+			want:   true,
+		},
+		{
+			name:   "heartbeat is written for unchanged status",
+			prior:  []statusUpdate{{DeviceID: "a", Status: "online", LastSeen: now}},
+			update: statusUpdate{DeviceID: "a", Status: "online", LastSeen: now.Add(6 * time.Minute)},
+			at:     now.Add(6 * time.Minute),
+			want:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newStatusWriter(nil, 5*time.Minute)
+			for _, prior := range tt.prior {
+				w.record(prior, now)
+				w.takePending()
+			}
+
+			if got := w.record(tt.update, tt.at); got != tt.want {
+				t.Errorf("record() = %v, want %v", got, tt.want)
+			}
+
+			pending := w.takePending()
+			if tt.want && (len(pending) != 1 || pending[0] != tt.update) {
+				t.Errorf("Expected pending update %+v, got %+v", tt.update, pending)
+			}
+			if !tt.want && len(pending) != 0 {
+				t.Errorf("Expected no pending updates, got %+v", pending)
+			}
+		})
+	}
+}
+
+func TestStatusWriter_QueuedUpdateKeepsLatestValues(t *testing.T) {
+	w := newStatusWriter(nil, 5*time.Minute)
+	now := time.Now()
+
+	w.record(statusUpdate{DeviceID: "a", Status: "online", LastSeen: now}, now)
+	later := now.Add(10 * time.Second)
+	if !w.record(statusUpdate{DeviceID: "a", Status: "online", LastSeen: later}, later) {
+		t.Fatal("Expected unflushed update to be refreshed")
+	}
+
+	pending := w.takePending()
+	if len(pending) != 1 || !pending[0].LastSeen.Equal(later) {
+		t.Errorf("Expected latest last_seen %v, got %+v", later, pending)
+	}
+}
+
+func TestStatusWriter_FlushEmpty(t *testing.T) {
+	w := newStatusWriter(nil, time.Minute)
+
+	written, err := w.flush(context.Background())
+	if err != nil || written != 0 {
+		t.Errorf("flush() = %d, %v; want 0, nil", written, err)
+	}
+}
+
+func TestStatusWriter_Requeue(t *testing.T) {
+	w := newStatusWriter(nil, time.Minute)
+	now := time.Now()
+
+	w.requeue([]statusUpdate{{DeviceID: "a", Status: "offline"}})
+	w.record(statusUpdate{DeviceID: "b", Status: "online", LastSeen: now}, now)
+	w.requeue([]statusUpdate{{DeviceID: "b", Status: "offline"}})
+
+	pending := map[string]string{}
+	for _, update := range w.takePending() {
+		pending[update.DeviceID] = update.Status
+	}
+	if pending["a"] != "offline" {
+		t.Errorf("Expected failed update for a to be requeued, got %q", pending["a"])
+	}
+	if pending["b"] != "online" {
+		t.Errorf("Expected newer update for b to win over requeue, got %q", pending["b"])
+	}
+}
@@ -2,6 +2,8 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"strings"
 	"time"
 
 	"github.com/spf13/viper"
@@ -13,10 +15,22 @@ type Config struct {
 	StatusPollInterval  time.Duration `mapstructure:"status_poll_interval"`
 	MetricsPollInterval time.Duration `mapstructure:"metrics_poll_interval"`
 
+	// Per-device polling classes and overrides
+	Polling PollingConfig `mapstructure:"polling"`
+
 	// Timeout configuration
 	DeviceTimeout     time.Duration `mapstructure:"device_timeout"`
 	CollectionTimeout time.Duration `mapstructure:"collection_timeout"`
 
+	// Worker pool configuration
+	Workers WorkersConfig `mapstructure:"workers"`
+
+	// Device state machine thresholds
+	State StateConfig `mapstructure:"state"`
+
+	// Write collected status back to the devices table
+	StatusWriteback StatusWritebackConfig `mapstructure:"status_writeback"`
+
 	// InfluxDB configuration
 	InfluxDB InfluxDBConfig `mapstructure:"influxdb"`
 
@@ -27,9 +41,119 @@ type Config struct {
 	LogLevel string `mapstructure:"log_level"`
 
 	// Device communication configuration
-	SNMP SNMPConfig `mapstructure:"snmp"`
-	SSH  SSHConfig  `mapstructure:"ssh"`
-	WMI  WMIConfig  `mapstructure:"wmi"`
+	Ping         PingConfig         `mapstructure:"ping"`
+	PortProbe    PortProbeConfig    `mapstructure:"port_probe"`
+	Reachability ReachabilityConfig `mapstructure:"reachability"`
+	HTTP         HTTPConfig         `mapstructure:"http"`
+	TLS          TLSConfig          `mapstructure:"tls"`
+	SNMP         SNMPConfig         `mapstructure:"snmp"`
+	SSH          SSHConfig          `mapstructure:"ssh"`
+	WMI          WMIConfig          `mapstructure:"wmi"`
+
+	// Named credential profiles assigned to devices
+	Credentials CredentialsConfig `mapstructure:"credentials"`
+
+	// Secret providers for credential references
+	Secrets SecretsConfig `mapstructure:"secrets"`
+}
+
+// PollingConfig holds per-device polling schedule settings
+type PollingConfig struct {
+	// Jitter is the fraction of a device's interval by which each poll may
+	// randomly deviate, e.g. 0.1 for +/-5%
+	Jitter float64 `mapstructure:"jitter"`
+
+	// DeviceRefreshInterval controls how often changed devices are reloaded
+	DeviceRefreshInterval time.Duration `mapstructure:"device_refresh_interval"`
+
+	// FullRefreshInterval controls how often the whole device list is
+	// reloaded, which is how deleted devices are noticed
+	FullRefreshInterval time.Duration `mapstructure:"full_refresh_interval"`
+
+	Classes   map[string]PollClass `mapstructure:"classes"`
+	Overrides []PollOverride       `mapstructure:"overrides"`
+}
+
+// PollClass is a named set of polling intervals. Zero intervals fall back to
+// the global StatusPollInterval and MetricsPollInterval. Devices in classes
+// with a higher priority are dispatched first when several are due at once.
+type PollClass struct {
+	StatusInterval  time.Duration `mapstructure:"status_interval"`
+	MetricsInterval time.Duration `mapstructure:"metrics_interval"`
+	Priority        int           `mapstructure:"priority"`
+}
+
+// PollOverride assigns a polling class to the devices matched by its selector.
+// Overrides are evaluated in order and the first match wins.
+type PollOverride struct {
+	DeviceSelector `mapstructure:",squash"`
+	Class          string `mapstructure:"class"`
+}
+
+// DeviceSelector matches devices by ID, type or tag. Each non-empty list must
+// contain a match; an empty selector matches every device.
+type DeviceSelector struct {
+	DeviceIDs   []string `mapstructure:"device_ids"`
+	DeviceTypes []string `mapstructure:"device_types"`
+	Tags        []string `mapstructure:"tags"`
+}
+
+// Matches reports whether a device with the given attributes is selected
+func (s DeviceSelector) Matches(deviceID, deviceType string, tags []string) bool {
+	if len(s.DeviceIDs) > 0 && !containsString(s.DeviceIDs, deviceID) {
+		return false
+	}
+	if len(s.DeviceTypes) > 0 && !containsString(s.DeviceTypes, deviceType) {
+		return false
+	}
+	if len(s.Tags) > 0 {
+		found := false
+		for _, tag := range tags {
+			if containsString(s.Tags, tag) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// containsString reports whether values contains value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// WorkersConfig holds the worker pool size for each polling protocol
+type WorkersConfig struct {
+	Ping      int `mapstructure:"ping"`
+	Port      int `mapstructure:"port"`
+	SNMP      int `mapstructure:"snmp"`
+	SSH       int `mapstructure:"ssh"`
+	WMI       int `mapstructure:"wmi"`
+	QueueSize int `mapstructure:"queue_size"`
+}
+
+// StateConfig holds the thresholds of the device state machine
+type StateConfig struct {
+	// FailuresToDown is the number of consecutive failures before a device is down
+	FailuresToDown int `mapstructure:"failures_to_down"`
+	// SuccessesToUp is the number of consecutive successes before a down device is up
+	SuccessesToUp int `mapstructure:"successes_to_up"`
+}
+
+// StatusWritebackConfig controls how collected status is written to PostgreSQL
+type StatusWritebackConfig struct {
+	Enabled           bool          `mapstructure:"enabled"`
+	FlushInterval     time.Duration `mapstructure:"flush_interval"`
+	HeartbeatInterval time.Duration `mapstructure:"heartbeat_interval"`
 }
 
 // InfluxDBConfig holds InfluxDB connection settings
@@ -43,6 +167,138 @@ type InfluxDBConfig struct {
 // PostgreSQLConfig holds PostgreSQL connection settings
 type PostgreSQLConfig struct {
 	URL string `mapstructure:"url"`
+
+	// NotifyChannel, when set, is LISTENed on for device change notifications
+	NotifyChannel string `mapstructure:"notify_channel"`
+}
+
+// PingConfig holds ICMP echo probe configuration
+type PingConfig struct {
+	// Mode selects the ICMP socket type: "privileged" (raw sockets, needs
+	// root or CAP_NET_RAW), "unprivileged" (datagram ICMP, needs
+	// net.ipv4.ping_group_range) or "auto" to try raw sockets first
+	Mode     string        `mapstructure:"mode"`
+	Count    int           `mapstructure:"count"`
+	Interval time.Duration `mapstructure:"interval"`
+	Timeout  time.Duration `mapstructure:"timeout"`
+	Size     int           `mapstructure:"size"`
+}
+
+// PortProbeConfig holds TCP/UDP port reachability probe configuration
+type PortProbeConfig struct {
+	Timeout time.Duration `mapstructure:"timeout"`
+	// TCPPorts are probed with a connect; a refused connection still
+	// proves the host is up
+	TCPPorts []int `mapstructure:"tcp_ports"`
+	// UDPServices are "dns", "ntp", "snmp", a service on another port such
+	// as "dns:5353", or a bare port number
+	UDPServices []string `mapstructure:"udp_services"`
+	// SNMPCommunity is used by the "snmp" UDP probe
+	SNMPCommunity string `mapstructure:"snmp_community"`
+}
+
+// Reachability check methods
+const (
+	ReachabilityPing       = "ping"
+	ReachabilityPort       = "port"
+	ReachabilityPingOrPort = "ping_or_port"
+)
+
+// ReachabilityConfig selects how device reachability is checked
+type ReachabilityConfig struct {
+	// Method is the default check: "ping", "port", or "ping_or_port" to
+	// fall back to a port probe when ping fails
+	Method    string                 `mapstructure:"method"`
+	Overrides []ReachabilityOverride `mapstructure:"overrides"`
+}
+
+// ReachabilityOverride selects the reachability check for matching devices.
+// The first matching override wins. TCPPorts and UDPServices replace the
+// port probe defaults when set.
+type ReachabilityOverride struct {
+	DeviceSelector `mapstructure:",squash"`
+	Method         string   `mapstructure:"method"`
+	TCPPorts       []int    `mapstructure:"tcp_ports"`
+	UDPServices    []string `mapstructure:"udp_services"`
+}
+
+// validReachabilityMethod reports whether method is a known check method
+func validReachabilityMethod(method string) bool {
+	switch method {
+	case "", ReachabilityPing, ReachabilityPort, ReachabilityPingOrPort:
+		return true
+	default:
+		return false
+	}
+}
+
+// HTTPConfig holds HTTP endpoint check configuration
+type HTTPConfig struct {
+	Timeout time.Duration `mapstructure:"timeout"`
+	// MaxBodyBytes caps how much of a response body is read for assertions
+	MaxBodyBytes int64        `mapstructure:"max_body_bytes"`
+	Targets      []HTTPTarget `mapstructure:"targets"`
+}
+
+// HTTPTarget is an HTTP endpoint checked for every device matched by its
+// selector. URL may contain {ip} and {hostname} placeholders.
+type HTTPTarget struct {
+	DeviceSelector `mapstructure:",squash"`
+	Name           string            `mapstructure:"name"`
+	URL            string            `mapstructure:"url"`
+	Method         string            `mapstructure:"method"`
+	Headers        map[string]string `mapstructure:"headers"`
+	Body           string            `mapstructure:"body"`
+	Timeout        time.Duration     `mapstructure:"timeout"`
+
+	// Authentication, either basic or bearer
+	Username    string `mapstructure:"username"`
+	Password    string `mapstructure:"password"`
+	BearerToken string `mapstructure:"bearer_token"`
+
+	// ExpectedStatus lists accepted status codes as "200", "200-299" or
+	// "2xx"; 200-399 when empty
+	ExpectedStatus []string `mapstructure:"expected_status"`
+	// BodyRegex must match the response body when set
+	BodyRegex string `mapstructure:"body_regex"`
+	// JSONAssertions are checked against a JSON response body
+	JSONAssertions []JSONAssertion `mapstructure:"json_assertions"`
+
+	InsecureSkipVerify bool `mapstructure:"insecure_skip_verify"`
+}
+
+// JSONAssertion checks a value in a JSON response body. Path is a dotted
+// path with optional array indexes such as "data.items[0].status".
+type JSONAssertion struct {
+	Path string `mapstructure:"path"`
+	// Equals is compared with the value's string form; when empty the
+	// path only has to exist
+	Equals string `mapstructure:"equals"`
+}
+
+// TLSConfig holds TLS certificate check configuration
+type TLSConfig struct {
+	Timeout time.Duration `mapstructure:"timeout"`
+	// CABundle is a PEM file of trusted roots for chain validation; the
+	// system roots are used when empty
+	CABundle string `mapstructure:"ca_bundle"`
+	// ExpiryWarningDays flags certificates that expire within this many days
+	ExpiryWarningDays int         `mapstructure:"expiry_warning_days"`
+	Targets           []TLSTarget `mapstructure:"targets"`
+}
+
+// TLSTarget is a TLS endpoint checked for every device matched by its
+// selector
+type TLSTarget struct {
+	DeviceSelector `mapstructure:",squash"`
+	Name           string `mapstructure:"name"`
+	Port           int    `mapstructure:"port"`
+	// StartTLS is "smtp", "imap" or "ldap" to upgrade a plaintext session,
+	// or empty for implicit TLS
+	StartTLS string `mapstructure:"starttls"`
+	// ServerName is used for SNI and hostname verification; the device
+	// hostname is used when empty
+	ServerName string `mapstructure:"server_name"`
 }
 
 // SNMPConfig holds SNMP client configuration
@@ -51,21 +307,498 @@ type SNMPConfig struct {
 	Version   string        `mapstructure:"version"`
 	Timeout   time.Duration `mapstructure:"timeout"`
 	Retries   int           `mapstructure:"retries"`
+
+	// V3 holds the USM credentials used when Version is "3"
+	V3 SNMPv3Config `mapstructure:"v3"`
+	// EngineCacheTTL is how long a discovered SNMPv3 engine ID, boots and
+	// time are reused before the agent is rediscovered
+	EngineCacheTTL time.Duration `mapstructure:"engine_cache_ttl"`
+	// Overrides replace the version and credentials for matching devices.
+	// The first matching override wins.
+	Overrides []SNMPOverride `mapstructure:"overrides"`
+
+	// Interfaces selects the interfaces whose metrics are collected
+	Interfaces SNMPInterfaceFilter `mapstructure:"interfaces"`
+
+	// ProfilesDir is a directory of YAML metric profiles (*.yaml, *.yml)
+	// collected from the devices they match. Changed files are picked up
+	// without a restart.
+	ProfilesDir string `mapstructure:"profiles_dir"`
+}
+
+// SNMPInterfaceFilter selects interfaces by regular expressions on their
+// name (ifName, or ifDescr when the agent has none), type and alias. Each
+// non-empty include list must contain a match, and an interface matching
+// any exclude pattern is skipped. An empty filter selects every interface.
+type SNMPInterfaceFilter struct {
+	IncludeNames []string `mapstructure:"include_names"`
+	ExcludeNames []string `mapstructure:"exclude_names"`
+	// Types are matched against IANAifType names such as "ethernetCsmacd",
+	// or the type number when it has no known name
+	IncludeTypes   []string `mapstructure:"include_types"`
+	ExcludeTypes   []string `mapstructure:"exclude_types"`
+	IncludeAliases []string `mapstructure:"include_aliases"`
+	ExcludeAliases []string `mapstructure:"exclude_aliases"`
+}
+
+// SNMP security levels
+const (
+	SNMPNoAuthNoPriv = "noAuthNoPriv"
+	SNMPAuthNoPriv   = "authNoPriv"
+	SNMPAuthPriv     = "authPriv"
+)
+
+// SNMPv3Config holds SNMPv3 User-based Security Model credentials
+type SNMPv3Config struct {
+	Username string `mapstructure:"username"`
+	// SecurityLevel is "noAuthNoPriv", "authNoPriv" or "authPriv"; empty
+	// means authPriv
+	SecurityLevel string `mapstructure:"security_level"`
+	// AuthProtocol is MD5, SHA, SHA224, SHA256, SHA384 or SHA512
+	AuthProtocol   string `mapstructure:"auth_protocol"`
+	AuthPassphrase string `mapstructure:"auth_passphrase"`
+	// PrivProtocol is DES, AES (AES128), AES192, AES256, or AES192C/AES256C
+	// for the Cisco-style key extension
+	PrivProtocol   string `mapstructure:"priv_protocol"`
+	PrivPassphrase string `mapstructure:"priv_passphrase"`
+	ContextName    string `mapstructure:"context_name"`
+}
+
+// SNMPOverride replaces SNMP settings for matching devices. Version and
+// Community replace the global values when set; V3 replaces the global
+// credentials as a whole when its Username is set.
+type SNMPOverride struct {
+	DeviceSelector `mapstructure:",squash"`
+	Version        string       `mapstructure:"version"`
+	Community      string       `mapstructure:"community"`
+	V3             SNMPv3Config `mapstructure:"v3"`
+}
+
+// snmpAuthProtocols and snmpPrivProtocols are the supported USM protocols
+var (
+	snmpAuthProtocols = []string{"MD5", "SHA", "SHA224", "SHA256", "SHA384", "SHA512"}
+	snmpPrivProtocols = []string{"DES", "AES", "AES128", "AES192", "AES256", "AES192C", "AES256C"}
+)
+
+// validateSNMPVersion checks an SNMP version and, for v3, its credentials
+func validateSNMPVersion(version string, v3 SNMPv3Config) error {
+	switch version {
+	case "", "1", "2c":
+		return nil
+	case "3":
+		return validateSNMPv3(v3)
+	default:
+		return fmt.Errorf("unsupported SNMP version %q", version)
+	}
+}
+
+// validateSNMPv3 checks that the credentials required by the security level
+// are present and use supported protocols
+func validateSNMPv3(v3 SNMPv3Config) error {
+	if v3.Username == "" {
+		return fmt.Errorf("SNMPv3 username is required")
+	}
+
+	level := v3.SecurityLevel
+	if level == "" {
+		level = SNMPAuthPriv
+	}
+	switch level {
+	case SNMPNoAuthNoPriv:
+		return nil
+	case SNMPAuthNoPriv, SNMPAuthPriv:
+	default:
+		return fmt.Errorf("unsupported SNMPv3 security level %q", level)
+	}
+
+	if !containsString(snmpAuthProtocols, strings.ToUpper(v3.AuthProtocol)) {
+		return fmt.Errorf("unsupported SNMPv3 auth protocol %q", v3.AuthProtocol)
+	}
+	// RFC 3414 requires passphrases of at least 8 characters; referenced
+	// secrets are checked when they are resolved
+	if len(v3.AuthPassphrase) < 8 && !IsSecretReference(v3.AuthPassphrase) {
+		return fmt.Errorf("SNMPv3 auth passphrase must be at least 8 characters")
+	}
+	if level == SNMPAuthNoPriv {
+		return nil
+	}
+
+	if !containsString(snmpPrivProtocols, strings.ToUpper(v3.PrivProtocol)) {
+		return fmt.Errorf("unsupported SNMPv3 privacy protocol %q", v3.PrivProtocol)
+	}
+	if len(v3.PrivPassphrase) < 8 && !IsSecretReference(v3.PrivPassphrase) {
+		return fmt.Errorf("SNMPv3 privacy passphrase must be at least 8 characters")
+	}
+	return nil
 }
 
+// SSH host key policies
+const (
+	// SSHHostKeyStrict only accepts host keys recorded in known_hosts
+	SSHHostKeyStrict = "strict"
+	// SSHHostKeyTOFU also accepts and records the key of a host without an
+	// entry (trust on first use)
+	SSHHostKeyTOFU = "tofu"
+	// SSHHostKeyInsecure skips host key verification
+	SSHHostKeyInsecure = "insecure"
+)
+
+// DefaultKnownHostsFile is used when ssh.known_hosts_file is not configured
+const DefaultKnownHostsFile = "/etc/collector/known_hosts"
+
 // SSHConfig holds SSH client configuration
 type SSHConfig struct {
 	Username string        `mapstructure:"username"`
 	Password string        `mapstructure:"password"`
 	KeyFile  string        `mapstructure:"key_file"`
 	Timeout  time.Duration `mapstructure:"timeout"`
+
+	// KnownHostsFile holds trusted host keys in OpenSSH known_hosts format
+	KnownHostsFile string `mapstructure:"known_hosts_file"`
+	// HostKeyPolicy is "strict", "tofu" or "insecure". If unset, it is
+	// strict when KnownHostsFile is configured and tofu otherwise, so that
+	// collectors upgraded from releases without host key verification keep
+	// polling and populate DefaultKnownHostsFile instead of rejecting every
+	// host. Set it to strict once the file holds every monitored host.
+	HostKeyPolicy string `mapstructure:"host_key_policy"`
+
+	// Port is the SSH port of monitored devices, 22 if unset
+	Port int `mapstructure:"port"`
+	// KeepaliveInterval is how often idle pooled connections are probed
+	KeepaliveInterval time.Duration `mapstructure:"keepalive_interval"`
+	// IdleTimeout closes pooled connections unused for this long; zero
+	// disables pooling
+	IdleTimeout time.Duration `mapstructure:"idle_timeout"`
+
+	// Checks are custom commands run alongside the built-in metrics
+	Checks []SSHCheck `mapstructure:"checks"`
 }
 
-// WMIConfig holds WMI client configuration
+// SSH check output parsers
+const (
+	SSHParserNumber   = "number"
+	SSHParserKeyValue = "keyvalue"
+	SSHParserJSON     = "json"
+	SSHParserRegex    = "regex"
+	SSHParserNagios   = "nagios"
+)
+
+// SSHCheck is a command run on every device matched by its selector, whose
+// output is parsed into a metric
+type SSHCheck struct {
+	DeviceSelector `mapstructure:",squash"`
+	Name           string `mapstructure:"name"`
+	Command        string `mapstructure:"command"`
+	// Parser is "number", "keyvalue", "json", "regex" or "nagios"
+	Parser string `mapstructure:"parser"`
+	// Measurement is the metric name; the check name is used when empty
+	Measurement string `mapstructure:"measurement"`
+	// Field names the value of the number parser, "value" when empty
+	Field string `mapstructure:"field"`
+	// Pattern is the regular expression of the regex parser. Each named
+	// group becomes a field.
+	Pattern string `mapstructure:"pattern"`
+}
+
+// WinRM authentication methods
+const (
+	WinRMAuthNTLM  = "ntlm"
+	WinRMAuthBasic = "basic"
+)
+
+// WMIConfig holds WMI client configuration. WMI is queried over WinRM
+// (WS-Management), which must be enabled on monitored hosts.
 type WMIConfig struct {
 	Username string        `mapstructure:"username"`
 	Password string        `mapstructure:"password"`
 	Timeout  time.Duration `mapstructure:"timeout"`
+
+	// Port is the WinRM port, 5985 for HTTP or 5986 for HTTPS if unset
+	Port  int  `mapstructure:"port"`
+	HTTPS bool `mapstructure:"https"`
+	// InsecureSkipVerify disables certificate verification over HTTPS
+	InsecureSkipVerify bool `mapstructure:"insecure_skip_verify"`
+	// AuthMethod is "ntlm" or "basic". Over HTTP, both require the host to
+	// allow unencrypted WinRM traffic.
+	AuthMethod string `mapstructure:"auth_method"`
+
+	// Services are Windows services whose state is reported
+	Services []WMIServiceCheck `mapstructure:"services"`
+	// EventLogs are event logs whose new entries are counted every poll
+	EventLogs []WMIEventLog `mapstructure:"event_logs"`
+	// Counters are performance counter classes collected as metrics
+	Counters []WMICounter `mapstructure:"counters"`
+}
+
+// WMIServiceCheck reports the state and start mode of named services on the
+// devices matched by its selector
+type WMIServiceCheck struct {
+	DeviceSelector `mapstructure:",squash"`
+	// Names are service names, such as "W3SVC", not display names
+	Names []string `mapstructure:"names"`
+}
+
+// Event log levels, the entry types of Win32_NTLogEvent
+const (
+	EventLevelError        = "error"
+	EventLevelWarning      = "warning"
+	EventLevelInformation  = "information"
+	EventLevelAuditSuccess = "audit_success"
+	EventLevelAuditFailure = "audit_failure"
+)
+
+// eventLevels lists the supported event log levels
+var eventLevels = []string{
+	EventLevelError, EventLevelWarning, EventLevelInformation,
+	EventLevelAuditSuccess, EventLevelAuditFailure,
+}
+
+// WMIEventLog counts the entries written to an event log since the previous
+// poll, by level and source
+type WMIEventLog struct {
+	DeviceSelector `mapstructure:",squash"`
+	// Log is the log file name, such as "System" or "Application"
+	Log string `mapstructure:"log"`
+	// Levels limits the counted entries to these levels; all if empty
+	Levels []string `mapstructure:"levels"`
+	// Sources limits the counted entries to these sources; all if empty
+	Sources []string `mapstructure:"sources"`
+}
+
+// WMICounter collects a Win32_PerfFormattedData class, with one metric per
+// instance. Properties are reported under snake_case names, so
+// CurrentDiskQueueLength becomes current_disk_queue_length.
+type WMICounter struct {
+	DeviceSelector `mapstructure:",squash"`
+	// Class is the counter class, such as
+	// "Win32_PerfFormattedData_PerfDisk_PhysicalDisk"
+	Class string `mapstructure:"class"`
+	// Measurement is the metric name; the class name without its
+	// Win32_PerfFormattedData_ prefix is used when empty
+	Measurement string `mapstructure:"measurement"`
+	// Fields are the numeric properties reported as fields
+	Fields []string `mapstructure:"fields"`
+	// Tags are the properties reported as tags, such as "Name"
+	Tags []string `mapstructure:"tags"`
+	// Where is an optional WQL condition, such as "Name != '_Total'"
+	Where string `mapstructure:"where"`
+}
+
+// WMICounterPrefix is the prefix of the formatted performance counter
+// classes
+const WMICounterPrefix = "Win32_PerfFormattedData_"
+
+// validWMIName reports whether name is a valid WMI class or property name,
+// which is also what keeps counter queries well-formed
+func validWMIName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		if r != '_' && !(r >= 'a' && r <= 'z') && !(r >= 'A' && r <= 'Z') && !(i > 0 && r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// validateWMIChecks checks the service, event log and counter checks
+func validateWMIChecks(cfg WMIConfig) error {
+	for i, check := range cfg.Services {
+		if len(check.Names) == 0 {
+			return fmt.Errorf("WMI service check %d must name at least one service", i)
+		}
+		for _, name := range check.Names {
+			if strings.TrimSpace(name) == "" {
+				return fmt.Errorf("WMI service check %d has an empty service name", i)
+			}
+		}
+	}
+	for i, eventLog := range cfg.EventLogs {
+		if eventLog.Log == "" {
+			return fmt.Errorf("WMI event log %d must have a log name", i)
+		}
+		for _, level := range eventLog.Levels {
+			if !containsString(eventLevels, level) {
+				return fmt.Errorf("WMI event log %q has unsupported level %q", eventLog.Log, level)
+			}
+		}
+	}
+	for i, counter := range cfg.Counters {
+		if !strings.HasPrefix(counter.Class, WMICounterPrefix) || !validWMIName(counter.Class) {
+			return fmt.Errorf("WMI counter %d must have a %s class", i, WMICounterPrefix)
+		}
+		if len(counter.Fields) == 0 {
+			return fmt.Errorf("WMI counter %q must have at least one field", counter.Class)
+		}
+		for _, property := range append(append([]string(nil), counter.Fields...), counter.Tags...) {
+			if !validWMIName(property) {
+				return fmt.Errorf("WMI counter %q has invalid property %q", counter.Class, property)
+			}
+		}
+	}
+	return nil
+}
+
+// SecretsConfig configures how secret references are resolved. Passwords,
+// communities, passphrases and tokens may be given as "file:///path",
+// "env:NAME", "keystore:name" or "vault:path#field" instead of plain values.
+type SecretsConfig struct {
+	// RefreshInterval is how long a resolved secret is cached before it is
+	// read again, which is how rotated secrets are picked up
+	RefreshInterval time.Duration  `mapstructure:"refresh_interval"`
+	Keystore        KeystoreConfig `mapstructure:"keystore"`
+	Vault           VaultConfig    `mapstructure:"vault"`
+}
+
+// KeystoreConfig locates the encrypted local keystore and its passphrase
+type KeystoreConfig struct {
+	Path string `mapstructure:"path"`
+	// PassphraseFile takes precedence over PassphraseEnv
+	PassphraseFile string `mapstructure:"passphrase_file"`
+	PassphraseEnv  string `mapstructure:"passphrase_env"`
+}
+
+// VaultConfig holds the connection to a Vault-compatible KV version 2
+// secrets engine. Address and Token fall back to VAULT_ADDR and VAULT_TOKEN.
+type VaultConfig struct {
+	Address string        `mapstructure:"address"`
+	Token   string        `mapstructure:"token"`
+	Mount   string        `mapstructure:"mount"`
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// secretSchemes are the prefixes of the built-in secret references
+var secretSchemes = []string{"file:", "env:", "keystore:", "vault:"}
+
+// IsSecretReference reports whether value refers to a secret held elsewhere
+// rather than being the secret itself
+func IsSecretReference(value string) bool {
+	for _, scheme := range secretSchemes {
+		if strings.HasPrefix(value, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// CredentialsConfig holds named credential profiles and the devices they
+// are assigned to. Devices without an assignment use the credentials of the
+// snmp, ssh and wmi sections.
+type CredentialsConfig struct {
+	Profiles map[string]CredentialProfile `mapstructure:"profiles"`
+	// Assignments are evaluated in order and the first match wins
+	Assignments []CredentialAssignment `mapstructure:"assignments"`
+}
+
+// CredentialProfile is a named set of credentials. A profile may hold
+// credentials for any subset of protocols; it is skipped for the others.
+type CredentialProfile struct {
+	SNMP SNMPCredentials `mapstructure:"snmp"`
+	SSH  SSHCredentials  `mapstructure:"ssh"`
+	WMI  WMICredentials  `mapstructure:"wmi"`
+}
+
+// SNMPCredentials holds the SNMP version and community or USM user of a
+// profile. An empty Version uses the global SNMP version.
+type SNMPCredentials struct {
+	Version   string       `mapstructure:"version"`
+	Community string       `mapstructure:"community"`
+	V3        SNMPv3Config `mapstructure:"v3"`
+}
+
+// Configured reports whether the profile holds SNMP credentials
+func (c SNMPCredentials) Configured() bool {
+	return c.Community != "" || c.V3.Username != ""
+}
+
+// SSHCredentials holds an SSH username with a password or private key
+type SSHCredentials struct {
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	KeyFile  string `mapstructure:"key_file"`
+}
+
+// Configured reports whether the profile holds SSH credentials
+func (c SSHCredentials) Configured() bool {
+	return c.Username != ""
+}
+
+// WMICredentials holds a Windows username and password
+type WMICredentials struct {
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+}
+
+// Configured reports whether the profile holds WMI credentials
+func (c WMICredentials) Configured() bool {
+	return c.Username != ""
+}
+
+// CredentialAssignment assigns an ordered list of profiles to the devices
+// matched by its selector and, when set, whose IP address is in one of
+// Subnets. The profiles are tried in order until one succeeds.
+type CredentialAssignment struct {
+	DeviceSelector `mapstructure:",squash"`
+	// Subnets are CIDR blocks such as "10.1.0.0/16"
+	Subnets  []string `mapstructure:"subnets"`
+	Profiles []string `mapstructure:"profiles"`
+}
+
+// Matches reports whether a device with the given attributes and IP
+// address is assigned. Subnets must already have been validated.
+func (a CredentialAssignment) Matches(deviceID, deviceType, ipAddress string, tags []string) bool {
+	if !a.DeviceSelector.Matches(deviceID, deviceType, tags) {
+		return false
+	}
+	if len(a.Subnets) == 0 {
+		return true
+	}
+
+	ip := net.ParseIP(ipAddress)
+	if ip == nil {
+		return false
+	}
+	for _, subnet := range a.Subnets {
+		if _, network, err := net.ParseCIDR(subnet); err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateCredentials checks that assignments reference known profiles and
+// valid subnets, and that SNMP profiles use valid versions
+func validateCredentials(creds CredentialsConfig, snmpVersion string) error {
+	for name, profile := range creds.Profiles {
+		if !profile.SNMP.Configured() {
+			continue
+		}
+		version := profile.SNMP.Version
+		if version == "" {
+			version = snmpVersion
+		}
+		if err := validateSNMPVersion(version, profile.SNMP.V3); err != nil {
+			return fmt.Errorf("credential profile %q: snmp: %w", name, err)
+		}
+	}
+
+	for i, assignment := range creds.Assignments {
+		if len(assignment.Profiles) == 0 {
+			return fmt.Errorf("credential assignment %d has no profiles", i)
+		}
+		for _, name := range assignment.Profiles {
+			if _, ok := creds.Profiles[name]; !ok {
+				return fmt.Errorf("credential assignment %d references unknown profile %q", i, name)
+			}
+		}
+		for _, subnet := range assignment.Subnets {
+			if _, _, err := net.ParseCIDR(subnet); err != nil {
+				return fmt.Errorf("credential assignment %d has invalid subnet %q", i, subnet)
+			}
+		}
+	}
+	return nil
 }
 
 // Load reads configuration from file and environment variables
@@ -77,17 +810,73 @@ func Load() (*Config, error) {
 	viper.SetDefault("collection_timeout", "30s")
 	viper.SetDefault("log_level", "info")
 
+	// Polling schedule defaults
+	viper.SetDefault("polling.jitter", 0.1)
+	viper.SetDefault("polling.device_refresh_interval", "1m")
+	viper.SetDefault("polling.full_refresh_interval", "10m")
+
+	// Worker pool defaults
+	viper.SetDefault("workers.ping", 64)
+	viper.SetDefault("workers.port", 64)
+	viper.SetDefault("workers.snmp", 32)
+	viper.SetDefault("workers.ssh", 16)
+	viper.SetDefault("workers.wmi", 8)
+	viper.SetDefault("workers.queue_size", 1024)
+
+	// State machine defaults
+	viper.SetDefault("state.failures_to_down", 3)
+	viper.SetDefault("state.successes_to_up", 2)
+
+	// Status write-back defaults
+	viper.SetDefault("status_writeback.enabled", true)
+	viper.SetDefault("status_writeback.flush_interval", "30s")
+	viper.SetDefault("status_writeback.heartbeat_interval", "5m")
+
+	// Ping defaults
+	viper.SetDefault("ping.mode", "auto")
+	viper.SetDefault("ping.count", 5)
+	viper.SetDefault("ping.interval", "200ms")
+	viper.SetDefault("ping.timeout", "2s")
+	viper.SetDefault("ping.size", 56)
+
+	// Port probe defaults
+	viper.SetDefault("port_probe.timeout", "3s")
+	viper.SetDefault("port_probe.tcp_ports", []int{22, 80, 443})
+	viper.SetDefault("port_probe.snmp_community", "public")
+
+	// Reachability defaults
+	viper.SetDefault("reachability.method", ReachabilityPing)
+
+	// HTTP check defaults
+	viper.SetDefault("http.timeout", "10s")
+	viper.SetDefault("http.max_body_bytes", 1<<20)
+
+	// TLS check defaults
+	viper.SetDefault("tls.timeout", "10s")
+	viper.SetDefault("tls.expiry_warning_days", 30)
+
 	// SNMP defaults
 	viper.SetDefault("snmp.community", "public")
 	viper.SetDefault("snmp.version", "2c")
 	viper.SetDefault("snmp.timeout", "5s")
 	viper.SetDefault("snmp.retries", 3)
+	viper.SetDefault("snmp.engine_cache_ttl", "1h")
 
 	// SSH defaults
 	viper.SetDefault("ssh.timeout", "10s")
+	viper.SetDefault("ssh.port", 22)
+	viper.SetDefault("ssh.keepalive_interval", "30s")
+	viper.SetDefault("ssh.idle_timeout", "5m")
 
 	// WMI defaults
 	viper.SetDefault("wmi.timeout", "10s")
+	viper.SetDefault("wmi.auth_method", WinRMAuthNTLM)
+
+	// Secret provider defaults
+	viper.SetDefault("secrets.refresh_interval", "5m")
+	viper.SetDefault("secrets.keystore.passphrase_env", "COLLECTOR_KEYSTORE_PASSPHRASE")
+	viper.SetDefault("secrets.vault.mount", "secret")
+	viper.SetDefault("secrets.vault.timeout", "10s")
 
 	// Read from config file if it exists
 	viper.SetConfigName("config")
@@ -116,6 +905,7 @@ func Load() (*Config, error) {
 	if err := viper.Unmarshal(&config); err != nil {
 		return nil, fmt.Errorf("error unmarshaling config: %w", err)
 	}
+	applySSHHostKeyDefaults(&config.SSH)
 
 	// Validate required configuration
 	if err := validateConfig(&config); err != nil {
@@ -125,6 +915,22 @@ func Load() (*Config, error) {
 	return &config, nil
 }
 
+// applySSHHostKeyDefaults resolves an unset host key policy. Strict
+// verification is kept for an explicitly configured known_hosts file, which
+// is expected to hold the monitored hosts; with the default file, host keys
+// are trusted on first use and recorded.
+func applySSHHostKeyDefaults(cfg *SSHConfig) {
+	if cfg.KnownHostsFile == "" {
+		cfg.KnownHostsFile = DefaultKnownHostsFile
+		if cfg.HostKeyPolicy == "" {
+			cfg.HostKeyPolicy = SSHHostKeyTOFU
+		}
+	}
+	if cfg.HostKeyPolicy == "" {
+		cfg.HostKeyPolicy = SSHHostKeyStrict
+	}
+}
+
 // validateConfig ensures all required configuration is present
 func validateConfig(config *Config) error {
 	if config.InfluxDB.URL == "" {
@@ -145,6 +951,119 @@ func validateConfig(config *Config) error {
 	if config.MetricsPollInterval <= 0 {
 		return fmt.Errorf("metrics poll interval must be greater than zero")
 	}
+	if config.Polling.Jitter < 0 || config.Polling.Jitter > 1 {
+		return fmt.Errorf("polling jitter must be between 0 and 1")
+	}
+	for i, override := range config.Polling.Overrides {
+		if _, ok := config.Polling.Classes[override.Class]; !ok {
+			return fmt.Errorf("polling override %d references unknown class %q", i, override.Class)
+		}
+	}
+	for name, class := range config.Polling.Classes {
+		if class.StatusInterval < 0 || class.MetricsInterval < 0 {
+			return fmt.Errorf("polling class %q intervals cannot be negative", name)
+		}
+	}
+	if config.State.FailuresToDown < 0 || config.State.SuccessesToUp < 0 {
+		return fmt.Errorf("state thresholds cannot be negative")
+	}
+	if config.StatusWriteback.Enabled && config.StatusWriteback.FlushInterval <= 0 {
+		return fmt.Errorf("status write-back flush interval must be greater than zero")
+	}
+	switch config.Ping.Mode {
+	case "", "auto", "privileged", "unprivileged":
+	default:
+		return fmt.Errorf("ping mode must be auto, privileged or unprivileged")
+	}
+	if !validReachabilityMethod(config.Reachability.Method) {
+		return fmt.Errorf("unknown reachability method %q", config.Reachability.Method)
+	}
+	for i, override := range config.Reachability.Overrides {
+		if !validReachabilityMethod(override.Method) {
+			return fmt.Errorf("reachability override %d has unknown method %q", i, override.Method)
+		}
+	}
+	for i, target := range config.HTTP.Targets {
+		if target.URL == "" {
+			return fmt.Errorf("HTTP target %d must have a URL", i)
+		}
+		if target.Username != "" && target.BearerToken != "" {
+			return fmt.Errorf("HTTP target %d cannot use both basic and bearer auth", i)
+		}
+	}
+	for i, target := range config.TLS.Targets {
+		if target.Port < 1 || target.Port > 65535 {
+			return fmt.Errorf("TLS target %d has invalid port %d", i, target.Port)
+		}
+		switch target.StartTLS {
+		case "", "smtp", "imap", "ldap":
+		default:
+			return fmt.Errorf("TLS target %d has unsupported STARTTLS protocol %q", i, target.StartTLS)
+		}
+	}
+	if err := validateSNMPVersion(config.SNMP.Version, config.SNMP.V3); err != nil {
+		return fmt.Errorf("snmp: %w", err)
+	}
+	for i, override := range config.SNMP.Overrides {
+		v3 := config.SNMP.V3
+		if override.V3.Username != "" {
+			v3 = override.V3
+		}
+		version := override.Version
+		if version == "" {
+			version = config.SNMP.Version
+		}
+		if err := validateSNMPVersion(version, v3); err != nil {
+			return fmt.Errorf("snmp override %d: %w", i, err)
+		}
+	}
+	switch config.SSH.HostKeyPolicy {
+	case "", SSHHostKeyStrict, SSHHostKeyTOFU, SSHHostKeyInsecure:
+	default:
+		return fmt.Errorf("SSH host key policy must be strict, tofu or insecure")
+	}
+	if config.SSH.Port < 0 || config.SSH.Port > 65535 {
+		return fmt.Errorf("SSH port must be between 1 and 65535")
+	}
+	switch config.WMI.AuthMethod {
+	case "", WinRMAuthNTLM, WinRMAuthBasic:
+	default:
+		return fmt.Errorf("WMI auth method must be ntlm or basic")
+	}
+	if config.WMI.Port < 0 || config.WMI.Port > 65535 {
+		return fmt.Errorf("WMI port must be between 1 and 65535")
+	}
+	if err := validateWMIChecks(config.WMI); err != nil {
+		return err
+	}
+	checkNames := make(map[string]bool)
+	for i, check := range config.SSH.Checks {
+		if check.Name == "" || check.Command == "" {
+			return fmt.Errorf("SSH check %d must have a name and a command", i)
+		}
+		if checkNames[check.Name] {
+			return fmt.Errorf("SSH check %q is defined more than once", check.Name)
+		}
+		checkNames[check.Name] = true
+		switch check.Parser {
+		case SSHParserNumber, SSHParserKeyValue, SSHParserJSON, SSHParserNagios:
+		case SSHParserRegex:
+			if check.Pattern == "" {
+				return fmt.Errorf("SSH check %q must have a pattern", check.Name)
+			}
+		default:
+			return fmt.Errorf("SSH check %q has unsupported parser %q", check.Name, check.Parser)
+		}
+	}
+	if err := validateCredentials(config.Credentials, config.SNMP.Version); err != nil {
+		return err
+	}
+	if config.Secrets.RefreshInterval < 0 {
+		return fmt.Errorf("secret refresh interval cannot be negative")
+	}
+	if config.Workers.Ping < 0 || config.Workers.Port < 0 || config.Workers.SNMP < 0 || config.Workers.SSH < 0 || config.Workers.WMI < 0 {
+		return fmt.Errorf("worker pool sizes cannot be negative")
+	}
 
 	return nil
 }
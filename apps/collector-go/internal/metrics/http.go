@@ -0,0 +1,482 @@
+package metrics
+
+import (
+	"collector/internal/config"
+	"collector/internal/secrets"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTPCollector checks HTTP(S) endpoints and records request timings,
+// status codes and the outcome of body assertions
+type HTTPCollector struct {
+	config   config.HTTPConfig
+	targets  []httpTarget
+	secure   *http.Client
+	insecure *http.Client
+	secrets  *secrets.Resolver
+}
+
+// httpTarget is a configured target with its assertions compiled
+type httpTarget struct {
+	config.HTTPTarget
+	name      string
+	method    string
+	statuses  []statusRange
+	bodyRegex *regexp.Regexp
+}
+
+// statusRange is an inclusive range of accepted status codes
+type statusRange struct {
+	min, max int
+}
+
+// NewHTTPCollector creates a new HTTPCollector
+func NewHTTPCollector(cfg config.HTTPConfig) (*HTTPCollector, error) {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.MaxBodyBytes <= 0 {
+		cfg.MaxBodyBytes = 1 << 20
+	}
+
+	targets := make([]httpTarget, 0, len(cfg.Targets))
+	for i, target := range cfg.Targets {
+		compiled, err := compileHTTPTarget(target)
+		if err != nil {
+			return nil, fmt.Errorf("HTTP target %d: %w", i, err)
+		}
+		targets = append(targets, compiled)
+	}
+
+	return &HTTPCollector{
+		config:   cfg,
+		targets:  targets,
+		secure:   newHTTPClient(false),
+		insecure: newHTTPClient(true),
+	}, nil
+}
+
+// UseSecrets resolves passwords and bearer tokens given as secret
+// references with r
+func (c *HTTPCollector) UseSecrets(r *secrets.Resolver) {
+	c.secrets = r
+}
+
+// newHTTPClient creates a client that opens a new connection for every
+// request, so that DNS, connect and TLS timings are measured on every check
+func newHTTPClient(insecureSkipVerify bool) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:             http.ProxyFromEnvironment,
+			DisableKeepAlives: true,
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: insecureSkipVerify},
+		},
+	}
+}
+
+// compileHTTPTarget validates a target and compiles its assertions
+func compileHTTPTarget(target config.HTTPTarget) (httpTarget, error) {
+	compiled := httpTarget{
+		HTTPTarget: target,
+		name:       target.Name,
+		method:     strings.ToUpper(target.Method),
+	}
+	if compiled.name == "" {
+		compiled.name = target.URL
+	}
+	if compiled.method == "" {
+		compiled.method = http.MethodGet
+	}
+	if target.URL == "" {
+		return compiled, fmt.Errorf("URL cannot be empty")
+	}
+
+	expected := target.ExpectedStatus
+	if len(expected) == 0 {
+		expected = []string{"200-399"}
+	}
+	for _, spec := range expected {
+		r, err := parseStatusRange(spec)
+		if err != nil {
+			return compiled, err
+		}
+		compiled.statuses = append(compiled.statuses, r)
+	}
+
+	if target.BodyRegex != "" {
+		re, err := regexp.Compile(target.BodyRegex)
+		if err != nil {
+			return compiled, fmt.Errorf("invalid body regex: %w", err)
+		}
+		compiled.bodyRegex = re
+	}
+
+	return compiled, nil
+}
+
+// parseStatusRange parses "200", "200-299" or "2xx"
+func parseStatusRange(spec string) (statusRange, error) {
+	spec = strings.ToLower(strings.TrimSpace(spec))
+
+	if len(spec) == 3 && strings.HasSuffix(spec, "xx") && spec[0] >= '1' && spec[0] <= '5' {
+		base := int(spec[0]-'0') * 100
+		return statusRange{min: base, max: base + 99}, nil
+	}
+
+	lo, hi := spec, spec
+	if i := strings.Index(spec, "-"); i >= 0 {
+		lo, hi = spec[:i], spec[i+1:]
+	}
+	min, err1 := strconv.Atoi(lo)
+	max, err2 := strconv.Atoi(hi)
+	if err1 != nil || err2 != nil || min < 100 || max > 599 || min > max {
+		return statusRange{}, fmt.Errorf("invalid expected status %q", spec)
+	}
+	return statusRange{min: min, max: max}, nil
+}
+
+// Collect checks every configured target against ipAddress
+func (c *HTTPCollector) Collect(ctx context.Context, ipAddress string) ([]Metric, error) {
+	return c.collect(ctx, ipAddress, "", c.targets)
+}
+
+// ForDevice returns a MetricCollector that checks the targets whose
+// selector matches the device, or nil when none does
+func (c *HTTPCollector) ForDevice(deviceID, deviceType, hostname string, tags []string) MetricCollector {
+	var matched []httpTarget
+	for _, target := range c.targets {
+		if target.Matches(deviceID, deviceType, tags) {
+			matched = append(matched, target)
+		}
+	}
+	if len(matched) == 0 {
+		return nil
+	}
+	return &boundHTTPCheck{collector: c, hostname: hostname, targets: matched}
+}
+
+// boundHTTPCheck is an HTTPCollector bound to the targets of one device
+type boundHTTPCheck struct {
+	collector *HTTPCollector
+	hostname  string
+	targets   []httpTarget
+}
+
+// Collect checks the bound targets
+func (b *boundHTTPCheck) Collect(ctx context.Context, ipAddress string) ([]Metric, error) {
+	return b.collector.collect(ctx, ipAddress, b.hostname, b.targets)
+}
+
+// collect checks targets concurrently. Failed checks are reported as
+// metrics with success=false rather than as an error.
+func (c *HTTPCollector) collect(ctx context.Context, ipAddress, hostname string, targets []httpTarget) ([]Metric, error) {
+	if ipAddress == "" {
+		return nil, fmt.Errorf("IP address cannot be empty")
+	}
+
+	metrics := make([]Metric, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target httpTarget) {
+			defer wg.Done()
+			metrics[i] = c.check(ctx, target, ipAddress, hostname)
+		}(i, target)
+	}
+	wg.Wait()
+
+	return metrics, nil
+}
+
+// httpTimings are the phases of a single request. Parallel dials to
+// several addresses can report concurrently, hence the lock.
+type httpTimings struct {
+	mu                        sync.Mutex
+	dnsStart, dnsDone         time.Time
+	connectStart, connectDone time.Time
+	tlsStart, tlsDone         time.Time
+	firstByte                 time.Time
+}
+
+// trace returns a client trace that records the timings. Only the first
+// occurrence of each phase is kept so that redirects do not overwrite them.
+func (t *httpTimings) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { t.mark(&t.dnsStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { t.mark(&t.dnsDone) },
+		ConnectStart:         func(string, string) { t.mark(&t.connectStart) },
+		ConnectDone:          func(string, string, error) { t.mark(&t.connectDone) },
+		TLSHandshakeStart:    func() { t.mark(&t.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { t.mark(&t.tlsDone) },
+		GotFirstResponseByte: func() { t.mark(&t.firstByte) },
+	}
+}
+
+// mark records the current time in field unless it is already set
+func (t *httpTimings) mark(field *time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if field.IsZero() {
+		*field = time.Now()
+	}
+}
+
+// check performs a single request and evaluates the target's assertions
+func (c *HTTPCollector) check(ctx context.Context, target httpTarget, ipAddress, hostname string) Metric {
+	url := expandTargetURL(target.URL, ipAddress, hostname)
+	metric := Metric{
+		Name:      "http_check",
+		Value:     map[string]interface{}{},
+		Timestamp: time.Now(),
+		Tags: map[string]string{
+			"ip_address": ipAddress,
+			"target":     target.name,
+			"url":        url,
+			"method":     target.method,
+		},
+	}
+
+	fail := func(err error) Metric {
+		metric.Value["success"] = false
+		metric.Value["error"] = err.Error()
+		return metric
+	}
+
+	timeout := target.Timeout
+	if timeout <= 0 {
+		timeout = c.config.Timeout
+	}
+	checkCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var body io.Reader
+	if target.Body != "" {
+		body = strings.NewReader(target.Body)
+	}
+
+	var timings httpTimings
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(checkCtx, timings.trace()), target.method, url, body)
+	if err != nil {
+		return fail(fmt.Errorf("failed to create request: %w", err))
+	}
+	for name, value := range target.Headers {
+		req.Header.Set(name, value)
+	}
+	if target.Username != "" {
+		password, err := c.secrets.Resolve(ctx, target.Password)
+		if err != nil {
+			return fail(err)
+		}
+		req.SetBasicAuth(target.Username, password)
+	} else if target.BearerToken != "" {
+		token, err := c.secrets.Resolve(ctx, target.BearerToken)
+		if err != nil {
+			return fail(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := c.secure
+	if target.InsecureSkipVerify {
+		client = c.insecure
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		timings.addTo(metric.Value, start)
+		metric.Value["total_ms"] = msSince(start)
+		return fail(err)
+	}
+	defer resp.Body.Close()
+
+	// Only the first MaxBodyBytes are kept for assertions, the rest is
+	// counted so that size_bytes is the size of the whole body
+	content, err := io.ReadAll(io.LimitReader(resp.Body, c.config.MaxBodyBytes))
+	var rest int64
+	if err == nil {
+		rest, err = io.Copy(io.Discard, resp.Body)
+	}
+	total := msSince(start)
+	timings.addTo(metric.Value, start)
+	metric.Value["total_ms"] = total
+	metric.Value["status_code"] = resp.StatusCode
+	metric.Value["size_bytes"] = int64(len(content)) + rest
+	metric.Value["body_truncated"] = rest > 0
+	if err != nil {
+		return fail(fmt.Errorf("failed to read response body: %w", err))
+	}
+
+	success := target.statusOK(resp.StatusCode)
+	metric.Value["status_ok"] = success
+
+	if target.bodyRegex != nil {
+		matched := target.bodyRegex.Match(content)
+		metric.Value["body_match"] = matched
+		success = success && matched
+	}
+
+	if len(target.JSONAssertions) > 0 {
+		var failed int
+		var err error
+		if rest > 0 {
+			// A cut off document would only be reported as invalid JSON
+			failed = len(target.JSONAssertions)
+			err = fmt.Errorf("response body exceeds http.max_body_bytes (%d bytes), JSON assertions were not evaluated", c.config.MaxBodyBytes)
+		} else {
+			failed, err = evaluateJSONAssertions(content, target.JSONAssertions)
+		}
+		if err != nil {
+			metric.Value["error"] = err.Error()
+		}
+		metric.Value["json_assertions_failed"] = failed
+		success = success && failed == 0 && err == nil
+	}
+
+	metric.Value["success"] = success
+	return metric
+}
+
+// addTo stores the recorded phase durations in milliseconds. Phases that did
+// not happen, such as TLS for plain HTTP, are left out.
+func (t *httpTimings) addTo(value map[string]interface{}, start time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.dnsStart.IsZero() && !t.dnsDone.IsZero() {
+		value["dns_ms"] = msBetween(t.dnsStart, t.dnsDone)
+	}
+	if !t.connectStart.IsZero() && !t.connectDone.IsZero() {
+		value["connect_ms"] = msBetween(t.connectStart, t.connectDone)
+	}
+	if !t.tlsStart.IsZero() && !t.tlsDone.IsZero() {
+		value["tls_ms"] = msBetween(t.tlsStart, t.tlsDone)
+	}
+	if !t.firstByte.IsZero() {
+		value["ttfb_ms"] = msBetween(start, t.firstByte)
+	}
+}
+
+// statusOK reports whether code is one of the expected status codes
+func (t httpTarget) statusOK(code int) bool {
+	for _, r := range t.statuses {
+		if code >= r.min && code <= r.max {
+			return true
+		}
+	}
+	return false
+}
+
+// expandTargetURL fills in the {ip} and {hostname} placeholders. IPv6
+// addresses are bracketed so they can be used as a URL host.
+func expandTargetURL(url, ipAddress, hostname string) string {
+	host := ipAddress
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if hostname == "" {
+		hostname = host
+	}
+	return strings.NewReplacer("{ip}", host, "{hostname}", hostname).Replace(url)
+}
+
+// evaluateJSONAssertions returns how many assertions failed against body
+func evaluateJSONAssertions(body []byte, assertions []config.JSONAssertion) (int, error) {
+	var document interface{}
+	if err := json.Unmarshal(body, &document); err != nil {
+		return len(assertions), fmt.Errorf("response is not valid JSON: %w", err)
+	}
+
+	failed := 0
+	for _, assertion := range assertions {
+		value, ok := jsonPathLookup(document, assertion.Path)
+		if !ok || (assertion.Equals != "" && jsonString(value) != assertion.Equals) {
+			failed++
+		}
+	}
+	return failed, nil
+}
+
+// jsonPathLookup resolves a dotted path with optional array indexes, such
+// as "data.items[0].status". A leading "$." is ignored.
+func jsonPathLookup(document interface{}, path string) (interface{}, bool) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	current := document
+	if path == "" {
+		return current, true
+	}
+
+	for _, part := range strings.Split(path, ".") {
+		key := part
+		var indexes []int
+		if i := strings.Index(part, "["); i >= 0 {
+			key = part[:i]
+			for _, idx := range strings.Split(strings.TrimSuffix(part[i+1:], "]"), "][") {
+				n, err := strconv.Atoi(idx)
+				if err != nil {
+					return nil, false
+				}
+				indexes = append(indexes, n)
+			}
+		}
+
+		if key != "" {
+			object, ok := current.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if current, ok = object[key]; !ok {
+				return nil, false
+			}
+		}
+
+		for _, idx := range indexes {
+			array, ok := current.([]interface{})
+			if !ok || idx < 0 || idx >= len(array) {
+				return nil, false
+			}
+			current = array[idx]
+		}
+	}
+
+	return current, true
+}
+
+// jsonString formats a decoded JSON value for comparison with an assertion
+func jsonString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case nil:
+		return "null"
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		encoded, _ := json.Marshal(v)
+		return string(encoded)
+	}
+}
+
+// msBetween returns the duration between two times in milliseconds
+func msBetween(from, to time.Time) float64 {
+	return float64(to.Sub(from).Nanoseconds()) / 1e6
+}
+
+// msSince returns the time elapsed since t in milliseconds
+func msSince(t time.Time) float64 {
+	return msBetween(t, time.Now())
+}
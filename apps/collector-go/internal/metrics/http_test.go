@@ -0,0 +1,279 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"collector/internal/config"
+)
+
+func TestHTTPCollector_Collect(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"ok","checks":[{"name":"db","healthy":true}],"uptime":42}`))
+	})
+	mux.HandleFunc("/secure", func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); ok && user == "admin" && pass == "secret" {
+			w.Write([]byte("welcome"))
+			return
+		}
+		if r.Header.Get("Authorization") == "Bearer token123" {
+			w.Write([]byte("welcome"))
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Method + " " + r.Header.Get("X-Probe")))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// Targets use the {ip} placeholder with the test server's port
+	port := server.URL[strings.LastIndex(server.URL, ":")+1:]
+	base := "http://{ip}:" + port
+
+	tests := []struct {
+		name        string
+		target      config.HTTPTarget
+		wantSuccess bool
+		wantStatus  int
+		wantFields  []string
+	}{
+		{
+			name:        "status and timings",
+			target:      config.HTTPTarget{URL: base + "/health"},
+			wantSuccess: true,
+			wantStatus:  200,
+			wantFields:  []string{"connect_ms", "ttfb_ms", "total_ms", "size_bytes", "status_ok"},
+		},
+		{
+			name: "JSON assertions pass",
+			target: config.HTTPTarget{
+				URL: base + "/health",
+				JSONAssertions: []config.JSONAssertion{
+					{Path: "status", Equals: "ok"},
+					{Path: "$.checks[0].healthy", Equals: "true"},
+					{Path: "uptime", Equals: "42"},
+					{Path: "checks[0].name"},
+				},
+			},
+			wantSuccess: true,
+			wantStatus:  200,
+			wantFields:  []string{"json_assertions_failed"},
+		},
+		{
+			name: "JSON assertion fails",
+			target: config.HTTPTarget{
+				URL:            base + "/health",
+				JSONAssertions: []config.JSONAssertion{{Path: "status", Equals: "degraded"}},
+			},
+			wantStatus: 200,
+		},
+		{
+			name:        "body regex",
+			target:      config.HTTPTarget{URL: base + "/health", BodyRegex: `"status":\s*"ok"`},
+			wantSuccess: true,
+			wantStatus:  200,
+			wantFields:  []string{"body_match"},
+		},
+		{
+			name:       "unauthorized without credentials",
+			target:     config.HTTPTarget{URL: base + "/secure"},
+			wantStatus: 401,
+		},
+		{
+			name:        "expected status range accepts 401",
+			target:      config.HTTPTarget{URL: base + "/secure", ExpectedStatus: []string{"2xx", "401"}},
+			wantSuccess: true,
+			wantStatus:  401,
+		},
+		{
+			name:        "basic auth",
+			target:      config.HTTPTarget{URL: base + "/secure", Username: "admin", Password: "secret"},
+			wantSuccess: true,
+			wantStatus:  200,
+		},
+		{
+			name:        "bearer auth",
+			target:      config.HTTPTarget{URL: base + "/secure", BearerToken: "token123"},
+			wantSuccess: true,
+			wantStatus:  200,
+		},
+		{
+			name: "method and headers",
+			target: config.HTTPTarget{
+				URL:       base + "/echo",
+				Method:    "post",
+				Headers:   map[string]string{"x-probe": "collector"},
+				BodyRegex: "^POST collector$",
+			},
+			wantSuccess: true,
+			wantStatus:  200,
+		},
+		{
+			name:   "connection refused",
+			target: config.HTTPTarget{URL: "http://{ip}:1/"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewHTTPCollector(config.HTTPConfig{Targets: []config.HTTPTarget{tt.target}})
+			if err != nil {
+				t.Fatalf("Failed to create HTTP collector: %v", err)
+			}
+
+			metrics, err := c.Collect(context.Background(), "127.0.0.1")
+			if err != nil {
+				t.Fatalf("HTTPCollector.Collect() error = %v", err)
+			}
+			if len(metrics) != 1 || metrics[0].Name != "http_check" {
+				t.Fatalf("Expected one http_check metric, got %+v", metrics)
+			}
+
+			value := metrics[0].Value
+			if value["success"] != tt.wantSuccess {
+				t.Errorf("success = %v, want %v (error: %v)", value["success"], tt.wantSuccess, value["error"])
+			}
+			if tt.wantStatus != 0 && value["status_code"] != tt.wantStatus {
+				t.Errorf("status_code = %v, want %d", value["status_code"], tt.wantStatus)
+			}
+			for _, field := range tt.wantFields {
+				if _, ok := value[field]; !ok {
+					t.Errorf("Missing field %s in %v", field, value)
+				}
+			}
+			if !strings.HasPrefix(metrics[0].Tags["url"], "http://127.0.0.1:") {
+				t.Errorf("URL placeholder not expanded: %s", metrics[0].Tags["url"])
+			}
+		})
+	}
+}
+
+func TestHTTPCollector_BodyTruncated(t *testing.T) {
+	document := `{"status":"ok","padding":"` + strings.Repeat("x", 64) + `"}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(document))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name          string
+		maxBodyBytes  int64
+		wantTruncated bool
+		wantSuccess   bool
+	}{
+		{name: "body within limit", maxBodyBytes: 1024, wantSuccess: true},
+		{name: "body over limit", maxBodyBytes: 16, wantTruncated: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewHTTPCollector(config.HTTPConfig{
+				MaxBodyBytes: tt.maxBodyBytes,
+				Targets: []config.HTTPTarget{{
+					URL:            server.URL,
+					JSONAssertions: []config.JSONAssertion{{Path: "status", Equals: "ok"}},
+				}},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create HTTP collector: %v", err)
+			}
+
+			metrics, err := c.Collect(context.Background(), "127.0.0.1")
+			if err != nil {
+				t.Fatalf("HTTPCollector.Collect() error = %v", err)
+			}
+			value := metrics[0].Value
+			if value["size_bytes"] != int64(len(document)) {
+				t.Errorf("size_bytes = %v, want %d", value["size_bytes"], len(document))
+			}
+			if value["body_truncated"] != tt.wantTruncated {
+				t.Errorf("body_truncated = %v, want %v", value["body_truncated"], tt.wantTruncated)
+			}
+			if value["success"] != tt.wantSuccess {
+				t.Errorf("success = %v, want %v (error: %v)", value["success"], tt.wantSuccess, value["error"])
+			}
+			if errMsg, _ := value["error"].(string); tt.wantTruncated && !strings.Contains(errMsg, "max_body_bytes") {
+				t.Errorf("Expected a truncation error, got %q", errMsg)
+			}
+		})
+	}
+}
+
+func TestHTTPCollector_ForDevice(t *testing.T) {
+	c, err := NewHTTPCollector(config.HTTPConfig{Targets: []config.HTTPTarget{
+		{Name: "ui", URL: "https://{hostname}/", DeviceSelector: config.DeviceSelector{DeviceTypes: []string{"firewall"}}},
+		{Name: "api", URL: "http://{ip}:8080/api", DeviceSelector: config.DeviceSelector{Tags: []string{"api"}}},
+	}})
+	if err != nil {
+		t.Fatalf("Failed to create HTTP collector: %v", err)
+	}
+
+	if check := c.ForDevice("a", "switch", "sw1", nil); check != nil {
+		t.Error("Expected no checks for a device without matching targets")
+	}
+
+	check, ok := c.ForDevice("b", "firewall", "fw1.example.com", []string{"api"}).(*boundHTTPCheck)
+	if !ok || len(check.targets) != 2 {
+		t.Fatalf("Expected both targets to match, got %+v", check)
+	}
+	if got := expandTargetURL(check.targets[0].URL, "192.0.2.1", check.hostname); got != "https://fw1.example.com/" {
+		t.Errorf("expandTargetURL() = %s", got)
+	}
+}
+
+func TestNewHTTPCollector_InvalidTargets(t *testing.T) {
+	tests := []struct {
+		name   string
+		target config.HTTPTarget
+	}{
+		{"missing URL", config.HTTPTarget{}},
+		{"bad status range", config.HTTPTarget{URL: "http://{ip}/", ExpectedStatus: []string{"300-200"}}},
+		{"bad regex", config.HTTPTarget{URL: "http://{ip}/", BodyRegex: "("}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewHTTPCollector(config.HTTPConfig{Targets: []config.HTTPTarget{tt.target}}); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestParseStatusRange(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    statusRange
+		wantErr bool
+	}{
+		{spec: "200", want: statusRange{200, 200}},
+		{spec: "200-299", want: statusRange{200, 299}},
+		{spec: "3XX", want: statusRange{300, 399}},
+		{spec: "6xx", wantErr: true},
+		{spec: "abc", wantErr: true},
+		{spec: "99", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseStatusRange(tt.spec)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && got != tt.want) {
+			t.Errorf("parseStatusRange(%q) = %v, %v; want %v, wantErr %v", tt.spec, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestExpandTargetURL(t *testing.T) {
+	if got := expandTargetURL("http://{ip}:8080/", "2001:db8::1", ""); got != "http://[2001:db8::1]:8080/" {
+		t.Errorf("expandTargetURL() = %s", got)
+	}
+	if got := expandTargetURL("https://{hostname}/", "192.0.2.1", ""); got != "https://192.0.2.1/" {
+		t.Errorf("expandTargetURL() without hostname = %s", got)
+	}
+}
@@ -0,0 +1,331 @@
+package metrics
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// ICMP socket modes
+const (
+	// ICMPModeAuto uses raw sockets when permitted and datagram sockets otherwise
+	ICMPModeAuto = "auto"
+	// ICMPModePrivileged uses raw sockets and requires root or CAP_NET_RAW
+	ICMPModePrivileged = "privileged"
+	// ICMPModeUnprivileged uses datagram ICMP sockets allowed by
+	// net.ipv4.ping_group_range
+	ICMPModeUnprivileged = "unprivileged"
+)
+
+// echoTokenSize is the number of payload bytes that carry the probe token
+const echoTokenSize = 8
+
+// IANA protocol numbers used to parse ICMP messages
+const (
+	protocolICMP   = 1
+	protocolICMPv6 = 58
+)
+
+// icmpEngine multiplexes echo probes to any number of targets over a single
+// long-lived socket per address family. Every probe carries a unique token
+// at the start of its payload and replies are dispatched by that token. This
+// works for raw sockets as well as for datagram sockets, where the kernel
+// replaces the echo identifier with the socket's port.
+type icmpEngine struct {
+	mode string
+	id   int
+
+	mu      sync.Mutex
+	sockets map[int]*icmpSocket // keyed by IP version
+	pending map[uint64]pendingEcho
+	closed  bool
+
+	nextToken uint64
+}
+
+// icmpSocket is an open ICMP socket for one address family
+type icmpSocket struct {
+	conn       *icmp.PacketConn
+	version    int
+	privileged bool
+}
+
+// pendingEcho is a probe waiting for its reply
+type pendingEcho struct {
+	dst     net.IP
+	seq     int
+	replies chan<- echoReply
+}
+
+// echoReply is a matched reply to one of our probes
+type echoReply struct {
+	seq int
+	at  time.Time
+}
+
+// newICMPEngine creates an engine. Sockets are opened on first use so that a
+// collector that never pings IPv6 targets never opens an IPv6 socket.
+func newICMPEngine(mode string) *icmpEngine {
+	if mode == "" {
+		mode = ICMPModeAuto
+	}
+
+	return &icmpEngine{
+		mode:      mode,
+		id:        os.Getpid() & 0xffff,
+		sockets:   make(map[int]*icmpSocket),
+		pending:   make(map[uint64]pendingEcho),
+		nextToken: uint64(time.Now().UnixNano()),
+	}
+}
+
+// send registers a probe and writes an echo request to dst. The reply, if
+// any, is delivered to replies. The returned token must be passed to release
+// once the caller stops waiting.
+func (e *icmpEngine) send(dst *net.IPAddr, seq, size int, replies chan<- echoReply) (uint64, error) {
+	version := ipVersion(dst.IP)
+	sock, err := e.socket(version)
+	if err != nil {
+		return 0, err
+	}
+
+	if size < echoTokenSize {
+		size = echoTokenSize
+	}
+	payload := make([]byte, size)
+	token := e.register(dst.IP, seq, replies)
+	binary.BigEndian.PutUint64(payload, token)
+
+	var typ icmp.Type = ipv4.ICMPTypeEcho
+	if version == 6 {
+		typ = ipv6.ICMPTypeEchoRequest
+	}
+
+	// Create ICMP message
+	message := &icmp.Message{
+		Type: typ,
+		Code: 0,
+		Body: &icmp.Echo{
+			ID:   e.id,
+			Seq:  seq,
+			Data: payload,
+		},
+	}
+
+	// Marshal the message; ICMPv6 checksums are filled in by the kernel
+	data, err := message.Marshal(nil)
+	if err != nil {
+		e.release([]uint64{token})
+		return 0, fmt.Errorf("failed to marshal ICMP message: %w", err)
+	}
+
+	var addr net.Addr = dst
+	if !sock.privileged {
+		addr = &net.UDPAddr{IP: dst.IP, Zone: dst.Zone}
+	}
+
+	if _, err := sock.conn.WriteTo(data, addr); err != nil {
+		e.release([]uint64{token})
+		return 0, fmt.Errorf("failed to send ping to %s: %w", dst, err)
+	}
+
+	return token, nil
+}
+
+// register reserves a token for a probe to dst
+func (e *icmpEngine) register(dst net.IP, seq int, replies chan<- echoReply) uint64 {
+	token := atomic.AddUint64(&e.nextToken, 1)
+
+	e.mu.Lock()
+	e.pending[token] = pendingEcho{dst: dst, seq: seq, replies: replies}
+	e.mu.Unlock()
+
+	return token
+}
+
+// release forgets probes that are no longer waited for
+func (e *icmpEngine) release(tokens []uint64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, token := range tokens {
+		delete(e.pending, token)
+	}
+}
+
+// socket returns the socket for an IP version, opening it if needed
+func (e *icmpEngine) socket(version int) (*icmpSocket, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return nil, fmt.Errorf("ICMP engine is closed")
+	}
+	if sock, ok := e.sockets[version]; ok {
+		return sock, nil
+	}
+
+	sock, err := openICMPSocket(version, e.mode)
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"ip_version": version,
+		"privileged": sock.privileged,
+	}).Info("Opened ICMP socket")
+
+	e.sockets[version] = sock
+	go e.readLoop(sock)
+	return sock, nil
+}
+
+// openICMPSocket opens a raw or datagram ICMP socket according to mode
+func openICMPSocket(version int, mode string) (*icmpSocket, error) {
+	rawNetwork, dgramNetwork, address := "ip4:icmp", "udp4", "0.0.0.0"
+	if version == 6 {
+		rawNetwork, dgramNetwork, address = "ip6:ipv6-icmp", "udp6", "::"
+	}
+
+	if mode != ICMPModeUnprivileged {
+		conn, err := icmp.ListenPacket(rawNetwork, address)
+		if err == nil {
+			return &icmpSocket{conn: conn, version: version, privileged: true}, nil
+		}
+		if mode == ICMPModePrivileged || !errors.Is(err, os.ErrPermission) {
+			return nil, fmt.Errorf("failed to create ICMP connection: %w", err)
+		}
+	}
+
+	conn, err := icmp.ListenPacket(dgramNetwork, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create unprivileged ICMP connection: %w", err)
+	}
+	return &icmpSocket{conn: conn, version: version}, nil
+}
+
+// readLoop dispatches replies until the socket fails or is closed. A failed
+// socket is dropped so that the next probe opens a fresh one.
+func (e *icmpEngine) readLoop(sock *icmpSocket) {
+	buf := make([]byte, 65536)
+	for {
+		n, peer, err := sock.conn.ReadFrom(buf)
+		if err != nil {
+			e.mu.Lock()
+			if !e.closed && e.sockets[sock.version] == sock {
+				logrus.WithError(err).Warn("ICMP socket read failed, reopening on next probe")
+				delete(e.sockets, sock.version)
+			}
+			e.mu.Unlock()
+			sock.conn.Close()
+			return
+		}
+
+		e.dispatch(sock, buf[:n], peer, time.Now())
+	}
+}
+
+// dispatch delivers a received packet to the probe it answers. It reports
+// whether the packet matched a pending probe.
+func (e *icmpEngine) dispatch(sock *icmpSocket, packet []byte, peer net.Addr, at time.Time) bool {
+	token, seq, ok := parseEchoReply(packet, sock.version)
+	if !ok {
+		return false
+	}
+	// Raw sockets see every echo reply on the host, including those for
+	// other pingers; datagram sockets only see their own
+	if sock.privileged && seq.id != e.id {
+		return false
+	}
+
+	e.mu.Lock()
+	probe, ok := e.pending[token]
+	if ok && probe.seq == seq.seq && peerIP(peer).Equal(probe.dst) {
+		delete(e.pending, token)
+	} else {
+		ok = false
+	}
+	e.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	// replies is sized for the whole burst, so this never blocks
+	select {
+	case probe.replies <- echoReply{seq: probe.seq, at: at}:
+	default:
+	}
+	return true
+}
+
+// Close closes all sockets. Pending probes time out.
+func (e *icmpEngine) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.closed = true
+	for version, sock := range e.sockets {
+		sock.conn.Close()
+		delete(e.sockets, version)
+	}
+	return nil
+}
+
+// echoIdent is the identifier and sequence number of an echo reply
+type echoIdent struct {
+	id  int
+	seq int
+}
+
+// parseEchoReply parses an ICMP echo reply and returns the probe token from
+// its payload together with its identifier and sequence number
+func parseEchoReply(packet []byte, version int) (uint64, echoIdent, bool) {
+	protocol, replyType := protocolICMP, icmp.Type(ipv4.ICMPTypeEchoReply)
+	if version == 6 {
+		protocol, replyType = protocolICMPv6, ipv6.ICMPTypeEchoReply
+	}
+
+	rm, err := icmp.ParseMessage(protocol, packet)
+	if err != nil || rm.Type != replyType {
+		return 0, echoIdent{}, false
+	}
+
+	echo, ok := rm.Body.(*icmp.Echo)
+	if !ok || len(echo.Data) < echoTokenSize {
+		return 0, echoIdent{}, false
+	}
+
+	token := binary.BigEndian.Uint64(echo.Data)
+	return token, echoIdent{id: echo.ID, seq: echo.Seq}, true
+}
+
+// peerIP extracts the IP address from a raw or datagram socket peer
+func peerIP(peer net.Addr) net.IP {
+	switch addr := peer.(type) {
+	case *net.IPAddr:
+		return addr.IP
+	case *net.UDPAddr:
+		return addr.IP
+	default:
+		return nil
+	}
+}
+
+// ipVersion returns 4 for IPv4 (including IPv4-mapped) addresses and 6 otherwise
+func ipVersion(ip net.IP) int {
+	if ip.To4() != nil {
+		return 4
+	}
+	return 6
+}
@@ -0,0 +1,171 @@
+package metrics
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+func TestICMPEngine_Dispatch(t *testing.T) {
+	dst4 := net.ParseIP("192.0.2.10")
+	dst6 := net.ParseIP("2001:db8::10")
+
+	marshal := func(typ icmp.Type, id, seq int, token uint64) []byte {
+		payload := make([]byte, 16)
+		binary.BigEndian.PutUint64(payload, token)
+		data, err := (&icmp.Message{Type: typ, Body: &icmp.Echo{ID: id, Seq: seq, Data: payload}}).Marshal(nil)
+		if err != nil {
+			t.Fatalf("Failed to marshal ICMP message: %v", err)
+		}
+		return data
+	}
+
+	tests := []struct {
+		name       string
+		version    int
+		privileged bool
+		dst        net.IP
+		reply      func(e *icmpEngine, token uint64) ([]byte, net.Addr)
+		wantOK     bool
+	}{
+		{
+			name:       "raw IPv4 reply",
+			version:    4,
+			privileged: true,
+			dst:        dst4,
+			reply: func(e *icmpEngine, token uint64) ([]byte, net.Addr) {
+				return marshal(ipv4.ICMPTypeEchoReply, e.id, 3, token), &net.IPAddr{IP: dst4}
+			},
+			wantOK: true,
+		},
+		{
+			name:    "datagram IPv4 reply with rewritten identifier",
+			version: 4,
+			dst:     dst4,
+			reply: func(e *icmpEngine, token uint64) ([]byte, net.Addr) {
+				return marshal(ipv4.ICMPTypeEchoReply, 54321, 3, token), &net.UDPAddr{IP: dst4}
+			},
+			wantOK: true,
+		},
+		{
+			name:    "datagram IPv6 reply",
+			version: 6,
+			dst:     dst6,
+			reply: func(e *icmpEngine, token uint64) ([]byte, net.Addr) {
+				return marshal(ipv6.ICMPTypeEchoReply, 54321, 3, token), &net.UDPAddr{IP: dst6}
+			},
+			wantOK: true,
+		},
+		{
+			name:       "raw reply for another pinger",
+			version:    4,
+			privileged: true,
+			dst:        dst4,
+			reply: func(e *icmpEngine, token uint64) ([]byte, net.Addr) {
+				return marshal(ipv4.ICMPTypeEchoReply, e.id+1, 3, token), &net.IPAddr{IP: dst4}
+			},
+		},
+		{
+			name:       "echo request seen on loopback",
+			version:    4,
+			privileged: true,
+			dst:        dst4,
+			reply: func(e *icmpEngine, token uint64) ([]byte, net.Addr) {
+				return marshal(ipv4.ICMPTypeEcho, e.id, 3, token), &net.IPAddr{IP: dst4}
+			},
+		},
+		{
+			name:    "reply from another host",
+			version: 4,
+			dst:     dst4,
+			reply: func(e *icmpEngine, token uint64) ([]byte, net.Addr) {
+				return marshal(ipv4.ICMPTypeEchoReply, e.id, 3, token), &net.UDPAddr{IP: net.ParseIP("192.0.2.11")}
+			},
+		},
+		{
+			name:    "unknown token",
+			version: 4,
+			dst:     dst4,
+			reply: func(e *icmpEngine, token uint64) ([]byte, net.Addr) {
+				return marshal(ipv4.ICMPTypeEchoReply, e.id, 3, token+1), &net.UDPAddr{IP: dst4}
+			},
+		},
+		{
+			name:    "sequence mismatch",
+			version: 4,
+			dst:     dst4,
+			reply: func(e *icmpEngine, token uint64) ([]byte, net.Addr) {
+				return marshal(ipv4.ICMPTypeEchoReply, e.id, 4, token), &net.UDPAddr{IP: dst4}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newICMPEngine(ICMPModeAuto)
+			sock := &icmpSocket{version: tt.version, privileged: tt.privileged}
+			replies := make(chan echoReply, 1)
+			token := e.register(tt.dst, 3, replies)
+
+			packet, peer := tt.reply(e, token)
+			at := time.Now()
+			if got := e.dispatch(sock, packet, peer, at); got != tt.wantOK {
+				t.Fatalf("dispatch() = %v, want %v", got, tt.wantOK)
+			}
+
+			if !tt.wantOK {
+				return
+			}
+			reply := <-replies
+			if reply.seq != 3 || !reply.at.Equal(at) {
+				t.Errorf("reply = %+v, want seq 3 at %v", reply, at)
+			}
+			// A duplicate of the same reply must not be delivered again
+			if e.dispatch(sock, packet, peer, at) {
+				t.Error("dispatch() delivered a duplicate reply")
+			}
+		})
+	}
+}
+
+func TestICMPEngine_Release(t *testing.T) {
+	e := newICMPEngine(ICMPModeAuto)
+	dst := net.ParseIP("192.0.2.10")
+	replies := make(chan echoReply, 1)
+	token := e.register(dst, 0, replies)
+	e.release([]uint64{token})
+
+	payload := make([]byte, echoTokenSize)
+	binary.BigEndian.PutUint64(payload, token)
+	packet, err := (&icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: e.id, Data: payload}}).Marshal(nil)
+	if err != nil {
+		t.Fatalf("Failed to marshal ICMP message: %v", err)
+	}
+
+	if e.dispatch(&icmpSocket{version: 4}, packet, &net.UDPAddr{IP: dst}, time.Now()) {
+		t.Error("dispatch() delivered a reply for a released probe")
+	}
+}
+
+func TestIPVersion(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want int
+	}{
+		{"192.0.2.1", 4},
+		{"::ffff:192.0.2.1", 4},
+		{"2001:db8::1", 6},
+		{"::1", 6},
+	}
+
+	for _, tt := range tests {
+		if got := ipVersion(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("ipVersion(%s) = %d, want %d", tt.ip, got, tt.want)
+		}
+	}
+}
@@ -1,98 +1,200 @@
 package metrics
 
 import (
+	"collector/internal/config"
 	"context"
 	"fmt"
+	"math"
 	"net"
 	"time"
-
-	"golang.org/x/net/icmp"
-	"golang.org/x/net/ipv4"
 )
 
 // PingCollector implements the MetricCollector interface for ping-based status checks
 type PingCollector struct {
-	timeout time.Duration
+	config config.PingConfig
+	engine *icmpEngine
+}
+
+// PingStats summarizes the results of a burst of echo probes
+type PingStats struct {
+	Sent     int
+	Received int
+	RTTs     []time.Duration // in sequence order, lost probes omitted
 }
 
 // NewPingCollector creates a new PingCollector
-func NewPingCollector() (*PingCollector, error) {
-	return &PingCollector{
-		timeout: 5 * time.Second,
-	}, nil
+func NewPingCollector(cfg config.PingConfig) (*PingCollector, error) {
+	if cfg.Count <= 0 {
+		cfg.Count = 1
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.Size < 0 {
+		cfg.Size = 0
+	}
+
+	switch cfg.Mode {
+	case "", ICMPModeAuto, ICMPModePrivileged, ICMPModeUnprivileged:
+	default:
+		return nil, fmt.Errorf("unsupported ICMP mode: %s", cfg.Mode)
+	}
+
+	return &PingCollector{config: cfg, engine: newICMPEngine(cfg.Mode)}, nil
 }
 
-// Collect performs a ping check on the given IP address
+// Close releases the ICMP sockets shared by all probes
+func (c *PingCollector) Close() error {
+	return c.engine.Close()
+}
+
+// Collect sends a burst of echo probes to the given IP address and reports
+// packet loss and RTT statistics. It returns an error only when no probe
+// was answered.
 func (c *PingCollector) Collect(ctx context.Context, ipAddress string) ([]Metric, error) {
-	// Parse the IP address
-	dst, err := net.ResolveIPAddr("ip4", ipAddress)
+	if ipAddress == "" {
+		return nil, fmt.Errorf("IP address cannot be empty")
+	}
+
+	// Parse the IP address; IPv6 targets are probed with ICMPv6
+	dst, err := net.ResolveIPAddr("ip", ipAddress)
 	if err != nil {
 		return nil, fmt.Errorf("failed to resolve IP address %s: %w", ipAddress, err)
 	}
 
-	// Create ICMP connection
-	conn, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+	stats, err := c.probe(ctx, dst)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create ICMP connection: %w", err)
+		return nil, err
 	}
-	defer conn.Close()
+	if stats.Received == 0 {
+		return nil, fmt.Errorf("no echo reply from %s: %d probes lost", ipAddress, stats.Sent)
+	}
+
+	return stats.metrics(ipAddress, time.Now()), nil
+}
 
-	// Set deadline
-	deadline := time.Now().Add(c.timeout)
-	if err := conn.SetDeadline(deadline); err != nil {
-		return nil, fmt.Errorf("failed to set deadline: %w", err)
+// probe sends the configured number of echo requests through the shared
+// ICMP engine and waits for their replies
+func (c *PingCollector) probe(ctx context.Context, dst *net.IPAddr) (*PingStats, error) {
+	// Bound the whole burst by the send schedule plus the reply timeout
+	deadline := time.Now().Add(time.Duration(c.config.Count-1)*c.config.Interval + c.config.Timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
 	}
 
-	// Create ICMP message
-	message := &icmp.Message{
-		Type: ipv4.ICMPTypeEcho,
-		Code: 0,
-		Body: &icmp.Echo{
-			ID:   1,
-			Seq:  1,
-			Data: []byte("ping"),
-		},
+	replies := make(chan echoReply, c.config.Count)
+	tokens := make([]uint64, 0, c.config.Count)
+	defer func() { c.engine.release(tokens) }()
+
+	sentAt := make([]time.Time, 0, c.config.Count)
+	stats := &PingStats{}
+
+	for seq := 0; seq < c.config.Count && ctx.Err() == nil; seq++ {
+		if seq > 0 && c.config.Interval > 0 {
+			select {
+			case <-ctx.Done():
+				continue
+			case <-time.After(c.config.Interval):
+			}
+		}
+
+		sentAt = append(sentAt, time.Now())
+		token, err := c.engine.send(dst, seq, c.config.Size, replies)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+		stats.Sent++
 	}
 
-	// Marshal the message
-	data, err := message.Marshal(nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal ICMP message: %w", err)
+	// Collect replies until every probe is answered, the deadline passes or
+	// ctx is cancelled
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	rtts := make(map[int]time.Duration, stats.Sent)
+	for len(rtts) < stats.Sent {
+		select {
+		case reply := <-replies:
+			if reply.seq < len(sentAt) {
+				rtts[reply.seq] = reply.at.Sub(sentAt[reply.seq])
+			}
+		case <-timer.C:
+			return stats.withRTTs(rtts), nil
+		case <-ctx.Done():
+			return stats.withRTTs(rtts), nil
+		}
 	}
 
-	// Send the ping
-	start := time.Now()
-	_, err = conn.WriteTo(data, dst)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send ping to %s: %w", ipAddress, err)
+	return stats.withRTTs(rtts), nil
+}
+
+// withRTTs fills in the received count and the RTTs in sequence order
+func (s *PingStats) withRTTs(rtts map[int]time.Duration) *PingStats {
+	s.Received = len(rtts)
+	s.RTTs = nil
+	for seq := 0; seq < s.Sent; seq++ {
+		if rtt, ok := rtts[seq]; ok {
+			s.RTTs = append(s.RTTs, rtt)
+		}
 	}
+	return s
+}
 
-	// Read the reply
-	reply := make([]byte, 1500)
-	n, _, err := conn.ReadFrom(reply)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read ping reply from %s: %w", ipAddress, err)
+// LossPercent returns the percentage of probes that were not answered
+func (s *PingStats) LossPercent() float64 {
+	if s.Sent == 0 {
+		return 100
 	}
-	rtt := time.Since(start)
+	return float64(s.Sent-s.Received) / float64(s.Sent) * 100
+}
 
-	// Parse the reply
-	rm, err := icmp.ParseMessage(1, reply[:n]) // 1 is the protocol number for ICMP for IPv4
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse ICMP reply: %w", err)
+// rttSummary returns min, avg, max, mdev and jitter in milliseconds. mdev is
+// the standard deviation as reported by ping; jitter is the mean absolute
+// difference between consecutive RTTs.
+func (s *PingStats) rttSummary() (min, avg, max, mdev, jitter float64) {
+	if len(s.RTTs) == 0 {
+		return 0, 0, 0, 0, 0
+	}
+
+	var sum, sumSquares, diffs float64
+	min = math.MaxFloat64
+	for i, rtt := range s.RTTs {
+		ms := float64(rtt.Nanoseconds()) / 1e6
+		sum += ms
+		sumSquares += ms * ms
+		if ms < min {
+			min = ms
+		}
+		if ms > max {
+			max = ms
+		}
+		if i > 0 {
+			diffs += math.Abs(ms - float64(s.RTTs[i-1].Nanoseconds())/1e6)
+		}
 	}
 
-	// Check if it's an echo reply
-	if rm.Type != ipv4.ICMPTypeEchoReply {
-		return nil, fmt.Errorf("received non-echo reply from %s", ipAddress)
+	n := float64(len(s.RTTs))
+	avg = sum / n
+	mdev = math.Sqrt(math.Max(sumSquares/n-avg*avg, 0))
+	if len(s.RTTs) > 1 {
+		jitter = diffs / (n - 1)
 	}
+	return min, avg, max, mdev, jitter
+}
 
-	// Create separate metrics as expected by tests
-	timestamp := time.Now()
-	metrics := []Metric{
+// metrics converts the statistics into ping_status and ping_rtt_ms metrics
+func (s *PingStats) metrics(ipAddress string, timestamp time.Time) []Metric {
+	min, avg, max, mdev, jitter := s.rttSummary()
+
+	return []Metric{
 		{
 			Name: "ping_status",
 			Value: map[string]interface{}{
-				"status": "online",
+				"status":           "online",
+				"packets_sent":     s.Sent,
+				"packets_received": s.Received,
+				"packet_loss_pct":  s.LossPercent(),
 			},
 			Timestamp: timestamp,
 			Tags: map[string]string{
@@ -102,7 +204,12 @@ func (c *PingCollector) Collect(ctx context.Context, ipAddress string) ([]Metric
 		{
 			Name: "ping_rtt_ms",
 			Value: map[string]interface{}{
-				"rtt_ms": float64(rtt.Nanoseconds()) / 1e6,
+				"rtt_ms":      avg,
+				"rtt_min_ms":  min,
+				"rtt_avg_ms":  avg,
+				"rtt_max_ms":  max,
+				"rtt_mdev_ms": mdev,
+				"jitter_ms":   jitter,
 			},
 			Timestamp: timestamp,
 			Tags: map[string]string{
@@ -110,6 +217,4 @@ func (c *PingCollector) Collect(ctx context.Context, ipAddress string) ([]Metric
 			},
 		},
 	}
-
-	return metrics, nil
 }
@@ -2,9 +2,21 @@ package metrics
 
 import (
 	"context"
+	"math"
 	"testing"
+	"time"
+
+	"collector/internal/config"
 )
 
+// testPingConfig keeps probe bursts short in tests
+var testPingConfig = config.PingConfig{
+	Count:    3,
+	Interval: 10 * time.Millisecond,
+	Timeout:  time.Second,
+	Size:     56,
+}
+
 func TestPingCollector_Collect(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -30,7 +42,7 @@ func TestPingCollector_Collect(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			c, err := NewPingCollector()
+			c, err := NewPingCollector(testPingConfig)
 			if err != nil {
 				t.Fatalf("Failed to create ping collector: %v", err)
 			}
@@ -90,7 +102,7 @@ func TestPingCollector_ValidateConfig(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			c, err := NewPingCollector()
+			c, err := NewPingCollector(testPingConfig)
 			if err != nil {
 				t.Fatalf("Failed to create ping collector: %v", err)
 			}
@@ -104,4 +116,71 @@ func TestPingCollector_ValidateConfig(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+func TestPingStats_Summary(t *testing.T) {
+	tests := []struct {
+		name       string
+		stats      PingStats
+		wantLoss   float64
+		wantMin    float64
+		wantAvg    float64
+		wantMax    float64
+		wantMdev   float64
+		wantJitter float64
+	}{
+		{
+			name:     "all lost",
+			stats:    PingStats{Sent: 4},
+			wantLoss: 100,
+		},
+		{
+			name: "partial loss",
+			stats: PingStats{
+				Sent:     4,
+				Received: 3,
+				RTTs:     []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 30 * time.Millisecond},
+			},
+			wantLoss:   25,
+			wantMin:    10,
+			wantAvg:    20,
+			wantMax:    30,
+			wantMdev:   math.Sqrt(200.0 / 3),
+			wantJitter: 10,
+		},
+		{
+			name: "single reply has no jitter",
+			stats: PingStats{
+				Sent:     1,
+				Received: 1,
+				RTTs:     []time.Duration{5 * time.Millisecond},
+			},
+			wantMin: 5,
+			wantAvg: 5,
+			wantMax: 5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.stats.LossPercent(); got != tt.wantLoss {
+				t.Errorf("LossPercent() = %v, want %v", got, tt.wantLoss)
+			}
+
+			min, avg, max, mdev, jitter := tt.stats.rttSummary()
+			for _, check := range []struct {
+				field     string
+				got, want float64
+			}{
+				{"min", min, tt.wantMin},
+				{"avg", avg, tt.wantAvg},
+				{"max", max, tt.wantMax},
+				{"mdev", mdev, tt.wantMdev},
+				{"jitter", jitter, tt.wantJitter},
+			} {
+				if math.Abs(check.got-check.want) > 1e-9 {
+					t.Errorf("%s = %v, want %v", check.field, check.got, check.want)
+				}
+			}
+		})
+	}
+}
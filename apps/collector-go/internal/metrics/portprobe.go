@@ -0,0 +1,429 @@
+package metrics
+
+import (
+	"collector/internal/config"
+	"collector/internal/secrets"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+// Port probe states
+const (
+	// PortOpen means the port accepted a connection or answered the probe
+	PortOpen = "open"
+	// PortClosed means the host refused the connection or reported the UDP
+	// port unreachable, which still proves that the host is up
+	PortClosed = "closed"
+	// PortTimeout means nothing answered before the timeout
+	PortTimeout = "timeout"
+	// PortError means the probe failed for another reason, such as no route
+	PortError = "error"
+)
+
+// udpServicePorts are the well-known ports of the UDP services we can probe
+// with a protocol-aware payload
+var udpServicePorts = map[string]int{
+	"dns":  53,
+	"ntp":  123,
+	"snmp": 161,
+}
+
+// PortTarget is a single TCP port or UDP service to probe
+type PortTarget struct {
+	Protocol string // "tcp" or "udp"
+	Port     int
+	// Service selects the UDP payload: "dns", "ntp", "snmp" or "" for an
+	// empty datagram that only detects closed ports and echo services
+	Service string
+}
+
+// String returns the target in the form used in configuration
+func (t PortTarget) String() string {
+	if t.Service != "" {
+		return fmt.Sprintf("%s/%s:%d", t.Protocol, t.Service, t.Port)
+	}
+	return fmt.Sprintf("%s/%d", t.Protocol, t.Port)
+}
+
+// PortResult is the outcome of probing a single target
+type PortResult struct {
+	Target PortTarget
+	State  string
+	RTT    time.Duration
+	Err    error
+}
+
+// Reachable reports whether the result proves that the host is up
+func (r PortResult) Reachable() bool {
+	return r.State == PortOpen || r.State == PortClosed
+}
+
+// PortProbeCollector implements the MetricCollector interface for TCP and
+// UDP port reachability checks. It is meant for hosts that drop ICMP.
+type PortProbeCollector struct {
+	config  config.PortProbeConfig
+	targets []PortTarget
+	secrets *secrets.Resolver
+}
+
+// NewPortProbeCollector creates a new PortProbeCollector
+func NewPortProbeCollector(cfg config.PortProbeConfig) (*PortProbeCollector, error) {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 3 * time.Second
+	}
+	if cfg.SNMPCommunity == "" {
+		cfg.SNMPCommunity = "public"
+	}
+
+	targets, err := ParsePortTargets(cfg.TCPPorts, cfg.UDPServices)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PortProbeCollector{config: cfg, targets: targets}, nil
+}
+
+// ParsePortTargets builds probe targets from TCP port numbers and UDP
+// service specs. A UDP spec is a service name ("dns", "ntp", "snmp"), a
+// service on a non-standard port ("dns:5353") or a bare port number.
+func ParsePortTargets(tcpPorts []int, udpServices []string) ([]PortTarget, error) {
+	targets := make([]PortTarget, 0, len(tcpPorts)+len(udpServices))
+
+	for _, port := range tcpPorts {
+		if port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid TCP port: %d", port)
+		}
+		targets = append(targets, PortTarget{Protocol: "tcp", Port: port})
+	}
+
+	for _, spec := range udpServices {
+		target, err := parseUDPService(spec)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+
+	return targets, nil
+}
+
+// parseUDPService parses a single UDP service spec
+func parseUDPService(spec string) (PortTarget, error) {
+	target := PortTarget{Protocol: "udp"}
+
+	name, portStr := strings.TrimSpace(strings.ToLower(spec)), ""
+	if i := strings.LastIndex(name, ":"); i >= 0 {
+		name, portStr = name[:i], name[i+1:]
+	} else if _, err := strconv.Atoi(name); err == nil {
+		name, portStr = "", name
+	}
+
+	if name != "" {
+		port, ok := udpServicePorts[name]
+		if !ok {
+			return target, fmt.Errorf("unsupported UDP service: %s", spec)
+		}
+		target.Service, target.Port = name, port
+	}
+
+	if portStr != "" {
+		port, err := strconv.Atoi(portStr)
+		if err != nil || port < 1 || port > 65535 {
+			return target, fmt.Errorf("invalid UDP port in %q", spec)
+		}
+		target.Port = port
+	}
+
+	return target, nil
+}
+
+// Collect probes the configured default targets
+func (c *PortProbeCollector) Collect(ctx context.Context, ipAddress string) ([]Metric, error) {
+	return c.CollectTargets(ctx, ipAddress, c.targets)
+}
+
+// UseSecrets resolves an SNMP community given as a secret reference with r
+func (c *PortProbeCollector) UseSecrets(r *secrets.Resolver) {
+	c.secrets = r
+}
+
+// WithTargets returns a MetricCollector that probes targets instead of the
+// configured defaults
+func (c *PortProbeCollector) WithTargets(targets []PortTarget) MetricCollector {
+	return &boundPortProbe{collector: c, targets: targets}
+}
+
+// boundPortProbe is a PortProbeCollector bound to a specific target list
+type boundPortProbe struct {
+	collector *PortProbeCollector
+	targets   []PortTarget
+}
+
+// Collect probes the bound targets
+func (b *boundPortProbe) Collect(ctx context.Context, ipAddress string) ([]Metric, error) {
+	return b.collector.CollectTargets(ctx, ipAddress, b.targets)
+}
+
+// CollectTargets probes all targets concurrently. It returns an error when
+// none of them proves the host reachable, along with the per-target results
+// so that filtered ports are still reported.
+func (c *PortProbeCollector) CollectTargets(ctx context.Context, ipAddress string, targets []PortTarget) ([]Metric, error) {
+	if ipAddress == "" {
+		return nil, fmt.Errorf("IP address cannot be empty")
+	}
+	if net.ParseIP(ipAddress) == nil {
+		return nil, fmt.Errorf("invalid IP address: %s", ipAddress)
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no ports configured for port probe")
+	}
+
+	results := make([]PortResult, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target PortTarget) {
+			defer wg.Done()
+			results[i] = c.probe(ctx, ipAddress, target)
+		}(i, target)
+	}
+	wg.Wait()
+
+	return portMetrics(ipAddress, results, time.Now())
+}
+
+// probe checks a single target
+func (c *PortProbeCollector) probe(ctx context.Context, ipAddress string, target PortTarget) PortResult {
+	probeCtx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	if target.Protocol == "udp" {
+		return c.probeUDP(probeCtx, ipAddress, target)
+	}
+	return probeTCP(probeCtx, ipAddress, target)
+}
+
+// probeTCP measures the time to establish a TCP connection
+func probeTCP(ctx context.Context, ipAddress string, target PortTarget) PortResult {
+	address := net.JoinHostPort(ipAddress, strconv.Itoa(target.Port))
+
+	var dialer net.Dialer
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	rtt := time.Since(start)
+	if err != nil {
+		return PortResult{Target: target, State: classifyProbeError(err), RTT: rtt, Err: err}
+	}
+	conn.Close()
+
+	return PortResult{Target: target, State: PortOpen, RTT: rtt}
+}
+
+// probeUDP sends a service request and waits for a valid response
+func (c *PortProbeCollector) probeUDP(ctx context.Context, ipAddress string, target PortTarget) PortResult {
+	address := net.JoinHostPort(ipAddress, strconv.Itoa(target.Port))
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", address)
+	if err != nil {
+		return PortResult{Target: target, State: classifyProbeError(err), Err: err}
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	request, validate, err := c.udpRequest(ctx, target.Service)
+	if err != nil {
+		return PortResult{Target: target, State: PortError, Err: err}
+	}
+
+	start := time.Now()
+	if _, err := conn.Write(request); err != nil {
+		return PortResult{Target: target, State: classifyProbeError(err), Err: err}
+	}
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return PortResult{Target: target, State: classifyProbeError(err), RTT: time.Since(start), Err: err}
+		}
+		// Ignore stray datagrams that do not answer our request
+		if validate(buf[:n]) {
+			return PortResult{Target: target, State: PortOpen, RTT: time.Since(start)}
+		}
+	}
+}
+
+// udpRequest returns the request payload for a UDP service and a function
+// that checks whether a datagram is a response to it
+func (c *PortProbeCollector) udpRequest(ctx context.Context, service string) ([]byte, func([]byte) bool, error) {
+	switch service {
+	case "dns":
+		id := uint16(rand.Intn(1 << 16))
+		return dnsQuery(id), func(resp []byte) bool { return validDNSResponse(resp, id) }, nil
+	case "ntp":
+		return ntpRequest(), validNTPResponse, nil
+	case "snmp":
+		requestID := uint32(rand.Int31())
+		community, err := c.secrets.Resolve(ctx, c.config.SNMPCommunity)
+		if err != nil {
+			return nil, nil, err
+		}
+		request, err := snmpGetRequest(community, requestID)
+		if err != nil {
+			return nil, nil, err
+		}
+		return request, func(resp []byte) bool { return validSNMPResponse(resp, requestID) }, nil
+	default:
+		return []byte{}, func([]byte) bool { return true }, nil
+	}
+}
+
+// dnsQuery builds a recursive query for the NS records of the root zone
+func dnsQuery(id uint16) []byte {
+	query := make([]byte, 12, 17)
+	binary.BigEndian.PutUint16(query[0:], id)
+	binary.BigEndian.PutUint16(query[2:], 0x0100) // RD
+	binary.BigEndian.PutUint16(query[4:], 1)      // QDCOUNT
+	// Root name, QTYPE NS, QCLASS IN
+	return append(query, 0x00, 0x00, 0x02, 0x00, 0x01)
+}
+
+// validDNSResponse checks that resp is a DNS response to query id. Any
+// response code counts: a REFUSED answer still proves the server is up.
+func validDNSResponse(resp []byte, id uint16) bool {
+	return len(resp) >= 12 &&
+		binary.BigEndian.Uint16(resp[0:]) == id &&
+		resp[2]&0x80 != 0 // QR
+}
+
+// ntpRequest builds an NTPv4 client request
+func ntpRequest() []byte {
+	request := make([]byte, 48)
+	request[0] = 0x23 // LI 0, VN 4, mode 3 (client)
+	return request
+}
+
+// validNTPResponse checks that resp is an NTP server reply
+func validNTPResponse(resp []byte) bool {
+	return len(resp) >= 48 && resp[0]&0x07 == 4 // mode 4 (server)
+}
+
+// snmpGetRequest builds an SNMPv2c get request for sysUpTime.0
+func snmpGetRequest(community string, requestID uint32) ([]byte, error) {
+	packet := &gosnmp.SnmpPacket{
+		Version:   gosnmp.Version2c,
+		Community: community,
+		PDUType:   gosnmp.GetRequest,
+		RequestID: requestID,
+		Variables: []gosnmp.SnmpPDU{{Name: ".1.3.6.1.2.1.1.3.0", Type: gosnmp.Null}},
+	}
+
+	request, err := packet.MarshalMsg()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal SNMP request: %w", err)
+	}
+	return request, nil
+}
+
+// validSNMPResponse checks that resp is an SNMP response to requestID
+func validSNMPResponse(resp []byte, requestID uint32) bool {
+	decoder := &gosnmp.GoSNMP{Version: gosnmp.Version2c}
+	packet, err := decoder.SnmpDecodePacket(resp)
+	return err == nil && packet.PDUType == gosnmp.GetResponse && packet.RequestID == requestID
+}
+
+// classifyProbeError maps a dial or read error to a probe state
+func classifyProbeError(err error) string {
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return PortClosed
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return PortTimeout
+	}
+	return PortError
+}
+
+// portMetrics converts probe results into one port_probe metric per target
+// and a port_status summary
+func portMetrics(ipAddress string, results []PortResult, timestamp time.Time) ([]Metric, error) {
+	metrics := make([]Metric, 0, len(results)+1)
+
+	var reachable, open int
+	var fastest time.Duration
+	var firstErr error
+	for _, result := range results {
+		value := map[string]interface{}{
+			"state": result.State,
+			"open":  result.State == PortOpen,
+		}
+		if result.Reachable() {
+			value["response_time_ms"] = float64(result.RTT.Nanoseconds()) / 1e6
+			if reachable == 0 || result.RTT < fastest {
+				fastest = result.RTT
+			}
+			reachable++
+		} else if firstErr == nil && result.Err != nil {
+			firstErr = result.Err
+		}
+		if result.State == PortOpen {
+			open++
+		}
+
+		tags := map[string]string{
+			"ip_address": ipAddress,
+			"protocol":   result.Target.Protocol,
+			"port":       strconv.Itoa(result.Target.Port),
+		}
+		if result.Target.Service != "" {
+			tags["service"] = result.Target.Service
+		}
+
+		metrics = append(metrics, Metric{
+			Name:      "port_probe",
+			Value:     value,
+			Timestamp: timestamp,
+			Tags:      tags,
+		})
+	}
+
+	if reachable == 0 {
+		if firstErr == nil {
+			firstErr = fmt.Errorf("no response")
+		}
+		return metrics, fmt.Errorf("no port reachable on %s: %w", ipAddress, firstErr)
+	}
+
+	metrics = append(metrics, Metric{
+		Name: "port_status",
+		Value: map[string]interface{}{
+			"status":          "online",
+			"ports_probed":    len(results),
+			"ports_reachable": reachable,
+			"ports_open":      open,
+			"rtt_ms":          float64(fastest.Nanoseconds()) / 1e6,
+		},
+		Timestamp: timestamp,
+		Tags: map[string]string{
+			"ip_address": ipAddress,
+		},
+	})
+
+	return metrics, nil
+}
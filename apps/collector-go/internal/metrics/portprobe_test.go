@@ -0,0 +1,280 @@
+package metrics
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"collector/internal/config"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+func TestParsePortTargets(t *testing.T) {
+	tests := []struct {
+		name        string
+		tcpPorts    []int
+		udpServices []string
+		want        []PortTarget
+		wantErr     bool
+	}{
+		{
+			name:        "known services and ports",
+			tcpPorts:    []int{22, 443},
+			udpServices: []string{"dns", "NTP", "snmp:1161", "5000"},
+			want: []PortTarget{
+				{Protocol: "tcp", Port: 22},
+				{Protocol: "tcp", Port: 443},
+				{Protocol: "udp", Port: 53, Service: "dns"},
+				{Protocol: "udp", Port: 123, Service: "ntp"},
+				{Protocol: "udp", Port: 1161, Service: "snmp"},
+				{Protocol: "udp", Port: 5000},
+			},
+		},
+		{
+			name:     "TCP port out of range",
+			tcpPorts: []int{70000},
+			wantErr:  true,
+		},
+		{
+			name:        "unknown UDP service",
+			udpServices: []string{"gopher"},
+			wantErr:     true,
+		},
+		{
+			name:        "bad UDP port",
+			udpServices: []string{"dns:abc"},
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePortTargets(tt.tcpPorts, tt.udpServices)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePortTargets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ParsePortTargets() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("target %d = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPortProbeCollector_TCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	openPort := listener.Addr().(*net.TCPAddr).Port
+
+	// A port that was just released is refused by the kernel
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	c, err := NewPortProbeCollector(config.PortProbeConfig{Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("Failed to create port probe collector: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		ports     []int
+		wantErr   bool
+		wantState map[int]string
+	}{
+		{
+			name:      "open port",
+			ports:     []int{openPort},
+			wantState: map[int]string{openPort: PortOpen},
+		},
+		{
+			name:      "refused port still proves the host is up",
+			ports:     []int{closedPort},
+			wantState: map[int]string{closedPort: PortClosed},
+		},
+		{
+			name:      "mixed",
+			ports:     []int{closedPort, openPort},
+			wantState: map[int]string{openPort: PortOpen, closedPort: PortClosed},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targets, _ := ParsePortTargets(tt.ports, nil)
+			metrics, err := c.CollectTargets(context.Background(), "127.0.0.1", targets)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CollectTargets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			var foundStatus bool
+			for _, metric := range metrics {
+				switch metric.Name {
+				case "port_probe":
+					port, _ := strconv.Atoi(metric.Tags["port"])
+					if want := tt.wantState[port]; metric.Value["state"] != want {
+						t.Errorf("port %d state = %v, want %s", port, metric.Value["state"], want)
+					}
+				case "port_status":
+					foundStatus = true
+					if _, ok := metric.Value["rtt_ms"].(float64); !ok {
+						t.Error("port_status is missing rtt_ms")
+					}
+				}
+			}
+			if !foundStatus {
+				t.Error("CollectTargets() returned no port_status metric")
+			}
+		})
+	}
+}
+
+func TestPortProbeCollector_UDP(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer server.Close()
+
+	// Answer DNS queries after a stray datagram that must be ignored
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := server.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			server.WriteTo([]byte("noise"), addr)
+			resp := append([]byte(nil), buf[:n]...)
+			resp[2] |= 0x80
+			server.WriteTo(resp, addr)
+		}
+	}()
+
+	c, err := NewPortProbeCollector(config.PortProbeConfig{Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("Failed to create port probe collector: %v", err)
+	}
+
+	port := server.LocalAddr().(*net.UDPAddr).Port
+	targets, _ := ParsePortTargets(nil, []string{"dns:" + strconv.Itoa(port)})
+	metrics, err := c.CollectTargets(context.Background(), "127.0.0.1", targets)
+	if err != nil {
+		t.Fatalf("CollectTargets() error = %v", err)
+	}
+	if metrics[0].Value["state"] != PortOpen || metrics[0].Tags["service"] != "dns" {
+		t.Errorf("Expected an open DNS service, got %v %v", metrics[0].Value, metrics[0].Tags)
+	}
+
+	// Nothing listens here any more, so the kernel reports it unreachable
+	server.Close()
+	metrics, err = c.CollectTargets(context.Background(), "127.0.0.1", targets)
+	if err != nil {
+		t.Fatalf("CollectTargets() error = %v", err)
+	}
+	if metrics[0].Value["state"] != PortClosed {
+		t.Errorf("Expected a closed UDP port, got %v", metrics[0].Value["state"])
+	}
+}
+
+func TestPortProbeCollector_InvalidInput(t *testing.T) {
+	c, err := NewPortProbeCollector(config.PortProbeConfig{TCPPorts: []int{22}})
+	if err != nil {
+		t.Fatalf("Failed to create port probe collector: %v", err)
+	}
+
+	if _, err := c.Collect(context.Background(), ""); err == nil {
+		t.Error("Expected an error for an empty IP address")
+	}
+	if _, err := c.CollectTargets(context.Background(), "127.0.0.1", nil); err == nil {
+		t.Error("Expected an error without targets")
+	}
+}
+
+func TestPortMetrics_Unreachable(t *testing.T) {
+	results := []PortResult{
+		{Target: PortTarget{Protocol: "tcp", Port: 22}, State: PortTimeout, Err: errors.New("i/o timeout")},
+		{Target: PortTarget{Protocol: "udp", Port: 53, Service: "dns"}, State: PortError},
+	}
+
+	metrics, err := portMetrics("192.0.2.1", results, time.Now())
+	if err == nil {
+		t.Fatal("Expected an error when no port is reachable")
+	}
+	if len(metrics) != len(results) {
+		t.Fatalf("Expected %d port_probe metrics, got %d", len(results), len(metrics))
+	}
+	for _, metric := range metrics {
+		if metric.Name != "port_probe" || metric.Value["state"] == PortOpen {
+			t.Errorf("Expected an unreachable port_probe metric, got %s %v", metric.Name, metric.Value)
+		}
+	}
+}
+
+func TestUDPResponseValidation(t *testing.T) {
+	query := dnsQuery(0x1234)
+	dnsResponse := append([]byte(nil), query...)
+	dnsResponse[2] |= 0x80
+
+	ntpResponse := make([]byte, 48)
+	ntpResponse[0] = 0x24
+
+	snmpResponse, err := (&gosnmp.SnmpPacket{
+		Version:   gosnmp.Version2c,
+		Community: "public",
+		PDUType:   gosnmp.GetResponse,
+		RequestID: 77,
+		Variables: []gosnmp.SnmpPDU{{Name: ".1.3.6.1.2.1.1.3.0", Type: gosnmp.TimeTicks, Value: uint32(100)}},
+	}).MarshalMsg()
+	if err != nil {
+		t.Fatalf("Failed to marshal SNMP response: %v", err)
+	}
+	snmpRequest, err := snmpGetRequest("public", 77)
+	if err != nil {
+		t.Fatalf("snmpGetRequest() error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		valid bool
+		got   bool
+	}{
+		{"DNS response", true, validDNSResponse(dnsResponse, 0x1234)},
+		{"DNS response with other ID", false, validDNSResponse(dnsResponse, 0x4321)},
+		{"DNS query echoed back", false, validDNSResponse(query, 0x1234)},
+		{"NTP server reply", true, validNTPResponse(ntpResponse)},
+		{"NTP client request echoed back", false, validNTPResponse(ntpRequest())},
+		{"SNMP response", true, validSNMPResponse(snmpResponse, 77)},
+		{"SNMP response with other request ID", false, validSNMPResponse(snmpResponse, 78)},
+		{"SNMP request echoed back", false, validSNMPResponse(snmpRequest, 77)},
+		{"garbage", false, validSNMPResponse([]byte("noise"), 77)},
+	}
+
+	for _, tt := range tests {
+		if tt.got != tt.valid {
+			t.Errorf("%s: valid = %v, want %v", tt.name, tt.got, tt.valid)
+		}
+	}
+
+	if id := binary.BigEndian.Uint16(query); id != 0x1234 {
+		t.Errorf("dnsQuery() ID = %#x, want 0x1234", id)
+	}
+}
@@ -0,0 +1,410 @@
+package metrics
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cpuTimes holds the jiffies a CPU spent in each state, as listed in
+// /proc/stat
+type cpuTimes struct {
+	user, nice, system, idle, iowait, irq, softirq, steal uint64
+}
+
+// total returns the jiffies spent in all states. Guest time is already
+// included in user and nice.
+func (t cpuTimes) total() uint64 {
+	return t.user + t.nice + t.system + t.idle + t.iowait + t.irq + t.softirq + t.steal
+}
+
+// cpuSample is a /proc/stat reading of every CPU
+type cpuSample struct {
+	at time.Time
+	// interval is the time since the previous reading, zero on the first
+	interval time.Duration
+	cpus     map[string]cpuTimes
+}
+
+// parseProcStat parses the cpu lines of /proc/stat, keyed by "cpu" for the
+// aggregate and "cpuN" for each core
+func parseProcStat(output string) (map[string]cpuTimes, error) {
+	samples := make(map[string]cpuTimes)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 || !strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+
+		// Older kernels omit the trailing columns
+		var values [8]uint64
+		for i := 0; i < len(values) && i+1 < len(fields); i++ {
+			v, err := strconv.ParseUint(fields[i+1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse %s times: %w", fields[0], err)
+			}
+			values[i] = v
+		}
+		samples[fields[0]] = cpuTimes{
+			user: values[0], nice: values[1], system: values[2], idle: values[3],
+			iowait: values[4], irq: values[5], softirq: values[6], steal: values[7],
+		}
+	}
+
+	if _, ok := samples["cpu"]; !ok {
+		return nil, fmt.Errorf("no cpu line in /proc/stat output")
+	}
+	return samples, nil
+}
+
+// cpuMetrics computes utilization between two /proc/stat samples, for the
+// aggregate and each core present in both. CPUs whose counters went
+// backwards, as after a reboot, are skipped.
+func cpuMetrics(prev, cur map[string]cpuTimes, timestamp time.Time) []Metric {
+	var metrics []Metric
+	for name, now := range cur {
+		before, ok := prev[name]
+		if !ok || now.total() <= before.total() || now.idle < before.idle {
+			continue
+		}
+
+		elapsed := float64(now.total() - before.total())
+		percent := func(now, before uint64) float64 {
+			if now < before {
+				return 0
+			}
+			return float64(now-before) / elapsed * 100
+		}
+
+		idle := percent(now.idle, before.idle)
+		iowait := percent(now.iowait, before.iowait)
+		cpu := "total"
+		if name != "cpu" {
+			cpu = strings.TrimPrefix(name, "cpu")
+		}
+
+		metrics = append(metrics, Metric{
+			Name: "cpu_utilization",
+			Value: map[string]interface{}{
+				"cpu_percent":     100 - idle - iowait,
+				"user_percent":    percent(now.user, before.user),
+				"nice_percent":    percent(now.nice, before.nice),
+				"system_percent":  percent(now.system, before.system),
+				"idle_percent":    idle,
+				"iowait_percent":  iowait,
+				"irq_percent":     percent(now.irq, before.irq),
+				"softirq_percent": percent(now.softirq, before.softirq),
+				"steal_percent":   percent(now.steal, before.steal),
+			},
+			Timestamp: timestamp,
+			Tags: map[string]string{
+				"metric_type": "cpu",
+				"source":      "ssh",
+				"cpu":         cpu,
+			},
+		})
+	}
+	return metrics
+}
+
+// parseMeminfo parses /proc/meminfo into values in kilobytes
+func parseMeminfo(output string) (map[string]float64, error) {
+	values := make(map[string]float64)
+	for _, line := range strings.Split(output, "\n") {
+		name, rest, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		v, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+		}
+		values[strings.TrimSpace(name)] = v
+	}
+
+	if values["MemTotal"] == 0 {
+		return nil, fmt.Errorf("no MemTotal in /proc/meminfo output")
+	}
+	return values, nil
+}
+
+// parseMemoryMetrics parses /proc/meminfo into memory and swap utilization
+// in megabytes
+func parseMemoryMetrics(output string, timestamp time.Time) ([]Metric, error) {
+	info, err := parseMeminfo(output)
+	if err != nil {
+		return nil, err
+	}
+
+	cached := info["Cached"] + info["SReclaimable"]
+	available, ok := info["MemAvailable"]
+	if !ok {
+		// Kernels before 3.14 do not estimate available memory
+		available = info["MemFree"] + info["Buffers"] + cached
+	}
+
+	const kbPerMB = 1024
+	total := info["MemTotal"]
+	used := total - available
+	value := map[string]interface{}{
+		"memory_percent":   used / total * 100,
+		"memory_total":     total / kbPerMB,
+		"memory_used":      used / kbPerMB,
+		"memory_available": available / kbPerMB,
+		"memory_free":      info["MemFree"] / kbPerMB,
+		"memory_buffers":   info["Buffers"] / kbPerMB,
+		"memory_cached":    cached / kbPerMB,
+		"swap_total":       info["SwapTotal"] / kbPerMB,
+		"swap_used":        (info["SwapTotal"] - info["SwapFree"]) / kbPerMB,
+	}
+	if info["SwapTotal"] > 0 {
+		value["swap_percent"] = (info["SwapTotal"] - info["SwapFree"]) / info["SwapTotal"] * 100
+	}
+
+	metrics := []Metric{
+		{
+			Name:      "memory_utilization",
+			Value:     value,
+			Timestamp: timestamp,
+			Tags: map[string]string{
+				"metric_type": "memory",
+				"source":      "ssh",
+			},
+		},
+	}
+
+	return metrics, nil
+}
+
+// parseLoadMetrics parses /proc/loadavg
+func parseLoadMetrics(output string, timestamp time.Time) ([]Metric, error) {
+	fields := strings.Fields(output)
+	if len(fields) < 4 {
+		return nil, fmt.Errorf("unexpected loadavg output format: %s", output)
+	}
+
+	var load [3]float64
+	for i := range load {
+		v, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse load average: %w", err)
+		}
+		load[i] = v
+	}
+
+	value := map[string]interface{}{
+		"load1":  load[0],
+		"load5":  load[1],
+		"load15": load[2],
+	}
+
+	// The fourth field is "running/total" scheduling entities
+	if running, total, ok := strings.Cut(fields[3], "/"); ok {
+		if r, err := strconv.ParseFloat(running, 64); err == nil {
+			value["processes_running"] = r
+		}
+		if t, err := strconv.ParseFloat(total, 64); err == nil {
+			value["processes_total"] = t
+		}
+	}
+
+	metrics := []Metric{
+		{
+			Name:      "system_load",
+			Value:     value,
+			Timestamp: timestamp,
+			Tags: map[string]string{
+				"metric_type": "system",
+				"source":      "ssh",
+			},
+		},
+	}
+
+	return metrics, nil
+}
+
+// pseudoFilesystems are skipped when reporting disk utilization
+var pseudoFilesystems = map[string]bool{
+	"tmpfs":    true,
+	"devtmpfs": true,
+	"udev":     true,
+	"none":     true,
+	"shm":      true,
+}
+
+// parseDiskMetrics parses POSIX "df -P -k" output into the utilization of
+// every mounted filesystem, in bytes
+func parseDiskMetrics(output string, timestamp time.Time) ([]Metric, error) {
+	lines := strings.Split(output, "\n")
+	if len(lines) < 2 {
+		return nil, fmt.Errorf("unexpected disk output format: %s", output)
+	}
+
+	var metrics []Metric
+	seen := make(map[string]bool)
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < 6 {
+			continue
+		}
+		device := fields[0]
+		mount := strings.Join(fields[5:], " ")
+		if pseudoFilesystems[device] || seen[mount] {
+			continue
+		}
+
+		var kb [3]float64
+		for i := range kb {
+			v, err := strconv.ParseFloat(fields[i+1], 64)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse disk usage of %s: %w", mount, err)
+			}
+			kb[i] = v
+		}
+		total, used, free := kb[0]*1024, kb[1]*1024, kb[2]*1024
+		if total == 0 {
+			continue
+		}
+		seen[mount] = true
+
+		// Like df, the percentage excludes blocks reserved for root
+		var percent float64
+		if used+free > 0 {
+			percent = used / (used + free) * 100
+		}
+
+		metrics = append(metrics, Metric{
+			Name: "disk_utilization",
+			Value: map[string]interface{}{
+				"disk_percent": percent,
+				"disk_total":   total,
+				"disk_used":    used,
+				"disk_free":    free,
+			},
+			Timestamp: timestamp,
+			Tags: map[string]string{
+				"metric_type": "disk",
+				"source":      "ssh",
+				"filesystem":  mount,
+				"device":      device,
+			},
+		})
+	}
+
+	if len(metrics) == 0 {
+		return nil, fmt.Errorf("no filesystems in disk output")
+	}
+	return metrics, nil
+}
+
+// netCounters holds the counters of an interface from /proc/net/dev
+type netCounters struct {
+	rxBytes, rxPackets, rxErrors, rxDrops uint64
+	txBytes, txPackets, txErrors, txDrops uint64
+}
+
+// fields returns the counters by metric field name
+func (n netCounters) fields() map[string]uint64 {
+	return map[string]uint64{
+		"bytes_in":    n.rxBytes,
+		"bytes_out":   n.txBytes,
+		"packets_in":  n.rxPackets,
+		"packets_out": n.txPackets,
+		"errors_in":   n.rxErrors,
+		"errors_out":  n.txErrors,
+		"drops_in":    n.rxDrops,
+		"drops_out":   n.txDrops,
+	}
+}
+
+// netSample is a /proc/net/dev reading of every interface
+type netSample struct {
+	at time.Time
+	// interval is the time since the previous reading, zero on the first
+	interval   time.Duration
+	interfaces map[string]netCounters
+}
+
+// parseNetDev parses /proc/net/dev, skipping the loopback interface
+func parseNetDev(output string) (map[string]netCounters, error) {
+	interfaces := make(map[string]netCounters)
+	for _, line := range strings.Split(output, "\n") {
+		// Old kernels print no space between the name and the first counter
+		name, rest, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		name = strings.TrimSpace(name)
+		if name == "lo" {
+			continue
+		}
+
+		fields := strings.Fields(rest)
+		if len(fields) < 16 {
+			return nil, fmt.Errorf("unexpected counters for interface %s: %s", name, rest)
+		}
+		var values [16]uint64
+		for i := range values {
+			v, err := strconv.ParseUint(fields[i], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse counters of interface %s: %w", name, err)
+			}
+			values[i] = v
+		}
+		interfaces[name] = netCounters{
+			rxBytes: values[0], rxPackets: values[1], rxErrors: values[2], rxDrops: values[3],
+			txBytes: values[8], txPackets: values[9], txErrors: values[10], txDrops: values[11],
+		}
+	}
+	return interfaces, nil
+}
+
+// networkMetrics reports the counters of every interface in cur, with
+// per-second rates since prev. Rates are omitted for an interface that is
+// new or whose counters went backwards, which happens when the interface is
+// recreated, the device reboots or a 32-bit counter wraps.
+func networkMetrics(prev, cur netSample, timestamp time.Time) []Metric {
+	elapsed := cur.at.Sub(prev.at).Seconds()
+
+	var metrics []Metric
+	for name, now := range cur.interfaces {
+		counters := now.fields()
+		value := make(map[string]interface{}, len(counters)*2)
+		for field, v := range counters {
+			value[field] = float64(v)
+		}
+
+		if before, ok := prev.interfaces[name]; ok && elapsed > 0 {
+			previous := before.fields()
+			reset := false
+			for field, v := range counters {
+				if v < previous[field] {
+					reset = true
+					break
+				}
+			}
+			if !reset {
+				for field, v := range counters {
+					value[field+"_per_sec"] = float64(v-previous[field]) / elapsed
+				}
+			}
+		}
+
+		metrics = append(metrics, Metric{
+			Name:      "network_traffic",
+			Value:     value,
+			Timestamp: timestamp,
+			Tags: map[string]string{
+				"metric_type": "network",
+				"source":      "ssh",
+				"interface":   name,
+			},
+		})
+	}
+	return metrics
+}
@@ -0,0 +1,250 @@
+package metrics
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readFixture returns a recorded command output from testdata/procfs
+func readFixture(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "procfs", name))
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+	return string(data)
+}
+
+// approx reports whether a metric value is within 0.01 of want
+func approx(value interface{}, want float64) bool {
+	got, ok := value.(float64)
+	return ok && math.Abs(got-want) < 0.01
+}
+
+func TestCPUMetrics(t *testing.T) {
+	first, err := parseProcStat(readFixture(t, "stat.1"))
+	if err != nil {
+		t.Fatalf("parseProcStat() error = %v", err)
+	}
+	second, err := parseProcStat(readFixture(t, "stat.2"))
+	if err != nil {
+		t.Fatalf("parseProcStat() error = %v", err)
+	}
+	if len(second) != 3 {
+		t.Errorf("Expected the aggregate and 2 cores, got %d entries", len(second))
+	}
+
+	metrics := cpuMetrics(first, second, time.Now())
+	byCPU := make(map[string]Metric)
+	for _, m := range metrics {
+		byCPU[m.Tags["cpu"]] = m
+	}
+	if len(byCPU) != 3 {
+		t.Fatalf("Expected 3 CPU metrics, got %d", len(metrics))
+	}
+
+	total := byCPU["total"].Value
+	want := map[string]float64{
+		"cpu_percent":    45,
+		"user_percent":   30,
+		"system_percent": 10,
+		"idle_percent":   50,
+		"iowait_percent": 5,
+		"steal_percent":  5,
+	}
+	for field, v := range want {
+		if !approx(total[field], v) {
+			t.Errorf("%s = %v, want %v", field, total[field], v)
+		}
+	}
+
+	core := byCPU["0"].Value
+	if !approx(core["idle_percent"], 400.0/900*100) {
+		t.Errorf("cpu0 idle_percent = %v", core["idle_percent"])
+	}
+
+	// No utilization without a previous sample or after a counter reset
+	if got := cpuMetrics(nil, second, time.Now()); len(got) != 0 {
+		t.Errorf("Expected no metrics without a previous sample, got %d", len(got))
+	}
+	if got := cpuMetrics(second, first, time.Now()); len(got) != 0 {
+		t.Errorf("Expected no metrics after a counter reset, got %d", len(got))
+	}
+
+	if _, err := parseProcStat("intr 1 2 3\n"); err == nil {
+		t.Error("Expected an error without a cpu line")
+	}
+}
+
+func TestParseMemoryMetrics(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		want    map[string]float64
+	}{
+		{
+			name:    "with MemAvailable",
+			fixture: "meminfo",
+			want: map[string]float64{
+				"memory_percent":   50,
+				"memory_total":     7859.5,
+				"memory_available": 3929.75,
+				"memory_cached":    3072,
+				"swap_used":        512,
+				"swap_percent":     25,
+			},
+		},
+		{
+			name:    "before MemAvailable",
+			fixture: "meminfo.old",
+			want: map[string]float64{
+				"memory_percent":   37.5,
+				"memory_available": 640,
+				"swap_total":       0,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics, err := parseMemoryMetrics(readFixture(t, tt.fixture), time.Now())
+			if err != nil {
+				t.Fatalf("parseMemoryMetrics() error = %v", err)
+			}
+			value := metrics[0].Value
+			for field, v := range tt.want {
+				if !approx(value[field], v) {
+					t.Errorf("%s = %v, want %v", field, value[field], v)
+				}
+			}
+		})
+	}
+
+	if _, err := parseMemoryMetrics("MemFree: 1 kB\n", time.Now()); err == nil {
+		t.Error("Expected an error without MemTotal")
+	}
+}
+
+func TestParseLoadMetrics(t *testing.T) {
+	metrics, err := parseLoadMetrics(readFixture(t, "loadavg"), time.Now())
+	if err != nil {
+		t.Fatalf("parseLoadMetrics() error = %v", err)
+	}
+	value := metrics[0].Value
+	want := map[string]float64{"load1": 0.52, "load5": 0.58, "load15": 0.59, "processes_running": 2, "processes_total": 389}
+	for field, v := range want {
+		if !approx(value[field], v) {
+			t.Errorf("%s = %v, want %v", field, value[field], v)
+		}
+	}
+
+	if _, err := parseLoadMetrics("0.52", time.Now()); err == nil {
+		t.Error("Expected an error for truncated output")
+	}
+}
+
+func TestParseDiskMetrics(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		want    map[string]float64 // disk_total by mount point
+		percent map[string]float64
+	}{
+		{
+			name:    "procps",
+			fixture: "df.procps",
+			want:    map[string]float64{"/": 51290592 * 1024, "/srv/data files": 103080224 * 1024},
+			percent: map[string]float64{"/": 20513280.0 / (20513280 + 28139900) * 100},
+		},
+		{
+			name:    "busybox",
+			fixture: "df.busybox",
+			want:    map[string]float64{"/": 126931 * 1024, "/overlay": 972056 * 1024},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics, err := parseDiskMetrics(readFixture(t, tt.fixture), time.Now())
+			if err != nil {
+				t.Fatalf("parseDiskMetrics() error = %v", err)
+			}
+			if len(metrics) != len(tt.want) {
+				t.Fatalf("Expected %d filesystems, got %d", len(tt.want), len(metrics))
+			}
+			for _, m := range metrics {
+				value := m.Value
+				mount := m.Tags["filesystem"]
+				if !approx(value["disk_total"], tt.want[mount]) {
+					t.Errorf("%s disk_total = %v, want %v", mount, value["disk_total"], tt.want[mount])
+				}
+				if p, ok := tt.percent[mount]; ok && !approx(value["disk_percent"], p) {
+					t.Errorf("%s disk_percent = %v, want %v", mount, value["disk_percent"], p)
+				}
+			}
+		})
+	}
+}
+
+func TestNetworkMetrics(t *testing.T) {
+	first, err := parseNetDev(readFixture(t, "net_dev.1"))
+	if err != nil {
+		t.Fatalf("parseNetDev() error = %v", err)
+	}
+	second, err := parseNetDev(readFixture(t, "net_dev.2"))
+	if err != nil {
+		t.Fatalf("parseNetDev() error = %v", err)
+	}
+	if _, ok := second["lo"]; ok {
+		t.Error("Expected the loopback interface to be skipped")
+	}
+
+	start := time.Now()
+	prev := netSample{at: start, interfaces: first}
+	cur := netSample{at: start.Add(10 * time.Second), interfaces: second}
+
+	byInterface := make(map[string]map[string]interface{})
+	for _, m := range networkMetrics(prev, cur, cur.at) {
+		byInterface[m.Tags["interface"]] = m.Value
+	}
+	if len(byInterface) != 6 {
+		t.Fatalf("Expected 6 interfaces, got %v", len(byInterface))
+	}
+
+	eno1 := byInterface["eno1"]
+	want := map[string]float64{
+		"bytes_in":           1100000,
+		"errors_in":          3,
+		"drops_in":           2,
+		"bytes_in_per_sec":   10000,
+		"bytes_out_per_sec":  2000,
+		"packets_in_per_sec": 20,
+		"errors_in_per_sec":  0.2,
+		"drops_out_per_sec":  0,
+	}
+	for field, v := range want {
+		if !approx(eno1[field], v) {
+			t.Errorf("eno1 %s = %v, want %v", field, eno1[field], v)
+		}
+	}
+	if !approx(byInterface["bond0.100"]["bytes_in_per_sec"], 1000) {
+		t.Errorf("bond0.100 bytes_in_per_sec = %v", byInterface["bond0.100"]["bytes_in_per_sec"])
+	}
+
+	// A reset counter or a new interface has counters but no rates
+	for _, name := range []string{"br0", "tun0"} {
+		if _, ok := byInterface[name]["bytes_in_per_sec"]; ok {
+			t.Errorf("Expected no rates for %s", name)
+		}
+		if _, ok := byInterface[name]["bytes_in"]; !ok {
+			t.Errorf("Expected counters for %s", name)
+		}
+	}
+
+	if _, err := parseNetDev("eth0: 1 2 3\n"); err == nil {
+		t.Error("Expected an error for truncated counters")
+	}
+}
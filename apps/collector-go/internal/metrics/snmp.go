@@ -3,7 +3,11 @@ package metrics
 import (
 	"context"
 	"collector/internal/config"
+	"collector/internal/secrets"
 	"fmt"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gosnmp/gosnmp"
@@ -11,7 +15,34 @@ import (
 
 // SNMPCollector implements the MetricCollector interface for SNMP-based metric collection
 type SNMPCollector struct {
-	config config.SNMPConfig
+	config     config.SNMPConfig
+	engines    *snmpEngineCache
+	secrets    *secrets.Resolver
+	interfaces snmpInterfaceFilter
+
+	// The previous interface counters of each address, from which rates
+	// are computed
+	sampleMu  sync.Mutex
+	ifSamples map[string]snmpIfSample
+	// The previous readings of the profile counters reported as rates,
+	// by address, profile, measurement, row and field
+	rateSamples map[string]snmpRateSample
+	// When samples of devices no longer polled were last removed
+	samplesPruned time.Time
+
+	// The profiles loaded from ProfilesDir, the state of the directory they
+	// were loaded from and when it was last checked for changes
+	profileMu      sync.Mutex
+	profiles       []*metricProfile
+	profileState   string
+	profileChecked time.Time
+}
+
+// snmpSettings is the SNMP version and credentials resolved for one device
+type snmpSettings struct {
+	version   string
+	community string
+	v3        config.SNMPv3Config
 }
 
 // SNMP OIDs for common metrics
@@ -19,106 +50,246 @@ var (
 	// System information
 	sysDescrOID    = "1.3.6.1.2.1.1.1.0"   // System description
 	sysUptimeOID   = "1.3.6.1.2.1.1.3.0"   // System uptime
+	sysObjectIDOID = "1.3.6.1.2.1.1.2.0"   // Vendor platform identifier
 	
-	// CPU utilization (varies by vendor)
-	cpuUtilOID     = "1.3.6.1.4.1.9.9.109.1.1.1.1.7.1" // Cisco CPU utilization
-	
-	// Memory utilization
-	memTotalOID    = "1.3.6.1.2.1.25.2.2.0"  // Total memory
-	memUsedOID     = "1.3.6.1.2.1.25.2.3.1.6.1" // Used memory
+	// CPU and memory OIDs are per vendor, in snmpProfiles
 	
-	// Interface statistics (for network traffic)
-	ifInOctetsOID  = "1.3.6.1.2.1.2.2.1.10" // Interface input octets
-	ifOutOctetsOID = "1.3.6.1.2.1.2.2.1.16" // Interface output octets
-	ifOperStatusOID = "1.3.6.1.2.1.2.2.1.8"  // Interface operational status
-	
-	// Temperature sensors (varies by vendor)
-	tempSensorOID  = "1.3.6.1.4.1.9.9.13.1.3.1.3" // Cisco temperature sensors
+	// Environmental sensors are in snmpsensor.go
 	
 	// Disk/Storage utilization
 	storageTypeOID = "1.3.6.1.2.1.25.2.3.1.2"  // Storage type
 	storageSizeOID = "1.3.6.1.2.1.25.2.3.1.5"  // Storage size
 	storageUsedOID = "1.3.6.1.2.1.25.2.3.1.6"  // Storage used
 	storageDescrOID = "1.3.6.1.2.1.25.2.3.1.3" // Storage description
+	storageUnitsOID = "1.3.6.1.2.1.25.2.3.1.4" // Storage allocation units
+	storageRamType  = "1.3.6.1.2.1.25.2.1.2"   // hrStorageRam
 )
 
 // NewSNMPCollector creates a new SNMPCollector
 func NewSNMPCollector(cfg config.SNMPConfig) (*SNMPCollector, error) {
-	return &SNMPCollector{config: cfg}, nil
+	interfaces, err := compileInterfaceFilter(cfg.Interfaces)
+	if err != nil {
+		return nil, err
+	}
+	c := &SNMPCollector{
+		config:     cfg,
+		engines:    newSNMPEngineCache(cfg.EngineCacheTTL),
+		interfaces: interfaces,
+		ifSamples:  make(map[string]snmpIfSample),
+
+		rateSamples: make(map[string]snmpRateSample),
+	}
+
+	// Reject unusable v3 credentials at startup rather than on every poll
+	if _, err := c.newClient("", c.settings(nil)); err != nil {
+		return nil, err
+	}
+	for i := range cfg.Overrides {
+		if _, err := c.newClient("", c.settings(&cfg.Overrides[i])); err != nil {
+			return nil, fmt.Errorf("SNMP override %d: %w", i, err)
+		}
+	}
+
+	if err := c.ReloadProfiles(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
 }
 
-// Collect performs SNMP metric collection for the given IP address
-func (c *SNMPCollector) Collect(ctx context.Context, ipAddress string) ([]Metric, error) {
-	// Validate input
-	if ipAddress == "" {
-		return nil, fmt.Errorf("IP address cannot be empty")
+// settings merges an override, if any, into the global SNMP settings
+func (c *SNMPCollector) settings(override *config.SNMPOverride) snmpSettings {
+	s := snmpSettings{
+		version:   c.config.Version,
+		community: c.config.Community,
+		v3:        c.config.V3,
+	}
+	if override == nil {
+		return s
 	}
 
-	// Create SNMP client
+	if override.Version != "" {
+		s.version = override.Version
+	}
+	if override.Community != "" {
+		s.community = override.Community
+	}
+	if override.V3.Username != "" {
+		s.v3 = override.V3
+	}
+	return s
+}
+
+// ForDevice returns a MetricCollector that uses the SNMP settings of the
+// first override matching the device
+func (c *SNMPCollector) ForDevice(deviceID, deviceType, hostname string, tags []string) MetricCollector {
+	for i := range c.config.Overrides {
+		if c.config.Overrides[i].Matches(deviceID, deviceType, tags) {
+			return &boundSNMP{collector: c, settings: c.settings(&c.config.Overrides[i])}
+		}
+	}
+	return c
+}
+
+// WithCredentials returns a MetricCollector that uses the SNMP credentials
+// of a profile, or nil if the profile has none. An empty profile version
+// uses the global version.
+func (c *SNMPCollector) WithCredentials(profile config.CredentialProfile) MetricCollector {
+	if !profile.SNMP.Configured() {
+		return nil
+	}
+
+	s := snmpSettings{
+		version:   profile.SNMP.Version,
+		community: profile.SNMP.Community,
+		v3:        profile.SNMP.V3,
+	}
+	if s.version == "" {
+		s.version = c.config.Version
+	}
+	return &boundSNMP{collector: c, settings: s}
+}
+
+// boundSNMP is an SNMPCollector bound to the settings of one device
+type boundSNMP struct {
+	collector *SNMPCollector
+	settings  snmpSettings
+}
+
+// Collect collects with the bound settings
+func (b *boundSNMP) Collect(ctx context.Context, ipAddress string) ([]Metric, error) {
+	return b.collector.collect(ctx, ipAddress, b.settings)
+}
+
+// UseSecrets resolves communities and passphrases given as secret
+// references with r
+func (c *SNMPCollector) UseSecrets(r *secrets.Resolver) {
+	c.secrets = r
+}
+
+// resolveSettings replaces secret references in s with their values
+func (c *SNMPCollector) resolveSettings(ctx context.Context, s snmpSettings) (snmpSettings, error) {
+	var err error
+	for _, field := range []*string{&s.community, &s.v3.Username, &s.v3.AuthPassphrase, &s.v3.PrivPassphrase} {
+		if *field, err = c.secrets.Resolve(ctx, *field); err != nil {
+			return s, err
+		}
+	}
+	return s, nil
+}
+
+// newClient creates an SNMP client for ipAddress with the given settings
+func (c *SNMPCollector) newClient(ipAddress string, s snmpSettings) (*gosnmp.GoSNMP, error) {
 	g := &gosnmp.GoSNMP{
 		Target:    ipAddress,
 		Port:      161,
-		Community: c.config.Community,
+		Community: s.community,
 		Timeout:   c.config.Timeout,
 		Retries:   c.config.Retries,
 	}
 
 	// Set SNMP version
-	switch c.config.Version {
+	switch s.version {
 	case "1":
 		g.Version = gosnmp.Version1
 	case "2c":
 		g.Version = gosnmp.Version2c
 	case "3":
 		g.Version = gosnmp.Version3
+		flags, params, err := usmParameters(s.v3)
+		if err != nil {
+			return nil, err
+		}
+		g.SecurityModel = gosnmp.UserSecurityModel
+		g.MsgFlags = flags
+		g.SecurityParameters = params
+		g.ContextName = s.v3.ContextName
 	default:
 		g.Version = gosnmp.Version2c
 	}
 
+	return g, nil
+}
+
+// Collect performs SNMP metric collection for the given IP address
+func (c *SNMPCollector) Collect(ctx context.Context, ipAddress string) ([]Metric, error) {
+	return c.collect(ctx, ipAddress, c.settings(nil))
+}
+
+// collect performs SNMP metric collection with the given settings
+func (c *SNMPCollector) collect(ctx context.Context, ipAddress string, settings snmpSettings) ([]Metric, error) {
+	// Validate input
+	if ipAddress == "" {
+		return nil, fmt.Errorf("IP address cannot be empty")
+	}
+
+	settings, err := c.resolveSettings(ctx, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create SNMP client
+	g, err := c.newClient(ipAddress, settings)
+	if err != nil {
+		return nil, err
+	}
+	g.Context = ctx
+
+	// Skip engine discovery when the agent's engine is already known
+	usm, _ := g.SecurityParameters.(*gosnmp.UsmSecurityParameters)
+	engineKey := fmt.Sprintf("%s:%d", ipAddress, g.Port)
+	if usm != nil {
+		c.engines.apply(engineKey, usm, time.Now())
+	}
+
 	// Connect to SNMP agent
-	err := g.Connect()
+	err = g.Connect()
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to SNMP agent: %w", err)
 	}
 	defer g.Conn.Close()
 
-	// Test basic connectivity with a simple get
-	_, err = g.Get([]string{"1.3.6.1.2.1.1.1.0"}) // sysDescr
+	// Test basic connectivity, identifying the device for its profile
+	system, err := getScalars(g, []string{sysDescrOID, sysObjectIDOID, sysUptimeOID})
 	if err != nil {
+		if usm != nil {
+			c.engines.invalidate(engineKey)
+		}
 		return nil, fmt.Errorf("SNMP agent not responding: %w", err)
 	}
+	if usm != nil {
+		c.engines.store(engineKey, usm, time.Now())
+	}
 
 	var metrics []Metric
 	timestamp := time.Now()
+	profile := selectSNMPProfile(snmpProfiles, snmpOID(snmpString(system[sysObjectIDOID])), snmpString(system[sysDescrOID]))
 
 	// Collect system information
-	sysMetrics, err := c.collectSystemMetrics(g, timestamp)
-	if err == nil {
-		metrics = append(metrics, sysMetrics...)
-	}
+	metrics = append(metrics, systemMetrics(system, timestamp)...)
 
 	// Collect CPU metrics
-	cpuMetrics, err := c.collectCPUMetrics(g, timestamp)
+	cpuMetrics, err := profileCPUMetrics(g, profile, timestamp)
 	if err == nil {
 		metrics = append(metrics, cpuMetrics...)
 	}
 
 	// Collect memory metrics
-	memMetrics, err := c.collectMemoryMetrics(g, timestamp)
+	memMetrics, err := profileMemoryMetrics(g, profile, timestamp)
 	if err == nil {
 		metrics = append(metrics, memMetrics...)
 	}
 
 	// Collect interface metrics
-	ifMetrics, err := c.collectInterfaceMetrics(g, timestamp)
+	ifMetrics, err := c.collectInterfaceMetrics(g, ipAddress, system, timestamp)
 	if err == nil {
 		metrics = append(metrics, ifMetrics...)
 	}
 
-	// Collect temperature metrics
-	tempMetrics, err := c.collectTemperatureMetrics(g, timestamp)
+	// Collect environmental sensor metrics
+	envMetrics, err := collectSensorMetrics(g, timestamp)
 	if err == nil {
-		metrics = append(metrics, tempMetrics...)
+		metrics = append(metrics, envMetrics...)
 	}
 
 	// Collect disk/storage metrics
@@ -127,41 +298,10 @@ func (c *SNMPCollector) Collect(ctx context.Context, ipAddress string) ([]Metric
 		metrics = append(metrics, diskMetrics...)
 	}
 
-	return metrics, nil
-}
+	// Collect the metrics of the YAML profiles matching the device
+	metrics = append(metrics, c.collectProfileMetrics(g, ipAddress, system, timestamp)...)
 
-// collectTemperatureMetrics collects temperature sensor metrics
-func (c *SNMPCollector) collectTemperatureMetrics(g *gosnmp.GoSNMP, timestamp time.Time) ([]Metric, error) {
-	// Walk temperature sensor table
-	result, err := g.BulkWalkAll(tempSensorOID)
-	if err != nil {
-		// Temperature sensors might not be available on all devices
-		return nil, nil
-	}
-
-	var metrics []Metric
-	for i, variable := range result {
-		// Limit to first 5 temperature sensors
-		if i >= 5 {
-			break
-		}
-
-		if temp, ok := variable.Value.(int); ok {
-			// Temperature is usually in Celsius
-			temperature := float64(temp)
-			metrics = append(metrics, Metric{
-				Name: "temperature",
-				Value: map[string]interface{}{
-					"temperature_celsius": temperature,
-				},
-				Timestamp: timestamp,
-				Tags: map[string]string{
-					"metric_type": "temperature",
-					"sensor_id":   fmt.Sprintf("%d", i+1),
-				},
-			})
-		}
-	}
+	c.pruneSamples(timestamp)
 
 	return metrics, nil
 }
@@ -185,7 +325,7 @@ func (c *SNMPCollector) collectDiskMetrics(g *gosnmp.GoSNMP, timestamp time.Time
 		}
 
 		// Extract storage index from OID
-		storageIndex := variable.Name[len(storageTypeOID)+1:]
+		storageIndex := snmpIndex(variable.Name, storageTypeOID)
 
 		// Check if this is a disk storage type (type 4 = fixed disk)
 		if storageType, ok := variable.Value.(int); ok && storageType == 4 {
@@ -217,14 +357,14 @@ func (c *SNMPCollector) collectSingleStorageMetrics(g *gosnmp.GoSNMP, storageInd
 	var description string
 
 	for _, variable := range result.Variables {
-		switch variable.Name {
+		switch snmpOID(variable.Name) {
 		case sizeOID:
-			if size, ok := variable.Value.(uint); ok {
-				totalSize = float64(size)
+			if size, ok := snmpFloat(variable.Value); ok {
+				totalSize = size
 			}
 		case usedOID:
-			if used, ok := variable.Value.(uint); ok {
-				usedSize = float64(used)
+			if used, ok := snmpFloat(variable.Value); ok {
+				usedSize = used
 			}
 		case descrOID:
 			if descr, ok := variable.Value.(string); ok {
@@ -256,180 +396,218 @@ func (c *SNMPCollector) collectSingleStorageMetrics(g *gosnmp.GoSNMP, storageInd
 	return metrics, nil
 }
 
-// collectSystemMetrics collects basic system information
-func (c *SNMPCollector) collectSystemMetrics(g *gosnmp.GoSNMP, timestamp time.Time) ([]Metric, error) {
-	oids := []string{sysDescrOID, sysUptimeOID}
-	result, err := g.Get(oids)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get system metrics: %w", err)
+// systemMetrics reports basic system information from the system group
+// scalars read at the start of the poll
+func systemMetrics(system map[string]interface{}, timestamp time.Time) []Metric {
+	uptime, ok := snmpUint(system[sysUptimeOID])
+	if !ok {
+		return nil
 	}
+	return []Metric{{
+		Name: "system_uptime",
+		Value: map[string]interface{}{
+			"uptime_seconds": float64(uptime) / 100, // Convert from centiseconds
+		},
+		Timestamp: timestamp,
+		Tags: map[string]string{
+			"metric_type": "system",
+		},
+	}}
+}
 
-	var metrics []Metric
-	for _, variable := range result.Variables {
-		switch variable.Name {
-		case sysUptimeOID:
-			if uptime, ok := variable.Value.(uint); ok {
-				metrics = append(metrics, Metric{
-					Name: "system_uptime",
-					Value: map[string]interface{}{
-						"uptime_seconds": float64(uptime) / 100, // Convert from centiseconds
-					},
-					Timestamp: timestamp,
-					Tags: map[string]string{
-						"metric_type": "system",
-					},
-				})
-			}
-		}
+// collectInterfaceMetrics collects the status and counters of every
+// selected interface, with rates since the previous poll of the address.
+// system holds the system group scalars read at the start of the poll.
+func (c *SNMPCollector) collectInterfaceMetrics(g snmpAgent, ipAddress string, system map[string]interface{}, timestamp time.Time) ([]Metric, error) {
+	// sysUpTime tells counter resets from wraps
+	sample := snmpIfSample{at: timestamp}
+	if ticks, ok := snmpUint(system[sysUptimeOID]); ok {
+		sample.uptime = uint32(ticks)
 	}
 
-	return metrics, nil
-}
-
-// collectCPUMetrics collects CPU utilization metrics
-func (c *SNMPCollector) collectCPUMetrics(g *gosnmp.GoSNMP, timestamp time.Time) ([]Metric, error) {
-	result, err := g.Get([]string{cpuUtilOID})
+	interfaces, err := readInterfaces(g)
 	if err != nil {
-		// CPU OID might not be available on all devices
-		return nil, nil
+		return nil, err
 	}
-
-	var metrics []Metric
-	for _, variable := range result.Variables {
-		if variable.Name == cpuUtilOID {
-			if cpuUtil, ok := variable.Value.(uint); ok {
-				metrics = append(metrics, Metric{
-					Name: "cpu_utilization",
-					Value: map[string]interface{}{
-						"cpu_percent": float64(cpuUtil),
-					},
-					Timestamp: timestamp,
-					Tags: map[string]string{
-						"metric_type": "cpu",
-					},
-				})
-			}
+	sample.interfaces = make(map[string]snmpInterface, len(interfaces))
+	for index, iface := range interfaces {
+		if c.interfaces.matches(iface) {
+			sample.interfaces[index] = iface
 		}
 	}
 
-	return metrics, nil
+	c.sampleMu.Lock()
+	prev, seen := c.ifSamples[ipAddress]
+	if seen {
+		sample.interval = sample.at.Sub(prev.at)
+	}
+	c.ifSamples[ipAddress] = sample
+	c.sampleMu.Unlock()
+
+	return snmpInterfaceMetrics(prev, sample, timestamp), nil
 }
 
-// collectMemoryMetrics collects memory utilization metrics
-func (c *SNMPCollector) collectMemoryMetrics(g *gosnmp.GoSNMP, timestamp time.Time) ([]Metric, error) {
-	oids := []string{memTotalOID, memUsedOID}
-	result, err := g.Get(oids)
-	if err != nil {
-		// Memory OIDs might not be available on all devices
-		return nil, nil
+// Samples are kept for a few poll intervals after their last reading, or
+// for sampleDefaultRetention while the interval is not known yet, so that
+// the sample maps do not grow with devices, interfaces and profile rows
+// that are gone
+const (
+	sampleRetentionIntervals = 3
+	sampleDefaultRetention   = 24 * time.Hour
+	samplePruneInterval      = time.Minute
+)
+
+// sampleExpired reports whether a sample read at at, interval after the
+// reading before it, is no longer needed
+func sampleExpired(at time.Time, interval time.Duration, now time.Time) bool {
+	retention := sampleDefaultRetention
+	if interval > 0 {
+		retention = sampleRetentionIntervals * interval
 	}
+	return now.Sub(at) > retention
+}
 
-	var totalMem, usedMem float64
-	for _, variable := range result.Variables {
-		switch variable.Name {
-		case memTotalOID:
-			if total, ok := variable.Value.(uint); ok {
-				totalMem = float64(total)
-			}
-		case memUsedOID:
-			if used, ok := variable.Value.(uint); ok {
-				usedMem = float64(used)
-			}
+// pruneSamples removes the interface and profile counter samples that have
+// expired. It does the work at most once per samplePruneInterval.
+func (c *SNMPCollector) pruneSamples(now time.Time) {
+	c.sampleMu.Lock()
+	defer c.sampleMu.Unlock()
+
+	if now.Sub(c.samplesPruned) < samplePruneInterval {
+		return
+	}
+	c.samplesPruned = now
+
+	for key, sample := range c.ifSamples {
+		if sampleExpired(sample.at, sample.interval, now) {
+			delete(c.ifSamples, key)
 		}
 	}
+	for key, sample := range c.rateSamples {
+		if sampleExpired(sample.at, sample.interval, now) {
+			delete(c.rateSamples, key)
+		}
+	}
+}
 
-	var metrics []Metric
-	if totalMem > 0 {
-		memPercent := (usedMem / totalMem) * 100
-		metrics = append(metrics, Metric{
-			Name: "memory_utilization",
-			Value: map[string]interface{}{
-				"memory_percent": memPercent,
-				"memory_total":   totalMem,
-				"memory_used":    usedMem,
-			},
-			Timestamp: timestamp,
-			Tags: map[string]string{
-				"metric_type": "memory",
-			},
-		})
+// snmpUint converts an integer value of any SNMP type, such as a Counter32
+// (uint), TimeTicks (uint32) or Counter64 (uint64), to uint64
+func snmpUint(value interface{}) (uint64, bool) {
+	switch v := value.(type) {
+	case uint:
+		return uint64(v), true
+	case uint32:
+		return uint64(v), true
+	case uint64:
+		return v, true
+	case int:
+		if v >= 0 {
+			return uint64(v), true
+		}
+	case int64:
+		if v >= 0 {
+			return uint64(v), true
+		}
 	}
+	return 0, false
+}
 
-	return metrics, nil
+// snmpFloat converts an integer value of any SNMP type to float64
+func snmpFloat(value interface{}) (float64, bool) {
+	if v, ok := value.(int); ok {
+		return float64(v), true
+	}
+	v, ok := snmpUint(value)
+	return float64(v), ok
 }
 
-// collectInterfaceMetrics collects network interface metrics
-func (c *SNMPCollector) collectInterfaceMetrics(g *gosnmp.GoSNMP, timestamp time.Time) ([]Metric, error) {
-	// Walk interface table to get all interfaces
-	result, err := g.BulkWalkAll(ifOperStatusOID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to walk interface table: %w", err)
+// snmpString converts an OctetString value to a string
+func snmpString(value interface{}) string {
+	switch v := value.(type) {
+	case []byte:
+		return string(v)
+	case string:
+		return v
 	}
+	return ""
+}
 
-	var metrics []Metric
-	// Collect metrics for up to 5 interfaces to avoid overwhelming the system
-	interfaceCount := 0
-	for _, variable := range result {
-		if interfaceCount >= 5 {
-			break
-		}
+// snmpOID returns a varbind name without the leading dot gosnmp adds
+func snmpOID(name string) string {
+	return strings.TrimPrefix(name, ".")
+}
 
-		// Extract interface index from OID
-		ifIndex := variable.Name[len(ifOperStatusOID)+1:]
-		
-		// Check if interface is up
-		if status, ok := variable.Value.(int); ok && status == 1 {
-			// Collect traffic metrics for this interface
-			ifMetrics, err := c.collectSingleInterfaceMetrics(g, ifIndex, timestamp)
-			if err == nil {
-				metrics = append(metrics, ifMetrics...)
-				interfaceCount++
-			}
-		}
-	}
+// snmpIndex returns the index of a table column instance, such as "3" for
+// .1.3.6.1.2.1.2.2.1.8.3 under column 1.3.6.1.2.1.2.2.1.8
+func snmpIndex(name, column string) string {
+	return strings.TrimPrefix(snmpOID(name), column+".")
+}
 
-	return metrics, nil
+// snmpAgent is the SNMP operations used to read an agent, implemented by
+// *gosnmp.GoSNMP
+type snmpAgent interface {
+	Get(oids []string) (*gosnmp.SnmpPacket, error)
+	BulkWalkAll(rootOid string) ([]gosnmp.SnmpPDU, error)
 }
 
-// collectSingleInterfaceMetrics collects metrics for a single interface
-func (c *SNMPCollector) collectSingleInterfaceMetrics(g *gosnmp.GoSNMP, ifIndex string, timestamp time.Time) ([]Metric, error) {
-	inOctetsOID := ifInOctetsOID + "." + ifIndex
-	outOctetsOID := ifOutOctetsOID + "." + ifIndex
+// snmpWalk walks a subtree, with GETBULK unless the agent speaks SNMPv1
+func snmpWalk(g snmpAgent, rootOID string) ([]gosnmp.SnmpPDU, error) {
+	if client, ok := g.(*gosnmp.GoSNMP); ok && client.Version == gosnmp.Version1 {
+		return client.WalkAll(rootOID)
+	}
+	return g.BulkWalkAll(rootOID)
+}
 
-	oids := []string{inOctetsOID, outOctetsOID}
+// getScalars gets scalar values by OID. Objects the agent does not have
+// are absent.
+func getScalars(g snmpAgent, oids []string) (map[string]interface{}, error) {
 	result, err := g.Get(oids)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get interface metrics for index %s: %w", ifIndex, err)
+		return nil, err
 	}
-
-	var inOctets, outOctets float64
+	values := make(map[string]interface{}, len(result.Variables))
 	for _, variable := range result.Variables {
-		switch variable.Name {
-		case inOctetsOID:
-			if octets, ok := variable.Value.(uint); ok {
-				inOctets = float64(octets)
-			}
-		case outOctetsOID:
-			if octets, ok := variable.Value.(uint); ok {
-				outOctets = float64(octets)
-			}
+		switch variable.Type {
+		case gosnmp.NoSuchObject, gosnmp.NoSuchInstance, gosnmp.EndOfMibView, gosnmp.Null:
+			continue
 		}
+		values[snmpOID(variable.Name)] = variable.Value
 	}
+	return values, nil
+}
 
-	metrics := []Metric{
-		{
-			Name: "network_traffic",
-			Value: map[string]interface{}{
-				"bytes_in":  inOctets,
-				"bytes_out": outOctets,
-			},
-			Timestamp: timestamp,
-			Tags: map[string]string{
-				"metric_type":    "network",
-				"interface_index": ifIndex,
-			},
-		},
+// walkColumn walks a table column and returns its values by row index
+func walkColumn(g snmpAgent, column string) (map[string]interface{}, error) {
+	result, err := snmpWalk(g, column)
+	if err != nil {
+		return nil, err
 	}
+	values := make(map[string]interface{}, len(result))
+	for _, variable := range result {
+		values[snmpIndex(variable.Name, column)] = variable.Value
+	}
+	return values, nil
+}
 
-	return metrics, nil
+// walkTable walks an SNMP table entry and returns the values by row index
+// and column number
+func walkTable(g snmpAgent, entryOID string) (map[string]map[int]interface{}, error) {
+	result, err := snmpWalk(g, entryOID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make(map[string]map[int]interface{})
+	for _, variable := range result {
+		column, index, ok := strings.Cut(snmpIndex(variable.Name, entryOID), ".")
+		number, err := strconv.Atoi(column)
+		if !ok || err != nil {
+			continue
+		}
+		if rows[index] == nil {
+			rows[index] = make(map[int]interface{})
+		}
+		rows[index][number] = variable.Value
+	}
+	return rows, nil
 }
@@ -99,4 +99,19 @@ func TestSNMPCollector_ValidateConfig(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+func TestSNMPCollector_WithCredentials(t *testing.T) {
+	c, err := NewSNMPCollector(config.SNMPConfig{Community: "public", Version: "2c"})
+	if err != nil {
+		t.Fatalf("NewSNMPCollector() error = %v", err)
+	}
+
+	if got := c.WithCredentials(config.CredentialProfile{SSH: config.SSHCredentials{Username: "monitor"}}); got != nil {
+		t.Errorf("Expected nil for a profile without SNMP credentials, got %T", got)
+	}
+
+	bound, ok := c.WithCredentials(config.CredentialProfile{SNMP: config.SNMPCredentials{Community: "site-a"}}).(*boundSNMP)
+	if !ok || bound.settings.version != "2c" || bound.settings.community != "site-a" {
+		t.Errorf("Expected site-a v2c settings, got %+v", bound)
+	}
+}
@@ -0,0 +1,348 @@
+package metrics
+
+import (
+	"collector/internal/config"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// IF-MIB interface tables. The 64-bit ifHC counters of ifXTable are
+// preferred; the 32-bit ifTable counters wrap within a minute at 1 Gbit/s.
+const (
+	ifEntryOID  = "1.3.6.1.2.1.2.2.1"
+	ifXEntryOID = "1.3.6.1.2.1.31.1.1.1"
+)
+
+// ifTable columns
+const (
+	ifDescrColumn       = 2
+	ifTypeColumn        = 3
+	ifSpeedColumn       = 5
+	ifAdminStatusColumn = 7
+	ifOperStatusColumn  = 8
+	ifLastChangeColumn  = 9
+)
+
+// ifXTable columns
+const (
+	ifNameColumn                     = 1
+	ifHighSpeedColumn                = 15
+	ifAliasColumn                    = 18
+	ifCounterDiscontinuityTimeColumn = 19
+)
+
+// ifCounterColumns maps interface counter fields to their ifXTable 64-bit
+// column, if any, and their 32-bit column in ifTable or ifXTable
+var ifCounterColumns = []struct {
+	field    string
+	hcColumn int
+	column   int
+	xTable   bool
+}{
+	{field: "bytes_in", hcColumn: 6, column: 10},
+	{field: "bytes_out", hcColumn: 10, column: 16},
+	{field: "unicast_packets_in", hcColumn: 7, column: 11},
+	{field: "unicast_packets_out", hcColumn: 11, column: 17},
+	{field: "multicast_packets_in", hcColumn: 8, column: 2, xTable: true},
+	{field: "multicast_packets_out", hcColumn: 12, column: 4, xTable: true},
+	{field: "broadcast_packets_in", hcColumn: 9, column: 3, xTable: true},
+	{field: "broadcast_packets_out", hcColumn: 13, column: 5, xTable: true},
+	{field: "discards_in", column: 13},
+	{field: "discards_out", column: 19},
+	{field: "errors_in", column: 14},
+	{field: "errors_out", column: 20},
+}
+
+// ianaIfTypes names common IANAifType values
+var ianaIfTypes = map[int]string{
+	1:   "other",
+	6:   "ethernetCsmacd",
+	23:  "ppp",
+	24:  "softwareLoopback",
+	53:  "propVirtual",
+	62:  "fastEther",
+	71:  "ieee80211",
+	117: "gigabitEthernet",
+	131: "tunnel",
+	135: "l2vlan",
+	136: "l3ipvlan",
+	161: "ieee8023adLag",
+	166: "mpls",
+	209: "bridge",
+}
+
+// snmpCounter is a counter reading and whether it is 64 bits wide
+type snmpCounter struct {
+	value uint64
+	hc    bool
+}
+
+// snmpInterface is an interface read from ifTable and ifXTable
+type snmpInterface struct {
+	name   string
+	alias  string
+	descr  string
+	ifType int
+	// speed is in bits per second
+	speed       uint64
+	adminStatus int
+	operStatus  int
+	// lastChange is the sysUpTime of the last status change
+	lastChange uint32
+	counters   map[string]snmpCounter
+	// discontinuity is ifCounterDiscontinuityTime, the sysUpTime of the
+	// last counter discontinuity, or 0 if the agent does not report it
+	discontinuity uint32
+}
+
+// typeName returns the IANAifType name of the interface, or its number
+func (i snmpInterface) typeName() string {
+	if name, ok := ianaIfTypes[i.ifType]; ok {
+		return name
+	}
+	return strconv.Itoa(i.ifType)
+}
+
+// snmpIfSample is a reading of the interfaces of a device
+type snmpIfSample struct {
+	at time.Time
+	// interval is the time since the previous reading, zero on the first
+	interval time.Duration
+	// uptime is sysUpTime in hundredths of a second
+	uptime     uint32
+	interfaces map[string]snmpInterface
+}
+
+// parseInterfaceTables builds interfaces from the rows of ifTable and
+// ifXTable, by index
+func parseInterfaceTables(ifRows, ifXRows map[string]map[int]interface{}) map[string]snmpInterface {
+	interfaces := make(map[string]snmpInterface, len(ifRows))
+	for index, row := range ifRows {
+		xRow := ifXRows[index]
+		iface := snmpInterface{
+			descr:    snmpString(row[ifDescrColumn]),
+			name:     snmpString(xRow[ifNameColumn]),
+			alias:    snmpString(xRow[ifAliasColumn]),
+			counters: make(map[string]snmpCounter, len(ifCounterColumns)),
+		}
+		if iface.name == "" {
+			iface.name = iface.descr
+		}
+		if v, ok := row[ifTypeColumn].(int); ok {
+			iface.ifType = v
+		}
+		if v, ok := row[ifAdminStatusColumn].(int); ok {
+			iface.adminStatus = v
+		}
+		if v, ok := row[ifOperStatusColumn].(int); ok {
+			iface.operStatus = v
+		}
+		if v, ok := snmpUint(row[ifLastChangeColumn]); ok {
+			iface.lastChange = uint32(v)
+		}
+		if v, ok := snmpUint(xRow[ifCounterDiscontinuityTimeColumn]); ok {
+			iface.discontinuity = uint32(v)
+		}
+
+		// ifSpeed saturates at 4294967295; ifHighSpeed is in Mbit/s
+		iface.speed, _ = snmpUint(row[ifSpeedColumn])
+		if v, ok := snmpUint(xRow[ifHighSpeedColumn]); ok && v > 0 && (iface.speed == math.MaxUint32 || iface.speed == 0) {
+			iface.speed = v * 1000000
+		}
+
+		for _, column := range ifCounterColumns {
+			if column.hcColumn > 0 {
+				if v, ok := snmpUint(xRow[column.hcColumn]); ok {
+					iface.counters[column.field] = snmpCounter{value: v, hc: true}
+					continue
+				}
+			}
+			values := row
+			if column.xTable {
+				values = xRow
+			}
+			if v, ok := snmpUint(values[column.column]); ok {
+				iface.counters[column.field] = snmpCounter{value: v}
+			}
+		}
+
+		interfaces[index] = iface
+	}
+	return interfaces
+}
+
+// snmpInterfaceFilter is a compiled config.SNMPInterfaceFilter
+type snmpInterfaceFilter struct {
+	includeNames, excludeNames     []*regexp.Regexp
+	includeTypes, excludeTypes     []*regexp.Regexp
+	includeAliases, excludeAliases []*regexp.Regexp
+}
+
+// compileInterfaceFilter compiles the patterns of an interface filter
+func compileInterfaceFilter(cfg config.SNMPInterfaceFilter) (snmpInterfaceFilter, error) {
+	var f snmpInterfaceFilter
+	lists := []struct {
+		patterns []string
+		compiled *[]*regexp.Regexp
+	}{
+		{cfg.IncludeNames, &f.includeNames},
+		{cfg.ExcludeNames, &f.excludeNames},
+		{cfg.IncludeTypes, &f.includeTypes},
+		{cfg.ExcludeTypes, &f.excludeTypes},
+		{cfg.IncludeAliases, &f.includeAliases},
+		{cfg.ExcludeAliases, &f.excludeAliases},
+	}
+	for _, list := range lists {
+		for _, pattern := range list.patterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return f, fmt.Errorf("invalid interface pattern %q: %w", pattern, err)
+			}
+			*list.compiled = append(*list.compiled, re)
+		}
+	}
+	return f, nil
+}
+
+// matchesAny reports whether any of patterns matches s
+func matchesAny(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the interface is selected
+func (f snmpInterfaceFilter) matches(iface snmpInterface) bool {
+	if len(f.includeNames) > 0 && !matchesAny(f.includeNames, iface.name) {
+		return false
+	}
+	if len(f.includeTypes) > 0 && !matchesAny(f.includeTypes, iface.typeName()) {
+		return false
+	}
+	if len(f.includeAliases) > 0 && !matchesAny(f.includeAliases, iface.alias) {
+		return false
+	}
+	return !matchesAny(f.excludeNames, iface.name) &&
+		!matchesAny(f.excludeTypes, iface.typeName()) &&
+		!matchesAny(f.excludeAliases, iface.alias)
+}
+
+// counterDelta returns how much a counter increased between two readings.
+// A 32-bit counter that went backwards is assumed to have wrapped once; a
+// 64-bit counter cannot wrap between polls, so one that went backwards was
+// reset and no delta is returned.
+func counterDelta(prev, cur uint64, hc bool) (uint64, bool) {
+	if cur >= prev {
+		return cur - prev, true
+	}
+	if !hc && prev <= math.MaxUint32 {
+		return cur + (math.MaxUint32 + 1) - prev, true
+	}
+	return 0, false
+}
+
+// interfaceRates returns the per-second rates of the counters of an
+// interface since its previous reading, or nil if the counters cannot be
+// compared. Octet counters are reported as bits.
+func interfaceRates(before, now snmpInterface, elapsed float64) map[string]float64 {
+	// A renumbered index is a different interface
+	if before.name != now.name || before.discontinuity != now.discontinuity {
+		return nil
+	}
+
+	rates := make(map[string]float64, len(now.counters))
+	for field, counter := range now.counters {
+		previous, ok := before.counters[field]
+		if !ok || previous.hc != counter.hc {
+			continue
+		}
+		delta, ok := counterDelta(previous.value, counter.value, counter.hc)
+		if !ok {
+			return nil
+		}
+		switch field {
+		case "bytes_in":
+			rates["bits_in_per_sec"] = float64(delta) * 8 / elapsed
+		case "bytes_out":
+			rates["bits_out_per_sec"] = float64(delta) * 8 / elapsed
+		default:
+			rates[field+"_per_sec"] = float64(delta) / elapsed
+		}
+	}
+	return rates
+}
+
+// snmpInterfaceMetrics reports the status and counters of every interface
+// in cur, with rates and utilization since prev. Rates are omitted for an
+// interface that is new or whose counters were reset: when sysUpTime went
+// backwards, as after a reboot, or ifCounterDiscontinuityTime changed.
+func snmpInterfaceMetrics(prev, cur snmpIfSample, timestamp time.Time) []Metric {
+	elapsed := cur.at.Sub(prev.at).Seconds()
+	restarted := cur.uptime < prev.uptime
+
+	var metrics []Metric
+	for ifIndex, iface := range cur.interfaces {
+		value := map[string]interface{}{
+			"admin_status":        float64(iface.adminStatus),
+			"oper_status":         float64(iface.operStatus),
+			"speed":               float64(iface.speed),
+			"last_change_seconds": float64(iface.lastChange) / 100,
+		}
+		if cur.uptime >= iface.lastChange {
+			value["seconds_since_change"] = float64(cur.uptime-iface.lastChange) / 100
+		}
+		for field, counter := range iface.counters {
+			value[field] = float64(counter.value)
+		}
+
+		if before, ok := prev.interfaces[ifIndex]; ok && elapsed > 0 && !restarted {
+			for field, rate := range interfaceRates(before, iface, elapsed) {
+				value[field] = rate
+			}
+		}
+		if iface.speed > 0 {
+			if bits, ok := value["bits_in_per_sec"].(float64); ok {
+				value["utilization_in_percent"] = bits / float64(iface.speed) * 100
+			}
+			if bits, ok := value["bits_out_per_sec"].(float64); ok {
+				value["utilization_out_percent"] = bits / float64(iface.speed) * 100
+			}
+		}
+
+		metrics = append(metrics, Metric{
+			Name:      "network_traffic",
+			Value:     value,
+			Timestamp: timestamp,
+			Tags: map[string]string{
+				"metric_type":           "network",
+				"interface_index":       ifIndex,
+				"interface":             iface.name,
+				"interface_alias":       iface.alias,
+				"interface_description": iface.descr,
+				"interface_type":        iface.typeName(),
+				"interface_speed":       strconv.FormatUint(iface.speed, 10),
+			},
+		})
+	}
+	return metrics
+}
+
+// readInterfaces walks ifTable and ifXTable. Agents without ifXTable, such
+// as most SNMPv1 agents, report the 32-bit counters only.
+func readInterfaces(g snmpAgent) (map[string]snmpInterface, error) {
+	ifRows, err := walkTable(g, ifEntryOID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk interface table: %w", err)
+	}
+	ifXRows, err := walkTable(g, ifXEntryOID)
+	if err != nil {
+		ifXRows = nil
+	}
+	return parseInterfaceTables(ifRows, ifXRows), nil
+}
@@ -0,0 +1,378 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"collector/internal/config"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+func TestCounterDelta(t *testing.T) {
+	tests := []struct {
+		name       string
+		prev, cur  uint64
+		hc         bool
+		want       uint64
+		wantResult bool
+	}{
+		{name: "increase", prev: 1000, cur: 1500, want: 500, wantResult: true},
+		{name: "32-bit wrap", prev: 4294967000, cur: 704, want: 1000, wantResult: true},
+		{name: "64-bit increase", prev: 1 << 40, cur: 1<<40 + 10, hc: true, want: 10, wantResult: true},
+		{name: "64-bit reset", prev: 1 << 40, cur: 10, hc: true},
+		{name: "32-bit reading above 32 bits", prev: 1 << 33, cur: 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := counterDelta(tt.prev, tt.cur, tt.hc)
+			if ok != tt.wantResult || got != tt.want {
+				t.Errorf("counterDelta() = %d, %v, want %d, %v", got, ok, tt.want, tt.wantResult)
+			}
+		})
+	}
+}
+
+func TestSNMPUint(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  uint64
+		ok    bool
+	}{
+		{value: uint(42), want: 42, ok: true},           // Counter32, Gauge32
+		{value: uint32(360000), want: 360000, ok: true}, // TimeTicks
+		{value: uint64(1) << 40, want: 1 << 40, ok: true},
+		{value: 7, want: 7, ok: true},
+		{value: -1},
+		{value: nil},
+		{value: "12"},
+	}
+
+	for _, tt := range tests {
+		got, ok := snmpUint(tt.value)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("snmpUint(%#v) = %d, %v, want %d, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestSNMPIndex(t *testing.T) {
+	if got := snmpIndex(".1.3.6.1.2.1.2.2.1.8.12", ifEntryOID+".8"); got != "12" {
+		t.Errorf("snmpIndex() = %q, want 12", got)
+	}
+	if got := snmpIndex("1.3.6.1.2.1.25.2.3.1.2.31", storageTypeOID); got != "31" {
+		t.Errorf("snmpIndex() = %q, want 31", got)
+	}
+}
+
+func TestParseInterfaceTables(t *testing.T) {
+	ifRows := map[string]map[int]interface{}{
+		"1": {
+			ifDescrColumn: []byte("GigabitEthernet1/0/1"), ifTypeColumn: 6, ifSpeedColumn: uint(1000000000),
+			ifAdminStatusColumn: 1, ifOperStatusColumn: 1, ifLastChangeColumn: uint32(4200),
+			10: uint(12345), 16: uint(67890), 13: uint(3), 14: uint(7),
+		},
+		"2": {
+			ifDescrColumn: []byte("TenGigabitEthernet1/1/1"), ifTypeColumn: 6, ifSpeedColumn: uint(4294967295),
+			ifAdminStatusColumn: 1, ifOperStatusColumn: 2,
+			10: uint(1), 16: uint(2),
+		},
+		"3": {ifDescrColumn: []byte("Null0"), ifTypeColumn: 1},
+	}
+	ifXRows := map[string]map[int]interface{}{
+		"1": {
+			ifNameColumn: []byte("Gi1/0/1"), ifAliasColumn: []byte("uplink core-1"),
+			6: uint64(1) << 40, 10: uint64(5000), 2: uint(11), ifHighSpeedColumn: uint(1000),
+			ifCounterDiscontinuityTimeColumn: uint32(0),
+		},
+		"2": {ifNameColumn: []byte("Te1/1/1"), ifHighSpeedColumn: uint(10000)},
+	}
+
+	interfaces := parseInterfaceTables(ifRows, ifXRows)
+	if len(interfaces) != 3 {
+		t.Fatalf("Expected 3 interfaces, got %d", len(interfaces))
+	}
+
+	gi := interfaces["1"]
+	if gi.name != "Gi1/0/1" || gi.alias != "uplink core-1" || gi.descr != "GigabitEthernet1/0/1" || gi.typeName() != "ethernetCsmacd" {
+		t.Errorf("Unexpected interface attributes %+v", gi)
+	}
+	if gi.speed != 1000000000 || gi.adminStatus != 1 || gi.operStatus != 1 || gi.lastChange != 4200 {
+		t.Errorf("Unexpected interface status %+v", gi)
+	}
+	if c := gi.counters["bytes_in"]; c.value != 1<<40 || !c.hc {
+		t.Errorf("Expected the 64-bit octet counter, got %+v", c)
+	}
+	if c := gi.counters["multicast_packets_in"]; c.value != 11 || c.hc {
+		t.Errorf("Expected the 32-bit multicast counter, got %+v", c)
+	}
+	if gi.counters["errors_in"].value != 7 || gi.counters["discards_in"].value != 3 {
+		t.Errorf("Unexpected error counters %+v", gi.counters)
+	}
+
+	te := interfaces["2"]
+	if te.speed != 10000000000 {
+		t.Errorf("Expected ifHighSpeed for a saturated ifSpeed, got %d", te.speed)
+	}
+	if c := te.counters["bytes_in"]; c.value != 1 || c.hc {
+		t.Errorf("Expected the 32-bit octet counter without ifHC counters, got %+v", c)
+	}
+
+	// Without ifXTable the description names the interface
+	if null := interfaces["3"]; null.name != "Null0" || null.typeName() != "other" {
+		t.Errorf("Unexpected interface %+v", null)
+	}
+}
+
+func TestSNMPInterfaceFilter(t *testing.T) {
+	interfaces := []snmpInterface{
+		{name: "Gi1/0/1", alias: "uplink core-1", ifType: 6},
+		{name: "Gi1/0/2", alias: "", ifType: 6},
+		{name: "Vlan10", alias: "users", ifType: 53},
+		{name: "Lo0", ifType: 24},
+		{name: "Tunnel5", ifType: 999},
+	}
+
+	tests := []struct {
+		name   string
+		filter config.SNMPInterfaceFilter
+		want   []string
+	}{
+		{
+			name: "empty filter",
+			want: []string{"Gi1/0/1", "Gi1/0/2", "Vlan10", "Lo0", "Tunnel5"},
+		},
+		{
+			name:   "exclude loopbacks and VLANs by type",
+			filter: config.SNMPInterfaceFilter{ExcludeTypes: []string{"^softwareLoopback$", "^propVirtual$"}},
+			want:   []string{"Gi1/0/1", "Gi1/0/2", "Tunnel5"},
+		},
+		{
+			name:   "include by name and alias",
+			filter: config.SNMPInterfaceFilter{IncludeNames: []string{"^Gi"}, IncludeAliases: []string{"."}},
+			want:   []string{"Gi1/0/1"},
+		},
+		{
+			name:   "unknown type by number",
+			filter: config.SNMPInterfaceFilter{IncludeTypes: []string{"^999$"}},
+			want:   []string{"Tunnel5"},
+		},
+		{
+			name:   "exclude by name",
+			filter: config.SNMPInterfaceFilter{IncludeTypes: []string{"ethernet"}, ExcludeNames: []string{"/2$"}},
+			want:   []string{"Gi1/0/1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := compileInterfaceFilter(tt.filter)
+			if err != nil {
+				t.Fatalf("compileInterfaceFilter() error = %v", err)
+			}
+			var got []string
+			for _, iface := range interfaces {
+				if f.matches(iface) {
+					got = append(got, iface.name)
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Selected %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := compileInterfaceFilter(config.SNMPInterfaceFilter{ExcludeNames: []string{"("}}); err == nil {
+		t.Error("Expected an error for an invalid pattern")
+	}
+}
+
+// testInterface returns an up 1 Gbit/s interface with the given octet
+// counters
+func testInterface(name string, in, out uint64, hc bool) snmpInterface {
+	return snmpInterface{
+		name: name, speed: 1000000000, adminStatus: 1, operStatus: 1, lastChange: 1000,
+		counters: map[string]snmpCounter{
+			"bytes_in":  {value: in, hc: hc},
+			"bytes_out": {value: out, hc: hc},
+			"errors_in": {value: 10},
+		},
+	}
+}
+
+func TestSNMPInterfaceMetrics(t *testing.T) {
+	start := time.Now()
+	discontinued := testInterface("Gi1/0/3", 9000, 9000, true)
+	discontinued.discontinuity = 500
+	prev := snmpIfSample{
+		at:     start,
+		uptime: 100000,
+		interfaces: map[string]snmpInterface{
+			"1": testInterface("Gi1/0/1", 1<<40, 5000, true),
+			"2": testInterface("Gi1/0/2", 4294967000, 1000, false),
+			"3": discontinued,
+			"4": testInterface("Gi1/0/4", 100, 100, false),
+			"6": testInterface("Gi1/0/6", 100, 100, true),
+		},
+	}
+	discontinued = testInterface("Gi1/0/3", 10, 10, true)
+	discontinued.discontinuity = 100500
+	busy := testInterface("Gi1/0/1", 1<<40+125000000, 5000+1250, true)
+	busy.counters["errors_in"] = snmpCounter{value: 15}
+	cur := snmpIfSample{
+		at:     start.Add(10 * time.Second),
+		uptime: 101000,
+		interfaces: map[string]snmpInterface{
+			"1": busy,
+			"2": testInterface("Gi1/0/2", 704, 2000, false),
+			"3": discontinued,
+			"4": testInterface("Gi1/0/4", 200, 200, true),
+			"5": testInterface("Gi1/0/5", 1, 1, true),
+			"6": testInterface("Po1", 200, 200, true),
+		},
+	}
+
+	byIndex := make(map[string]Metric)
+	for _, m := range snmpInterfaceMetrics(prev, cur, cur.at) {
+		byIndex[m.Tags["interface_index"]] = m
+	}
+	if len(byIndex) != 6 {
+		t.Fatalf("Expected 6 interfaces, got %d", len(byIndex))
+	}
+
+	gi := byIndex["1"]
+	if gi.Tags["interface"] != "Gi1/0/1" || gi.Tags["interface_speed"] != "1000000000" {
+		t.Errorf("Unexpected tags %v", gi.Tags)
+	}
+	want := map[string]float64{
+		"bits_in_per_sec":         100000000,
+		"bits_out_per_sec":        1000,
+		"utilization_in_percent":  10,
+		"utilization_out_percent": 0.0001,
+		"errors_in_per_sec":       0.5,
+		"errors_in":               15,
+		"oper_status":             1,
+		"seconds_since_change":    1000,
+	}
+	for field, v := range want {
+		if !approx(gi.Value[field], v) {
+			t.Errorf("%s = %v, want %v", field, gi.Value[field], v)
+		}
+	}
+
+	if !approx(byIndex["2"].Value["bits_in_per_sec"], 800) || !approx(byIndex["2"].Value["bytes_in"], 704) {
+		t.Errorf("Unexpected rates across a 32-bit wrap %v", byIndex["2"].Value)
+	}
+
+	// A discontinuity, a new interface or a renumbered index has counters
+	// but no rates, and a change of counter width has no octet rates
+	for _, ifIndex := range []string{"3", "4", "5", "6"} {
+		if _, ok := byIndex[ifIndex].Value["bits_in_per_sec"]; ok {
+			t.Errorf("Expected no rates for interface %s, got %v", ifIndex, byIndex[ifIndex].Value)
+		}
+		if _, ok := byIndex[ifIndex].Value["bytes_in"]; !ok {
+			t.Errorf("Expected counters for interface %s", ifIndex)
+		}
+	}
+
+	// A reboot resets every counter
+	cur.uptime = 500
+	for _, m := range snmpInterfaceMetrics(prev, cur, cur.at) {
+		if _, ok := m.Value["bits_in_per_sec"]; ok {
+			t.Errorf("Expected no rates after a reboot, got %v", m.Value)
+		}
+	}
+
+	// The first poll has no rates
+	for _, m := range snmpInterfaceMetrics(snmpIfSample{}, cur, cur.at) {
+		if _, ok := m.Value["bits_in_per_sec"]; ok {
+			t.Errorf("Expected no rates on the first poll, got %v", m.Value)
+		}
+	}
+}
+
+// scalarRecorder is a fakeAgent that records the scalars it is asked for
+type scalarRecorder struct {
+	fakeAgent
+	gets []string
+}
+
+func (a *scalarRecorder) Get(oids []string) (*gosnmp.SnmpPacket, error) {
+	a.gets = append(a.gets, oids...)
+	return a.fakeAgent.Get(oids)
+}
+
+func TestSNMPCollector_InterfaceMetricsUsePolledUptime(t *testing.T) {
+	c, err := NewSNMPCollector(config.SNMPConfig{Community: "public", Version: "2c"})
+	if err != nil {
+		t.Fatalf("NewSNMPCollector() error = %v", err)
+	}
+
+	agent := &scalarRecorder{fakeAgent: fakeAgent{
+		ifEntryOID + ".2.1":  "Gi1/0/1",
+		ifEntryOID + ".8.1":  1,
+		ifEntryOID + ".10.1": uint(1000),
+	}}
+	system := map[string]interface{}{sysUptimeOID: uint32(123456)}
+
+	if _, err := c.collectInterfaceMetrics(agent, "192.0.2.1", system, time.Now()); err != nil {
+		t.Fatalf("collectInterfaceMetrics() error = %v", err)
+	}
+	if len(agent.gets) != 0 {
+		t.Errorf("Expected no scalar requests, got %v", agent.gets)
+	}
+	if got := c.ifSamples["192.0.2.1"]; got.uptime != 123456 || len(got.interfaces) != 1 {
+		t.Errorf("Expected a sample at uptime 123456 with one interface, got %d with %d", got.uptime, len(got.interfaces))
+	}
+
+	metrics := systemMetrics(system, time.Now())
+	if len(metrics) != 1 || !approx(metrics[0].Value["uptime_seconds"], 1234.56) {
+		t.Errorf("Expected system_uptime from the polled sysUpTime, got %v", metrics)
+	}
+}
+
+func TestSNMPCollector_PruneSamples(t *testing.T) {
+	c, err := NewSNMPCollector(config.SNMPConfig{Community: "public", Version: "2c"})
+	if err != nil {
+		t.Fatalf("NewSNMPCollector() error = %v", err)
+	}
+
+	now := time.Now()
+	c.ifSamples = map[string]snmpIfSample{
+		"polled":  {at: now.Add(-time.Minute), interval: time.Minute},
+		"gone":    {at: now.Add(-4 * time.Minute), interval: time.Minute},
+		"new":     {at: now.Add(-time.Hour)},
+		"retired": {at: now.Add(-25 * time.Hour)},
+	}
+	c.counterRate("polled|row", uint(10), 100, now.Add(-2*time.Minute))
+	c.counterRate("polled|row", uint(20), 200, now.Add(-time.Minute))
+	c.counterRate("gone|row", uint(10), 100, now.Add(-5*time.Minute))
+	c.counterRate("gone|row", uint(20), 200, now.Add(-4*time.Minute))
+
+	c.pruneSamples(now)
+	for _, key := range []string{"polled", "new"} {
+		if _, ok := c.ifSamples[key]; !ok {
+			t.Errorf("Expected interface sample %s to be kept", key)
+		}
+	}
+	for _, key := range []string{"gone", "retired"} {
+		if _, ok := c.ifSamples[key]; ok {
+			t.Errorf("Expected interface sample %s to be removed", key)
+		}
+	}
+	if _, ok := c.rateSamples["polled|row"]; !ok {
+		t.Error("Expected the rate sample of a polled device to be kept")
+	}
+	if _, ok := c.rateSamples["gone|row"]; ok {
+		t.Error("Expected the rate sample of a device no longer polled to be removed")
+	}
+
+	// Pruning runs at most once per interval
+	c.ifSamples["gone"] = snmpIfSample{at: now.Add(-time.Hour), interval: time.Minute}
+	c.pruneSamples(now.Add(time.Second))
+	if _, ok := c.ifSamples["gone"]; !ok {
+		t.Error("Expected no pruning before samplePruneInterval")
+	}
+}
@@ -0,0 +1,433 @@
+package metrics
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// hrProcessorLoadOID is the HOST-RESOURCES-MIB per-processor load column
+const hrProcessorLoadOID = "1.3.6.1.2.1.25.3.3.1.2"
+
+// snmpRowFilter selects table rows by the value of a column, such as the
+// processor pool of a memory pool table. An empty filter selects every row.
+type snmpRowFilter struct {
+	column  string
+	pattern *regexp.Regexp
+}
+
+// rows returns the selected row indexes, or nil for every row
+func (f snmpRowFilter) rows(g snmpAgent) (map[string]bool, error) {
+	if f.column == "" {
+		return nil, nil
+	}
+	values, err := walkColumn(g, f.column)
+	if err != nil {
+		return nil, err
+	}
+	rows := make(map[string]bool)
+	for index, value := range values {
+		if f.pattern.MatchString(snmpString(value)) {
+			rows[index] = true
+		}
+	}
+	return rows, nil
+}
+
+// readNumbers reads a scalar, or the selected rows of a table column, as
+// numbers
+func readNumbers(g snmpAgent, oid string, table bool, rows map[string]bool) []float64 {
+	if !table {
+		values, err := getScalars(g, []string{oid})
+		if err != nil {
+			return nil
+		}
+		if v, ok := snmpFloat(values[oid]); ok {
+			return []float64{v}
+		}
+		return nil
+	}
+
+	values, err := walkColumn(g, oid)
+	if err != nil {
+		return nil
+	}
+	var numbers []float64
+	for index, value := range values {
+		if rows != nil && !rows[index] {
+			continue
+		}
+		if v, ok := snmpFloat(value); ok {
+			numbers = append(numbers, v)
+		}
+	}
+	return numbers
+}
+
+// sumNumbers reads the sum of a scalar or the selected rows of a column
+func sumNumbers(g snmpAgent, oid string, table bool, rows map[string]bool) (float64, bool) {
+	numbers := readNumbers(g, oid, table, rows)
+	var sum float64
+	for _, v := range numbers {
+		sum += v
+	}
+	return sum, len(numbers) > 0
+}
+
+// snmpCPUSource reads CPU utilization in percent from a scalar, or from a
+// table column whose selected rows are averaged
+type snmpCPUSource struct {
+	oid   string
+	table bool
+	rows  snmpRowFilter
+	// idle is set when the value is the idle percentage
+	idle bool
+}
+
+// read returns the utilization and the number of CPUs it averages
+func (s snmpCPUSource) read(g snmpAgent) (float64, int, bool) {
+	rows, err := s.rows.rows(g)
+	if err != nil {
+		return 0, 0, false
+	}
+	numbers := readNumbers(g, s.oid, s.table, rows)
+	if len(numbers) == 0 {
+		return 0, 0, false
+	}
+
+	var sum float64
+	for _, v := range numbers {
+		sum += v
+	}
+	percent := sum / float64(len(numbers))
+	if s.idle {
+		percent = 100 - percent
+	}
+	return percent, len(numbers), true
+}
+
+// snmpMemory is a memory reading. Total and used are in bytes and are
+// unknown when sized is false.
+type snmpMemory struct {
+	percent     float64
+	total, used float64
+	sized       bool
+}
+
+// snmpMemorySource reads memory from scalars or the selected rows of a
+// table. Total is derived from used and free when not given; used from
+// total and free, or from total and percent.
+type snmpMemorySource struct {
+	table   bool
+	rows    snmpRowFilter
+	total   string
+	used    string
+	free    []string
+	percent string
+	// scale converts values to bytes
+	scale float64
+}
+
+// read returns the memory utilization
+func (s snmpMemorySource) read(g snmpAgent) (snmpMemory, bool) {
+	rows, err := s.rows.rows(g)
+	if err != nil {
+		return snmpMemory{}, false
+	}
+	sum := func(oid string) (float64, bool) {
+		if oid == "" {
+			return 0, false
+		}
+		v, ok := sumNumbers(g, oid, s.table, rows)
+		return v * s.scale, ok
+	}
+
+	total, hasTotal := sum(s.total)
+	used, hasUsed := sum(s.used)
+	var free float64
+	hasFree := len(s.free) > 0
+	for _, oid := range s.free {
+		v, ok := sum(oid)
+		hasFree = hasFree && ok
+		free += v
+	}
+
+	switch {
+	case hasUsed && hasFree && !hasTotal:
+		total, hasTotal = used+free, true
+	case hasTotal && hasFree && !hasUsed:
+		used, hasUsed = total-free, true
+	}
+
+	if hasTotal && hasUsed && total > 0 {
+		return snmpMemory{percent: used / total * 100, total: total, used: used, sized: true}, true
+	}
+	if s.percent == "" {
+		return snmpMemory{}, false
+	}
+	percents := readNumbers(g, s.percent, s.table, rows)
+	if len(percents) == 0 {
+		return snmpMemory{}, false
+	}
+	var memory snmpMemory
+	for _, v := range percents {
+		memory.percent += v / float64(len(percents))
+	}
+	if hasTotal && total > 0 {
+		memory.total, memory.used, memory.sized = total, total*memory.percent/100, true
+	}
+	return memory, true
+}
+
+// snmpMatch selects devices by sysObjectID or sysDescr. An empty match
+// selects every device.
+type snmpMatch struct {
+	// sysObjectIDs are the enterprise subtrees of matching devices
+	sysObjectIDs []string
+	// sysDescr matches devices by description, for agents such as net-snmp
+	// whose sysObjectID does not identify the platform
+	sysDescr *regexp.Regexp
+}
+
+// matches reports whether a device is selected
+func (m snmpMatch) matches(sysObjectID, sysDescr string) bool {
+	if len(m.sysObjectIDs) == 0 && m.sysDescr == nil {
+		return true
+	}
+	for _, prefix := range m.sysObjectIDs {
+		if sysObjectID == prefix || strings.HasPrefix(sysObjectID, prefix+".") {
+			return true
+		}
+	}
+	return m.sysDescr != nil && m.sysDescr.MatchString(sysDescr)
+}
+
+// snmpProfile holds the CPU and memory sources of a device family. Sources
+// are tried in order, then the HOST-RESOURCES-MIB fallbacks.
+type snmpProfile struct {
+	name string
+	snmpMatch
+	cpu    []snmpCPUSource
+	memory []snmpMemorySource
+}
+
+// Row filters of vendor tables
+var (
+	// jnxOperatingDescr of the routing engines in jnxOperatingTable
+	juniperRoutingEngines = snmpRowFilter{column: "1.3.6.1.4.1.2636.3.1.13.1.5", pattern: regexp.MustCompile(`(?i)routing engine`)}
+	// ciscoMemoryPoolName of the processor pool
+	ciscoProcessorPool = snmpRowFilter{column: "1.3.6.1.4.1.9.9.48.1.1.1.2", pattern: regexp.MustCompile(`^Processor$`)}
+)
+
+// snmpProfiles are the built-in vendor profiles, matched in order
+var snmpProfiles = []*snmpProfile{
+	{
+		name:      "cisco",
+		snmpMatch: snmpMatch{sysObjectIDs: []string{"1.3.6.1.4.1.9"}},
+		cpu: []snmpCPUSource{
+			// CISCO-PROCESS-MIB cpmCPUTotal1minRev, per CPU
+			{oid: "1.3.6.1.4.1.9.9.109.1.1.1.1.7", table: true},
+			// OLD-CISCO-CPU-MIB avgBusy1
+			{oid: "1.3.6.1.4.1.9.2.1.57.0"},
+		},
+		memory: []snmpMemorySource{
+			// CISCO-MEMORY-POOL-MIB ciscoMemoryPoolUsed and Free
+			{table: true, rows: ciscoProcessorPool, used: "1.3.6.1.4.1.9.9.48.1.1.1.5", free: []string{"1.3.6.1.4.1.9.9.48.1.1.1.6"}, scale: 1},
+			// CISCO-PROCESS-MIB cpmCPUMemoryUsed and Free, in kilobytes
+			{table: true, used: "1.3.6.1.4.1.9.9.109.1.1.1.1.12", free: []string{"1.3.6.1.4.1.9.9.109.1.1.1.1.13"}, scale: 1024},
+		},
+	},
+	{
+		name:      "juniper",
+		snmpMatch: snmpMatch{sysObjectIDs: []string{"1.3.6.1.4.1.2636"}},
+		cpu: []snmpCPUSource{
+			// JUNIPER-MIB jnxOperatingCPU of the routing engines
+			{oid: "1.3.6.1.4.1.2636.3.1.13.1.8", table: true, rows: juniperRoutingEngines},
+		},
+		memory: []snmpMemorySource{
+			// jnxOperatingBuffer percentage of jnxOperatingMemory megabytes
+			{table: true, rows: juniperRoutingEngines, percent: "1.3.6.1.4.1.2636.3.1.13.1.11", total: "1.3.6.1.4.1.2636.3.1.13.1.15", scale: 1024 * 1024},
+		},
+	},
+	{
+		name:      "aruba",
+		snmpMatch: snmpMatch{sysObjectIDs: []string{"1.3.6.1.4.1.14823"}},
+		cpu: []snmpCPUSource{
+			// WLSX-SYSTEMEXT-MIB sysXProcessorLoad
+			{oid: "1.3.6.1.4.1.14823.2.2.1.1.1.9.1.3", table: true},
+		},
+		memory: []snmpMemorySource{
+			// sysXMemoryTotal and sysXMemoryUsed, in kilobytes
+			{table: true, total: "1.3.6.1.4.1.14823.2.2.1.1.1.11.1.2", used: "1.3.6.1.4.1.14823.2.2.1.1.1.11.1.3", scale: 1024},
+		},
+	},
+	{
+		name:      "aruba-switch",
+		snmpMatch: snmpMatch{sysObjectIDs: []string{"1.3.6.1.4.1.11.2.3.7.11"}},
+		cpu: []snmpCPUSource{
+			// STATISTICS-MIB hpSwitchCpuStat
+			{oid: "1.3.6.1.4.1.11.2.14.11.5.1.9.6.1.0"},
+		},
+		memory: []snmpMemorySource{
+			// NETSWITCH-MIB hpLocalMemTotalBytes and hpLocalMemAllocBytes
+			{table: true, total: "1.3.6.1.4.1.11.2.14.11.5.1.1.2.1.1.1.5", used: "1.3.6.1.4.1.11.2.14.11.5.1.1.2.1.1.1.7", scale: 1},
+		},
+	},
+	{
+		name:      "fortinet",
+		snmpMatch: snmpMatch{sysObjectIDs: []string{"1.3.6.1.4.1.12356"}},
+		cpu: []snmpCPUSource{
+			// FORTINET-FORTIGATE-MIB fgSysCpuUsage
+			{oid: "1.3.6.1.4.1.12356.101.4.1.3.0"},
+		},
+		memory: []snmpMemorySource{
+			// fgSysMemUsage percentage of fgSysMemCapacity kilobytes
+			{percent: "1.3.6.1.4.1.12356.101.4.1.4.0", total: "1.3.6.1.4.1.12356.101.4.1.5.0", scale: 1024},
+		},
+	},
+	{
+		// RouterOS reports CPU and RAM through HOST-RESOURCES-MIB
+		name:      "mikrotik",
+		snmpMatch: snmpMatch{sysObjectIDs: []string{"1.3.6.1.4.1.14988"}},
+	},
+	{
+		name:      "net-snmp",
+		snmpMatch: snmpMatch{sysObjectIDs: []string{"1.3.6.1.4.1.8072.3.2.10"}, sysDescr: regexp.MustCompile(`^Linux `)},
+		memory: []snmpMemorySource{
+			// UCD-SNMP-MIB memTotalReal less memAvailReal, memBuffer and
+			// memCached, in kilobytes; hrStorageRam counts the page cache
+			// as used
+			{
+				total: "1.3.6.1.4.1.2021.4.5.0",
+				free:  []string{"1.3.6.1.4.1.2021.4.6.0", "1.3.6.1.4.1.2021.4.14.0", "1.3.6.1.4.1.2021.4.15.0"},
+				scale: 1024,
+			},
+		},
+	},
+}
+
+// hostResourcesProfile is used for devices no vendor profile matches
+var hostResourcesProfile = &snmpProfile{name: "host-resources"}
+
+// selectSNMPProfile returns the first profile matching a device
+func selectSNMPProfile(profiles []*snmpProfile, sysObjectID, sysDescr string) *snmpProfile {
+	for _, profile := range profiles {
+		if profile.matches(sysObjectID, sysDescr) {
+			return profile
+		}
+	}
+	return hostResourcesProfile
+}
+
+// readHostResourcesMemory sums the hrStorageRam entries of hrStorageTable
+func readHostResourcesMemory(g snmpAgent) (snmpMemory, bool) {
+	types, err := walkColumn(g, storageTypeOID)
+	if err != nil {
+		return snmpMemory{}, false
+	}
+	rows := make(map[string]bool)
+	for index, value := range types {
+		if snmpOID(snmpString(value)) == storageRamType {
+			rows[index] = true
+		}
+	}
+	if len(rows) == 0 {
+		return snmpMemory{}, false
+	}
+
+	units, _ := walkColumn(g, storageUnitsOID)
+	sizes, _ := walkColumn(g, storageSizeOID)
+	used, _ := walkColumn(g, storageUsedOID)
+
+	var memory snmpMemory
+	for index := range rows {
+		unit, ok1 := snmpFloat(units[index])
+		size, ok2 := snmpFloat(sizes[index])
+		inUse, ok3 := snmpFloat(used[index])
+		if !ok1 || !ok2 || !ok3 {
+			continue
+		}
+		memory.total += size * unit
+		memory.used += inUse * unit
+	}
+	if memory.total == 0 {
+		return snmpMemory{}, false
+	}
+	memory.percent = memory.used / memory.total * 100
+	memory.sized = true
+	return memory, true
+}
+
+// profileCPUMetrics reads CPU utilization with the sources of a profile,
+// falling back to hrProcessorLoad averaged across processors
+func profileCPUMetrics(g snmpAgent, profile *snmpProfile, timestamp time.Time) ([]Metric, error) {
+	sources := append(append([]snmpCPUSource(nil), profile.cpu...), snmpCPUSource{oid: hrProcessorLoadOID, table: true})
+	for _, source := range sources {
+		percent, count, ok := source.read(g)
+		if !ok {
+			continue
+		}
+		return []Metric{
+			{
+				Name: "cpu_utilization",
+				Value: map[string]interface{}{
+					"cpu_percent": percent,
+					"cpu_count":   float64(count),
+				},
+				Timestamp: timestamp,
+				Tags: map[string]string{
+					"metric_type": "cpu",
+					"profile":     profile.name,
+				},
+			},
+		}, nil
+	}
+	return nil, fmt.Errorf("no CPU utilization for profile %s", profile.name)
+}
+
+// profileMemoryMetrics reads memory utilization with the sources of a
+// profile, falling back to the hrStorageRam entries of hrStorageTable.
+// memory_total and memory_used keep the kilobytes of hrMemorySize that SNMP
+// memory was always reported in; the _mb fields match the SSH and WMI
+// collectors.
+func profileMemoryMetrics(g snmpAgent, profile *snmpProfile, timestamp time.Time) ([]Metric, error) {
+	memory, ok := snmpMemory{}, false
+	for _, source := range profile.memory {
+		if memory, ok = source.read(g); ok {
+			break
+		}
+	}
+	if !ok {
+		if memory, ok = readHostResourcesMemory(g); !ok {
+			return nil, fmt.Errorf("no memory utilization for profile %s", profile.name)
+		}
+	}
+
+	const (
+		bytesPerKB = 1024
+		bytesPerMB = 1024 * 1024
+	)
+	value := map[string]interface{}{
+		"memory_percent": memory.percent,
+	}
+	if memory.sized {
+		value["memory_total"] = memory.total / bytesPerKB
+		value["memory_used"] = memory.used / bytesPerKB
+		value["memory_total_mb"] = memory.total / bytesPerMB
+		value["memory_used_mb"] = memory.used / bytesPerMB
+		value["memory_available_mb"] = (memory.total - memory.used) / bytesPerMB
+	}
+
+	return []Metric{
+		{
+			Name:      "memory_utilization",
+			Value:     value,
+			Timestamp: timestamp,
+			Tags: map[string]string{
+				"metric_type": "memory",
+				"profile":     profile.name,
+			},
+		},
+	}, nil
+}
@@ -0,0 +1,294 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+// fakeAgent is an SNMP agent serving a fixed set of objects
+type fakeAgent map[string]interface{}
+
+func (a fakeAgent) Get(oids []string) (*gosnmp.SnmpPacket, error) {
+	packet := &gosnmp.SnmpPacket{}
+	for _, oid := range oids {
+		pdu := gosnmp.SnmpPDU{Name: "." + oid, Type: gosnmp.NoSuchObject}
+		if value, ok := a[oid]; ok {
+			pdu.Type, pdu.Value = gosnmp.Integer, value
+		}
+		packet.Variables = append(packet.Variables, pdu)
+	}
+	return packet, nil
+}
+
+func (a fakeAgent) BulkWalkAll(rootOid string) ([]gosnmp.SnmpPDU, error) {
+	var result []gosnmp.SnmpPDU
+	for oid, value := range a {
+		if strings.HasPrefix(oid, rootOid+".") {
+			result = append(result, gosnmp.SnmpPDU{Name: "." + oid, Type: gosnmp.Integer, Value: value})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+func TestSelectSNMPProfile(t *testing.T) {
+	tests := []struct {
+		name        string
+		sysObjectID string
+		sysDescr    string
+		want        string
+	}{
+		{name: "Cisco Catalyst", sysObjectID: "1.3.6.1.4.1.9.1.1208", sysDescr: "Cisco IOS Software", want: "cisco"},
+		{name: "enterprise number sharing a prefix", sysObjectID: "1.3.6.1.4.1.94.1.1", want: "host-resources"},
+		{name: "Juniper MX", sysObjectID: "1.3.6.1.4.1.2636.1.1.1.2.25", want: "juniper"},
+		{name: "Aruba controller", sysObjectID: "1.3.6.1.4.1.14823.1.1.32", want: "aruba"},
+		{name: "ArubaOS-Switch", sysObjectID: "1.3.6.1.4.1.11.2.3.7.11.182", want: "aruba-switch"},
+		{name: "MikroTik", sysObjectID: "1.3.6.1.4.1.14988.1", want: "mikrotik"},
+		{name: "FortiGate", sysObjectID: "1.3.6.1.4.1.12356.101.1.1004", want: "fortinet"},
+		{name: "net-snmp on Linux", sysObjectID: "1.3.6.1.4.1.8072.3.2.10", want: "net-snmp"},
+		{name: "Linux by description", sysObjectID: "1.3.6.1.4.1.99999.1", sysDescr: "Linux web1 5.15.0-91-generic", want: "net-snmp"},
+		{name: "unknown device", sysObjectID: "1.3.6.1.4.1.99999.1", sysDescr: "Printer", want: "host-resources"},
+		{name: "no sysObjectID", want: "host-resources"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := selectSNMPProfile(snmpProfiles, tt.sysObjectID, tt.sysDescr); got.name != tt.want {
+				t.Errorf("selectSNMPProfile() = %s, want %s", got.name, tt.want)
+			}
+		})
+	}
+}
+
+// profileNamed returns a built-in profile by name
+func profileNamed(t *testing.T, name string) *snmpProfile {
+	for _, profile := range snmpProfiles {
+		if profile.name == name {
+			return profile
+		}
+	}
+	t.Fatalf("No profile %s", name)
+	return nil
+}
+
+func TestProfileCPUMetrics(t *testing.T) {
+	tests := []struct {
+		name      string
+		profile   string
+		agent     fakeAgent
+		want      float64
+		wantCount float64
+		wantErr   bool
+	}{
+		{
+			name:    "Cisco averages cpmCPUTotal1minRev",
+			profile: "cisco",
+			agent: fakeAgent{
+				"1.3.6.1.4.1.9.9.109.1.1.1.1.7.1":    uint(10),
+				"1.3.6.1.4.1.9.9.109.1.1.1.1.7.4001": uint(30),
+			},
+			want: 20, wantCount: 2,
+		},
+		{
+			name:    "Cisco without CISCO-PROCESS-MIB",
+			profile: "cisco",
+			agent:   fakeAgent{"1.3.6.1.4.1.9.2.1.57.0": 12},
+			want:    12, wantCount: 1,
+		},
+		{
+			name:    "Juniper routing engines only",
+			profile: "juniper",
+			agent: fakeAgent{
+				"1.3.6.1.4.1.2636.3.1.13.1.5.7.1.0.0": []byte("FPC: MPC7E 3D @ 0/*/*"),
+				"1.3.6.1.4.1.2636.3.1.13.1.5.9.1.0.0": []byte("Routing Engine 0"),
+				"1.3.6.1.4.1.2636.3.1.13.1.5.9.2.0.0": []byte("Routing Engine 1"),
+				"1.3.6.1.4.1.2636.3.1.13.1.8.7.1.0.0": uint(90),
+				"1.3.6.1.4.1.2636.3.1.13.1.8.9.1.0.0": uint(8),
+				"1.3.6.1.4.1.2636.3.1.13.1.8.9.2.0.0": uint(2),
+			},
+			want: 5, wantCount: 2,
+		},
+		{
+			name:    "FortiGate",
+			profile: "fortinet",
+			agent:   fakeAgent{"1.3.6.1.4.1.12356.101.4.1.3.0": uint(41)},
+			want:    41, wantCount: 1,
+		},
+		{
+			name:    "hrProcessorLoad fallback",
+			profile: "mikrotik",
+			agent: fakeAgent{
+				"1.3.6.1.2.1.25.3.3.1.2.1": 10,
+				"1.3.6.1.2.1.25.3.3.1.2.2": 20,
+				"1.3.6.1.2.1.25.3.3.1.2.3": 30,
+				"1.3.6.1.2.1.25.3.3.1.2.4": 40,
+			},
+			want: 25, wantCount: 4,
+		},
+		{
+			name:    "vendor OIDs missing",
+			profile: "fortinet",
+			agent:   fakeAgent{"1.3.6.1.2.1.25.3.3.1.2.196608": 7},
+			want:    7, wantCount: 1,
+		},
+		{
+			name:    "no CPU objects",
+			profile: "cisco",
+			agent:   fakeAgent{},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile := profileNamed(t, tt.profile)
+			metrics, err := profileCPUMetrics(tt.agent, profile, time.Now())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("profileCPUMetrics() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(metrics) != 1 {
+				t.Fatalf("Expected 1 metric, got %d", len(metrics))
+			}
+			m := metrics[0]
+			if !approx(m.Value["cpu_percent"], tt.want) || !approx(m.Value["cpu_count"], tt.wantCount) {
+				t.Errorf("Unexpected CPU metric %v", m.Value)
+			}
+			if m.Tags["profile"] != tt.profile {
+				t.Errorf("Expected profile tag %s, got %v", tt.profile, m.Tags)
+			}
+		})
+	}
+}
+
+func TestProfileMemoryMetrics(t *testing.T) {
+	const mb = 1024 * 1024
+
+	tests := []struct {
+		name      string
+		profile   string
+		agent     fakeAgent
+		want      float64
+		wantTotal float64 // megabytes, 0 when unknown
+		wantErr   bool
+	}{
+		{
+			name:    "Cisco processor pool",
+			profile: "cisco",
+			agent: fakeAgent{
+				"1.3.6.1.4.1.9.9.48.1.1.1.2.1": []byte("Processor"),
+				"1.3.6.1.4.1.9.9.48.1.1.1.2.2": []byte("I/O"),
+				"1.3.6.1.4.1.9.9.48.1.1.1.5.1": uint(256 * mb),
+				"1.3.6.1.4.1.9.9.48.1.1.1.5.2": uint(60 * mb),
+				"1.3.6.1.4.1.9.9.48.1.1.1.6.1": uint(768 * mb),
+				"1.3.6.1.4.1.9.9.48.1.1.1.6.2": uint(4 * mb),
+			},
+			want: 25, wantTotal: 1024,
+		},
+		{
+			name:    "Cisco cpmCPUMemory in kilobytes",
+			profile: "cisco",
+			agent: fakeAgent{
+				"1.3.6.1.4.1.9.9.109.1.1.1.1.12.7": uint(3 * 1024 * 1024),
+				"1.3.6.1.4.1.9.9.109.1.1.1.1.13.7": uint(1 * 1024 * 1024),
+			},
+			want: 75, wantTotal: 4096,
+		},
+		{
+			name:    "Juniper routing engine buffer percentage",
+			profile: "juniper",
+			agent: fakeAgent{
+				"1.3.6.1.4.1.2636.3.1.13.1.5.7.1.0.0":  []byte("FPC: MPC7E 3D @ 0/*/*"),
+				"1.3.6.1.4.1.2636.3.1.13.1.5.9.1.0.0":  []byte("Routing Engine 0"),
+				"1.3.6.1.4.1.2636.3.1.13.1.11.7.1.0.0": uint(80),
+				"1.3.6.1.4.1.2636.3.1.13.1.11.9.1.0.0": uint(40),
+				"1.3.6.1.4.1.2636.3.1.13.1.15.7.1.0.0": uint(2048),
+				"1.3.6.1.4.1.2636.3.1.13.1.15.9.1.0.0": uint(16384),
+			},
+			want: 40, wantTotal: 16384,
+		},
+		{
+			name:    "FortiGate",
+			profile: "fortinet",
+			agent: fakeAgent{
+				"1.3.6.1.4.1.12356.101.4.1.4.0": uint(60),
+				"1.3.6.1.4.1.12356.101.4.1.5.0": uint(2 * 1024 * 1024),
+			},
+			want: 60, wantTotal: 2048,
+		},
+		{
+			name:    "FortiGate without capacity",
+			profile: "fortinet",
+			agent:   fakeAgent{"1.3.6.1.4.1.12356.101.4.1.4.0": uint(60)},
+			want:    60,
+		},
+		{
+			name:    "net-snmp excludes buffers and cache",
+			profile: "net-snmp",
+			agent: fakeAgent{
+				"1.3.6.1.4.1.2021.4.5.0":  8 * 1024 * 1024,
+				"1.3.6.1.4.1.2021.4.6.0":  1 * 1024 * 1024,
+				"1.3.6.1.4.1.2021.4.14.0": 1 * 1024 * 1024,
+				"1.3.6.1.4.1.2021.4.15.0": 4 * 1024 * 1024,
+			},
+			want: 25, wantTotal: 8192,
+		},
+		{
+			name:    "hrStorageRam fallback",
+			profile: "mikrotik",
+			agent: fakeAgent{
+				// Index 1 is a disk, not RAM
+				"1.3.6.1.2.1.25.2.3.1.2.1":     ".1.3.6.1.2.1.25.2.1.4",
+				"1.3.6.1.2.1.25.2.3.1.2.65536": ".1.3.6.1.2.1.25.2.1.2",
+				"1.3.6.1.2.1.25.2.3.1.4.1":     4096,
+				"1.3.6.1.2.1.25.2.3.1.4.65536": 1024,
+				"1.3.6.1.2.1.25.2.3.1.5.1":     1000000,
+				"1.3.6.1.2.1.25.2.3.1.5.65536": 262144,
+				"1.3.6.1.2.1.25.2.3.1.6.1":     900000,
+				"1.3.6.1.2.1.25.2.3.1.6.65536": 65536,
+			},
+			want: 25, wantTotal: 256,
+		},
+		{
+			name:    "no memory objects",
+			profile: "aruba",
+			agent:   fakeAgent{"1.3.6.1.2.1.25.2.3.1.2.1": ".1.3.6.1.2.1.25.2.1.4"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics, err := profileMemoryMetrics(tt.agent, profileNamed(t, tt.profile), time.Now())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("profileMemoryMetrics() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			value := metrics[0].Value
+			if !approx(value["memory_percent"], tt.want) {
+				t.Errorf("memory_percent = %v, want %v", value["memory_percent"], tt.want)
+			}
+			if tt.wantTotal == 0 {
+				if _, ok := value["memory_total"]; ok {
+					t.Errorf("Expected no memory size, got %v", value)
+				}
+				return
+			}
+			if !approx(value["memory_total_mb"], tt.wantTotal) || !approx(value["memory_used_mb"], tt.wantTotal*tt.want/100) {
+				t.Errorf("Unexpected memory size %v", value)
+			}
+			// The original fields stay in kilobytes
+			if !approx(value["memory_total"], tt.wantTotal*1024) || !approx(value["memory_used"], tt.wantTotal*1024*tt.want/100) {
+				t.Errorf("Expected memory_total and memory_used in kilobytes, got %v", value)
+			}
+		})
+	}
+}
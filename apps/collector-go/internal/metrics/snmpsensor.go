@@ -0,0 +1,323 @@
+package metrics
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Sensor tables. CISCO-ENTITY-SENSOR-MIB entSensorValueTable has the layout
+// of ENTITY-SENSOR-MIB entPhySensorTable, with a dBm type added, and is
+// read on Cisco devices without the standard MIB.
+const (
+	entPhySensorEntryOID   = "1.3.6.1.2.1.99.1.1.1"
+	ciscoEntSensorEntryOID = "1.3.6.1.4.1.9.9.91.1.1.1.1"
+	entPhysicalEntryOID    = "1.3.6.1.2.1.47.1.1.1.1"
+)
+
+// Sensor table columns
+const (
+	sensorTypeColumn      = 1
+	sensorScaleColumn     = 2
+	sensorPrecisionColumn = 3
+	sensorValueColumn     = 4
+	sensorStatusColumn    = 5
+)
+
+// entPhysicalTable columns
+const (
+	entPhysicalDescrColumn       = 2
+	entPhysicalContainedInColumn = 4
+	entPhysicalClassColumn       = 5
+	entPhysicalNameColumn        = 7
+)
+
+// PhysicalClass values of the entities containing sensors
+const (
+	entClassPowerSupply = 6
+	entClassFan         = 7
+	entClassPort        = 10
+)
+
+// EntitySensorStatus ok, and the EntitySensorDataType values of optical
+// power; dBm is a CISCO-ENTITY-SENSOR-MIB addition
+const (
+	sensorStatusOK  = 1
+	sensorTypeWatts = 6
+	sensorTypeDBm   = 14
+)
+
+// sensorTypes names each sensor type and its measurement and field
+var sensorTypes = map[int]struct {
+	name        string
+	measurement string
+	field       string
+}{
+	1:  {"other", "sensor", "value"},
+	2:  {"unknown", "sensor", "value"},
+	3:  {"voltsAC", "voltage", "volts"},
+	4:  {"voltsDC", "voltage", "volts"},
+	5:  {"amperes", "current", "amperes"},
+	6:  {"watts", "power", "watts"},
+	7:  {"hertz", "frequency", "hertz"},
+	8:  {"celsius", "temperature", "temperature_celsius"},
+	9:  {"percentRH", "humidity", "percent_rh"},
+	10: {"rpm", "fan_speed", "rpm"},
+	11: {"cmm", "airflow", "cmm"},
+	12: {"truthvalue", "sensor", "value"},
+	13: {"specialEnum", "sensor", "value"},
+	14: {"dBm", "power", "dbm"},
+}
+
+// transceiverPattern matches the names of pluggable optics
+var transceiverPattern = regexp.MustCompile(`(?i)transceiver|\bsfp|qsfp|xfp|cfp|optic`)
+
+// physicalEntity is a row of ENTITY-MIB entPhysicalTable
+type physicalEntity struct {
+	name   string
+	descr  string
+	class  int
+	parent string
+}
+
+// snmpSensor is a sensor reading with the entities containing it
+type snmpSensor struct {
+	index  string
+	name   string
+	typ    int
+	status int
+	// value is in the units of the type, and not set unless status is ok
+	value    float64
+	hasValue bool
+	parent   string
+	// component is the kind of the device part the sensor monitors
+	component string
+}
+
+// sensorValue applies the scale and precision of a sensor to its reading.
+// SensorDataScale 9 is units, each step a factor of 1000; the precision is
+// the number of decimal places.
+func sensorValue(raw, scale, precision int) float64 {
+	return float64(raw) * math.Pow10(3*(scale-9)-precision)
+}
+
+// sensorComponent classifies a sensor by the entities containing it. A dBm
+// sensor or one within a port or pluggable optic monitors a transceiver.
+func sensorComponent(entities map[string]physicalEntity, index string, sensorType int) string {
+	if sensorType == sensorTypeDBm {
+		return "transceiver"
+	}
+	parent := entities[index].parent
+	for depth := 0; depth < 16 && parent != "" && parent != "0"; depth++ {
+		entity, ok := entities[parent]
+		if !ok {
+			break
+		}
+		switch {
+		case entity.class == entClassPowerSupply:
+			return "power_supply"
+		case entity.class == entClassFan:
+			return "fan"
+		case entity.class == entClassPort || transceiverPattern.MatchString(entity.name+" "+entity.descr):
+			return "transceiver"
+		}
+		parent = entity.parent
+	}
+	return "chassis"
+}
+
+// parseSensors builds sensors from the rows of a sensor table, named by
+// their physical entity
+func parseSensors(rows map[string]map[int]interface{}, entities map[string]physicalEntity) []snmpSensor {
+	var sensors []snmpSensor
+	for index, row := range rows {
+		raw, ok := row[sensorValueColumn].(int)
+		if !ok {
+			continue
+		}
+		sensor := snmpSensor{index: index, status: sensorStatusOK}
+		sensor.typ, _ = row[sensorTypeColumn].(int)
+		if status, ok := row[sensorStatusColumn].(int); ok {
+			sensor.status = status
+		}
+		if sensor.status == sensorStatusOK {
+			scale, ok := row[sensorScaleColumn].(int)
+			if !ok {
+				scale = 9
+			}
+			precision, _ := row[sensorPrecisionColumn].(int)
+			sensor.value, sensor.hasValue = sensorValue(raw, scale, precision), true
+		}
+
+		entity := entities[index]
+		sensor.name = entity.name
+		if sensor.name == "" {
+			sensor.name = entity.descr
+		}
+		if sensor.name == "" {
+			sensor.name = index
+		}
+		if parent, ok := entities[entity.parent]; ok {
+			sensor.parent = parent.name
+		}
+		sensor.component = sensorComponent(entities, index, sensor.typ)
+		sensors = append(sensors, sensor)
+	}
+	return sensors
+}
+
+// sensorMetrics reports a metric per sensor, in the measurement of its type.
+// Optical power in watts is also reported in dBm.
+func sensorMetrics(sensors []snmpSensor, timestamp time.Time) []Metric {
+	var metrics []Metric
+	for _, sensor := range sensors {
+		kind, ok := sensorTypes[sensor.typ]
+		if !ok {
+			kind = sensorTypes[1]
+		}
+		value := map[string]interface{}{
+			"status": float64(sensor.status),
+		}
+		if sensor.hasValue {
+			value[kind.field] = sensor.value
+			if sensor.typ == sensorTypeWatts && sensor.component == "transceiver" && sensor.value > 0 {
+				value["dbm"] = 10 * math.Log10(sensor.value*1000)
+			}
+		}
+
+		tags := map[string]string{
+			"metric_type":  kind.measurement,
+			"sensor":       sensor.name,
+			"sensor_index": sensor.index,
+			"sensor_type":  kind.name,
+			"component":    sensor.component,
+		}
+		if sensor.parent != "" {
+			tags["parent"] = sensor.parent
+		}
+		metrics = append(metrics, Metric{
+			Name:      kind.measurement,
+			Value:     value,
+			Timestamp: timestamp,
+			Tags:      tags,
+		})
+	}
+	return metrics
+}
+
+// readPhysicalEntities walks the columns of entPhysicalTable that name and
+// place sensors
+func readPhysicalEntities(g snmpAgent) map[string]physicalEntity {
+	column := func(number int) map[string]interface{} {
+		values, _ := walkColumn(g, entPhysicalEntryOID+"."+strconv.Itoa(number))
+		return values
+	}
+	names := column(entPhysicalNameColumn)
+	descrs := column(entPhysicalDescrColumn)
+	classes := column(entPhysicalClassColumn)
+	parents := column(entPhysicalContainedInColumn)
+
+	entities := make(map[string]physicalEntity, len(classes))
+	for _, values := range []map[string]interface{}{names, descrs, classes, parents} {
+		for index := range values {
+			entities[index] = physicalEntity{}
+		}
+	}
+	for index := range entities {
+		entity := physicalEntity{
+			name:  snmpString(names[index]),
+			descr: snmpString(descrs[index]),
+		}
+		entity.class, _ = classes[index].(int)
+		if parent, ok := parents[index].(int); ok {
+			entity.parent = strconv.Itoa(parent)
+		}
+		entities[index] = entity
+	}
+	return entities
+}
+
+// ciscoEnvMonTables are the CISCO-ENVMON-MIB status tables, read from
+// Cisco devices without entity sensors. Each has the description in column
+// 2 and a CiscoEnvMonState.
+var ciscoEnvMonTables = []struct {
+	entry       string
+	measurement string
+	field       string
+	valueColumn int
+	stateColumn int
+}{
+	{entry: "1.3.6.1.4.1.9.9.13.1.3.1", measurement: "temperature", field: "temperature_celsius", valueColumn: 3, stateColumn: 6},
+	{entry: "1.3.6.1.4.1.9.9.13.1.4.1", measurement: "fan_status", stateColumn: 3},
+	{entry: "1.3.6.1.4.1.9.9.13.1.5.1", measurement: "power_supply_status", stateColumn: 3},
+}
+
+// ciscoEnvMonStates names CiscoEnvMonState values
+var ciscoEnvMonStates = map[int]string{
+	1: "normal",
+	2: "warning",
+	3: "critical",
+	4: "shutdown",
+	5: "notPresent",
+	6: "notFunctioning",
+}
+
+// ciscoEnvMonMetrics reports the rows of the CISCO-ENVMON-MIB tables, by
+// table entry OID
+func ciscoEnvMonMetrics(tables map[string]map[string]map[int]interface{}, timestamp time.Time) []Metric {
+	var metrics []Metric
+	for _, table := range ciscoEnvMonTables {
+		for index, row := range tables[table.entry] {
+			value := make(map[string]interface{})
+			if state, ok := row[table.stateColumn].(int); ok {
+				value["state"] = float64(state)
+				value["state_name"] = enumName(ciscoEnvMonStates, state)
+			}
+			if table.valueColumn > 0 {
+				if v, ok := snmpFloat(row[table.valueColumn]); ok {
+					value[table.field] = v
+				}
+			}
+			if len(value) == 0 {
+				continue
+			}
+
+			name := snmpString(row[2])
+			if name == "" {
+				name = index
+			}
+			metrics = append(metrics, Metric{
+				Name:      table.measurement,
+				Value:     value,
+				Timestamp: timestamp,
+				Tags: map[string]string{
+					"metric_type":  table.measurement,
+					"sensor":       name,
+					"sensor_index": index,
+				},
+			})
+		}
+	}
+	return metrics
+}
+
+// collectSensorMetrics collects environmental sensors: temperatures, fans,
+// power supply voltage, current and power, and transceiver DOM levels
+func collectSensorMetrics(g snmpAgent, timestamp time.Time) ([]Metric, error) {
+	for _, entry := range []string{entPhySensorEntryOID, ciscoEntSensorEntryOID} {
+		rows, err := walkTable(g, entry)
+		if err != nil || len(rows) == 0 {
+			continue
+		}
+		return sensorMetrics(parseSensors(rows, readPhysicalEntities(g)), timestamp), nil
+	}
+
+	tables := make(map[string]map[string]map[int]interface{}, len(ciscoEnvMonTables))
+	for _, table := range ciscoEnvMonTables {
+		if rows, err := walkTable(g, table.entry); err == nil {
+			tables[table.entry] = rows
+		}
+	}
+	return ciscoEnvMonMetrics(tables, timestamp), nil
+}
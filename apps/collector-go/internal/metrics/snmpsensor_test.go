@@ -0,0 +1,162 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSensorValue(t *testing.T) {
+	tests := []struct {
+		raw, scale, precision int
+		want                  float64
+	}{
+		{raw: 42, scale: 9, want: 42},
+		{raw: 1215, scale: 9, precision: 2, want: 12.15},
+		{raw: 650, scale: 8, precision: 2, want: 0.0065},
+		{raw: 5012, scale: 7, precision: 1, want: 0.0005012},
+		{raw: 3, scale: 10, want: 3000},
+		{raw: 12, scale: 9, precision: -1, want: 120},
+	}
+	for _, tt := range tests {
+		if got := sensorValue(tt.raw, tt.scale, tt.precision); math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("sensorValue(%d, %d, %d) = %v, want %v", tt.raw, tt.scale, tt.precision, got, tt.want)
+		}
+	}
+}
+
+// addEntity adds an entPhysicalTable row to an agent
+func addEntity(agent fakeAgent, index, name string, class, parent int) {
+	agent[entPhysicalEntryOID+".7."+index] = []byte(name)
+	agent[entPhysicalEntryOID+".2."+index] = []byte(name + " description")
+	agent[entPhysicalEntryOID+".5."+index] = class
+	agent[entPhysicalEntryOID+".4."+index] = parent
+}
+
+// addSensor adds a sensor table row to an agent
+func addSensor(agent fakeAgent, entry, index string, sensorType, scale, precision, value, status int) {
+	agent[entry+".1."+index] = sensorType
+	agent[entry+".2."+index] = scale
+	agent[entry+".3."+index] = precision
+	agent[entry+".4."+index] = value
+	agent[entry+".5."+index] = status
+}
+
+// sensorsByIndex collects the sensor metrics of an agent by sensor index
+func sensorsByIndex(t *testing.T, agent fakeAgent) map[string]Metric {
+	metrics, err := collectSensorMetrics(agent, time.Now())
+	if err != nil {
+		t.Fatalf("collectSensorMetrics() error = %v", err)
+	}
+	byIndex := make(map[string]Metric)
+	for _, m := range metrics {
+		byIndex[m.Name+"/"+m.Tags["sensor_index"]] = m
+	}
+	return byIndex
+}
+
+func TestCollectSensorMetrics_EntitySensors(t *testing.T) {
+	agent := fakeAgent{}
+	addEntity(agent, "1", "Chassis", 3, 0)
+	addEntity(agent, "10", "PSU 1", entClassPowerSupply, 1)
+	addEntity(agent, "11", "PSU 1 Output Voltage", 8, 10)
+	addEntity(agent, "12", "PSU 1 Output Power", 8, 10)
+	addEntity(agent, "20", "Fan Tray 1", entClassFan, 1)
+	addEntity(agent, "21", "Fan 1 Speed", 8, 20)
+	addEntity(agent, "30", "Inlet Temperature", 8, 1)
+	addEntity(agent, "40", "Ethernet1", entClassPort, 1)
+	addEntity(agent, "41", "Ethernet1 Rx Power", 8, 40)
+	addEntity(agent, "42", "Ethernet1 Tx Bias", 8, 40)
+	addEntity(agent, "50", "Outlet Temperature", 8, 1)
+	addSensor(agent, entPhySensorEntryOID, "11", 4, 9, 2, 1215, 1)
+	addSensor(agent, entPhySensorEntryOID, "12", 6, 9, 0, 350, 1)
+	addSensor(agent, entPhySensorEntryOID, "21", 10, 9, 0, 7200, 1)
+	addSensor(agent, entPhySensorEntryOID, "30", 8, 9, 1, 285, 1)
+	addSensor(agent, entPhySensorEntryOID, "41", 6, 7, 1, 5012, 1)
+	addSensor(agent, entPhySensorEntryOID, "42", 5, 8, 2, 650, 1)
+	addSensor(agent, entPhySensorEntryOID, "50", 8, 9, 0, 0, 3)
+	// The vendor tables are not read when the standard MIB has sensors
+	addSensor(agent, ciscoEntSensorEntryOID, "99", 8, 9, 0, 40, 1)
+
+	byIndex := sensorsByIndex(t, agent)
+	if len(byIndex) != 7 {
+		t.Fatalf("Expected 7 sensors, got %d: %v", len(byIndex), byIndex)
+	}
+
+	tests := []struct {
+		key       string
+		field     string
+		want      float64
+		sensor    string
+		component string
+		parent    string
+	}{
+		{key: "voltage/11", field: "volts", want: 12.15, sensor: "PSU 1 Output Voltage", component: "power_supply", parent: "PSU 1"},
+		{key: "power/12", field: "watts", want: 350, sensor: "PSU 1 Output Power", component: "power_supply", parent: "PSU 1"},
+		{key: "fan_speed/21", field: "rpm", want: 7200, sensor: "Fan 1 Speed", component: "fan", parent: "Fan Tray 1"},
+		{key: "temperature/30", field: "temperature_celsius", want: 28.5, sensor: "Inlet Temperature", component: "chassis", parent: "Chassis"},
+		{key: "power/41", field: "dbm", want: -3, sensor: "Ethernet1 Rx Power", component: "transceiver", parent: "Ethernet1"},
+		{key: "current/42", field: "amperes", want: 0.0065, sensor: "Ethernet1 Tx Bias", component: "transceiver", parent: "Ethernet1"},
+	}
+	for _, tt := range tests {
+		m, ok := byIndex[tt.key]
+		if !ok {
+			t.Errorf("Missing sensor %s", tt.key)
+			continue
+		}
+		if !approx(m.Value[tt.field], tt.want) {
+			t.Errorf("%s %s = %v, want %v", tt.key, tt.field, m.Value[tt.field], tt.want)
+		}
+		if m.Tags["sensor"] != tt.sensor || m.Tags["component"] != tt.component || m.Tags["parent"] != tt.parent {
+			t.Errorf("Unexpected tags of %s: %v", tt.key, m.Tags)
+		}
+	}
+
+	// A nonoperational sensor reports its status without a value
+	failed := byIndex["temperature/50"]
+	if _, ok := failed.Value["temperature_celsius"]; ok || !approx(failed.Value["status"], 3) {
+		t.Errorf("Unexpected nonoperational sensor %v", failed.Value)
+	}
+}
+
+func TestCollectSensorMetrics_CiscoEntitySensors(t *testing.T) {
+	agent := fakeAgent{}
+	addEntity(agent, "1001", "Te1/1/1 Module", 9, 0)
+	addEntity(agent, "1002", "Te1/1/1 Receive Power Sensor", 8, 1001)
+	addSensor(agent, ciscoEntSensorEntryOID, "1002", sensorTypeDBm, 9, 1, -25, 1)
+
+	m, ok := sensorsByIndex(t, agent)["power/1002"]
+	if !ok {
+		t.Fatal("Missing dBm sensor")
+	}
+	if !approx(m.Value["dbm"], -2.5) || m.Tags["component"] != "transceiver" || m.Tags["sensor"] != "Te1/1/1 Receive Power Sensor" {
+		t.Errorf("Unexpected dBm sensor %+v", m)
+	}
+}
+
+func TestCollectSensorMetrics_CiscoEnvMon(t *testing.T) {
+	agent := fakeAgent{
+		"1.3.6.1.4.1.9.9.13.1.3.1.2.1": []byte("Inlet"),
+		"1.3.6.1.4.1.9.9.13.1.3.1.3.1": 31,
+		"1.3.6.1.4.1.9.9.13.1.3.1.6.1": 1,
+		"1.3.6.1.4.1.9.9.13.1.3.1.2.2": []byte("Outlet"),
+		"1.3.6.1.4.1.9.9.13.1.3.1.3.2": 44,
+		"1.3.6.1.4.1.9.9.13.1.3.1.6.2": 2,
+		"1.3.6.1.4.1.9.9.13.1.4.1.2.1": []byte("Fan 1"),
+		"1.3.6.1.4.1.9.9.13.1.4.1.3.1": 1,
+		"1.3.6.1.4.1.9.9.13.1.5.1.2.1": []byte("PS1"),
+		"1.3.6.1.4.1.9.9.13.1.5.1.3.1": 5,
+	}
+
+	byIndex := sensorsByIndex(t, agent)
+	if len(byIndex) != 4 {
+		t.Fatalf("Expected 4 sensors, got %d: %v", len(byIndex), byIndex)
+	}
+	outlet := byIndex["temperature/2"]
+	if outlet.Tags["sensor"] != "Outlet" || !approx(outlet.Value["temperature_celsius"], 44) || outlet.Value["state_name"] != "warning" {
+		t.Errorf("Unexpected temperature %+v", outlet)
+	}
+	if byIndex["fan_status/1"].Value["state_name"] != "normal" || byIndex["power_supply_status/1"].Value["state_name"] != "notPresent" {
+		t.Errorf("Unexpected fan and power supply states %v, %v", byIndex["fan_status/1"].Value, byIndex["power_supply_status/1"].Value)
+	}
+}
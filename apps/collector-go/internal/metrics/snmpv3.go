@@ -0,0 +1,156 @@
+package metrics
+
+import (
+	"collector/internal/config"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+// snmpAuthProtocols maps configured auth protocol names to gosnmp values
+var snmpAuthProtocols = map[string]gosnmp.SnmpV3AuthProtocol{
+	"MD5":    gosnmp.MD5,
+	"SHA":    gosnmp.SHA,
+	"SHA224": gosnmp.SHA224,
+	"SHA256": gosnmp.SHA256,
+	"SHA384": gosnmp.SHA384,
+	"SHA512": gosnmp.SHA512,
+}
+
+// snmpPrivProtocols maps configured privacy protocol names to gosnmp values
+var snmpPrivProtocols = map[string]gosnmp.SnmpV3PrivProtocol{
+	"DES":     gosnmp.DES,
+	"AES":     gosnmp.AES,
+	"AES128":  gosnmp.AES,
+	"AES192":  gosnmp.AES192,
+	"AES256":  gosnmp.AES256,
+	"AES192C": gosnmp.AES192C,
+	"AES256C": gosnmp.AES256C,
+}
+
+// usmParameters translates SNMPv3 credentials into gosnmp message flags and
+// USM security parameters
+func usmParameters(v3 config.SNMPv3Config) (gosnmp.SnmpV3MsgFlags, *gosnmp.UsmSecurityParameters, error) {
+	if v3.Username == "" {
+		return 0, nil, fmt.Errorf("SNMPv3 username is required")
+	}
+
+	params := &gosnmp.UsmSecurityParameters{
+		UserName:               v3.Username,
+		AuthenticationProtocol: gosnmp.NoAuth,
+		PrivacyProtocol:        gosnmp.NoPriv,
+	}
+
+	level := v3.SecurityLevel
+	if level == "" {
+		level = config.SNMPAuthPriv
+	}
+
+	var flags gosnmp.SnmpV3MsgFlags
+	switch level {
+	case config.SNMPNoAuthNoPriv:
+		return gosnmp.NoAuthNoPriv, params, nil
+	case config.SNMPAuthNoPriv:
+		flags = gosnmp.AuthNoPriv
+	case config.SNMPAuthPriv:
+		flags = gosnmp.AuthPriv
+	default:
+		return 0, nil, fmt.Errorf("unsupported SNMPv3 security level: %s", v3.SecurityLevel)
+	}
+
+	auth, ok := snmpAuthProtocols[strings.ToUpper(v3.AuthProtocol)]
+	if !ok {
+		return 0, nil, fmt.Errorf("unsupported SNMPv3 auth protocol: %s", v3.AuthProtocol)
+	}
+	params.AuthenticationProtocol = auth
+	params.AuthenticationPassphrase = v3.AuthPassphrase
+
+	if flags == gosnmp.AuthPriv {
+		priv, ok := snmpPrivProtocols[strings.ToUpper(v3.PrivProtocol)]
+		if !ok {
+			return 0, nil, fmt.Errorf("unsupported SNMPv3 privacy protocol: %s", v3.PrivProtocol)
+		}
+		params.PrivacyProtocol = priv
+		params.PrivacyPassphrase = v3.PrivPassphrase
+	}
+
+	return flags, params, nil
+}
+
+// snmpEngine is what discovery learned about an agent's SNMP engine
+type snmpEngine struct {
+	engineID     string
+	boots        uint32
+	engineTime   uint32
+	discoveredAt time.Time
+}
+
+// snmpEngineCache remembers discovered engine IDs, boots and times per
+// agent, so that requests skip the discovery round trip. gosnmp recovers on
+// its own when a cached engine time is out of window or the ID changed.
+type snmpEngineCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	engines map[string]snmpEngine
+}
+
+// newSNMPEngineCache creates an engine cache. A non-positive ttl disables it.
+func newSNMPEngineCache(ttl time.Duration) *snmpEngineCache {
+	return &snmpEngineCache{ttl: ttl, engines: make(map[string]snmpEngine)}
+}
+
+// apply seeds params with the cached engine of target, advancing the engine
+// time by the time elapsed since discovery. It reports whether an entry was
+// used.
+func (ec *snmpEngineCache) apply(target string, params *gosnmp.UsmSecurityParameters, now time.Time) bool {
+	if ec.ttl <= 0 {
+		return false
+	}
+
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	engine, ok := ec.engines[target]
+	if !ok {
+		return false
+	}
+	elapsed := now.Sub(engine.discoveredAt)
+	if elapsed >= ec.ttl {
+		delete(ec.engines, target)
+		return false
+	}
+
+	params.AuthoritativeEngineID = engine.engineID
+	params.AuthoritativeEngineBoots = engine.boots
+	params.AuthoritativeEngineTime = engine.engineTime + uint32(elapsed/time.Second)
+	return true
+}
+
+// store records the engine parameters negotiated with target
+func (ec *snmpEngineCache) store(target string, params *gosnmp.UsmSecurityParameters, now time.Time) {
+	if ec.ttl <= 0 || params.AuthoritativeEngineID == "" {
+		return
+	}
+
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	ec.engines[target] = snmpEngine{
+		engineID:     params.AuthoritativeEngineID,
+		boots:        params.AuthoritativeEngineBoots,
+		engineTime:   params.AuthoritativeEngineTime,
+		discoveredAt: now,
+	}
+}
+
+// invalidate forgets the engine of target, forcing rediscovery
+func (ec *snmpEngineCache) invalidate(target string) {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	delete(ec.engines, target)
+}
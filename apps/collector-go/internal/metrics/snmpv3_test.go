@@ -0,0 +1,221 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"collector/internal/config"
+	"collector/internal/secrets"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+func TestUSMParameters(t *testing.T) {
+	tests := []struct {
+		name      string
+		v3        config.SNMPv3Config
+		wantFlags gosnmp.SnmpV3MsgFlags
+		wantAuth  gosnmp.SnmpV3AuthProtocol
+		wantPriv  gosnmp.SnmpV3PrivProtocol
+		wantErr   bool
+	}{
+		{
+			name:      "noAuthNoPriv",
+			v3:        config.SNMPv3Config{Username: "monitor", SecurityLevel: "noAuthNoPriv", AuthProtocol: "SHA"},
+			wantFlags: gosnmp.NoAuthNoPriv,
+			wantAuth:  gosnmp.NoAuth,
+			wantPriv:  gosnmp.NoPriv,
+		},
+		{
+			name:      "authNoPriv",
+			v3:        config.SNMPv3Config{Username: "monitor", SecurityLevel: "authNoPriv", AuthProtocol: "sha512", AuthPassphrase: "authpass123", PrivProtocol: "AES"},
+			wantFlags: gosnmp.AuthNoPriv,
+			wantAuth:  gosnmp.SHA512,
+			wantPriv:  gosnmp.NoPriv,
+		},
+		{
+			name:      "authPriv by default",
+			v3:        config.SNMPv3Config{Username: "monitor", AuthProtocol: "SHA256", AuthPassphrase: "authpass123", PrivProtocol: "AES128", PrivPassphrase: "privpass123"},
+			wantFlags: gosnmp.AuthPriv,
+			wantAuth:  gosnmp.SHA256,
+			wantPriv:  gosnmp.AES,
+		},
+		{
+			name:      "authPriv with Cisco AES256",
+			v3:        config.SNMPv3Config{Username: "monitor", SecurityLevel: "authPriv", AuthProtocol: "MD5", AuthPassphrase: "authpass123", PrivProtocol: "aes256c", PrivPassphrase: "privpass123"},
+			wantFlags: gosnmp.AuthPriv,
+			wantAuth:  gosnmp.MD5,
+			wantPriv:  gosnmp.AES256C,
+		},
+		{
+			name:    "missing username",
+			v3:      config.SNMPv3Config{SecurityLevel: "noAuthNoPriv"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown auth protocol",
+			v3:      config.SNMPv3Config{Username: "monitor", SecurityLevel: "authNoPriv", AuthProtocol: "SHA3"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown privacy protocol",
+			v3:      config.SNMPv3Config{Username: "monitor", AuthProtocol: "SHA", PrivProtocol: "3DES"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags, params, err := usmParameters(tt.v3)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("usmParameters() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if flags != tt.wantFlags || params.AuthenticationProtocol != tt.wantAuth || params.PrivacyProtocol != tt.wantPriv {
+				t.Errorf("usmParameters() = %v/%v/%v, want %v/%v/%v",
+					flags, params.AuthenticationProtocol, params.PrivacyProtocol, tt.wantFlags, tt.wantAuth, tt.wantPriv)
+			}
+			if params.UserName != tt.v3.Username {
+				t.Errorf("UserName = %s, want %s", params.UserName, tt.v3.Username)
+			}
+		})
+	}
+}
+
+func TestSNMPEngineCache(t *testing.T) {
+	cache := newSNMPEngineCache(time.Hour)
+	now := time.Now()
+
+	discovered := &gosnmp.UsmSecurityParameters{
+		AuthoritativeEngineID:    "\x80\x00\x1f\x88\x04test",
+		AuthoritativeEngineBoots: 7,
+		AuthoritativeEngineTime:  1000,
+	}
+	cache.store("192.0.2.1:161", discovered, now)
+
+	params := &gosnmp.UsmSecurityParameters{}
+	if !cache.apply("192.0.2.1:161", params, now.Add(90*time.Second)) {
+		t.Fatal("Expected the cached engine to be applied")
+	}
+	if params.AuthoritativeEngineID != discovered.AuthoritativeEngineID || params.AuthoritativeEngineBoots != 7 {
+		t.Errorf("Engine ID/boots not applied: %+v", params)
+	}
+	if params.AuthoritativeEngineTime != 1090 {
+		t.Errorf("Engine time = %d, want 1090", params.AuthoritativeEngineTime)
+	}
+
+	if cache.apply("192.0.2.2:161", &gosnmp.UsmSecurityParameters{}, now) {
+		t.Error("Expected no entry for another agent")
+	}
+
+	// Entries expire after the TTL
+	if cache.apply("192.0.2.1:161", &gosnmp.UsmSecurityParameters{}, now.Add(2*time.Hour)) {
+		t.Error("Expected the entry to expire")
+	}
+
+	cache.store("192.0.2.1:161", discovered, now)
+	cache.invalidate("192.0.2.1:161")
+	if cache.apply("192.0.2.1:161", &gosnmp.UsmSecurityParameters{}, now) {
+		t.Error("Expected the entry to be invalidated")
+	}
+
+	disabled := newSNMPEngineCache(0)
+	disabled.store("192.0.2.1:161", discovered, now)
+	if disabled.apply("192.0.2.1:161", &gosnmp.UsmSecurityParameters{}, now) {
+		t.Error("Expected a zero TTL to disable caching")
+	}
+}
+
+func TestSNMPCollector_ForDevice(t *testing.T) {
+	global := config.SNMPv3Config{Username: "global", AuthProtocol: "SHA", AuthPassphrase: "authpass123", PrivProtocol: "AES", PrivPassphrase: "privpass123"}
+	core := config.SNMPv3Config{Username: "core", SecurityLevel: "authNoPriv", AuthProtocol: "SHA256", AuthPassphrase: "corepass123"}
+
+	c, err := NewSNMPCollector(config.SNMPConfig{
+		Community: "public",
+		Version:   "3",
+		V3:        global,
+		Overrides: []config.SNMPOverride{
+			{DeviceSelector: config.DeviceSelector{Tags: []string{"core"}}, V3: core},
+			{DeviceSelector: config.DeviceSelector{DeviceTypes: []string{"printer"}}, Version: "2c", Community: "printers"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewSNMPCollector() error = %v", err)
+	}
+
+	if got := c.ForDevice("a", "router", "r1", nil); got != MetricCollector(c) {
+		t.Errorf("Expected the global collector for a device without override, got %T", got)
+	}
+
+	bound, ok := c.ForDevice("b", "router", "r2", []string{"core"}).(*boundSNMP)
+	if !ok || bound.settings.version != "3" || bound.settings.v3.Username != "core" {
+		t.Errorf("Expected core v3 credentials, got %+v", bound)
+	}
+
+	bound, ok = c.ForDevice("c", "printer", "p1", nil).(*boundSNMP)
+	if !ok || bound.settings.version != "2c" || bound.settings.community != "printers" || bound.settings.v3.Username != "global" {
+		t.Errorf("Expected printer v2c settings, got %+v", bound)
+	}
+
+	g, err := c.newClient("192.0.2.1", c.settings(nil))
+	if err != nil {
+		t.Fatalf("newClient() error = %v", err)
+	}
+	if g.Version != gosnmp.Version3 || g.SecurityModel != gosnmp.UserSecurityModel || g.MsgFlags != gosnmp.AuthPriv {
+		t.Errorf("Expected a v3 authPriv client, got version=%v model=%v flags=%v", g.Version, g.SecurityModel, g.MsgFlags)
+	}
+}
+
+func TestNewSNMPCollector_InvalidV3(t *testing.T) {
+	_, err := NewSNMPCollector(config.SNMPConfig{
+		Version: "3",
+		V3:      config.SNMPv3Config{Username: "monitor", AuthProtocol: "SHA3"},
+	})
+	if err == nil {
+		t.Error("Expected an error for an unsupported auth protocol")
+	}
+
+	_, err = NewSNMPCollector(config.SNMPConfig{
+		Version:   "2c",
+		Overrides: []config.SNMPOverride{{Version: "3"}},
+	})
+	if err == nil {
+		t.Error("Expected an error for a v3 override without credentials")
+	}
+}
+
+func TestSNMPCollector_ResolveSettings(t *testing.T) {
+	t.Setenv("TEST_SNMP_COMMUNITY", "site-a")
+	t.Setenv("TEST_SNMP_AUTH", "authpass123")
+
+	resolver, err := secrets.NewResolver(config.SecretsConfig{RefreshInterval: time.Minute})
+	if err != nil {
+		t.Fatalf("NewResolver() error = %v", err)
+	}
+	c, err := NewSNMPCollector(config.SNMPConfig{Community: "env:TEST_SNMP_COMMUNITY", Version: "2c"})
+	if err != nil {
+		t.Fatalf("NewSNMPCollector() error = %v", err)
+	}
+	c.UseSecrets(resolver)
+
+	s := c.settings(nil)
+	s.v3 = config.SNMPv3Config{Username: "monitor", AuthPassphrase: "env:TEST_SNMP_AUTH", PrivPassphrase: "plainpass123"}
+	resolved, err := c.resolveSettings(context.Background(), s)
+	if err != nil {
+		t.Fatalf("resolveSettings() error = %v", err)
+	}
+	if resolved.community != "site-a" || resolved.v3.AuthPassphrase != "authpass123" || resolved.v3.PrivPassphrase != "plainpass123" {
+		t.Errorf("Expected resolved credentials, got %+v", resolved)
+	}
+	if s.community != "env:TEST_SNMP_COMMUNITY" {
+		t.Errorf("Expected the original settings to keep their references, got %q", s.community)
+	}
+
+	s.community = "env:TEST_SNMP_UNSET"
+	if _, err := c.resolveSettings(context.Background(), s); err == nil {
+		t.Error("Expected an error for an unresolvable reference")
+	}
+}
@@ -0,0 +1,678 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// profileCheckInterval is how often the profiles directory is checked for
+// changed files
+const profileCheckInterval = 30 * time.Second
+
+// profileFile is the YAML format of a metric profile. A profile without
+// match rules applies to every device.
+//
+//	name: etherlike
+//	match:
+//	  sys_object_ids: [1.3.6.1.4.1.9]
+//	  sys_descr: "IOS"
+//	metrics:
+//	  - measurement: ethernet_errors
+//	    table: 1.3.6.1.2.1.10.7.2.1
+//	    fields:
+//	      - name: fcs_errors_per_sec
+//	        oid: 1.3.6.1.2.1.10.7.2.1.3
+//	        rate: true
+//	      - name: duplex
+//	        oid: 1.3.6.1.2.1.10.7.2.1.19
+//	        enum: {1: unknown, 2: half, 3: full}
+//	    tags:
+//	      - name: interface
+//	        oid: 1.3.6.1.2.1.31.1.1.1.1
+type profileFile struct {
+	Name    string          `yaml:"name"`
+	Match   profileMatch    `yaml:"match"`
+	Metrics []profileMetric `yaml:"metrics"`
+}
+
+// profileMatch holds the sysObjectID subtrees and sysDescr pattern of the
+// devices a profile applies to
+type profileMatch struct {
+	SysObjectIDs []string `yaml:"sys_object_ids"`
+	SysDescr     string   `yaml:"sys_descr"`
+}
+
+// profileMetric is a measurement read from scalars, or from every row of a
+// table when Table, the OID of the table entry, is set
+type profileMetric struct {
+	Measurement string            `yaml:"measurement"`
+	Table       string            `yaml:"table"`
+	Fields      []profileField    `yaml:"fields"`
+	Tags        []profileTag      `yaml:"tags"`
+	StaticTags  map[string]string `yaml:"static_tags"`
+}
+
+// profileField is a field read from a scalar, or from a column of the
+// table
+type profileField struct {
+	Name string `yaml:"name"`
+	OID  string `yaml:"oid"`
+	// Rate reports the per-second increase of a counter instead of its
+	// value
+	Rate bool `yaml:"rate"`
+	// Scale multiplies the value, or the rate
+	Scale float64 `yaml:"scale"`
+	// Enum replaces integer values by names
+	Enum map[int]string `yaml:"enum"`
+}
+
+// profileTag is a tag read from the row index or a column. Index lists the
+// 1-based components of the row index that make up the tag value or, for a
+// column of another table, the index of the row to join; the whole row
+// index by default.
+type profileTag struct {
+	Name  string         `yaml:"name"`
+	OID   string         `yaml:"oid"`
+	Index []int          `yaml:"index"`
+	Enum  map[int]string `yaml:"enum"`
+}
+
+// metricProfile is a validated profile file
+type metricProfile struct {
+	name string
+	file string
+	snmpMatch
+	metrics []profileMetric
+}
+
+// profileError is a validation error at a path of mapping keys and
+// sequence indexes of a profile file
+type profileError struct {
+	path []interface{}
+	msg  string
+}
+
+func profileErrorf(path []interface{}, format string, args ...interface{}) *profileError {
+	return &profileError{path: path, msg: fmt.Sprintf(format, args...)}
+}
+
+// at returns path extended with steps
+func at(path []interface{}, steps ...interface{}) []interface{} {
+	return append(append([]interface{}(nil), path...), steps...)
+}
+
+// oidPattern matches a numeric OID
+var oidPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)+$`)
+
+// normalizeOID returns an OID without a leading dot, and whether it is
+// valid
+func normalizeOID(oid string) (string, bool) {
+	oid = strings.TrimPrefix(strings.TrimSpace(oid), ".")
+	return oid, oidPattern.MatchString(oid)
+}
+
+// yamlLine returns the line of the node at path, or of the deepest node of
+// the path that exists
+func yamlLine(node *yaml.Node, path []interface{}) int {
+	for _, step := range path {
+		var next *yaml.Node
+		switch step := step.(type) {
+		case string:
+			for i := 0; node.Kind == yaml.MappingNode && i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == step {
+					next = node.Content[i+1]
+					break
+				}
+			}
+		case int:
+			if node.Kind == yaml.SequenceNode && step < len(node.Content) {
+				next = node.Content[step]
+			}
+		}
+		if next == nil {
+			break
+		}
+		node = next
+	}
+	return node.Line
+}
+
+// parseProfile decodes and validates a profile file. Errors start with the
+// file and line.
+func parseProfile(file string, data []byte) (*metricProfile, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, strings.TrimPrefix(err.Error(), "yaml: "))
+	}
+	if len(root.Content) == 0 {
+		return nil, fmt.Errorf("%s: empty profile", file)
+	}
+
+	var pf profileFile
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&pf); err != nil {
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) {
+			return nil, fmt.Errorf("%s: %s", file, strings.Join(typeErr.Errors, "; "))
+		}
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	profile, perr := compileProfile(pf)
+	if perr != nil {
+		return nil, fmt.Errorf("%s:%d: %s", file, yamlLine(root.Content[0], perr.path), perr.msg)
+	}
+	profile.file = file
+	return profile, nil
+}
+
+// compileProfile validates a profile and normalizes its OIDs
+func compileProfile(pf profileFile) (*metricProfile, *profileError) {
+	if pf.Name == "" {
+		return nil, profileErrorf(nil, "profile name is required")
+	}
+	profile := &metricProfile{name: pf.Name}
+
+	for i, oid := range pf.Match.SysObjectIDs {
+		oid, ok := normalizeOID(oid)
+		if !ok {
+			return nil, profileErrorf(at(nil, "match", "sys_object_ids", i), "invalid sysObjectID %q", pf.Match.SysObjectIDs[i])
+		}
+		profile.sysObjectIDs = append(profile.sysObjectIDs, oid)
+	}
+	if pf.Match.SysDescr != "" {
+		re, err := regexp.Compile(pf.Match.SysDescr)
+		if err != nil {
+			return nil, profileErrorf(at(nil, "match", "sys_descr"), "invalid sys_descr pattern: %v", err)
+		}
+		profile.sysDescr = re
+	}
+
+	if len(pf.Metrics) == 0 {
+		return nil, profileErrorf(at(nil, "metrics"), "profile %s has no metrics", pf.Name)
+	}
+	for i, m := range pf.Metrics {
+		m, err := compileProfileMetric(m, at(nil, "metrics", i))
+		if err != nil {
+			return nil, err
+		}
+		profile.metrics = append(profile.metrics, m)
+	}
+	return profile, nil
+}
+
+// compileProfileMetric validates a metric of a profile
+func compileProfileMetric(m profileMetric, path []interface{}) (profileMetric, *profileError) {
+	if m.Measurement == "" {
+		return m, profileErrorf(path, "measurement is required")
+	}
+	if m.Table != "" {
+		table, ok := normalizeOID(m.Table)
+		if !ok {
+			return m, profileErrorf(at(path, "table"), "invalid table OID %q", m.Table)
+		}
+		m.Table = table
+	}
+	inTable := func(oid string) bool {
+		return m.Table != "" && strings.HasPrefix(oid, m.Table+".")
+	}
+
+	if len(m.Fields) == 0 {
+		return m, profileErrorf(at(path, "fields"), "metric %s has no fields", m.Measurement)
+	}
+	fields := make([]profileField, len(m.Fields))
+	names := make(map[string]bool, len(m.Fields))
+	for i, f := range m.Fields {
+		fieldPath := at(path, "fields", i)
+		if f.Name == "" {
+			return m, profileErrorf(fieldPath, "field name is required")
+		}
+		if names[f.Name] {
+			return m, profileErrorf(at(fieldPath, "name"), "duplicate field %s", f.Name)
+		}
+		names[f.Name] = true
+
+		oid, ok := normalizeOID(f.OID)
+		if !ok {
+			return m, profileErrorf(at(fieldPath, "oid"), "invalid OID %q", f.OID)
+		}
+		if m.Table != "" && !inTable(oid) {
+			return m, profileErrorf(at(fieldPath, "oid"), "field %s is not a column of table %s", f.Name, m.Table)
+		}
+		f.OID = oid
+
+		if f.Enum != nil && (f.Rate || f.Scale != 0) {
+			return m, profileErrorf(at(fieldPath, "enum"), "field %s cannot combine enum with rate or scale", f.Name)
+		}
+		if f.Scale == 0 {
+			f.Scale = 1
+		}
+		fields[i] = f
+	}
+	m.Fields = fields
+
+	tags := make([]profileTag, len(m.Tags))
+	for i, tag := range m.Tags {
+		tagPath := at(path, "tags", i)
+		if tag.Name == "" {
+			return m, profileErrorf(tagPath, "tag name is required")
+		}
+		for j, component := range tag.Index {
+			if component < 1 {
+				return m, profileErrorf(at(tagPath, "index", j), "index components start at 1")
+			}
+		}
+
+		if tag.OID == "" {
+			if m.Table == "" {
+				return m, profileErrorf(tagPath, "tag %s of a scalar metric needs an oid", tag.Name)
+			}
+			tags[i] = tag
+			continue
+		}
+		oid, ok := normalizeOID(tag.OID)
+		if !ok {
+			return m, profileErrorf(at(tagPath, "oid"), "invalid OID %q", tag.OID)
+		}
+		if len(tag.Index) > 0 && (m.Table == "" || inTable(oid)) {
+			return m, profileErrorf(at(tagPath, "index"), "tag %s only joins another table by index", tag.Name)
+		}
+		tag.OID = oid
+		tags[i] = tag
+	}
+	m.Tags = tags
+
+	return m, nil
+}
+
+// profileFiles lists the profile files of dir in name order
+func profileFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		files = append(files, filepath.Join(dir, entry.Name()))
+	}
+	return files, nil
+}
+
+// profileDirState identifies the contents of the profiles directory by the
+// name, size and modification time of its files
+func profileDirState(dir string) (string, error) {
+	files, err := profileFiles(dir)
+	if err != nil {
+		return "", err
+	}
+	var state strings.Builder
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&state, "%s %d %d\n", file, info.Size(), info.ModTime().UnixNano())
+	}
+	return state.String(), nil
+}
+
+// profileFileError is an error loading a single profile file
+type profileFileError struct {
+	file string
+	err  error
+}
+
+// Error implements error
+func (e *profileFileError) Error() string { return e.err.Error() }
+
+// Unwrap returns the underlying error
+func (e *profileFileError) Unwrap() error { return e.err }
+
+// loadProfileDir parses every profile file of dir. Errors of a single file
+// are *profileFileError.
+func loadProfileDir(dir string) ([]*metricProfile, error) {
+	files, err := profileFiles(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SNMP profiles: %w", err)
+	}
+
+	var profiles []*metricProfile
+	defined := make(map[string]string)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, &profileFileError{file: file, err: fmt.Errorf("failed to read SNMP profile: %w", err)}
+		}
+		profile, err := parseProfile(file, data)
+		if err != nil {
+			return nil, &profileFileError{file: file, err: err}
+		}
+		if other, ok := defined[profile.name]; ok {
+			return nil, &profileFileError{file: file, err: fmt.Errorf("%s: profile %s is already defined in %s", file, profile.name, other)}
+		}
+		defined[profile.name] = file
+		profiles = append(profiles, profile)
+	}
+	return profiles, nil
+}
+
+// ReloadProfiles loads the profiles directory again. The profiles in use
+// are kept if any file is invalid.
+func (c *SNMPCollector) ReloadProfiles() error {
+	dir := c.config.ProfilesDir
+	if dir == "" {
+		return nil
+	}
+
+	state, err := profileDirState(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read SNMP profiles: %w", err)
+	}
+	profiles, err := loadProfileDir(dir)
+
+	c.profileMu.Lock()
+	defer c.profileMu.Unlock()
+	c.profileState, c.profileChecked = state, time.Now()
+	if err != nil {
+		return err
+	}
+	c.profiles = profiles
+	return nil
+}
+
+// currentProfiles returns the loaded profiles, reloading them first when
+// the directory changed since it was last checked
+func (c *SNMPCollector) currentProfiles(now time.Time) []*metricProfile {
+	if c.config.ProfilesDir == "" {
+		return nil
+	}
+
+	c.profileMu.Lock()
+	check := now.Sub(c.profileChecked) >= profileCheckInterval
+	if check {
+		c.profileChecked = now
+	}
+	profiles, state := c.profiles, c.profileState
+	c.profileMu.Unlock()
+
+	if !check {
+		return profiles
+	}
+	if current, err := profileDirState(c.config.ProfilesDir); err != nil || current == state {
+		return profiles
+	}
+	if err := c.ReloadProfiles(); err != nil {
+		logger := logrus.WithError(err).WithField("dir", c.config.ProfilesDir)
+		var fileErr *profileFileError
+		if errors.As(err, &fileErr) {
+			logger = logger.WithField("file", fileErr.file)
+		}
+		logger.Error("SNMP profiles not reloaded, keeping the previous profiles")
+	}
+
+	c.profileMu.Lock()
+	defer c.profileMu.Unlock()
+	return c.profiles
+}
+
+// profileRow is the values of a scalar metric, or of a table row, with the
+// field values by OID
+type profileRow struct {
+	index  string
+	values map[string]interface{}
+	tags   map[string]string
+}
+
+// enumName returns the name of an enumerated value, or its number
+func enumName(enum map[int]string, value int) string {
+	if name, ok := enum[value]; ok {
+		return name
+	}
+	return strconv.Itoa(value)
+}
+
+// value formats a column value as the tag
+func (t profileTag) value(value interface{}) (string, bool) {
+	if value == nil {
+		return "", false
+	}
+	if n, ok := snmpFloat(value); ok {
+		if t.Enum != nil {
+			return enumName(t.Enum, int(n)), true
+		}
+		return strconv.FormatFloat(n, 'f', -1, 64), true
+	}
+	return snmpString(value), true
+}
+
+// indexComponents returns the components of a row index at the 1-based
+// positions, or false if the index is too short
+func indexComponents(index string, positions []int) (string, bool) {
+	if len(positions) == 0 {
+		return index, true
+	}
+	components := strings.Split(index, ".")
+	selected := make([]string, len(positions))
+	for i, position := range positions {
+		if position > len(components) {
+			return "", false
+		}
+		selected[i] = components[position-1]
+	}
+	return strings.Join(selected, "."), true
+}
+
+// readProfileMetric reads the scalars, or walks the columns, of a metric
+func readProfileMetric(g snmpAgent, m profileMetric) ([]profileRow, error) {
+	if m.Table == "" {
+		oids := make([]string, 0, len(m.Fields)+len(m.Tags))
+		for _, f := range m.Fields {
+			oids = append(oids, f.OID)
+		}
+		for _, tag := range m.Tags {
+			oids = append(oids, tag.OID)
+		}
+		values, err := getScalars(g, oids)
+		if err != nil {
+			return nil, err
+		}
+		row := profileRow{values: values, tags: make(map[string]string)}
+		for _, tag := range m.Tags {
+			if v, ok := tag.value(values[tag.OID]); ok {
+				row.tags[tag.Name] = v
+			}
+		}
+		return []profileRow{row}, nil
+	}
+
+	columns := make(map[string]map[string]interface{})
+	column := func(oid string) (map[string]interface{}, error) {
+		if values, ok := columns[oid]; ok {
+			return values, nil
+		}
+		values, err := walkColumn(g, oid)
+		if err != nil {
+			return nil, err
+		}
+		columns[oid] = values
+		return values, nil
+	}
+
+	rows := make(map[string]*profileRow)
+	var order []string
+	for _, f := range m.Fields {
+		values, err := column(f.OID)
+		if err != nil {
+			return nil, err
+		}
+		for index, value := range values {
+			row, ok := rows[index]
+			if !ok {
+				row = &profileRow{index: index, values: make(map[string]interface{}), tags: make(map[string]string)}
+				rows[index] = row
+				order = append(order, index)
+			}
+			row.values[f.OID] = value
+		}
+	}
+
+	for _, tag := range m.Tags {
+		var values map[string]interface{}
+		if tag.OID != "" {
+			var err error
+			if values, err = column(tag.OID); err != nil {
+				continue
+			}
+		}
+		for _, row := range rows {
+			index, ok := indexComponents(row.index, tag.Index)
+			if !ok {
+				continue
+			}
+			var v string
+			if tag.OID == "" {
+				v = index
+				if n, err := strconv.Atoi(index); err == nil && tag.Enum != nil {
+					v = enumName(tag.Enum, n)
+				}
+			} else if v, ok = tag.value(values[index]); !ok {
+				continue
+			}
+			row.tags[tag.Name] = v
+		}
+	}
+
+	result := make([]profileRow, 0, len(order))
+	for _, index := range order {
+		result = append(result, *rows[index])
+	}
+	return result, nil
+}
+
+// snmpRateSample is a counter reading kept to compute its rate
+type snmpRateSample struct {
+	counter snmpCounter
+	uptime  uint32
+	at      time.Time
+	// interval is the time since the previous reading, zero on the first
+	interval time.Duration
+}
+
+// counterRate returns the per-second increase of a counter since its
+// previous reading under key. Counter64 values are never assumed to wrap,
+// and a decrease of sysUpTime resets every counter.
+func (c *SNMPCollector) counterRate(key string, value interface{}, uptime uint32, at time.Time) (float64, bool) {
+	v, ok := snmpUint(value)
+	if !ok {
+		return 0, false
+	}
+	_, hc := value.(uint64)
+	sample := snmpRateSample{counter: snmpCounter{value: v, hc: hc}, uptime: uptime, at: at}
+
+	c.sampleMu.Lock()
+	prev, seen := c.rateSamples[key]
+	if seen {
+		sample.interval = at.Sub(prev.at)
+	}
+	c.rateSamples[key] = sample
+	c.sampleMu.Unlock()
+
+	elapsed := at.Sub(prev.at).Seconds()
+	if !seen || prev.counter.hc != hc || uptime < prev.uptime || elapsed <= 0 {
+		return 0, false
+	}
+	delta, ok := counterDelta(prev.counter.value, v, hc)
+	if !ok {
+		return 0, false
+	}
+	return float64(delta) / elapsed, true
+}
+
+// profileRowMetric converts a row of a profile metric, or returns false if
+// it has no fields
+func (c *SNMPCollector) profileRowMetric(ipAddress string, profile *metricProfile, m profileMetric, row profileRow, uptime uint32, timestamp time.Time) (Metric, bool) {
+	value := make(map[string]interface{}, len(m.Fields))
+	for _, f := range m.Fields {
+		raw, ok := row.values[f.OID]
+		if !ok {
+			continue
+		}
+		switch {
+		case f.Enum != nil:
+			if n, ok := snmpFloat(raw); ok {
+				value[f.Name] = enumName(f.Enum, int(n))
+			}
+		case f.Rate:
+			key := strings.Join([]string{ipAddress, profile.name, m.Measurement, row.index, f.Name}, "|")
+			if rate, ok := c.counterRate(key, raw, uptime, timestamp); ok {
+				value[f.Name] = rate * f.Scale
+			}
+		default:
+			if n, ok := snmpFloat(raw); ok {
+				value[f.Name] = n * f.Scale
+			} else if s, ok := raw.([]byte); ok {
+				value[f.Name] = string(s)
+			}
+		}
+	}
+	if len(value) == 0 {
+		return Metric{}, false
+	}
+
+	tags := map[string]string{
+		"metric_type": "snmp_profile",
+		"profile":     profile.name,
+	}
+	if m.Table != "" {
+		tags["index"] = row.index
+	}
+	for k, v := range m.StaticTags {
+		tags[k] = v
+	}
+	for k, v := range row.tags {
+		tags[k] = v
+	}
+	return Metric{Name: m.Measurement, Value: value, Timestamp: timestamp, Tags: tags}, true
+}
+
+// collectProfileMetrics collects the metrics of the profiles matching a
+// device. A metric the device does not support is skipped.
+func (c *SNMPCollector) collectProfileMetrics(g snmpAgent, ipAddress string, system map[string]interface{}, timestamp time.Time) []Metric {
+	sysObjectID := snmpOID(snmpString(system[sysObjectIDOID]))
+	sysDescr := snmpString(system[sysDescrOID])
+	uptime, _ := snmpUint(system[sysUptimeOID])
+
+	var metrics []Metric
+	for _, profile := range c.currentProfiles(timestamp) {
+		if !profile.matches(sysObjectID, sysDescr) {
+			continue
+		}
+		for _, m := range profile.metrics {
+			rows, err := readProfileMetric(g, m)
+			if err != nil {
+				continue
+			}
+			for _, row := range rows {
+				if metric, ok := c.profileRowMetric(ipAddress, profile, m, row, uint32(uptime), timestamp); ok {
+					metrics = append(metrics, metric)
+				}
+			}
+		}
+	}
+	return metrics
+}
@@ -0,0 +1,342 @@
+package metrics
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"collector/internal/config"
+
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+// etherlikeProfile reads EtherLike-MIB errors per interface, named by
+// ifName, and TCP connections
+const etherlikeProfile = `name: etherlike
+match:
+  sys_object_ids: [.1.3.6.1.4.1.9]
+metrics:
+  - measurement: ethernet_errors
+    table: 1.3.6.1.2.1.10.7.2.1
+    fields:
+      - name: fcs_errors_per_sec
+        oid: 1.3.6.1.2.1.10.7.2.1.3
+        rate: true
+      - name: fcs_errors
+        oid: 1.3.6.1.2.1.10.7.2.1.3
+      - name: duplex
+        oid: 1.3.6.1.2.1.10.7.2.1.19
+        enum: {1: unknown, 2: half, 3: full}
+    tags:
+      - name: interface
+        oid: 1.3.6.1.2.1.31.1.1.1.1
+    static_tags:
+      mib: EtherLike-MIB
+  - measurement: tcp
+    fields:
+      - name: established
+        oid: 1.3.6.1.2.1.6.9.0
+      - name: opens_per_min
+        oid: 1.3.6.1.2.1.6.5.0
+        rate: true
+        scale: 60
+`
+
+// queueProfile reads a table indexed by interface and queue, joining the
+// interface name on the first index component
+const queueProfile = `name: queues
+metrics:
+  - measurement: queue
+    table: 1.3.6.1.4.1.99999.1.1
+    fields:
+      - name: depth
+        oid: 1.3.6.1.4.1.99999.1.1.2
+    tags:
+      - name: queue
+        index: [2]
+        enum: {0: best_effort, 5: voice}
+      - name: interface
+        oid: 1.3.6.1.2.1.31.1.1.1.1
+        index: [1]
+`
+
+func TestParseProfile(t *testing.T) {
+	profile, err := parseProfile("etherlike.yaml", []byte(etherlikeProfile))
+	if err != nil {
+		t.Fatalf("parseProfile() error = %v", err)
+	}
+	if profile.name != "etherlike" || len(profile.metrics) != 2 {
+		t.Fatalf("Unexpected profile %+v", profile)
+	}
+	if !profile.matches("1.3.6.1.4.1.9.1.1208", "") || profile.matches("1.3.6.1.4.1.2636.1", "") {
+		t.Errorf("Unexpected match rules %+v", profile.snmpMatch)
+	}
+	fields := profile.metrics[0].Fields
+	if fields[0].Scale != 1 || fields[0].OID != "1.3.6.1.2.1.10.7.2.1.3" {
+		t.Errorf("Unexpected field %+v", fields[0])
+	}
+	if fields[2].Enum[3] != "full" {
+		t.Errorf("Unexpected enum %v", fields[2].Enum)
+	}
+}
+
+func TestParseProfile_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile string
+		want    string
+	}{
+		{
+			name:    "syntax error",
+			profile: "name: x\nmetrics:\n  - measurement: [\n",
+			want:    "bad.yaml: line",
+		},
+		{
+			name:    "unknown key",
+			profile: "name: x\nmetrics:\n  - measurement: m\n    fields:\n      - name: f\n        oid: 1.3.6.1.2.1.1.3.0\n        rat: true\n",
+			want:    "bad.yaml: line 7: field rat not found",
+		},
+		{
+			name:    "empty file",
+			profile: "",
+			want:    "bad.yaml: empty profile",
+		},
+		{
+			name:    "missing name",
+			profile: "metrics:\n  - measurement: m\n",
+			want:    "bad.yaml:1: profile name is required",
+		},
+		{
+			name:    "invalid sysObjectID",
+			profile: "name: x\nmatch:\n  sys_object_ids:\n    - 1.3.6.1.4.1.9\n    - cisco\n",
+			want:    `bad.yaml:5: invalid sysObjectID "cisco"`,
+		},
+		{
+			name:    "invalid sysDescr pattern",
+			profile: "name: x\nmatch:\n  sys_descr: \"(\"\n",
+			want:    "bad.yaml:3: invalid sys_descr pattern",
+		},
+		{
+			name:    "no metrics",
+			profile: "name: x\n",
+			want:    "bad.yaml:1: profile x has no metrics",
+		},
+		{
+			name:    "invalid field OID",
+			profile: "name: x\nmetrics:\n  - measurement: m\n    fields:\n      - name: a\n        oid: 1.3.6.1.2.1.1.3.0\n      - name: b\n        oid: iso.3.6\n",
+			want:    `bad.yaml:8: invalid OID "iso.3.6"`,
+		},
+		{
+			name:    "duplicate field",
+			profile: "name: x\nmetrics:\n  - measurement: m\n    fields:\n      - name: a\n        oid: 1.3.6.1.2.1.1.3.0\n      - name: a\n        oid: 1.3.6.1.2.1.1.5.0\n",
+			want:    "bad.yaml:7: duplicate field a",
+		},
+		{
+			name:    "field outside the table",
+			profile: "name: x\nmetrics:\n  - measurement: m\n    table: 1.3.6.1.2.1.2.2.1\n    fields:\n      - name: a\n        oid: 1.3.6.1.2.1.31.1.1.1.6\n",
+			want:    "bad.yaml:7: field a is not a column of table",
+		},
+		{
+			name:    "enum with rate",
+			profile: "name: x\nmetrics:\n  - measurement: m\n    fields:\n      - name: a\n        oid: 1.3.6.1.2.1.1.3.0\n        rate: true\n        enum: {1: up}\n",
+			want:    "bad.yaml:8: field a cannot combine enum with rate or scale",
+		},
+		{
+			name:    "index tag on a scalar metric",
+			profile: "name: x\nmetrics:\n  - measurement: m\n    fields:\n      - name: a\n        oid: 1.3.6.1.2.1.1.3.0\n    tags:\n      - name: t\n        index: [1]\n",
+			want:    "bad.yaml:8: tag t of a scalar metric needs an oid",
+		},
+		{
+			name:    "zero index component",
+			profile: "name: x\nmetrics:\n  - measurement: m\n    table: 1.3.6.1.2.1.2.2.1\n    fields:\n      - name: a\n        oid: 1.3.6.1.2.1.2.2.1.10\n    tags:\n      - name: t\n        index: [0]\n",
+			want:    "bad.yaml:10: index components start at 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseProfile("bad.yaml", []byte(tt.profile))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("parseProfile() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestIndexComponents(t *testing.T) {
+	tests := []struct {
+		index     string
+		positions []int
+		want      string
+		ok        bool
+	}{
+		{index: "12.5", want: "12.5", ok: true},
+		{index: "12.5", positions: []int{2}, want: "5", ok: true},
+		{index: "1.2.3", positions: []int{3, 1}, want: "3.1", ok: true},
+		{index: "12", positions: []int{2}},
+	}
+	for _, tt := range tests {
+		got, ok := indexComponents(tt.index, tt.positions)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("indexComponents(%q, %v) = %q, %v, want %q, %v", tt.index, tt.positions, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// writeProfile writes a profile file into dir
+func writeProfile(t *testing.T, dir, name, profile string) {
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(profile), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSNMPCollector_ProfileMetrics(t *testing.T) {
+	dir := t.TempDir()
+	writeProfile(t, dir, "etherlike.yaml", etherlikeProfile)
+	writeProfile(t, dir, "queues.yml", queueProfile)
+	writeProfile(t, dir, "README.md", "not a profile")
+
+	c, err := NewSNMPCollector(config.SNMPConfig{Community: "public", Version: "2c", ProfilesDir: dir})
+	if err != nil {
+		t.Fatalf("NewSNMPCollector() error = %v", err)
+	}
+
+	agent := fakeAgent{
+		"1.3.6.1.2.1.10.7.2.1.3.1":    uint(100),
+		"1.3.6.1.2.1.10.7.2.1.3.2":    uint(4294967290),
+		"1.3.6.1.2.1.10.7.2.1.19.1":   3,
+		"1.3.6.1.2.1.10.7.2.1.19.2":   9,
+		"1.3.6.1.2.1.31.1.1.1.1.1":    []byte("Gi1/0/1"),
+		"1.3.6.1.2.1.31.1.1.1.1.2":    []byte("Gi1/0/2"),
+		"1.3.6.1.2.1.6.9.0":           uint(42),
+		"1.3.6.1.2.1.6.5.0":           uint(1000),
+		"1.3.6.1.4.1.99999.1.1.2.1.0": uint(3),
+		"1.3.6.1.4.1.99999.1.1.2.1.5": uint(0),
+		"1.3.6.1.4.1.99999.1.1.2.2.0": uint(7),
+	}
+	system := map[string]interface{}{
+		sysObjectIDOID: ".1.3.6.1.4.1.9.1.1208",
+		sysDescrOID:    []byte("Cisco IOS Software"),
+		sysUptimeOID:   uint32(100000),
+	}
+
+	start := time.Now()
+	collect := func(at time.Time) map[string]Metric {
+		byKey := make(map[string]Metric)
+		for _, m := range c.collectProfileMetrics(agent, "10.0.0.1", system, at) {
+			byKey[m.Name+"/"+m.Tags["index"]] = m
+		}
+		return byKey
+	}
+
+	first := collect(start)
+	if len(first) != 6 {
+		t.Fatalf("Expected 6 metrics, got %d: %v", len(first), first)
+	}
+	gi := first["ethernet_errors/1"]
+	if gi.Tags["interface"] != "Gi1/0/1" || gi.Tags["mib"] != "EtherLike-MIB" || gi.Tags["profile"] != "etherlike" {
+		t.Errorf("Unexpected tags %v", gi.Tags)
+	}
+	if gi.Value["duplex"] != "full" || first["ethernet_errors/2"].Value["duplex"] != "9" {
+		t.Errorf("Unexpected enum values %v, %v", gi.Value, first["ethernet_errors/2"].Value)
+	}
+	if _, ok := gi.Value["fcs_errors_per_sec"]; ok || !approx(gi.Value["fcs_errors"], 100) {
+		t.Errorf("Expected no rate on the first poll, got %v", gi.Value)
+	}
+	if !approx(first["tcp/"].Value["established"], 42) {
+		t.Errorf("Unexpected scalar metric %v", first["tcp/"])
+	}
+	voice := first["queue/1.5"]
+	if voice.Tags["queue"] != "voice" || voice.Tags["interface"] != "Gi1/0/1" || first["queue/2.0"].Tags["interface"] != "Gi1/0/2" {
+		t.Errorf("Unexpected joined tags %v", voice.Tags)
+	}
+
+	agent["1.3.6.1.2.1.10.7.2.1.3.1"] = uint(150)
+	agent["1.3.6.1.2.1.10.7.2.1.3.2"] = uint(4)
+	agent["1.3.6.1.2.1.6.5.0"] = uint(1010)
+	second := collect(start.Add(10 * time.Second))
+	if !approx(second["ethernet_errors/1"].Value["fcs_errors_per_sec"], 5) {
+		t.Errorf("Unexpected rate %v", second["ethernet_errors/1"].Value)
+	}
+	if !approx(second["ethernet_errors/2"].Value["fcs_errors_per_sec"], 1) {
+		t.Errorf("Unexpected rate across a 32-bit wrap %v", second["ethernet_errors/2"].Value)
+	}
+	if !approx(second["tcp/"].Value["opens_per_min"], 60) {
+		t.Errorf("Unexpected scaled rate %v", second["tcp/"].Value)
+	}
+
+	// Counters restart with the agent
+	system[sysUptimeOID] = uint32(500)
+	agent["1.3.6.1.2.1.10.7.2.1.3.1"] = uint(2)
+	third := collect(start.Add(20 * time.Second))
+	if _, ok := third["ethernet_errors/1"].Value["fcs_errors_per_sec"]; ok {
+		t.Errorf("Expected no rate after a restart, got %v", third["ethernet_errors/1"].Value)
+	}
+
+	// Profiles only apply to the devices they match
+	system[sysObjectIDOID] = ".1.3.6.1.4.1.2636.1.1.1.2.25"
+	for _, m := range c.collectProfileMetrics(agent, "10.0.0.2", system, start) {
+		if m.Tags["profile"] != "queues" {
+			t.Errorf("Unexpected metric of an unmatched profile %+v", m)
+		}
+	}
+}
+
+func TestSNMPCollector_ReloadProfiles(t *testing.T) {
+	dir := t.TempDir()
+	writeProfile(t, dir, "etherlike.yaml", etherlikeProfile)
+	writeProfile(t, dir, "broken.yaml", "name: broken\nmetrics: []\n")
+
+	cfg := config.SNMPConfig{Community: "public", Version: "2c", ProfilesDir: dir}
+	if _, err := NewSNMPCollector(cfg); err == nil || !strings.Contains(err.Error(), "broken.yaml:2:") {
+		t.Fatalf("NewSNMPCollector() error = %v, want the invalid file and line", err)
+	}
+
+	writeProfile(t, dir, "broken.yaml", etherlikeProfile)
+	if _, err := NewSNMPCollector(cfg); err == nil || !strings.Contains(err.Error(), "already defined") {
+		t.Fatalf("NewSNMPCollector() error = %v, want a duplicate profile", err)
+	}
+
+	os.Remove(filepath.Join(dir, "broken.yaml"))
+	c, err := NewSNMPCollector(cfg)
+	if err != nil {
+		t.Fatalf("NewSNMPCollector() error = %v", err)
+	}
+	if got := c.currentProfiles(time.Now()); len(got) != 1 {
+		t.Fatalf("Expected 1 profile, got %d", len(got))
+	}
+
+	// An invalid change keeps the profiles in use
+	writeProfile(t, dir, "queues.yaml", "name: queues\nmetrics:\n  - measurement: queue\n")
+	if err := c.ReloadProfiles(); err == nil || !strings.Contains(err.Error(), "queues.yaml:3:") {
+		t.Errorf("ReloadProfiles() error = %v, want the invalid file and line", err)
+	}
+	if got := c.currentProfiles(time.Now().Add(profileCheckInterval)); len(got) != 1 || got[0].name != "etherlike" {
+		t.Errorf("Expected the previous profiles, got %v", got)
+	}
+
+	// Fixed files are picked up at the next check
+	writeProfile(t, dir, "queues.yaml", queueProfile)
+	if got := c.currentProfiles(time.Now()); len(got) != 1 {
+		t.Errorf("Expected no check before the interval, got %d profiles", len(got))
+	}
+	if got := c.currentProfiles(time.Now().Add(2 * profileCheckInterval)); len(got) != 2 {
+		t.Errorf("Expected the changed directory to be loaded, got %d profiles", len(got))
+	}
+
+	// A failed reload is logged with the invalid file
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+	broken := filepath.Join(dir, "queues.yaml")
+	writeProfile(t, dir, "queues.yaml", "name: queues\nmetrics:\n  - measurement: queue\n")
+	if got := c.currentProfiles(time.Now().Add(4 * profileCheckInterval)); len(got) != 2 {
+		t.Errorf("Expected the previous profiles, got %d", len(got))
+	}
+	entry := hook.LastEntry()
+	if entry == nil || entry.Level != logrus.ErrorLevel || entry.Data["file"] != broken {
+		t.Errorf("Expected a reload error logged with file %s, got %+v", broken, entry)
+	}
+}
@@ -3,11 +3,14 @@ package metrics
 import (
 	"context"
 	"collector/internal/config"
+	"collector/internal/secrets"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/crypto/ssh"
@@ -15,98 +18,206 @@ import (
 
 // SSHCollector implements the MetricCollector interface for SSH-based metric collection
 type SSHCollector struct {
-	config config.SSHConfig
+	config   config.SSHConfig
+	checks   []sshCheck
+	hostKeys *hostKeyVerifier
+	pool     *sshPool
+	secrets  *secrets.Resolver
+
+	// The previous /proc/stat and /proc/net/dev samples of each address,
+	// from which utilization and rates are computed
+	sampleMu   sync.Mutex
+	cpuSamples map[string]cpuSample
+	netSamples map[string]netSample
+	// When samples of devices no longer polled were last removed
+	samplesPruned time.Time
 }
 
 // NewSSHCollector creates a new SSHCollector
 func NewSSHCollector(cfg config.SSHConfig) (*SSHCollector, error) {
-	return &SSHCollector{config: cfg}, nil
+	hostKeys, err := newHostKeyVerifier(cfg.KnownHostsFile, cfg.HostKeyPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	checks := make([]sshCheck, 0, len(cfg.Checks))
+	for _, check := range cfg.Checks {
+		compiled, err := compileSSHCheck(check)
+		if err != nil {
+			return nil, fmt.Errorf("SSH check %q: %w", check.Name, err)
+		}
+		checks = append(checks, compiled)
+	}
+
+	return &SSHCollector{
+		config:     cfg,
+		checks:     checks,
+		hostKeys:   hostKeys,
+		pool:       newSSHPool(cfg.KeepaliveInterval, cfg.IdleTimeout),
+		cpuSamples: make(map[string]cpuSample),
+		netSamples: make(map[string]netSample),
+	}, nil
 }
 
 // Collect performs SSH metric collection for the given IP address
 func (c *SSHCollector) Collect(ctx context.Context, ipAddress string) ([]Metric, error) {
-	// Create SSH client configuration
-	config := &ssh.ClientConfig{
-		User:            c.config.Username,
-		Timeout:         c.config.Timeout,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // Note: In production, use proper host key verification
+	return c.collect(ctx, ipAddress, c.credentials(), nil)
+}
+
+// credentials returns the globally configured SSH credentials
+func (c *SSHCollector) credentials() config.SSHCredentials {
+	return config.SSHCredentials{
+		Username: c.config.Username,
+		Password: c.config.Password,
+		KeyFile:  c.config.KeyFile,
 	}
+}
 
-	// Set authentication method
-	if c.config.KeyFile != "" {
-		// Use key-based authentication
-		key, err := ioutil.ReadFile(c.config.KeyFile)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read SSH key file: %w", err)
+// ForDevice returns a MetricCollector that also runs the custom checks
+// whose selector matches the device
+func (c *SSHCollector) ForDevice(deviceID, deviceType, hostname string, tags []string) MetricCollector {
+	var matched []sshCheck
+	for _, check := range c.checks {
+		if check.Matches(deviceID, deviceType, tags) {
+			matched = append(matched, check)
 		}
+	}
+	if len(matched) == 0 {
+		return c
+	}
+	return &boundSSH{collector: c, creds: c.credentials(), checks: matched}
+}
 
-		signer, err := ssh.ParsePrivateKey(key)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse SSH key: %w", err)
-		}
+// UseSecrets resolves usernames and passwords given as secret references
+// with r
+func (c *SSHCollector) UseSecrets(r *secrets.Resolver) {
+	c.secrets = r
+}
 
-		config.Auth = []ssh.AuthMethod{ssh.PublicKeys(signer)}
-	} else if c.config.Password != "" {
-		// Use password authentication
-		config.Auth = []ssh.AuthMethod{ssh.Password(c.config.Password)}
-	} else {
-		return nil, fmt.Errorf("no SSH authentication method configured")
+// WithCredentials returns a MetricCollector that logs in with the SSH
+// credentials of a profile, or nil if the profile has none
+func (c *SSHCollector) WithCredentials(profile config.CredentialProfile) MetricCollector {
+	if !profile.SSH.Configured() {
+		return nil
 	}
+	return &boundSSH{collector: c, creds: profile.SSH}
+}
 
-	// Connect to SSH server
-	client, err := ssh.Dial("tcp", ipAddress+":22", config)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to SSH server at %s: %w", ipAddress, err)
+// boundSSH is an SSHCollector bound to one set of credentials and the
+// custom checks of a device
+type boundSSH struct {
+	collector *SSHCollector
+	creds     config.SSHCredentials
+	checks    []sshCheck
+}
+
+// Collect collects with the bound credentials
+func (b *boundSSH) Collect(ctx context.Context, ipAddress string) ([]Metric, error) {
+	return b.collector.collect(ctx, ipAddress, b.creds, b.checks)
+}
+
+// WithCredentials returns a MetricCollector that runs the bound checks with
+// the SSH credentials of a profile, or nil if the profile has none
+func (b *boundSSH) WithCredentials(profile config.CredentialProfile) MetricCollector {
+	if !profile.SSH.Configured() {
+		return nil
 	}
-	defer client.Close()
+	return &boundSSH{collector: b.collector, creds: profile.SSH, checks: b.checks}
+}
 
-	var metrics []Metric
-	var errors []string
-	timestamp := time.Now()
+// collect performs SSH metric collection with the given credentials,
+// running the given custom checks in the same session
+func (c *SSHCollector) collect(ctx context.Context, ipAddress string, creds config.SSHCredentials, checks []sshCheck) ([]Metric, error) {
+	// Validate input
+	if ipAddress == "" {
+		return nil, fmt.Errorf("IP address cannot be empty")
+	}
 
-	// Collect CPU metrics
-	cpuMetrics, err := c.collectCPUMetrics(client, timestamp)
-	if err != nil {
-		errors = append(errors, fmt.Sprintf("CPU metrics: %v", err))
-		log.Printf("SSH collector failed to collect CPU metrics from %s: %v", ipAddress, err)
-	} else {
-		metrics = append(metrics, cpuMetrics...)
+	var err error
+	if creds.Username, err = c.secrets.Resolve(ctx, creds.Username); err != nil {
+		return nil, err
+	}
+	if creds.Password, err = c.secrets.Resolve(ctx, creds.Password); err != nil {
+		return nil, err
 	}
 
-	// Collect memory metrics
-	memMetrics, err := c.collectMemoryMetrics(client, timestamp)
-	if err != nil {
-		errors = append(errors, fmt.Sprintf("Memory metrics: %v", err))
-		log.Printf("SSH collector failed to collect memory metrics from %s: %v", ipAddress, err)
-	} else {
-		metrics = append(metrics, memMetrics...)
+	port := c.config.Port
+	if port == 0 {
+		port = 22
 	}
+	address := net.JoinHostPort(ipAddress, strconv.Itoa(port))
+	key := newSSHPoolKey(address, creds.Username, creds.Password, creds.KeyFile)
 
-	// Collect disk metrics
-	diskMetrics, err := c.collectDiskMetrics(client, timestamp)
-	if err != nil {
-		errors = append(errors, fmt.Sprintf("Disk metrics: %v", err))
-		log.Printf("SSH collector failed to collect disk metrics from %s: %v", ipAddress, err)
-	} else {
-		metrics = append(metrics, diskMetrics...)
+	// Run all commands in one session, over a pooled connection if there is
+	// one. A pooled connection may have gone stale, so a failure on one is
+	// retried once on a fresh connection.
+	commands := sshMetricCommands
+	if len(checks) > 0 {
+		commands = make([]sshCommand, 0, len(sshMetricCommands)+len(checks))
+		commands = append(commands, sshMetricCommands...)
+		for i := range checks {
+			commands = append(commands, checks[i].command(i))
+		}
 	}
 
-	// Collect network metrics
-	netMetrics, err := c.collectNetworkMetrics(client, timestamp)
-	if err != nil {
-		errors = append(errors, fmt.Sprintf("Network metrics: %v", err))
-		log.Printf("SSH collector failed to collect network metrics from %s: %v", ipAddress, err)
-	} else {
-		metrics = append(metrics, netMetrics...)
+	client := c.pool.acquire(key)
+	pooled := client != nil
+	var outputs map[string]sshResult
+	for {
+		if client == nil {
+			client, err = c.connect(ctx, address, creds)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		outputs, err = runSSHBatch(ctx, client, commands)
+		if err == nil {
+			break
+		}
+		c.pool.discard(key, client)
+		if !pooled || ctx.Err() != nil {
+			return nil, fmt.Errorf("failed to run SSH commands on %s: %w", ipAddress, err)
+		}
+		client, pooled = nil, false
 	}
+	c.pool.release(key, client)
 
-	// Collect system uptime
-	uptimeMetrics, err := c.collectUptimeMetrics(client, timestamp)
-	if err != nil {
-		errors = append(errors, fmt.Sprintf("Uptime metrics: %v", err))
-		log.Printf("SSH collector failed to collect uptime metrics from %s: %v", ipAddress, err)
-	} else {
-		metrics = append(metrics, uptimeMetrics...)
+	var metrics []Metric
+	var errors []string
+	timestamp := time.Now()
+
+	for _, cmd := range sshMetricCommands {
+		result, ok := outputs[cmd.name]
+		var cmdMetrics []Metric
+		if !ok {
+			err = fmt.Errorf("no output")
+		} else {
+			cmdMetrics, err = c.parseSection(cmd.name, address, result.output, timestamp)
+		}
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("%s metrics: %v", sshMetricLabels[cmd.name], err))
+			log.Printf("SSH collector failed to collect %s metrics from %s: %v", cmd.name, ipAddress, err)
+			continue
+		}
+		metrics = append(metrics, cmdMetrics...)
+	}
+
+	for i := range checks {
+		check := &checks[i]
+		result, ok := outputs[check.command(i).name]
+		var metric Metric
+		if !ok {
+			err = fmt.Errorf("no output")
+		} else {
+			metric, err = check.parse(result, timestamp)
+		}
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("Check %s: %v", check.Name, err))
+			log.Printf("SSH collector failed to run check %s on %s: %v", check.Name, ipAddress, err)
+			continue
+		}
+		metrics = append(metrics, metric)
 	}
 
 	// Return metrics even if some collection failed, but log errors
@@ -114,6 +225,8 @@ func (c *SSHCollector) Collect(ctx context.Context, ipAddress string) ([]Metric,
 		log.Printf("SSH collector completed with %d errors for %s: %v", len(errors), ipAddress, errors)
 	}
 
+	c.pruneSamples(timestamp)
+
 	// Only return error if no metrics were collected at all
 	if len(metrics) == 0 && len(errors) > 0 {
 		return nil, fmt.Errorf("failed to collect any SSH metrics from %s: %s", ipAddress, strings.Join(errors, "; "))
@@ -122,189 +235,163 @@ func (c *SSHCollector) Collect(ctx context.Context, ipAddress string) ([]Metric,
 	return metrics, nil
 }
 
-// executeCommand executes a command via SSH and returns the output
-func (c *SSHCollector) executeCommand(client *ssh.Client, command string) (string, error) {
-	session, err := client.NewSession()
-	if err != nil {
-		return "", fmt.Errorf("failed to create SSH session: %w", err)
+// connect dials and authenticates to address
+func (c *SSHCollector) connect(ctx context.Context, address string, creds config.SSHCredentials) (*ssh.Client, error) {
+	// Create SSH client configuration
+	config := &ssh.ClientConfig{
+		User:              creds.Username,
+		Timeout:           c.config.Timeout,
+		HostKeyCallback:   c.hostKeys.Verify,
+		HostKeyAlgorithms: c.hostKeys.KnownAlgorithms(address),
 	}
-	defer session.Close()
 
-	output, err := session.Output(command)
-	if err != nil {
-		return "", fmt.Errorf("failed to execute command '%s': %w", command, err)
-	}
+	// Set authentication method
+	if creds.KeyFile != "" {
+		// Use key-based authentication
+		key, err := ioutil.ReadFile(creds.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SSH key file: %w", err)
+		}
 
-	return strings.TrimSpace(string(output)), nil
-}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse SSH key: %w", err)
+		}
 
-// collectCPUMetrics collects CPU utilization metrics
-func (c *SSHCollector) collectCPUMetrics(client *ssh.Client, timestamp time.Time) ([]Metric, error) {
-	// Use top command to get CPU usage
-	output, err := c.executeCommand(client, "top -bn1 | grep 'Cpu(s)' | awk '{print $2}' | cut -d'%' -f1")
-	if err != nil {
-		return nil, fmt.Errorf("failed to get CPU metrics: %w", err)
+		config.Auth = []ssh.AuthMethod{ssh.PublicKeys(signer)}
+	} else if creds.Password != "" {
+		// Use password authentication
+		config.Auth = []ssh.AuthMethod{ssh.Password(creds.Password)}
+	} else {
+		return nil, fmt.Errorf("no SSH authentication method configured")
 	}
 
-	cpuUsage, err := strconv.ParseFloat(output, 64)
+	// Connect to SSH server
+	client, err := dialSSH(ctx, address, config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse CPU usage: %w", err)
-	}
-
-	metrics := []Metric{
-		{
-			Name: "cpu_utilization",
-			Value: map[string]interface{}{
-				"cpu_percent": cpuUsage,
-			},
-			Timestamp: timestamp,
-			Tags: map[string]string{
-				"metric_type": "cpu",
-				"source":      "ssh",
-			},
-		},
+		return nil, fmt.Errorf("failed to connect to SSH server at %s: %w", address, err)
 	}
+	return client, nil
+}
 
-	return metrics, nil
+// Close closes all pooled SSH connections
+func (c *SSHCollector) Close() error {
+	return c.pool.Close()
 }
 
-// collectMemoryMetrics collects memory utilization metrics
-func (c *SSHCollector) collectMemoryMetrics(client *ssh.Client, timestamp time.Time) ([]Metric, error) {
-	// Use free command to get memory usage
-	output, err := c.executeCommand(client, "free -m | grep '^Mem:' | awk '{print $2,$3,$4}'")
-	if err != nil {
-		return nil, fmt.Errorf("failed to get memory metrics: %w", err)
-	}
+// sshMetricCommands are run in one batch on every poll. They read procfs
+// and POSIX df output, which do not depend on the locale or on the procps
+// or BusyBox version of the device.
+var sshMetricCommands = []sshCommand{
+	{name: "cpu", command: "cat /proc/stat"},
+	{name: "memory", command: "cat /proc/meminfo"},
+	{name: "load", command: "cat /proc/loadavg"},
+	{name: "disk", command: "df -P -k"},
+	{name: "network", command: "cat /proc/net/dev"},
+	{name: "uptime", command: "cat /proc/uptime | awk '{print $1}'"},
+}
 
-	fields := strings.Fields(output)
-	if len(fields) < 3 {
-		return nil, fmt.Errorf("unexpected memory output format: %s", output)
-	}
+// parseSection turns the output of a metric command into metrics
+func (c *SSHCollector) parseSection(name, address, output string, timestamp time.Time) ([]Metric, error) {
+	switch name {
+	case "cpu":
+		return c.parseCPUMetrics(address, output, timestamp)
+	case "memory":
+		return parseMemoryMetrics(output, timestamp)
+	case "load":
+		return parseLoadMetrics(output, timestamp)
+	case "disk":
+		return parseDiskMetrics(output, timestamp)
+	case "network":
+		return c.parseNetworkMetrics(address, output, timestamp)
+	case "uptime":
+		return parseUptimeMetrics(output, timestamp)
+	}
+	return nil, fmt.Errorf("unknown metric command %q", name)
+}
 
-	totalMem, err := strconv.ParseFloat(fields[0], 64)
+// parseCPUMetrics computes CPU utilization since the previous sample of
+// address. The first poll of an address, or the first after the previous
+// sample expired, only records a sample.
+func (c *SSHCollector) parseCPUMetrics(address, output string, timestamp time.Time) ([]Metric, error) {
+	cpus, err := parseProcStat(output)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse total memory: %w", err)
+		return nil, fmt.Errorf("failed to parse CPU usage: %w", err)
 	}
+	sample := cpuSample{at: timestamp, cpus: cpus}
 
-	usedMem, err := strconv.ParseFloat(fields[1], 64)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse used memory: %w", err)
+	c.sampleMu.Lock()
+	prev, seen := c.cpuSamples[address]
+	if seen && sampleExpired(prev.at, prev.interval, timestamp) {
+		prev, seen = cpuSample{}, false
 	}
-
-	memPercent := (usedMem / totalMem) * 100
-
-	metrics := []Metric{
-		{
-			Name: "memory_utilization",
-			Value: map[string]interface{}{
-				"memory_percent": memPercent,
-				"memory_total":   totalMem,
-				"memory_used":    usedMem,
-			},
-			Timestamp: timestamp,
-			Tags: map[string]string{
-				"metric_type": "memory",
-				"source":      "ssh",
-			},
-		},
+	if seen {
+		sample.interval = timestamp.Sub(prev.at)
 	}
+	c.cpuSamples[address] = sample
+	c.sampleMu.Unlock()
 
-	return metrics, nil
+	return cpuMetrics(prev.cpus, sample.cpus, timestamp), nil
 }
 
-// collectDiskMetrics collects disk utilization metrics
-func (c *SSHCollector) collectDiskMetrics(client *ssh.Client, timestamp time.Time) ([]Metric, error) {
-	// Use df command to get disk usage for root filesystem
-	output, err := c.executeCommand(client, "df -h / | tail -1 | awk '{print $2,$3,$4,$5}' | tr -d '%'")
-	if err != nil {
-		return nil, fmt.Errorf("failed to get disk metrics: %w", err)
-	}
+// pruneSamples removes the /proc/stat and /proc/net/dev samples that have
+// expired. It does the work at most once per samplePruneInterval.
+func (c *SSHCollector) pruneSamples(now time.Time) {
+	c.sampleMu.Lock()
+	defer c.sampleMu.Unlock()
 
-	fields := strings.Fields(output)
-	if len(fields) < 4 {
-		return nil, fmt.Errorf("unexpected disk output format: %s", output)
+	if now.Sub(c.samplesPruned) < samplePruneInterval {
+		return
 	}
+	c.samplesPruned = now
 
-	diskPercent, err := strconv.ParseFloat(fields[3], 64)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse disk usage percentage: %w", err)
+	for key, sample := range c.cpuSamples {
+		if sampleExpired(sample.at, sample.interval, now) {
+			delete(c.cpuSamples, key)
+		}
 	}
-
-	metrics := []Metric{
-		{
-			Name: "disk_utilization",
-			Value: map[string]interface{}{
-				"disk_percent": diskPercent,
-				"disk_total":   fields[0],
-				"disk_used":    fields[1],
-				"disk_free":    fields[2],
-			},
-			Timestamp: timestamp,
-			Tags: map[string]string{
-				"metric_type": "disk",
-				"source":      "ssh",
-				"filesystem":  "/",
-			},
-		},
+	for key, sample := range c.netSamples {
+		if sampleExpired(sample.at, sample.interval, now) {
+			delete(c.netSamples, key)
+		}
 	}
-
-	return metrics, nil
 }
 
-// collectNetworkMetrics collects network traffic metrics
-func (c *SSHCollector) collectNetworkMetrics(client *ssh.Client, timestamp time.Time) ([]Metric, error) {
-	// Get network interface statistics
-	output, err := c.executeCommand(client, "cat /proc/net/dev | grep -E 'eth0|ens|enp' | head -1 | awk '{print $2,$10}'")
-	if err != nil {
-		return nil, fmt.Errorf("failed to get network metrics: %w", err)
-	}
-
-	if output == "" {
-		// No network interface found
-		return nil, nil
-	}
-
-	fields := strings.Fields(output)
-	if len(fields) < 2 {
-		return nil, fmt.Errorf("unexpected network output format: %s", output)
-	}
+// sshMetricLabels name each command in error messages
+var sshMetricLabels = map[string]string{
+	"cpu":     "CPU",
+	"memory":  "Memory",
+	"disk":    "Disk",
+	"load":    "Load",
+	"network": "Network",
+	"uptime":  "Uptime",
+}
 
-	bytesIn, err := strconv.ParseFloat(fields[0], 64)
+// parseNetworkMetrics reports the counters of every interface and their
+// rates since the previous sample of address, unless it expired
+func (c *SSHCollector) parseNetworkMetrics(address, output string, timestamp time.Time) ([]Metric, error) {
+	interfaces, err := parseNetDev(output)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse bytes in: %w", err)
+		return nil, err
 	}
+	sample := netSample{at: timestamp, interfaces: interfaces}
 
-	bytesOut, err := strconv.ParseFloat(fields[1], 64)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse bytes out: %w", err)
+	c.sampleMu.Lock()
+	prev, seen := c.netSamples[address]
+	if seen && sampleExpired(prev.at, prev.interval, timestamp) {
+		prev, seen = netSample{}, false
 	}
-
-	metrics := []Metric{
-		{
-			Name: "network_traffic",
-			Value: map[string]interface{}{
-				"bytes_in":  bytesIn,
-				"bytes_out": bytesOut,
-			},
-			Timestamp: timestamp,
-			Tags: map[string]string{
-				"metric_type": "network",
-				"source":      "ssh",
-			},
-		},
+	if seen {
+		sample.interval = timestamp.Sub(prev.at)
 	}
+	c.netSamples[address] = sample
+	c.sampleMu.Unlock()
 
-	return metrics, nil
+	return networkMetrics(prev, sample, timestamp), nil
 }
 
-// collectUptimeMetrics collects system uptime metrics
-func (c *SSHCollector) collectUptimeMetrics(client *ssh.Client, timestamp time.Time) ([]Metric, error) {
-	// Get system uptime in seconds
-	output, err := c.executeCommand(client, "cat /proc/uptime | awk '{print $1}'")
-	if err != nil {
-		return nil, fmt.Errorf("failed to get uptime metrics: %w", err)
-	}
-
+// parseUptimeMetrics parses system uptime metrics
+func parseUptimeMetrics(output string, timestamp time.Time) ([]Metric, error) {
 	uptime, err := strconv.ParseFloat(output, 64)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse uptime: %w", err)
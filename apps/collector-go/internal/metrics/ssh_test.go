@@ -100,4 +100,77 @@ func TestSSHCollector_ValidateConfig(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+func TestSSHCollector_CPUSampleExpiry(t *testing.T) {
+	c, err := NewSSHCollector(config.SSHConfig{HostKeyPolicy: config.SSHHostKeyInsecure})
+	if err != nil {
+		t.Fatalf("NewSSHCollector() error = %v", err)
+	}
+	first, second := readFixture(t, "stat.1"), readFixture(t, "stat.2")
+	start := time.Now()
+
+	if metrics, _ := c.parseCPUMetrics("192.0.2.1:22", first, start); len(metrics) != 0 {
+		t.Errorf("Expected no utilization on the first poll, got %d metrics", len(metrics))
+	}
+	if metrics, _ := c.parseCPUMetrics("192.0.2.1:22", second, start.Add(time.Minute)); len(metrics) != 3 {
+		t.Errorf("Expected utilization of 3 CPUs, got %d metrics", len(metrics))
+	}
+
+	// After an outage of many poll intervals the old sample is not used
+	cpus, _ := parseProcStat(first)
+	c.cpuSamples["192.0.2.1:22"] = cpuSample{at: start, interval: time.Minute, cpus: cpus}
+	if metrics, _ := c.parseCPUMetrics("192.0.2.1:22", second, start.Add(2*time.Hour)); len(metrics) != 0 {
+		t.Errorf("Expected no utilization across an outage, got %d metrics", len(metrics))
+	}
+
+	// Samples of devices no longer polled are removed
+	c.cpuSamples["192.0.2.2:22"] = cpuSample{at: start, interval: time.Minute}
+	c.pruneSamples(start.Add(2*time.Hour + time.Minute))
+	if _, ok := c.cpuSamples["192.0.2.2:22"]; ok {
+		t.Error("Expected the sample of a device no longer polled to be removed")
+	}
+	if _, ok := c.cpuSamples["192.0.2.1:22"]; !ok {
+		t.Error("Expected the sample of a polled device to be kept")
+	}
+}
+
+func TestSSHCollector_NetworkSampleExpiry(t *testing.T) {
+	c, err := NewSSHCollector(config.SSHConfig{HostKeyPolicy: config.SSHHostKeyInsecure})
+	if err != nil {
+		t.Fatalf("NewSSHCollector() error = %v", err)
+	}
+	first, _ := parseNetDev(readFixture(t, "net_dev.1"))
+	second := readFixture(t, "net_dev.2")
+	start := time.Now()
+
+	hasRates := func(metrics []Metric) bool {
+		for _, m := range metrics {
+			if _, ok := m.Value["bytes_in_per_sec"]; ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	c.netSamples["192.0.2.1:22"] = netSample{at: start, interval: time.Minute, interfaces: first}
+	if metrics, _ := c.parseNetworkMetrics("192.0.2.1:22", second, start.Add(time.Minute)); !hasRates(metrics) {
+		t.Error("Expected rates since the previous poll")
+	}
+
+	// After an outage of many poll intervals only counters are reported
+	c.netSamples["192.0.2.1:22"] = netSample{at: start, interval: time.Minute, interfaces: first}
+	metrics, _ := c.parseNetworkMetrics("192.0.2.1:22", second, start.Add(2*time.Hour))
+	if len(metrics) == 0 || hasRates(metrics) {
+		t.Errorf("Expected counters without rates across an outage, got %d metrics", len(metrics))
+	}
+
+	// Samples of devices no longer polled are removed
+	c.netSamples["192.0.2.2:22"] = netSample{at: start, interval: time.Minute}
+	c.pruneSamples(start.Add(2*time.Hour + time.Minute))
+	if _, ok := c.netSamples["192.0.2.2:22"]; ok {
+		t.Error("Expected the sample of a device no longer polled to be removed")
+	}
+	if _, ok := c.netSamples["192.0.2.1:22"]; !ok {
+		t.Error("Expected the sample of a polled device to be kept")
+	}
+}
@@ -0,0 +1,282 @@
+package metrics
+
+import (
+	"collector/internal/config"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// sshCheck is a configured custom SSH check with its pattern compiled
+type sshCheck struct {
+	config.SSHCheck
+	measurement string
+	pattern     *regexp.Regexp
+}
+
+// compileSSHCheck validates a check and compiles its pattern
+func compileSSHCheck(check config.SSHCheck) (sshCheck, error) {
+	compiled := sshCheck{SSHCheck: check, measurement: check.Measurement}
+	if compiled.measurement == "" {
+		compiled.measurement = check.Name
+	}
+	if check.Name == "" || check.Command == "" {
+		return compiled, fmt.Errorf("name and command cannot be empty")
+	}
+
+	switch check.Parser {
+	case config.SSHParserNumber, config.SSHParserKeyValue, config.SSHParserJSON, config.SSHParserNagios:
+	case config.SSHParserRegex:
+		re, err := regexp.Compile(check.Pattern)
+		if err != nil {
+			return compiled, fmt.Errorf("invalid pattern: %w", err)
+		}
+		named := false
+		for _, name := range re.SubexpNames() {
+			named = named || name != ""
+		}
+		if !named {
+			return compiled, fmt.Errorf("pattern has no named groups")
+		}
+		compiled.pattern = re
+	default:
+		return compiled, fmt.Errorf("unsupported parser %q", check.Parser)
+	}
+
+	return compiled, nil
+}
+
+// command returns the batch command of the check. Checks are named by
+// position, since check names may contain spaces.
+func (c *sshCheck) command(index int) sshCommand {
+	return sshCommand{name: "check." + strconv.Itoa(index), command: c.Command}
+}
+
+// parse turns the result of the check command into a metric
+func (c *sshCheck) parse(result sshResult, timestamp time.Time) (Metric, error) {
+	// Nagios plugins report problems through their exit status, any other
+	// command is expected to succeed
+	if c.Parser != config.SSHParserNagios && result.status != 0 {
+		return Metric{}, fmt.Errorf("command exited with status %d", result.status)
+	}
+
+	var value map[string]interface{}
+	var err error
+	switch c.Parser {
+	case config.SSHParserNumber:
+		value, err = parseNumberOutput(result.output, c.Field)
+	case config.SSHParserKeyValue:
+		value, err = parseKeyValueOutput(result.output)
+	case config.SSHParserJSON:
+		value, err = parseJSONOutput(result.output)
+	case config.SSHParserRegex:
+		value, err = parseRegexOutput(result.output, c.pattern)
+	case config.SSHParserNagios:
+		value, err = parseNagiosOutput(result.output, result.status)
+	}
+	if err != nil {
+		return Metric{}, err
+	}
+	if len(value) == 0 {
+		return Metric{}, fmt.Errorf("no values in command output")
+	}
+
+	return Metric{
+		Name:      c.measurement,
+		Value:     value,
+		Timestamp: timestamp,
+		Tags: map[string]string{
+			"metric_type": "custom",
+			"source":      "ssh",
+			"check":       c.Name,
+		},
+	}, nil
+}
+
+// fieldValue returns a number for numeric text and the text otherwise
+func fieldValue(text string) interface{} {
+	if v, err := strconv.ParseFloat(text, 64); err == nil {
+		return v
+	}
+	return text
+}
+
+// parseNumberOutput parses output that is a single number
+func parseNumberOutput(output, field string) (map[string]interface{}, error) {
+	if field == "" {
+		field = "value"
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(output), 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse number: %w", err)
+	}
+	return map[string]interface{}{field: v}, nil
+}
+
+// parseKeyValueOutput parses "key=value" or "key: value" lines. Blank lines
+// and lines starting with # are ignored.
+func parseKeyValueOutput(output string) (map[string]interface{}, error) {
+	value := make(map[string]interface{})
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, v, ok := strings.Cut(line, "=")
+		if !ok {
+			key, v, ok = strings.Cut(line, ":")
+		}
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("unexpected key=value line: %s", line)
+		}
+		value[key] = fieldValue(strings.TrimSpace(v))
+	}
+	return value, nil
+}
+
+// parseJSONOutput parses a JSON object. Nested objects and arrays are
+// flattened into dotted field names such as "disks.0.state"; nulls are
+// skipped.
+func parseJSONOutput(output string) (map[string]interface{}, error) {
+	var doc map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(output))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON: %w", err)
+	}
+
+	value := make(map[string]interface{})
+	var flatten func(prefix string, v interface{})
+	flatten = func(prefix string, v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for key, child := range v {
+				flatten(joinField(prefix, key), child)
+			}
+		case []interface{}:
+			for i, child := range v {
+				flatten(joinField(prefix, strconv.Itoa(i)), child)
+			}
+		case json.Number:
+			if f, err := v.Float64(); err == nil {
+				value[prefix] = f
+			}
+		case string, bool:
+			value[prefix] = v
+		}
+	}
+	flatten("", doc)
+
+	return value, nil
+}
+
+// joinField appends a key to a dotted field name
+func joinField(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+// parseRegexOutput returns the named groups of the first match of re
+func parseRegexOutput(output string, re *regexp.Regexp) (map[string]interface{}, error) {
+	match := re.FindStringSubmatch(output)
+	if match == nil {
+		return nil, fmt.Errorf("pattern did not match output")
+	}
+
+	value := make(map[string]interface{})
+	for i, name := range re.SubexpNames() {
+		if name != "" && i < len(match) {
+			value[name] = fieldValue(match[i])
+		}
+	}
+	return value, nil
+}
+
+// nagiosStates names the exit statuses of Nagios plugins
+var nagiosStates = map[int]string{0: "OK", 1: "WARNING", 2: "CRITICAL", 3: "UNKNOWN"}
+
+// parseNagiosOutput parses Nagios plugin output. The first line is the
+// status text, optionally followed by "|" and performance data; later lines
+// may add more performance data after a "|". Each performance value
+// becomes a field, with its warning and critical thresholds when set.
+func parseNagiosOutput(output string, status int) (map[string]interface{}, error) {
+	state, ok := nagiosStates[status]
+	if !ok {
+		// Anything else means the plugin itself failed
+		state, status = "UNKNOWN", 3
+	}
+
+	lines := strings.Split(output, "\n")
+	text, perf, _ := strings.Cut(lines[0], "|")
+	for _, line := range lines[1:] {
+		if _, more, ok := strings.Cut(line, "|"); ok {
+			perf += " " + more
+		}
+	}
+
+	value := map[string]interface{}{
+		"status":      float64(status),
+		"state":       state,
+		"status_text": strings.TrimSpace(text),
+	}
+	for _, item := range splitPerfData(perf) {
+		label, data, ok := strings.Cut(item, "=")
+		if !ok {
+			continue
+		}
+		label = strings.Trim(label, "'")
+		parts := strings.Split(data, ";")
+
+		// Strip the unit of measurement, such as "%", "s" or "MB"
+		number := strings.TrimRightFunc(parts[0], func(r rune) bool {
+			return unicode.IsLetter(r) || r == '%'
+		})
+		v, err := strconv.ParseFloat(number, 64)
+		if err != nil {
+			continue
+		}
+		value[label] = v
+		for i, suffix := range []string{"_warn", "_crit", "_min", "_max"} {
+			if i+1 >= len(parts) || parts[i+1] == "" {
+				continue
+			}
+			// Range thresholds such as "10:20" are not reported
+			if threshold, err := strconv.ParseFloat(parts[i+1], 64); err == nil {
+				value[label+suffix] = threshold
+			}
+		}
+	}
+	return value, nil
+}
+
+// splitPerfData splits performance data on spaces outside quoted labels
+func splitPerfData(perf string) []string {
+	var items []string
+	var item strings.Builder
+	quoted := false
+	for _, r := range perf {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+			item.WriteRune(r)
+		case r == ' ' && !quoted:
+			if item.Len() > 0 {
+				items = append(items, item.String())
+				item.Reset()
+			}
+		default:
+			item.WriteRune(r)
+		}
+	}
+	if item.Len() > 0 {
+		items = append(items, item.String())
+	}
+	return items
+}
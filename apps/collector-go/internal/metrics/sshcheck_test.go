@@ -0,0 +1,186 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"collector/internal/config"
+)
+
+func TestCompileSSHCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		check   config.SSHCheck
+		wantErr bool
+	}{
+		{name: "number", check: config.SSHCheck{Name: "queue", Command: "wc -l < /var/spool/q", Parser: config.SSHParserNumber}},
+		{name: "regex", check: config.SSHCheck{Name: "raid", Command: "cat /proc/mdstat", Parser: config.SSHParserRegex, Pattern: `\[(?P<up>\d+)/(?P<total>\d+)\]`}},
+		{name: "missing command", check: config.SSHCheck{Name: "queue", Parser: config.SSHParserNumber}, wantErr: true},
+		{name: "unknown parser", check: config.SSHCheck{Name: "queue", Command: "true", Parser: "xml"}, wantErr: true},
+		{name: "invalid pattern", check: config.SSHCheck{Name: "raid", Command: "true", Parser: config.SSHParserRegex, Pattern: "("}, wantErr: true},
+		{name: "pattern without named groups", check: config.SSHCheck{Name: "raid", Command: "true", Parser: config.SSHParserRegex, Pattern: `(\d+)`}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compiled, err := compileSSHCheck(tt.check)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("compileSSHCheck() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && compiled.measurement != tt.check.Name {
+				t.Errorf("Expected the measurement to default to the name, got %q", compiled.measurement)
+			}
+		})
+	}
+}
+
+func TestSSHCheck_Parse(t *testing.T) {
+	tests := []struct {
+		name    string
+		check   config.SSHCheck
+		result  sshResult
+		want    map[string]interface{}
+		wantErr bool
+	}{
+		{
+			name:   "number",
+			check:  config.SSHCheck{Parser: config.SSHParserNumber, Field: "depth"},
+			result: sshResult{output: "42\n"},
+			want:   map[string]interface{}{"depth": 42.0},
+		},
+		{
+			name:    "number with garbage",
+			check:   config.SSHCheck{Parser: config.SSHParserNumber},
+			result:  sshResult{output: "queue: 42"},
+			wantErr: true,
+		},
+		{
+			name:    "failed command",
+			check:   config.SSHCheck{Parser: config.SSHParserNumber},
+			result:  sshResult{output: "42", status: 1},
+			wantErr: true,
+		},
+		{
+			name:   "key value",
+			check:  config.SSHCheck{Parser: config.SSHParserKeyValue},
+			result: sshResult{output: "# md0\nstate=clean\nactive_devices: 2\nfailed = 0\n"},
+			want:   map[string]interface{}{"state": "clean", "active_devices": 2.0, "failed": 0.0},
+		},
+		{
+			name:   "json",
+			check:  config.SSHCheck{Parser: config.SSHParserJSON},
+			result: sshResult{output: `{"queue": {"depth": 7, "paused": false}, "workers": [{"busy": 3}], "note": null}`},
+			want:   map[string]interface{}{"queue.depth": 7.0, "queue.paused": false, "workers.0.busy": 3.0},
+		},
+		{
+			name:    "json that is not an object",
+			check:   config.SSHCheck{Parser: config.SSHParserJSON},
+			result:  sshResult{output: `[1, 2]`},
+			wantErr: true,
+		},
+		{
+			name:   "regex",
+			check:  config.SSHCheck{Parser: config.SSHParserRegex, Pattern: `\[(?P<total>\d+)/(?P<up>\d+)\] \[(?P<status>[U_]+)\]`},
+			result: sshResult{output: "md0 : active raid1 sdb1[1] sda1[0]\n      976630336 blocks [2/1] [U_]\n"},
+			want:   map[string]interface{}{"total": 2.0, "up": 1.0, "status": "U_"},
+		},
+		{
+			name:    "regex without match",
+			check:   config.SSHCheck{Parser: config.SSHParserRegex, Pattern: `(?P<up>\d+) up`},
+			result:  sshResult{output: "nothing"},
+			wantErr: true,
+		},
+		{
+			name:   "nagios warning with perfdata",
+			check:  config.SSHCheck{Parser: config.SSHParserNagios},
+			result: sshResult{output: "DISK WARNING - free space: / 3326 MB (8%) | '/ used'=35122MB;34000;38000;0;38448 time=0.012s\n", status: 1},
+			want: map[string]interface{}{
+				"status": 1.0, "state": "WARNING", "status_text": "DISK WARNING - free space: / 3326 MB (8%)",
+				"/ used": 35122.0, "/ used_warn": 34000.0, "/ used_crit": 38000.0, "/ used_min": 0.0, "/ used_max": 38448.0,
+				"time": 0.012,
+			},
+		},
+		{
+			name:   "nagios long output",
+			check:  config.SSHCheck{Parser: config.SSHParserNagios},
+			result: sshResult{output: "OK - 2 jobs\njob a done\njob b done | jobs=2;5;10"},
+			want:   map[string]interface{}{"status": 0.0, "state": "OK", "status_text": "OK - 2 jobs", "jobs": 2.0, "jobs_warn": 5.0, "jobs_crit": 10.0},
+		},
+		{
+			name:   "nagios plugin failure",
+			check:  config.SSHCheck{Parser: config.SSHParserNagios},
+			result: sshResult{output: "sh: check_foo: not found", status: 127},
+			want:   map[string]interface{}{"status": 3.0, "state": "UNKNOWN", "status_text": "sh: check_foo: not found"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.check.Name, tt.check.Command = "check", "true"
+			check, err := compileSSHCheck(tt.check)
+			if err != nil {
+				t.Fatalf("compileSSHCheck() error = %v", err)
+			}
+
+			metric, err := check.parse(tt.result, time.Now())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(metric.Value) != len(tt.want) {
+				t.Errorf("parse() = %v, want %v", metric.Value, tt.want)
+			}
+			for field, v := range tt.want {
+				if metric.Value[field] != v {
+					t.Errorf("%s = %#v, want %#v", field, metric.Value[field], v)
+				}
+			}
+			if metric.Tags["check"] != "check" || metric.Tags["metric_type"] != "custom" {
+				t.Errorf("Unexpected tags %v", metric.Tags)
+			}
+		})
+	}
+}
+
+func TestSSHCollector_ForDevice(t *testing.T) {
+	server := startTestSSHServer(t, testSSHBatchOutput+
+		sshSectionMarker+" check.0\n17\n"+sshStatusMarker+" 0\n")
+	c := server.collector(t, time.Minute)
+	c.checks = []sshCheck{{
+		SSHCheck: config.SSHCheck{
+			DeviceSelector: config.DeviceSelector{Tags: []string{"mail"}},
+			Name:           "mail queue",
+			Command:        "mailq | grep -c '^[A-F0-9]'",
+			Parser:         config.SSHParserNumber,
+		},
+		measurement: "mail_queue",
+	}}
+
+	if bound := c.ForDevice("d1", "linux", "web1", []string{"web"}); bound != c {
+		t.Errorf("Expected the collector itself for a device without checks, got %T", bound)
+	}
+
+	bound := c.ForDevice("d2", "linux", "mx1", []string{"mail"})
+	result, err := bound.Collect(context.Background(), "127.0.0.1")
+	if err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	var found bool
+	for _, m := range result {
+		if m.Name == "mail_queue" {
+			found = m.Value["value"] == 17.0 && m.Tags["check"] == "mail queue"
+		}
+	}
+	if !found {
+		t.Errorf("Expected the mail_queue check metric, got %+v", result)
+	}
+
+	// Credential profiles keep the device's checks
+	withCreds := bound.(*boundSSH).WithCredentials(config.CredentialProfile{SSH: config.SSHCredentials{Username: "other", Password: "x"}})
+	if b, ok := withCreds.(*boundSSH); !ok || len(b.checks) != 1 || b.creds.Username != "other" {
+		t.Errorf("Expected the checks to be kept with profile credentials, got %+v", withCreds)
+	}
+}
@@ -0,0 +1,238 @@
+package metrics
+
+import (
+	"collector/internal/config"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// HostKeyChangedError is returned when a host presents a key that differs
+// from the one recorded in known_hosts, which may indicate a
+// man-in-the-middle attack
+type HostKeyChangedError struct {
+	Host        string
+	KeyType     string
+	Fingerprint string
+	// Known lists the recorded keys as "<file>:<line> <fingerprint>"
+	Known []string
+}
+
+// Error implements error
+func (e *HostKeyChangedError) Error() string {
+	return fmt.Sprintf("SSH host key for %s changed: presented %s %s, known_hosts has %s",
+		e.Host, e.KeyType, e.Fingerprint, strings.Join(e.Known, ", "))
+}
+
+// hostKeyVerifier checks SSH host keys against a known_hosts file, which is
+// re-read whenever it changes. In trust-on-first-use mode the key of a host
+// without an entry is accepted and appended to the file.
+type hostKeyVerifier struct {
+	path   string
+	policy string
+
+	// placeholder is a key no host presents, used to look up known keys
+	placeholder ssh.PublicKey
+
+	mu       sync.Mutex
+	callback ssh.HostKeyCallback
+	modTime  time.Time
+	size     int64
+}
+
+// newHostKeyVerifier creates a verifier for a host key policy. The
+// known_hosts file is read on first use, so it may be created after startup;
+// until then every host key is rejected in strict mode, which is logged once
+// here so that a missing file is not only visible as failing polls.
+func newHostKeyVerifier(path, policy string) (*hostKeyVerifier, error) {
+	if policy == "" {
+		policy = config.SSHHostKeyStrict
+	}
+
+	switch policy {
+	case config.SSHHostKeyInsecure:
+		logrus.Warn("SSH host key verification is disabled")
+		return &hostKeyVerifier{policy: policy}, nil
+	case config.SSHHostKeyStrict, config.SSHHostKeyTOFU:
+	default:
+		return nil, fmt.Errorf("unsupported SSH host key policy %q", policy)
+	}
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	placeholder, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	if policy == config.SSHHostKeyStrict {
+		if _, err := os.Stat(path); path == "" || errors.Is(err, os.ErrNotExist) {
+			logrus.WithFields(logrus.Fields{
+				"known_hosts_file": path,
+				"host_key_policy":  policy,
+			}).Error("SSH known_hosts file is missing, every SSH poll will fail until host keys are added " +
+				"(for example with ssh-keyscan) or ssh.host_key_policy is set to tofu")
+		}
+	}
+
+	return &hostKeyVerifier{path: path, policy: policy, placeholder: placeholder}, nil
+}
+
+// loadLocked re-reads the known_hosts file if it changed. A missing file is
+// treated as empty in trust-on-first-use mode. Callers must hold mu.
+func (v *hostKeyVerifier) loadLocked() error {
+	if v.path == "" {
+		return fmt.Errorf("no SSH known_hosts file configured for host key policy %q", v.policy)
+	}
+
+	info, err := os.Stat(v.path)
+	if errors.Is(err, os.ErrNotExist) && v.policy == config.SSHHostKeyTOFU {
+		v.callback, v.modTime, v.size = nil, time.Time{}, 0
+		return nil
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("known_hosts file %s does not exist; add host keys or set ssh.host_key_policy to tofu", v.path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read known_hosts: %w", err)
+	}
+	if v.callback != nil && info.ModTime().Equal(v.modTime) && info.Size() == v.size {
+		return nil
+	}
+
+	callback, err := knownhosts.New(v.path)
+	if err != nil {
+		return fmt.Errorf("failed to load known_hosts: %w", err)
+	}
+	v.callback, v.modTime, v.size = callback, info.ModTime(), info.Size()
+	return nil
+}
+
+// checkLocked checks a key against the loaded known_hosts entries. Callers
+// must hold mu.
+func (v *hostKeyVerifier) checkLocked(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	if v.callback == nil {
+		return &knownhosts.KeyError{}
+	}
+	return v.callback(hostname, remote, key)
+}
+
+// Verify implements ssh.HostKeyCallback
+func (v *hostKeyVerifier) Verify(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	if v.policy == config.SSHHostKeyInsecure {
+		return nil
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if err := v.loadLocked(); err != nil {
+		return err
+	}
+
+	err := v.checkLocked(hostname, remote, key)
+	var keyErr *knownhosts.KeyError
+	switch {
+	case err == nil:
+		return nil
+	case !errors.As(err, &keyErr):
+		return err
+	case len(keyErr.Want) > 0:
+		changed := &HostKeyChangedError{
+			Host:        hostname,
+			KeyType:     key.Type(),
+			Fingerprint: ssh.FingerprintSHA256(key),
+		}
+		for _, known := range keyErr.Want {
+			changed.Known = append(changed.Known, fmt.Sprintf("%s:%d %s", known.Filename, known.Line, ssh.FingerprintSHA256(known.Key)))
+		}
+		return changed
+	case v.policy == config.SSHHostKeyTOFU:
+		return v.trustLocked(hostname, key)
+	default:
+		return fmt.Errorf("unknown SSH host key %s %s for %s", key.Type(), ssh.FingerprintSHA256(key), hostname)
+	}
+}
+
+// trustLocked appends the key of a newly seen host to the known_hosts file,
+// creating the file and its directory if needed. Callers must hold mu.
+func (v *hostKeyVerifier) trustLocked(hostname string, key ssh.PublicKey) error {
+	if err := os.MkdirAll(filepath.Dir(v.path), 0700); err != nil {
+		return fmt.Errorf("failed to record SSH host key: %w", err)
+	}
+	f, err := os.OpenFile(v.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to record SSH host key: %w", err)
+	}
+	if _, err := f.WriteString(knownhosts.Line([]string{hostname}, key) + "\n"); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to record SSH host key: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to record SSH host key: %w", err)
+	}
+
+	// Force a reload so that the new entry is used from now on
+	v.callback = nil
+
+	logrus.WithFields(logrus.Fields{
+		"host":        hostname,
+		"key_type":    key.Type(),
+		"fingerprint": ssh.FingerprintSHA256(key),
+	}).Info("Trusted SSH host key on first use")
+	return nil
+}
+
+// KnownAlgorithms returns the host key algorithms of the keys recorded for
+// address, so that the server presents a key that can be verified rather
+// than one of another type, which would look like a changed key. It returns
+// nil when no key is recorded.
+func (v *hostKeyVerifier) KnownAlgorithms(address string) []string {
+	if v.policy == config.SSHHostKeyInsecure {
+		return nil
+	}
+
+	remote, err := net.ResolveTCPAddr("tcp", address)
+	if err != nil {
+		return nil
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if err := v.loadLocked(); err != nil {
+		return nil
+	}
+
+	var keyErr *knownhosts.KeyError
+	if err := v.checkLocked(address, remote, v.placeholder); !errors.As(err, &keyErr) {
+		return nil
+	}
+
+	var algorithms []string
+	for _, known := range keyErr.Want {
+		algorithms = append(algorithms, hostKeyAlgorithms(known.Key.Type())...)
+	}
+	return algorithms
+}
+
+// hostKeyAlgorithms returns the signature algorithms usable with a key type
+func hostKeyAlgorithms(keyType string) []string {
+	if keyType == ssh.KeyAlgoRSA {
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	}
+	return []string{keyType}
+}
@@ -0,0 +1,254 @@
+package metrics
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"collector/internal/config"
+
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// testHostKey generates an ed25519 host key
+func testHostKey(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate host key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	return signer
+}
+
+// writeKnownHosts writes known_hosts entries for the given keys
+func writeKnownHosts(t *testing.T, path string, entries map[string]ssh.PublicKey) {
+	t.Helper()
+	var lines []string
+	for host, key := range entries {
+		lines = append(lines, knownhosts.Line([]string{host}, key))
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatalf("Failed to write known_hosts: %v", err)
+	}
+}
+
+func TestHostKeyVerifier_Strict(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	known, other := testHostKey(t).PublicKey(), testHostKey(t).PublicKey()
+	writeKnownHosts(t, path, map[string]ssh.PublicKey{"192.0.2.1:22": known})
+
+	v, err := newHostKeyVerifier(path, config.SSHHostKeyStrict)
+	if err != nil {
+		t.Fatalf("newHostKeyVerifier() error = %v", err)
+	}
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}
+
+	if err := v.Verify("192.0.2.1:22", remote, known); err != nil {
+		t.Errorf("Verify() of the known key error = %v", err)
+	}
+
+	err = v.Verify("192.0.2.1:22", remote, other)
+	var changed *HostKeyChangedError
+	if !errors.As(err, &changed) {
+		t.Fatalf("Expected a HostKeyChangedError for a different key, got %v", err)
+	}
+	if changed.Fingerprint != ssh.FingerprintSHA256(other) || len(changed.Known) != 1 || !strings.Contains(changed.Known[0], ssh.FingerprintSHA256(known)) {
+		t.Errorf("Unexpected HostKeyChangedError: %+v", changed)
+	}
+
+	unknownRemote := &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 22}
+	err = v.Verify("192.0.2.2:22", unknownRemote, other)
+	if err == nil || errors.As(err, &changed) {
+		t.Errorf("Expected an unknown host error, got %v", err)
+	}
+
+	// Entries added to the file are picked up without a restart
+	writeKnownHosts(t, path, map[string]ssh.PublicKey{"192.0.2.1:22": known, "192.0.2.2:22": other})
+	later := time.Now().Add(time.Second)
+	os.Chtimes(path, later, later)
+	if err := v.Verify("192.0.2.2:22", unknownRemote, other); err != nil {
+		t.Errorf("Verify() after adding the host error = %v", err)
+	}
+}
+
+func TestHostKeyVerifier_StrictWithoutFile(t *testing.T) {
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+
+	missing := filepath.Join(t.TempDir(), "missing")
+	for _, path := range []string{"", missing} {
+		hook.Reset()
+		v, err := newHostKeyVerifier(path, "")
+		if err != nil {
+			t.Fatalf("newHostKeyVerifier() error = %v", err)
+		}
+		if entry := hook.LastEntry(); entry == nil || entry.Level != logrus.ErrorLevel {
+			t.Errorf("Expected a startup error for the missing known_hosts file %q", path)
+		}
+
+		remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}
+		if err := v.Verify("192.0.2.1:22", remote, testHostKey(t).PublicKey()); err == nil {
+			t.Errorf("Expected an error without a known_hosts file %q", path)
+		}
+	}
+
+	v, _ := newHostKeyVerifier(missing, config.SSHHostKeyStrict)
+	err := v.Verify("192.0.2.1:22", &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}, testHostKey(t).PublicKey())
+	if err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("Expected the error to name the missing file, got %v", err)
+	}
+
+	// Once the file exists no startup error is logged
+	hook.Reset()
+	writeKnownHosts(t, missing, map[string]ssh.PublicKey{"192.0.2.1:22": testHostKey(t).PublicKey()})
+	if _, err := newHostKeyVerifier(missing, config.SSHHostKeyStrict); err != nil {
+		t.Fatalf("newHostKeyVerifier() error = %v", err)
+	}
+	if len(hook.Entries) != 0 {
+		t.Errorf("Expected no log entries with a known_hosts file, got %d", len(hook.Entries))
+	}
+}
+
+func TestHostKeyVerifier_TOFU(t *testing.T) {
+	// The default file lives in a directory that may not exist yet
+	path := filepath.Join(t.TempDir(), "collector", "known_hosts")
+	first, second := testHostKey(t).PublicKey(), testHostKey(t).PublicKey()
+
+	v, err := newHostKeyVerifier(path, config.SSHHostKeyTOFU)
+	if err != nil {
+		t.Fatalf("newHostKeyVerifier() error = %v", err)
+	}
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}
+
+	if err := v.Verify("192.0.2.1:22", remote, first); err != nil {
+		t.Fatalf("Verify() of a new host error = %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || !strings.Contains(string(data), "192.0.2.1 ssh-ed25519 ") {
+		t.Errorf("Expected the key to be recorded, got %q, %v", data, err)
+	}
+
+	if err := v.Verify("192.0.2.1:22", remote, first); err != nil {
+		t.Errorf("Verify() of the trusted key error = %v", err)
+	}
+
+	var changed *HostKeyChangedError
+	if err := v.Verify("192.0.2.1:22", remote, second); !errors.As(err, &changed) {
+		t.Errorf("Expected a HostKeyChangedError after the key changed, got %v", err)
+	}
+}
+
+func TestHostKeyVerifier_Policies(t *testing.T) {
+	v, err := newHostKeyVerifier("", config.SSHHostKeyInsecure)
+	if err != nil {
+		t.Fatalf("newHostKeyVerifier() error = %v", err)
+	}
+	if err := v.Verify("192.0.2.1:22", &net.TCPAddr{}, testHostKey(t).PublicKey()); err != nil {
+		t.Errorf("Expected the insecure policy to accept any key, got %v", err)
+	}
+
+	if _, err := newHostKeyVerifier("", "trusting"); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+}
+
+func TestHostKeyVerifier_KnownAlgorithms(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	ecPub, err := ssh.NewPublicKey(&ecKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to convert key: %v", err)
+	}
+	writeKnownHosts(t, path, map[string]ssh.PublicKey{"192.0.2.1:22": ecPub})
+
+	v, err := newHostKeyVerifier(path, config.SSHHostKeyStrict)
+	if err != nil {
+		t.Fatalf("newHostKeyVerifier() error = %v", err)
+	}
+
+	if got := v.KnownAlgorithms("192.0.2.1:22"); len(got) != 1 || got[0] != ssh.KeyAlgoECDSA256 {
+		t.Errorf("KnownAlgorithms() = %v, want [%s]", got, ssh.KeyAlgoECDSA256)
+	}
+	if got := v.KnownAlgorithms("192.0.2.2:22"); got != nil {
+		t.Errorf("KnownAlgorithms() of an unknown host = %v, want nil", got)
+	}
+	if got := hostKeyAlgorithms(ssh.KeyAlgoRSA); len(got) != 3 || got[0] != ssh.KeyAlgoRSASHA512 {
+		t.Errorf("hostKeyAlgorithms(ssh-rsa) = %v", got)
+	}
+}
+
+func TestHostKeyVerifier_Handshake(t *testing.T) {
+	hostKey := testHostKey(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	serverConfig.AddHostKey(hostKey)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				ssh.NewServerConn(conn, serverConfig)
+			}()
+		}
+	}()
+
+	address := listener.Addr().String()
+	path := filepath.Join(t.TempDir(), "known_hosts")
+
+	dial := func(v *hostKeyVerifier) error {
+		client, err := ssh.Dial("tcp", address, &ssh.ClientConfig{
+			User:              "monitor",
+			Timeout:           5 * time.Second,
+			HostKeyCallback:   v.Verify,
+			HostKeyAlgorithms: v.KnownAlgorithms(address),
+		})
+		if err == nil {
+			client.Close()
+		}
+		return err
+	}
+
+	writeKnownHosts(t, path, map[string]ssh.PublicKey{address: hostKey.PublicKey()})
+	v, err := newHostKeyVerifier(path, config.SSHHostKeyStrict)
+	if err != nil {
+		t.Fatalf("newHostKeyVerifier() error = %v", err)
+	}
+	if err := dial(v); err != nil {
+		t.Errorf("Dial() with the known key error = %v", err)
+	}
+
+	// The changed-key error survives the SSH client's error wrapping
+	writeKnownHosts(t, path, map[string]ssh.PublicKey{address: testHostKey(t).PublicKey()})
+	later := time.Now().Add(time.Second)
+	os.Chtimes(path, later, later)
+	var changed *HostKeyChangedError
+	if err := dial(v); !errors.As(err, &changed) {
+		t.Errorf("Expected a HostKeyChangedError from Dial(), got %v", err)
+	}
+}
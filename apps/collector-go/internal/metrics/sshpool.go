@@ -0,0 +1,340 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshPoolKey identifies a pooled connection. Connections are only shared by
+// polls of the same address that log in with the same credentials.
+type sshPoolKey struct {
+	address    string
+	username   string
+	credential string // hash of the password or key file
+}
+
+// newSSHPoolKey creates the pool key of an address and set of credentials
+func newSSHPoolKey(address, username, password, keyFile string) sshPoolKey {
+	sum := sha256.Sum256([]byte(password + "\x00" + keyFile))
+	return sshPoolKey{address: address, username: username, credential: hex.EncodeToString(sum[:])}
+}
+
+// sshPool keeps authenticated SSH connections open between polls. Idle
+// connections are probed with keepalives and closed once they have been
+// unused for idleTimeout. A non-positive idleTimeout disables pooling.
+type sshPool struct {
+	keepalive   time.Duration
+	idleTimeout time.Duration
+
+	mu     sync.Mutex
+	conns  map[sshPoolKey]*pooledSSHConn
+	closed bool
+}
+
+// pooledSSHConn is an open connection with its last use
+type pooledSSHConn struct {
+	client   *ssh.Client
+	lastUsed time.Time
+	inUse    bool
+	done     chan struct{}
+}
+
+// newSSHPool creates a connection pool
+func newSSHPool(keepalive, idleTimeout time.Duration) *sshPool {
+	return &sshPool{
+		keepalive:   keepalive,
+		idleTimeout: idleTimeout,
+		conns:       make(map[sshPoolKey]*pooledSSHConn),
+	}
+}
+
+// enabled reports whether connections are kept between polls
+func (p *sshPool) enabled() bool {
+	return p.idleTimeout > 0
+}
+
+// acquire returns the pooled connection of key, or nil if there is none
+func (p *sshPool) acquire(key sshPoolKey) *ssh.Client {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pc, ok := p.conns[key]
+	if !ok || pc.inUse {
+		return nil
+	}
+	pc.inUse = true
+	return pc.client
+}
+
+// release returns a healthy connection to the pool, or closes it if pooling
+// is disabled or another connection for key was pooled meanwhile
+func (p *sshPool) release(key sshPoolKey, client *ssh.Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if pc, ok := p.conns[key]; ok && pc.client == client {
+		pc.inUse = false
+		pc.lastUsed = time.Now()
+		return
+	}
+	if !p.enabled() || p.closed || p.conns[key] != nil {
+		client.Close()
+		return
+	}
+
+	pc := &pooledSSHConn{client: client, lastUsed: time.Now(), done: make(chan struct{})}
+	p.conns[key] = pc
+	go p.maintain(key, pc)
+}
+
+// discard closes a connection that failed and removes it from the pool
+func (p *sshPool) discard(key sshPoolKey, client *ssh.Client) {
+	p.mu.Lock()
+	if pc, ok := p.conns[key]; ok && pc.client == client {
+		delete(p.conns, key)
+		close(pc.done)
+	}
+	p.mu.Unlock()
+
+	client.Close()
+}
+
+// maintain sends keepalives on an idle connection and closes it once it has
+// been idle for too long or stops answering
+func (p *sshPool) maintain(key sshPoolKey, pc *pooledSSHConn) {
+	interval := p.keepalive
+	if interval <= 0 || interval > p.idleTimeout {
+		interval = p.idleTimeout
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-pc.done:
+			return
+		case <-ticker.C:
+		}
+
+		p.mu.Lock()
+		idle := !pc.inUse && time.Since(pc.lastUsed) >= p.idleTimeout
+		p.mu.Unlock()
+		if idle {
+			logrus.WithField("address", key.address).Debug("Closing idle SSH connection")
+			p.discard(key, pc.client)
+			return
+		}
+
+		if p.keepalive > 0 {
+			if _, _, err := pc.client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+				logrus.WithError(err).WithField("address", key.address).Debug("SSH keepalive failed, dropping connection")
+				p.discard(key, pc.client)
+				return
+			}
+		}
+	}
+}
+
+// Close closes all pooled connections
+func (p *sshPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	for key, pc := range p.conns {
+		close(pc.done)
+		pc.client.Close()
+		delete(p.conns, key)
+	}
+	return nil
+}
+
+// dialSSH connects and authenticates to address. Both the TCP connect and
+// the handshake are abandoned when ctx is done.
+func dialSSH(ctx context.Context, address string, cfg *ssh.ClientConfig) (*ssh.Client, error) {
+	dialer := net.Dialer{Timeout: cfg.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bound the handshake by the configured timeout and the context
+	var deadline time.Time
+	if cfg.Timeout > 0 {
+		deadline = time.Now().Add(cfg.Timeout)
+	}
+	if d, ok := ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, address, cfg)
+	close(stop)
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+// runSSHCommand runs command in a new session and returns its standard
+// output. The session is closed when ctx is done. A non-zero exit status is
+// returned as an *ssh.ExitError together with the output.
+func runSSHCommand(ctx context.Context, client *ssh.Client, command string) (string, error) {
+	type result struct {
+		output string
+		err    error
+	}
+	results := make(chan result, 1)
+
+	var (
+		mu      sync.Mutex
+		session *ssh.Session
+		aborted bool
+	)
+	go func() {
+		s, err := client.NewSession()
+		if err != nil {
+			results <- result{err: fmt.Errorf("failed to create SSH session: %w", err)}
+			return
+		}
+		defer s.Close()
+
+		mu.Lock()
+		if aborted {
+			mu.Unlock()
+			return
+		}
+		session = s
+		mu.Unlock()
+
+		var stdout bytes.Buffer
+		s.Stdout = &stdout
+		err = s.Run(command)
+		results <- result{output: stdout.String(), err: err}
+	}()
+
+	select {
+	case r := <-results:
+		return r.output, r.err
+	case <-ctx.Done():
+		mu.Lock()
+		aborted = true
+		if session != nil {
+			session.Signal(ssh.SIGKILL)
+			session.Close()
+		}
+		mu.Unlock()
+		return "", ctx.Err()
+	}
+}
+
+// sshSectionMarker precedes the output of each command of a batch and
+// sshStatusMarker follows it with the command's exit status
+const (
+	sshSectionMarker = "__collector_section__"
+	sshStatusMarker  = "__collector_status__"
+)
+
+// sshCommand is a command whose output is collected as part of a batch
+type sshCommand struct {
+	name    string
+	command string
+}
+
+// sshResult is the trimmed output and exit status of a batched command. The
+// status is -1 if the batch was cut short before the command finished.
+type sshResult struct {
+	output string
+	status int
+}
+
+// runSSHBatch runs commands in a single session and returns the result of
+// each by name. Each command runs in its own subshell, so one failing
+// command does not affect the others; a missing entry means the batch was
+// cut short.
+func runSSHBatch(ctx context.Context, client *ssh.Client, commands []sshCommand) (map[string]sshResult, error) {
+	output, err := runSSHCommand(ctx, client, sshBatchScript(commands))
+	var exitErr *ssh.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, err
+	}
+	return splitSSHSections(output), nil
+}
+
+// sshBatchScript builds the shell script that runs commands as a batch. The
+// status marker is printed on a line of its own even when a command's
+// output does not end in a newline.
+func sshBatchScript(commands []sshCommand) string {
+	// Use the C locale so that number formats do not vary between devices
+	var script strings.Builder
+	script.WriteString("export LC_ALL=C\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(&script, "echo '%s %s'\n(%s) 2>/dev/null\nprintf '\\n%s %%d\\n' $?\n", sshSectionMarker, cmd.name, cmd.command, sshStatusMarker)
+	}
+	return script.String()
+}
+
+// splitSSHSections splits batch output into the result of each command
+func splitSSHSections(output string) map[string]sshResult {
+	sections := make(map[string]sshResult)
+
+	var name string
+	var section strings.Builder
+	status := -1
+	flush := func() {
+		if name != "" {
+			sections[name] = sshResult{output: strings.TrimSpace(section.String()), status: status}
+		}
+		section.Reset()
+		status = -1
+	}
+
+	for _, line := range strings.SplitAfter(output, "\n") {
+		if rest := strings.TrimPrefix(line, sshSectionMarker+" "); rest != line {
+			flush()
+			name = strings.TrimSpace(rest)
+			continue
+		}
+		if rest := strings.TrimPrefix(line, sshStatusMarker+" "); rest != line {
+			// Drop the newline printed before the status marker
+			output := strings.TrimSuffix(section.String(), "\n")
+			section.Reset()
+			section.WriteString(output)
+			if code, err := strconv.Atoi(strings.TrimSpace(rest)); err == nil {
+				status = code
+			}
+			continue
+		}
+		section.WriteString(line)
+	}
+	flush()
+
+	return sections
+}
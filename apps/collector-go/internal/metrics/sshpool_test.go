@@ -0,0 +1,272 @@
+package metrics
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"collector/internal/config"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// testSSHServer is an SSH server that answers every exec request with a
+// fixed output after an optional delay and counts handshakes
+type testSSHServer struct {
+	address    string
+	hostKey    ssh.Signer
+	output     string
+	delay      time.Duration
+	handshakes int32
+}
+
+func startTestSSHServer(t *testing.T, output string) *testSSHServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	s := &testSSHServer{address: listener.Addr().String(), hostKey: testHostKey(t), output: output}
+	serverConfig := &ssh.ServerConfig{
+		PasswordCallback: func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	serverConfig.AddHostKey(s.hostKey)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, serverConfig)
+		}
+	}()
+	return s
+}
+
+func (s *testSSHServer) serve(conn net.Conn, serverConfig *ssh.ServerConfig) {
+	defer conn.Close()
+	_, chans, reqs, err := ssh.NewServerConn(conn, serverConfig)
+	if err != nil {
+		return
+	}
+	atomic.AddInt32(&s.handshakes, 1)
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer channel.Close()
+			for req := range requests {
+				if req.Type != "exec" {
+					req.Reply(false, nil)
+					continue
+				}
+				req.Reply(true, nil)
+				select {
+				case <-time.After(s.delay):
+				case <-sessionClosed(requests):
+					return
+				}
+				channel.Write([]byte(s.output))
+				status := make([]byte, 4)
+				binary.BigEndian.PutUint32(status, 0)
+				channel.SendRequest("exit-status", false, status)
+				return
+			}
+		}()
+	}
+}
+
+// sessionClosed returns a channel that is closed once requests is drained,
+// which happens when the client closes the session
+func sessionClosed(requests <-chan *ssh.Request) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		for range requests {
+		}
+		close(done)
+	}()
+	return done
+}
+
+func (s *testSSHServer) collector(t *testing.T, idleTimeout time.Duration) *SSHCollector {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	writeKnownHosts(t, path, map[string]ssh.PublicKey{s.address: s.hostKey.PublicKey()})
+
+	_, port, _ := net.SplitHostPort(s.address)
+	portNumber, _ := strconv.Atoi(port)
+	c, err := NewSSHCollector(config.SSHConfig{
+		Username:       "monitor",
+		Password:       "secret",
+		Timeout:        5 * time.Second,
+		KnownHostsFile: path,
+		Port:           portNumber,
+		IdleTimeout:    idleTimeout,
+	})
+	if err != nil {
+		t.Fatalf("NewSSHCollector() error = %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+const testSSHBatchOutput = sshSectionMarker + " cpu\ncpu  100 0 50 1000 10 0 0 0 0 0\n" +
+	sshSectionMarker + " memory\nMemTotal: 2048 kB\nMemAvailable: 1024 kB\n" +
+	sshSectionMarker + " load\n0.10 0.20 0.30 1/100 42\n" +
+	sshSectionMarker + " disk\nFilesystem 1024-blocks Used Available Capacity Mounted on\n/dev/sda1 1000 400 600 40% /\n" +
+	sshSectionMarker + " network\n\n" +
+	sshSectionMarker + " uptime\n3600.5\n"
+
+func TestSSHCollector_ReusesConnection(t *testing.T) {
+	server := startTestSSHServer(t, testSSHBatchOutput)
+	c := server.collector(t, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		result, err := c.Collect(context.Background(), "127.0.0.1")
+		if err != nil {
+			t.Fatalf("Collect() error = %v", err)
+		}
+		if len(result) != 4 {
+			t.Errorf("Expected 4 metrics, got %d", len(result))
+		}
+	}
+	if got := atomic.LoadInt32(&server.handshakes); got != 1 {
+		t.Errorf("Expected one handshake for two polls, got %d", got)
+	}
+
+	// A closed pooled connection is replaced transparently
+	key := newSSHPoolKey(server.address, "monitor", "secret", "")
+	c.pool.acquire(key).Close()
+	c.pool.release(key, c.pool.conns[key].client)
+	if _, err := c.Collect(context.Background(), "127.0.0.1"); err != nil {
+		t.Fatalf("Collect() after the connection dropped error = %v", err)
+	}
+	if got := atomic.LoadInt32(&server.handshakes); got != 2 {
+		t.Errorf("Expected a reconnect, got %d handshakes", got)
+	}
+}
+
+func TestSSHCollector_PoolingDisabled(t *testing.T) {
+	server := startTestSSHServer(t, testSSHBatchOutput)
+	c := server.collector(t, 0)
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.Collect(context.Background(), "127.0.0.1"); err != nil {
+			t.Fatalf("Collect() error = %v", err)
+		}
+	}
+	if got := atomic.LoadInt32(&server.handshakes); got != 2 {
+		t.Errorf("Expected a handshake per poll, got %d", got)
+	}
+	if len(c.pool.conns) != 0 {
+		t.Errorf("Expected no pooled connections, got %d", len(c.pool.conns))
+	}
+}
+
+func TestSSHCollector_ContextCancel(t *testing.T) {
+	server := startTestSSHServer(t, testSSHBatchOutput)
+	server.delay = time.Minute
+	c := server.collector(t, time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := c.Collect(ctx, "127.0.0.1"); err == nil {
+		t.Fatal("Expected an error when the context is done")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Collect() returned after %v, expected it to stop on cancellation", elapsed)
+	}
+	if len(c.pool.conns) != 0 {
+		t.Error("Expected the interrupted connection not to be pooled")
+	}
+}
+
+func TestSSHPool_IdleEviction(t *testing.T) {
+	server := startTestSSHServer(t, testSSHBatchOutput)
+	c := server.collector(t, 50*time.Millisecond)
+
+	if _, err := c.Collect(context.Background(), "127.0.0.1"); err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.pool.mu.Lock()
+		n := len(c.pool.conns)
+		c.pool.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the idle connection to be closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSplitSSHSections(t *testing.T) {
+	got := splitSSHSections(testSSHBatchOutput[:len(testSSHBatchOutput)-len(sshSectionMarker+" uptime\n3600.5\n")])
+	want := map[string]string{
+		"cpu":     "cpu  100 0 50 1000 10 0 0 0 0 0",
+		"memory":  "MemTotal: 2048 kB\nMemAvailable: 1024 kB",
+		"load":    "0.10 0.20 0.30 1/100 42",
+		"disk":    "Filesystem 1024-blocks Used Available Capacity Mounted on\n/dev/sda1 1000 400 600 40% /",
+		"network": "",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("splitSSHSections() = %v, want %v", got, want)
+	}
+	for name, output := range want {
+		if got[name].output != output || got[name].status != -1 {
+			t.Errorf("Section %s = %+v, want output %q", name, got[name], output)
+		}
+	}
+	if _, ok := got["uptime"]; ok {
+		t.Error("Expected no section for a command that did not run")
+	}
+}
+
+func TestSSHBatchScript_OutputWithoutTrailingNewline(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+
+	script := sshBatchScript([]sshCommand{
+		{name: "value", command: "printf 42"},
+		{name: "lines", command: "printf 'a\\nb\\n'"},
+		{name: "failed", command: "printf partial; exit 3"},
+	})
+	output, err := exec.Command("sh", "-c", script).Output()
+	if err != nil {
+		t.Fatalf("Running the batch script failed: %v", err)
+	}
+
+	got := splitSSHSections(string(output))
+	want := map[string]sshResult{
+		"value":  {output: "42", status: 0},
+		"lines":  {output: "a\nb", status: 0},
+		"failed": {output: "partial", status: 3},
+	}
+	for name, result := range want {
+		if got[name] != result {
+			t.Errorf("Section %s = %+v, want %+v", name, got[name], result)
+		}
+	}
+}
@@ -0,0 +1,484 @@
+package metrics
+
+import (
+	"bufio"
+	"collector/internal/config"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ldapStartTLSRequest is an LDAPv3 ExtendedRequest (message ID 1) for the
+// StartTLS OID 1.3.6.1.4.1.1466.20037
+var ldapStartTLSRequest = append([]byte{
+	0x30, 0x1d, // LDAPMessage SEQUENCE
+	0x02, 0x01, 0x01, // messageID 1
+	0x77, 0x18, // [APPLICATION 23] ExtendedRequest
+	0x80, 0x16, // [0] requestName
+}, "1.3.6.1.4.1.1466.20037"...)
+
+// tlsVersionNames maps protocol versions to readable names
+var tlsVersionNames = map[uint16]string{
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+// inspectionCipherSuites offers every cipher suite Go implements, including
+// the insecure ones that are the only choice on some legacy devices
+var inspectionCipherSuites = func() []uint16 {
+	var ids []uint16
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		ids = append(ids, suite.ID)
+	}
+	return ids
+}()
+
+// TLSCollector checks TLS endpoints and reports certificate expiry, identity,
+// key strength, chain validity and the negotiated protocol and cipher
+type TLSCollector struct {
+	config config.TLSConfig
+	roots  *x509.CertPool
+}
+
+// NewTLSCollector creates a new TLSCollector
+func NewTLSCollector(cfg config.TLSConfig) (*TLSCollector, error) {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+
+	roots, err := loadCABundle(cfg.CABundle)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, target := range cfg.Targets {
+		if target.Port < 1 || target.Port > 65535 {
+			return nil, fmt.Errorf("TLS target %d: invalid port %d", i, target.Port)
+		}
+		switch target.StartTLS {
+		case "", "smtp", "imap", "ldap":
+		default:
+			return nil, fmt.Errorf("TLS target %d: unsupported STARTTLS protocol %q", i, target.StartTLS)
+		}
+	}
+
+	return &TLSCollector{config: cfg, roots: roots}, nil
+}
+
+// loadCABundle reads a PEM bundle of trusted roots. An empty path selects
+// the system roots.
+func loadCABundle(path string) (*x509.CertPool, error) {
+	if path == "" {
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load system CA pool: %w", err)
+		}
+		return roots, nil
+	}
+
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in CA bundle %s", path)
+	}
+	return roots, nil
+}
+
+// Collect checks every configured target against ipAddress
+func (c *TLSCollector) Collect(ctx context.Context, ipAddress string) ([]Metric, error) {
+	return c.collect(ctx, ipAddress, "", c.config.Targets)
+}
+
+// ForDevice returns a MetricCollector that checks the targets whose
+// selector matches the device, or nil when none does
+func (c *TLSCollector) ForDevice(deviceID, deviceType, hostname string, tags []string) MetricCollector {
+	var matched []config.TLSTarget
+	for _, target := range c.config.Targets {
+		if target.Matches(deviceID, deviceType, tags) {
+			matched = append(matched, target)
+		}
+	}
+	if len(matched) == 0 {
+		return nil
+	}
+	return &boundTLSCheck{collector: c, hostname: hostname, targets: matched}
+}
+
+// boundTLSCheck is a TLSCollector bound to the targets of one device
+type boundTLSCheck struct {
+	collector *TLSCollector
+	hostname  string
+	targets   []config.TLSTarget
+}
+
+// Collect checks the bound targets
+func (b *boundTLSCheck) Collect(ctx context.Context, ipAddress string) ([]Metric, error) {
+	return b.collector.collect(ctx, ipAddress, b.hostname, b.targets)
+}
+
+// collect checks targets concurrently. Failed handshakes are reported as
+// metrics with success=false rather than as an error.
+func (c *TLSCollector) collect(ctx context.Context, ipAddress, hostname string, targets []config.TLSTarget) ([]Metric, error) {
+	if ipAddress == "" {
+		return nil, fmt.Errorf("IP address cannot be empty")
+	}
+
+	metrics := make([]Metric, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target config.TLSTarget) {
+			defer wg.Done()
+			metrics[i] = c.check(ctx, target, ipAddress, hostname)
+		}(i, target)
+	}
+	wg.Wait()
+
+	return metrics, nil
+}
+
+// check performs the handshake with a single target and inspects the
+// certificate it presents
+func (c *TLSCollector) check(ctx context.Context, target config.TLSTarget, ipAddress, hostname string) Metric {
+	serverName := target.ServerName
+	if serverName == "" && net.ParseIP(hostname) == nil {
+		serverName = hostname
+	}
+
+	name := target.Name
+	if name == "" {
+		name = net.JoinHostPort(ipAddress, strconv.Itoa(target.Port))
+	}
+
+	metric := Metric{
+		Name:      "tls_certificate",
+		Value:     map[string]interface{}{},
+		Timestamp: time.Now(),
+		Tags: map[string]string{
+			"ip_address": ipAddress,
+			"target":     name,
+			"port":       strconv.Itoa(target.Port),
+		},
+	}
+	if target.StartTLS != "" {
+		metric.Tags["starttls"] = target.StartTLS
+	}
+	if serverName != "" {
+		metric.Tags["server_name"] = serverName
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	state, err := c.handshake(checkCtx, net.JoinHostPort(ipAddress, strconv.Itoa(target.Port)), target.StartTLS, serverName)
+	if err != nil {
+		metric.Value["success"] = false
+		metric.Value["error"] = err.Error()
+		return metric
+	}
+	metric.Value["handshake_ms"] = msSince(start)
+
+	c.inspect(metric.Value, state, serverName, time.Now())
+	metric.Value["success"] = true
+	return metric
+}
+
+// handshake connects to address, performs the STARTTLS exchange if needed
+// and completes a TLS handshake. Certificates are not verified here so that
+// expired or untrusted certificates can still be reported.
+func (c *TLSCollector) handshake(ctx context.Context, address, startTLS, serverName string) (tls.ConnectionState, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return tls.ConnectionState{}, fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// The handshake only inspects the endpoint, so TLS 1.0 and 1.1 are
+	// accepted as well; old switch and printer web UIs offer nothing newer
+	tlsConfig := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS10,
+		CipherSuites:       inspectionCipherSuites,
+	}
+
+	switch startTLS {
+	case "smtp":
+		return smtpStartTLS(conn, serverName, tlsConfig)
+	case "imap":
+		if err := imapStartTLS(conn); err != nil {
+			return tls.ConnectionState{}, err
+		}
+	case "ldap":
+		if err := ldapStartTLS(conn); err != nil {
+			return tls.ConnectionState{}, err
+		}
+	}
+
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return tls.ConnectionState{}, fmt.Errorf("TLS handshake failed: %w", err)
+	}
+	return tlsConn.ConnectionState(), nil
+}
+
+// smtpStartTLS upgrades an SMTP session with EHLO and STARTTLS
+func smtpStartTLS(conn net.Conn, serverName string, tlsConfig *tls.Config) (tls.ConnectionState, error) {
+	client, err := smtp.NewClient(conn, serverName)
+	if err != nil {
+		return tls.ConnectionState{}, fmt.Errorf("SMTP greeting failed: %w", err)
+	}
+	if err := client.StartTLS(tlsConfig); err != nil {
+		return tls.ConnectionState{}, fmt.Errorf("SMTP STARTTLS failed: %w", err)
+	}
+
+	state, _ := client.TLSConnectionState()
+	client.Quit()
+	return state, nil
+}
+
+// imapStartTLS reads the IMAP greeting and issues STARTTLS
+func imapStartTLS(conn net.Conn) error {
+	reader := bufio.NewReader(conn)
+
+	greeting, err := reader.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("IMAP greeting failed: %w", err)
+	}
+	if !strings.HasPrefix(greeting, "* OK") {
+		return fmt.Errorf("unexpected IMAP greeting: %s", strings.TrimSpace(greeting))
+	}
+
+	if _, err := io.WriteString(conn, "a1 STARTTLS\r\n"); err != nil {
+		return fmt.Errorf("IMAP STARTTLS failed: %w", err)
+	}
+
+	// Skip untagged responses until the tagged completion
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("IMAP STARTTLS failed: %w", err)
+		}
+		if strings.HasPrefix(line, "a1 ") {
+			if !strings.HasPrefix(line, "a1 OK") {
+				return fmt.Errorf("IMAP STARTTLS rejected: %s", strings.TrimSpace(line))
+			}
+			return nil
+		}
+	}
+}
+
+// ldapStartTLS sends the StartTLS extended operation and checks that the
+// server answered with resultCode success
+func ldapStartTLS(conn net.Conn) error {
+	if _, err := conn.Write(ldapStartTLSRequest); err != nil {
+		return fmt.Errorf("LDAP StartTLS failed: %w", err)
+	}
+
+	message, err := readBERElement(conn)
+	if err != nil {
+		return fmt.Errorf("LDAP StartTLS failed: %w", err)
+	}
+
+	code, err := ldapExtendedResultCode(message)
+	if err != nil {
+		return fmt.Errorf("LDAP StartTLS failed: %w", err)
+	}
+	if code != 0 {
+		return fmt.Errorf("LDAP StartTLS rejected with result code %d", code)
+	}
+	return nil
+}
+
+// readBERElement reads one complete BER element and returns its contents
+func readBERElement(r io.Reader) ([]byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	length := int(header[1])
+	if header[1]&0x80 != 0 {
+		n := int(header[1] & 0x7f)
+		if n == 0 || n > 4 {
+			return nil, fmt.Errorf("unsupported BER length encoding")
+		}
+		lengthBytes := make([]byte, n)
+		if _, err := io.ReadFull(r, lengthBytes); err != nil {
+			return nil, err
+		}
+		length = 0
+		for _, b := range lengthBytes {
+			length = length<<8 | int(b)
+		}
+	}
+	if length > 1<<16 {
+		return nil, fmt.Errorf("BER element too large: %d bytes", length)
+	}
+
+	contents := make([]byte, length)
+	if _, err := io.ReadFull(r, contents); err != nil {
+		return nil, err
+	}
+	return contents, nil
+}
+
+// ldapExtendedResultCode extracts the resultCode from the contents of an
+// LDAPMessage carrying an ExtendedResponse
+func ldapExtendedResultCode(message []byte) (int, error) {
+	// messageID INTEGER
+	tag, contents, rest, err := splitBER(message)
+	if err != nil || tag != 0x02 {
+		return 0, fmt.Errorf("malformed LDAP message ID")
+	}
+
+	// [APPLICATION 24] ExtendedResponse
+	tag, contents, _, err = splitBER(rest)
+	if err != nil || tag != 0x78 {
+		return 0, fmt.Errorf("expected an LDAP extended response")
+	}
+
+	// resultCode ENUMERATED
+	tag, contents, _, err = splitBER(contents)
+	if err != nil || tag != 0x0a || len(contents) == 0 {
+		return 0, fmt.Errorf("malformed LDAP result code")
+	}
+
+	code := 0
+	for _, b := range contents {
+		code = code<<8 | int(b)
+	}
+	return code, nil
+}
+
+// splitBER splits the first element off data, returning its tag, contents
+// and the remaining bytes. Only definite lengths are supported.
+func splitBER(data []byte) (byte, []byte, []byte, error) {
+	if len(data) < 2 {
+		return 0, nil, nil, io.ErrUnexpectedEOF
+	}
+
+	tag, length, offset := data[0], int(data[1]), 2
+	if data[1]&0x80 != 0 {
+		n := int(data[1] & 0x7f)
+		if n == 0 || n > 4 || len(data) < 2+n {
+			return 0, nil, nil, fmt.Errorf("unsupported BER length encoding")
+		}
+		length = 0
+		for _, b := range data[2 : 2+n] {
+			length = length<<8 | int(b)
+		}
+		offset += n
+	}
+	if len(data) < offset+length {
+		return 0, nil, nil, io.ErrUnexpectedEOF
+	}
+
+	return tag, data[offset : offset+length], data[offset+length:], nil
+}
+
+// inspect records the leaf certificate details, chain validity and the
+// negotiated parameters
+func (c *TLSCollector) inspect(value map[string]interface{}, state tls.ConnectionState, serverName string, now time.Time) {
+	value["tls_version"] = tlsVersionName(state.Version)
+	value["tls_version_weak"] = state.Version < tls.VersionTLS12
+	value["cipher_suite"] = tls.CipherSuiteName(state.CipherSuite)
+
+	if len(state.PeerCertificates) == 0 {
+		value["chain_valid"] = false
+		value["chain_error"] = "no certificate presented"
+		return
+	}
+
+	leaf := state.PeerCertificates[0]
+	daysToExpiry := leaf.NotAfter.Sub(now).Hours() / 24
+	value["days_to_expiry"] = daysToExpiry
+	value["not_before"] = leaf.NotBefore.Unix()
+	value["not_after"] = leaf.NotAfter.Unix()
+	value["expired"] = now.After(leaf.NotAfter)
+	value["not_yet_valid"] = now.Before(leaf.NotBefore)
+	value["expiring_soon"] = daysToExpiry < float64(c.config.ExpiryWarningDays)
+	value["issuer"] = leaf.Issuer.String()
+	value["subject"] = leaf.Subject.String()
+	value["sans"] = strings.Join(certificateSANs(leaf), ",")
+	value["serial"] = leaf.SerialNumber.Text(16)
+	value["chain_length"] = len(state.PeerCertificates)
+
+	keyType, keyBits := publicKeyInfo(leaf)
+	value["key_type"] = keyType
+	value["key_bits"] = keyBits
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         c.roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+	})
+	value["chain_valid"] = err == nil
+	if err != nil {
+		value["chain_error"] = err.Error()
+	}
+
+	if serverName != "" {
+		value["hostname_valid"] = leaf.VerifyHostname(serverName) == nil
+	}
+}
+
+// certificateSANs lists the DNS names and IP addresses in a certificate
+func certificateSANs(cert *x509.Certificate) []string {
+	sans := append([]string(nil), cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	return sans
+}
+
+// publicKeyInfo returns the key algorithm and size in bits
+func publicKeyInfo(cert *x509.Certificate) (string, int) {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return "RSA", key.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "ECDSA", key.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return "Ed25519", 256
+	default:
+		return cert.PublicKeyAlgorithm.String(), 0
+	}
+}
+
+// tlsVersionName returns a readable protocol version
+func tlsVersionName(version uint16) string {
+	if name, ok := tlsVersionNames[version]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04x", version)
+}
@@ -0,0 +1,387 @@
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"collector/internal/config"
+)
+
+// testPKI is a CA and a leaf certificate signed by it
+type testPKI struct {
+	caPEM []byte
+	leaf  tls.Certificate
+}
+
+// newTestPKI creates a CA and a leaf for localhost valid for validFor
+func newTestPKI(t *testing.T, validFor time.Duration) testPKI {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate CA key: %v", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Failed to create CA certificate: %v", err)
+	}
+	caCert, _ := x509.ParseCertificate(caDER)
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate leaf key: %v", err)
+	}
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(validFor),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, caCert, &leafKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Failed to create leaf certificate: %v", err)
+	}
+
+	return testPKI{
+		caPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		leaf:  tls.Certificate{Certificate: [][]byte{leafDER}, PrivateKey: leafKey},
+	}
+}
+
+// writeCABundle writes the CA to a temporary PEM file
+func (p testPKI) writeCABundle(t *testing.T) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, p.caPEM, 0o600); err != nil {
+		t.Fatalf("Failed to write CA bundle: %v", err)
+	}
+	return path
+}
+
+// serveTLS accepts connections, runs preamble on the plaintext connection
+// and then completes a TLS handshake. It returns the listening port.
+func serveTLS(t *testing.T, cert tls.Certificate, preamble func(net.Conn, *bufio.Reader) bool) int {
+	t.Helper()
+	return serveTLSConfig(t, &tls.Config{Certificates: []tls.Certificate{cert}}, preamble)
+}
+
+// serveTLSConfig is serveTLS with a custom server configuration
+func serveTLSConfig(t *testing.T, serverConfig *tls.Config, preamble func(net.Conn, *bufio.Reader) bool) int {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				if preamble != nil && !preamble(conn, reader) {
+					return
+				}
+				tlsConn := tls.Server(&bufferedConn{Conn: conn, reader: reader}, serverConfig)
+				if tlsConn.Handshake() != nil {
+					return
+				}
+				// Acknowledge commands sent over TLS, such as the EHLO
+				// that follows SMTP STARTTLS, until the client hangs up
+				tlsReader := bufio.NewReader(tlsConn)
+				for {
+					if _, err := tlsReader.ReadString('\n'); err != nil {
+						return
+					}
+					tlsConn.Write([]byte("250 OK\r\n"))
+				}
+			}(conn)
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// bufferedConn reads through a bufio.Reader so that no bytes buffered during
+// the plaintext preamble are lost
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) { return c.reader.Read(p) }
+
+func smtpPreamble(conn net.Conn, reader *bufio.Reader) bool {
+	conn.Write([]byte("220 mail.example.com ESMTP\r\n"))
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return false
+		}
+		switch {
+		case strings.HasPrefix(line, "EHLO"):
+			conn.Write([]byte("250-mail.example.com\r\n250 STARTTLS\r\n"))
+		case strings.HasPrefix(line, "STARTTLS"):
+			conn.Write([]byte("220 Ready to start TLS\r\n"))
+			return true
+		default:
+			conn.Write([]byte("500 unknown\r\n"))
+		}
+	}
+}
+
+func imapPreamble(conn net.Conn, reader *bufio.Reader) bool {
+	conn.Write([]byte("* OK IMAP4rev1 ready\r\n"))
+	line, err := reader.ReadString('\n')
+	if err != nil || line != "a1 STARTTLS\r\n" {
+		return false
+	}
+	conn.Write([]byte("a1 OK Begin TLS negotiation now\r\n"))
+	return true
+}
+
+func ldapPreamble(conn net.Conn, reader *bufio.Reader) bool {
+	if _, err := readBERElement(reader); err != nil {
+		return false
+	}
+	// ExtendedResponse with resultCode success, empty matchedDN and message
+	conn.Write([]byte{0x30, 0x0c, 0x02, 0x01, 0x01, 0x78, 0x07, 0x0a, 0x01, 0x00, 0x04, 0x00, 0x04, 0x00})
+	return true
+}
+
+func TestTLSCollector_Collect(t *testing.T) {
+	pki := newTestPKI(t, 10*24*time.Hour)
+	bundle := pki.writeCABundle(t)
+
+	tests := []struct {
+		name           string
+		preamble       func(net.Conn, *bufio.Reader) bool
+		startTLS       string
+		serverName     string
+		caBundle       string
+		wantChainValid bool
+		wantHostValid  interface{}
+	}{
+		{
+			name:           "implicit TLS trusted by bundle",
+			caBundle:       bundle,
+			serverName:     "localhost",
+			wantChainValid: true,
+			wantHostValid:  true,
+		},
+		{
+			name:          "untrusted by system roots",
+			serverName:    "other.example.com",
+			wantHostValid: false,
+		},
+		{
+			name:           "SMTP STARTTLS",
+			preamble:       smtpPreamble,
+			startTLS:       "smtp",
+			caBundle:       bundle,
+			wantChainValid: true,
+		},
+		{
+			name:           "IMAP STARTTLS",
+			preamble:       imapPreamble,
+			startTLS:       "imap",
+			caBundle:       bundle,
+			wantChainValid: true,
+		},
+		{
+			name:           "LDAP StartTLS",
+			preamble:       ldapPreamble,
+			startTLS:       "ldap",
+			caBundle:       bundle,
+			wantChainValid: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port := serveTLS(t, pki.leaf, tt.preamble)
+			c, err := NewTLSCollector(config.TLSConfig{
+				Timeout:           2 * time.Second,
+				CABundle:          tt.caBundle,
+				ExpiryWarningDays: 30,
+				Targets:           []config.TLSTarget{{Port: port, StartTLS: tt.startTLS, ServerName: tt.serverName}},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create TLS collector: %v", err)
+			}
+
+			metrics, err := c.Collect(context.Background(), "127.0.0.1")
+			if err != nil {
+				t.Fatalf("TLSCollector.Collect() error = %v", err)
+			}
+
+			value := metrics[0].Value
+			if value["success"] != true {
+				t.Fatalf("Check failed: %v", value["error"])
+			}
+			if value["chain_valid"] != tt.wantChainValid {
+				t.Errorf("chain_valid = %v, want %v (%v)", value["chain_valid"], tt.wantChainValid, value["chain_error"])
+			}
+			if value["hostname_valid"] != tt.wantHostValid {
+				t.Errorf("hostname_valid = %v, want %v", value["hostname_valid"], tt.wantHostValid)
+			}
+			if days := value["days_to_expiry"].(float64); days < 9.9 || days > 10 {
+				t.Errorf("days_to_expiry = %v, want about 10", days)
+			}
+			if value["expiring_soon"] != true || value["expired"] != false {
+				t.Errorf("expiring_soon = %v, expired = %v", value["expiring_soon"], value["expired"])
+			}
+			if value["key_type"] != "ECDSA" || value["key_bits"] != 256 {
+				t.Errorf("key = %v/%v, want ECDSA/256", value["key_type"], value["key_bits"])
+			}
+			if value["sans"] != "localhost,127.0.0.1" || value["issuer"] != "CN=Test CA" {
+				t.Errorf("sans = %v, issuer = %v", value["sans"], value["issuer"])
+			}
+			if value["tls_version"] != "TLS 1.3" || value["cipher_suite"] == "" {
+				t.Errorf("tls_version = %v, cipher_suite = %v", value["tls_version"], value["cipher_suite"])
+			}
+			if value["tls_version_weak"] != false {
+				t.Errorf("tls_version_weak = %v, want false", value["tls_version_weak"])
+			}
+		})
+	}
+}
+
+func TestTLSCollector_LegacyProtocol(t *testing.T) {
+	pki := newTestPKI(t, 10*24*time.Hour)
+	port := serveTLSConfig(t, &tls.Config{
+		Certificates: []tls.Certificate{pki.leaf},
+		MinVersion:   tls.VersionTLS10,
+		MaxVersion:   tls.VersionTLS10,
+	}, nil)
+
+	c, err := NewTLSCollector(config.TLSConfig{
+		Timeout: 2 * time.Second,
+		Targets: []config.TLSTarget{{Port: port}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create TLS collector: %v", err)
+	}
+
+	metrics, err := c.Collect(context.Background(), "127.0.0.1")
+	if err != nil {
+		t.Fatalf("TLSCollector.Collect() error = %v", err)
+	}
+
+	value := metrics[0].Value
+	if value["success"] != true {
+		t.Fatalf("Expected the certificate of a TLS 1.0 endpoint to be read, got %v", value["error"])
+	}
+	if value["tls_version"] != "TLS 1.0" || value["tls_version_weak"] != true {
+		t.Errorf("tls_version = %v, tls_version_weak = %v, want TLS 1.0 flagged weak", value["tls_version"], value["tls_version_weak"])
+	}
+	if value["sans"] != "localhost,127.0.0.1" {
+		t.Errorf("sans = %v", value["sans"])
+	}
+}
+
+func TestTLSCollector_ConnectionFailure(t *testing.T) {
+	c, err := NewTLSCollector(config.TLSConfig{Targets: []config.TLSTarget{{Port: 1}}})
+	if err != nil {
+		t.Fatalf("Failed to create TLS collector: %v", err)
+	}
+
+	metrics, err := c.Collect(context.Background(), "127.0.0.1")
+	if err != nil {
+		t.Fatalf("TLSCollector.Collect() error = %v", err)
+	}
+	if metrics[0].Value["success"] != false || metrics[0].Value["error"] == nil {
+		t.Errorf("Expected a failed check, got %v", metrics[0].Value)
+	}
+}
+
+func TestNewTLSCollector_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.TLSConfig
+	}{
+		{"missing CA bundle", config.TLSConfig{CABundle: "/nonexistent/ca.pem"}},
+		{"bad port", config.TLSConfig{Targets: []config.TLSTarget{{Port: 0}}}},
+		{"unknown STARTTLS", config.TLSConfig{Targets: []config.TLSTarget{{Port: 110, StartTLS: "pop3"}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewTLSCollector(tt.cfg); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestLDAPExtendedResultCode(t *testing.T) {
+	tests := []struct {
+		name    string
+		message []byte
+		want    int
+		wantErr bool
+	}{
+		{
+			name:    "success",
+			message: []byte{0x02, 0x01, 0x01, 0x78, 0x07, 0x0a, 0x01, 0x00, 0x04, 0x00, 0x04, 0x00},
+			want:    0,
+		},
+		{
+			name:    "protocol error",
+			message: []byte{0x02, 0x01, 0x01, 0x78, 0x07, 0x0a, 0x01, 0x02, 0x04, 0x00, 0x04, 0x00},
+			want:    2,
+		},
+		{
+			name:    "not an extended response",
+			message: []byte{0x02, 0x01, 0x01, 0x61, 0x07, 0x0a, 0x01, 0x00, 0x04, 0x00, 0x04, 0x00},
+			wantErr: true,
+		},
+		{
+			name:    "truncated",
+			message: []byte{0x02, 0x01},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ldapExtendedResultCode(tt.message)
+			if (err != nil) != tt.wantErr || (!tt.wantErr && got != tt.want) {
+				t.Errorf("ldapExtendedResultCode() = %d, %v; want %d, wantErr %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,320 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"collector/internal/config"
+
+	"github.com/Azure/go-ntlmssp"
+)
+
+// WS-Management namespaces, actions and URIs used for WQL enumeration
+const (
+	wsmanResourceURI  = "http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/*"
+	wsmanWQLDialect   = "http://schemas.microsoft.com/wbem/wsman/1/WQL"
+	wsmanEnumerate    = "http://schemas.xmlsoap.org/ws/2004/09/enumeration/Enumerate"
+	wsmanPull         = "http://schemas.xmlsoap.org/ws/2004/09/enumeration/Pull"
+	wsmanAnonymous    = "http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous"
+	wsmanMaxElements  = 100
+	wsmanEnvelopeSize = 512000
+)
+
+// wmiObject is a WMI instance returned by a WQL query, with its properties
+// in their text form. Null properties are absent.
+type wmiObject map[string]string
+
+// winrmFault is a SOAP fault returned by the WinRM service, such as an
+// invalid query or class. The service was reachable when one is returned.
+type winrmFault struct {
+	Code    string
+	Reason  string
+	Message string
+}
+
+// Error implements error
+func (f *winrmFault) Error() string {
+	msg := "WinRM fault " + f.Code + ": " + f.Reason
+	if f.Message != "" && f.Message != f.Reason {
+		msg += " (" + f.Message + ")"
+	}
+	return msg
+}
+
+// newWinRMTransport creates the HTTP transport for WinRM requests. NTLM
+// authentication is connection-oriented, so connections are kept alive.
+func newWinRMTransport(cfg config.WMIConfig) http.RoundTripper {
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSClientConfig:     &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify},
+		MaxIdleConnsPerHost: 2,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	if cfg.AuthMethod == config.WinRMAuthBasic {
+		return transport
+	}
+	// The negotiator turns the basic credentials of a request into an NTLM
+	// handshake when the service asks for one
+	return ntlmssp.Negotiator{RoundTripper: transport}
+}
+
+// winrmSession runs WQL queries against the WinRM service of one host
+type winrmSession struct {
+	client   *http.Client
+	endpoint string
+	username string
+	password string
+	timeout  time.Duration
+}
+
+// winrmEndpoint returns the WS-Management URL of a host
+func winrmEndpoint(ipAddress string, cfg config.WMIConfig) string {
+	scheme, port := "http", cfg.Port
+	if cfg.HTTPS {
+		scheme = "https"
+	}
+	if port == 0 {
+		port = 5985
+		if cfg.HTTPS {
+			port = 5986
+		}
+	}
+	return scheme + "://" + net.JoinHostPort(ipAddress, strconv.Itoa(port)) + "/wsman"
+}
+
+// Query runs a WQL query and returns every instance, pulling further
+// batches until the enumeration ends
+func (s *winrmSession) Query(ctx context.Context, wql string) ([]wmiObject, error) {
+	var query bytes.Buffer
+	if err := xml.EscapeText(&query, []byte(wql)); err != nil {
+		return nil, err
+	}
+	body := fmt.Sprintf(`<n:Enumerate><w:OptimizeEnumeration/><w:MaxElements>%d</w:MaxElements>`+
+		`<w:Filter Dialect="%s">%s</w:Filter></n:Enumerate>`, wsmanMaxElements, wsmanWQLDialect, query.String())
+
+	resp, err := s.send(ctx, wsmanEnumerate, body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Body.EnumerateResponse == nil {
+		return nil, fmt.Errorf("unexpected WinRM response to enumerate")
+	}
+
+	page := resp.Body.EnumerateResponse
+	objects := page.objects()
+	for page.EndOfSequence == nil {
+		if page.Context == "" {
+			return nil, fmt.Errorf("WinRM enumeration has no context")
+		}
+		var enumContext bytes.Buffer
+		xml.EscapeText(&enumContext, []byte(page.Context))
+		body := fmt.Sprintf(`<n:Pull><n:EnumerationContext>%s</n:EnumerationContext>`+
+			`<w:MaxElements>%d</w:MaxElements></n:Pull>`, enumContext.String(), wsmanMaxElements)
+
+		resp, err := s.send(ctx, wsmanPull, body)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Body.PullResponse == nil {
+			return nil, fmt.Errorf("unexpected WinRM response to pull")
+		}
+		page = resp.Body.PullResponse
+		objects = append(objects, page.objects()...)
+	}
+
+	return objects, nil
+}
+
+// send posts a WS-Management request and decodes the response envelope.
+// SOAP faults are returned as *winrmFault.
+func (s *winrmSession) send(ctx context.Context, action, body string) (*wsmanEnvelope, error) {
+	messageID, err := newMessageID()
+	if err != nil {
+		return nil, err
+	}
+
+	timeoutSeconds := int(s.timeout / time.Second)
+	if timeoutSeconds < 1 {
+		timeoutSeconds = 60
+	}
+	envelope := `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"` +
+		` xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing"` +
+		` xmlns:n="http://schemas.xmlsoap.org/ws/2004/09/enumeration"` +
+		` xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd">` +
+		`<s:Header>` +
+		`<a:To>` + s.endpoint + `</a:To>` +
+		`<w:ResourceURI s:mustUnderstand="true">` + wsmanResourceURI + `</w:ResourceURI>` +
+		`<a:ReplyTo><a:Address s:mustUnderstand="true">` + wsmanAnonymous + `</a:Address></a:ReplyTo>` +
+		`<a:Action s:mustUnderstand="true">` + action + `</a:Action>` +
+		`<w:MaxEnvelopeSize s:mustUnderstand="true">` + strconv.Itoa(wsmanEnvelopeSize) + `</w:MaxEnvelopeSize>` +
+		`<a:MessageID>uuid:` + messageID + `</a:MessageID>` +
+		`<w:Locale xml:lang="en-US" s:mustUnderstand="false"/>` +
+		`<w:OperationTimeout>PT` + strconv.Itoa(timeoutSeconds) + `S</w:OperationTimeout>` +
+		`</s:Header>` +
+		`<s:Body>` + body + `</s:Body>` +
+		`</s:Envelope>`
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, strings.NewReader(envelope))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/soap+xml;charset=UTF-8")
+	req.SetBasicAuth(s.username, s.password)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("WinRM request to %s failed: %w", s.endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 4*wsmanEnvelopeSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WinRM response: %w", err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return nil, fmt.Errorf("WinRM authentication to %s failed", s.endpoint)
+	case resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusInternalServerError:
+		return nil, fmt.Errorf("WinRM request to %s returned HTTP %d", s.endpoint, resp.StatusCode)
+	}
+
+	var decoded wsmanEnvelope
+	if err := xml.Unmarshal(data, &decoded); err != nil {
+		return nil, fmt.Errorf("failed to parse WinRM response: %w", err)
+	}
+	if f := decoded.Body.Fault; f != nil {
+		fault := &winrmFault{
+			Code:    f.Code.Subcode.Value,
+			Reason:  strings.TrimSpace(f.Reason.Text),
+			Message: strings.TrimSpace(f.Detail.WSManFault.Message),
+		}
+		if fault.Code == "" {
+			fault.Code = f.Code.Value
+		}
+		return nil, fault
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("WinRM request to %s returned HTTP %d", s.endpoint, resp.StatusCode)
+	}
+	return &decoded, nil
+}
+
+// newMessageID returns a random UUID for the WS-Addressing message ID
+func newMessageID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// isWinRMFault reports whether err is a fault returned by a reachable
+// WinRM service rather than a connection or authentication failure
+func isWinRMFault(err error) bool {
+	var fault *winrmFault
+	return errors.As(err, &fault)
+}
+
+// wsmanEnvelope is the part of a WS-Management response envelope the
+// collector reads
+type wsmanEnvelope struct {
+	Body struct {
+		Fault             *soapFault         `xml:"Fault"`
+		EnumerateResponse *enumerationResult `xml:"EnumerateResponse"`
+		PullResponse      *enumerationResult `xml:"PullResponse"`
+	} `xml:"Body"`
+}
+
+// soapFault is a SOAP 1.2 fault with the WS-Management fault detail
+type soapFault struct {
+	Code struct {
+		Value   string `xml:"Value"`
+		Subcode struct {
+			Value string `xml:"Value"`
+		} `xml:"Subcode"`
+	} `xml:"Code"`
+	Reason struct {
+		Text string `xml:"Text"`
+	} `xml:"Reason"`
+	Detail struct {
+		WSManFault struct {
+			Message string `xml:"Message"`
+		} `xml:"WSManFault"`
+	} `xml:"Detail"`
+}
+
+// enumerationResult is an enumerate or pull response
+type enumerationResult struct {
+	Context string `xml:"EnumerationContext"`
+	Items   struct {
+		Instances []xmlNode `xml:",any"`
+	} `xml:"Items"`
+	EndOfSequence *struct{} `xml:"EndOfSequence"`
+}
+
+// xmlNode is a generic XML element
+type xmlNode struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Content  string     `xml:",chardata"`
+	Children []xmlNode  `xml:",any"`
+}
+
+// text returns the text of the node and its descendants, which unwraps
+// values such as <cim:Datetime>
+func (n xmlNode) text() string {
+	if len(n.Children) == 0 {
+		return strings.TrimSpace(n.Content)
+	}
+	var parts []string
+	for _, child := range n.Children {
+		parts = append(parts, child.text())
+	}
+	return strings.Join(parts, "")
+}
+
+// isNil reports whether the node is marked xsi:nil
+func (n xmlNode) isNil() bool {
+	for _, attr := range n.Attrs {
+		if attr.Name.Local == "nil" && attr.Value == "true" {
+			return true
+		}
+	}
+	return false
+}
+
+// objects converts the items of a response into WMI instances. Each item
+// is either a class element or, for queries selecting properties, a
+// w:XmlFragment; either way its children are the properties.
+func (r *enumerationResult) objects() []wmiObject {
+	objects := make([]wmiObject, 0, len(r.Items.Instances))
+	for _, instance := range r.Items.Instances {
+		object := make(wmiObject, len(instance.Children))
+		for _, property := range instance.Children {
+			if property.isNil() {
+				continue
+			}
+			// Array properties repeat the element; the first value is kept
+			if _, ok := object[property.XMLName.Local]; !ok {
+				object[property.XMLName.Local] = property.text()
+			}
+		}
+		objects = append(objects, object)
+	}
+	return objects
+}
@@ -0,0 +1,408 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"collector/internal/config"
+)
+
+// fakeWinRM is a WinRM endpoint that answers WQL enumerations with canned
+// instances, paging them pageSize at a time
+type fakeWinRM struct {
+	t        *testing.T
+	auth     string
+	username string
+	password string
+	classes  map[string][]map[string]string
+	faults   map[string]bool
+	pageSize int
+
+	mu      sync.Mutex
+	pulls   int
+	queries []string
+}
+
+// fakeWinRMRequest is the part of a WS-Management request the fake reads
+type fakeWinRMRequest struct {
+	Header struct {
+		Action string `xml:"Action"`
+	} `xml:"Header"`
+	Body struct {
+		Enumerate struct {
+			Filter string `xml:"Filter"`
+		} `xml:"Enumerate"`
+		Pull struct {
+			EnumerationContext string `xml:"EnumerationContext"`
+		} `xml:"Pull"`
+	} `xml:"Body"`
+}
+
+var fakeWQLClass = regexp.MustCompile(`(?i)FROM\s+(\w+)`)
+
+func (f *fakeWinRM) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !f.authenticate(w, r) {
+		return
+	}
+
+	data, _ := ioutil.ReadAll(r.Body)
+	var req fakeWinRMRequest
+	if err := xml.Unmarshal(data, &req); err != nil {
+		f.t.Errorf("Fake WinRM received invalid XML: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var class string
+	offset := 0
+	switch req.Header.Action {
+	case wsmanEnumerate:
+		match := fakeWQLClass.FindStringSubmatch(req.Body.Enumerate.Filter)
+		if match == nil {
+			f.t.Errorf("Fake WinRM received query without class: %q", req.Body.Enumerate.Filter)
+			return
+		}
+		class = match[1]
+		f.mu.Lock()
+		f.queries = append(f.queries, req.Body.Enumerate.Filter)
+		f.mu.Unlock()
+	case wsmanPull:
+		parts := strings.SplitN(req.Body.Pull.EnumerationContext, "/", 2)
+		class = parts[0]
+		offset, _ = strconv.Atoi(parts[1])
+		f.mu.Lock()
+		f.pulls++
+		f.mu.Unlock()
+	default:
+		f.t.Errorf("Fake WinRM received unexpected action %q", req.Header.Action)
+		return
+	}
+
+	f.mu.Lock()
+	fault, instances := f.faults[class], f.classes[class]
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/soap+xml;charset=UTF-8")
+	if fault {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd">`+
+			`<s:Body><s:Fault><s:Code><s:Value>s:Sender</s:Value><s:Subcode><s:Value>w:InvalidSelectors</s:Value></s:Subcode></s:Code>`+
+			`<s:Reason><s:Text xml:lang="en-US">The WS-Management service cannot process the request.</s:Text></s:Reason>`+
+			`<s:Detail><f:WSManFault xmlns:f="http://schemas.microsoft.com/wbem/wsman/1/wsmanfault"><f:Message>Invalid class %s</f:Message></f:WSManFault></s:Detail>`+
+			`</s:Fault></s:Body></s:Envelope>`, class)
+		return
+	}
+
+	end := len(instances)
+	if f.pageSize > 0 && offset+f.pageSize < end {
+		end = offset + f.pageSize
+	}
+
+	var items strings.Builder
+	for _, instance := range instances[offset:end] {
+		items.WriteString("<w:XmlFragment>")
+		for name, value := range instance {
+			if value == "" {
+				fmt.Fprintf(&items, `<%s xsi:nil="true"/>`, name)
+			} else {
+				fmt.Fprintf(&items, "<%s>%s</%s>", name, value, name)
+			}
+		}
+		items.WriteString("</w:XmlFragment>")
+	}
+	tail := "<w:EndOfSequence/>"
+	if end < len(instances) {
+		tail = fmt.Sprintf("<n:EnumerationContext>%s/%d</n:EnumerationContext>", class, end)
+	}
+
+	response := "EnumerateResponse"
+	if req.Header.Action == wsmanPull {
+		response = "PullResponse"
+	}
+	fmt.Fprintf(w, `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:n="http://schemas.xmlsoap.org/ws/2004/09/enumeration"`+
+		` xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:cim="http://schemas.dmtf.org/wbem/wscim/1/common">`+
+		`<s:Body><n:%s><w:Items>%s</w:Items>%s</n:%s></s:Body></s:Envelope>`, response, items.String(), tail, response)
+}
+
+// setClass replaces the instances of a class
+func (f *fakeWinRM) setClass(class string, instances ...map[string]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.classes[class] = instances
+}
+
+// setFault makes queries for a class fail with a SOAP fault
+func (f *fakeWinRM) setFault(class string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults[class] = true
+}
+
+// authenticate checks basic credentials or runs a simplified NTLM exchange
+// that issues a challenge and accepts an authenticate message for the
+// expected user
+func (f *fakeWinRM) authenticate(w http.ResponseWriter, r *http.Request) bool {
+	if f.auth == config.WinRMAuthBasic {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != f.username || password != f.password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="WSMAN"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return false
+		}
+		return true
+	}
+
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Negotiate ") {
+		w.Header().Set("WWW-Authenticate", "Negotiate")
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+	message, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(header, "Negotiate "))
+	if err != nil || len(message) < 12 || string(message[:8]) != "NTLMSSP\x00" {
+		w.WriteHeader(http.StatusBadRequest)
+		return false
+	}
+
+	switch binary.LittleEndian.Uint32(message[8:12]) {
+	case 1:
+		w.Header().Set("WWW-Authenticate", "Negotiate "+base64.StdEncoding.EncodeToString(ntlmChallenge()))
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	case 3:
+		if !bytes.Contains(message, utf16Bytes(f.username)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return false
+		}
+		return true
+	}
+	w.WriteHeader(http.StatusBadRequest)
+	return false
+}
+
+// ntlmChallenge builds an NTLM challenge message with an empty target info
+func ntlmChallenge() []byte {
+	msg := make([]byte, 52)
+	copy(msg, "NTLMSSP\x00")
+	binary.LittleEndian.PutUint32(msg[8:], 2)
+	// Unicode, NTLM and target info
+	binary.LittleEndian.PutUint32(msg[20:], 0x00000001|0x00000200|0x00800000)
+	copy(msg[24:32], "\x01\x23\x45\x67\x89\xab\xcd\xef")
+	binary.LittleEndian.PutUint16(msg[40:], 4)
+	binary.LittleEndian.PutUint16(msg[42:], 4)
+	binary.LittleEndian.PutUint32(msg[44:], 48)
+	return msg
+}
+
+// utf16Bytes encodes s as little-endian UTF-16, as NTLM does
+func utf16Bytes(s string) []byte {
+	var b []byte
+	for _, r := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(r), byte(r>>8))
+	}
+	return b
+}
+
+// newFakeWinRM starts a fake WinRM endpoint with a typical Windows host
+func newFakeWinRM(t *testing.T, auth string) (*fakeWinRM, config.WMIConfig) {
+	t.Helper()
+	fake := &fakeWinRM{
+		t:        t,
+		auth:     auth,
+		username: "monitor",
+		password: "secret",
+		classes: map[string][]map[string]string{
+			"Win32_Processor":                 {{"LoadPercentage": "20"}, {"LoadPercentage": "40"}, {"LoadPercentage": ""}},
+			"Win32_ComputerSystem":            {{"TotalPhysicalMemory": "17179869184"}},
+			"Win32_PerfRawData_PerfOS_Memory": {{"AvailableBytes": "4294967296"}},
+			"Win32_LogicalDisk": {
+				{"DeviceID": "C:", "Size": "107374182400", "FreeSpace": "26843545600"},
+				{"DeviceID": "D:", "Size": "53687091200", "FreeSpace": "53687091200"},
+			},
+			"Win32_PerfRawData_Tcpip_NetworkInterface": {
+				{"Name": "Intel[R] Ethernet", "BytesReceivedPerSec": "123456789", "BytesSentPerSec": "98765"},
+			},
+			"Win32_OperatingSystem": {{"LastBootUpTime": "<cim:Datetime>" + time.Now().Add(-2*time.Hour).UTC().Format(time.RFC3339) + "</cim:Datetime>"}},
+		},
+		faults: map[string]bool{},
+	}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	portNumber, _ := strconv.Atoi(port)
+	return fake, config.WMIConfig{
+		Username:   fake.username,
+		Password:   fake.password,
+		Timeout:    5 * time.Second,
+		Port:       portNumber,
+		AuthMethod: auth,
+	}
+}
+
+func TestWMICollector_WinRM(t *testing.T) {
+	for _, auth := range []string{config.WinRMAuthBasic, config.WinRMAuthNTLM} {
+		t.Run(auth, func(t *testing.T) {
+			_, cfg := newFakeWinRM(t, auth)
+			c, err := NewWMICollector(cfg)
+			if err != nil {
+				t.Fatalf("NewWMICollector() error = %v", err)
+			}
+
+			result, err := c.Collect(context.Background(), "127.0.0.1")
+			if err != nil {
+				t.Fatalf("Collect() error = %v", err)
+			}
+
+			byName := make(map[string][]Metric)
+			for _, m := range result {
+				byName[m.Name] = append(byName[m.Name], m)
+			}
+			if got := byName["cpu_utilization"]; len(got) != 1 || !approx(got[0].Value["cpu_percent"], 30) {
+				t.Errorf("Unexpected CPU metrics %+v", got)
+			}
+			if got := byName["memory_utilization"]; len(got) != 1 || !approx(got[0].Value["memory_percent"], 75) || !approx(got[0].Value["memory_total"], 16384) {
+				t.Errorf("Unexpected memory metrics %+v", got)
+			}
+			if got := byName["disk_utilization"]; len(got) != 2 || got[0].Tags["drive"] != "C:" || !approx(got[0].Value["disk_percent"], 75) {
+				t.Errorf("Unexpected disk metrics %+v", got)
+			}
+			if got := byName["network_traffic"]; len(got) != 1 || got[0].Tags["interface"] != "Intel[R] Ethernet" || !approx(got[0].Value["bytes_in"], 123456789) {
+				t.Errorf("Unexpected network metrics %+v", got)
+			}
+			if got := byName["system_uptime"]; len(got) != 1 || got[0].Value["uptime_seconds"].(float64) < 7190 {
+				t.Errorf("Unexpected uptime metrics %+v", got)
+			}
+		})
+	}
+}
+
+func TestWMICollector_WinRMFailures(t *testing.T) {
+	fake, cfg := newFakeWinRM(t, config.WinRMAuthBasic)
+
+	// A fault for one class leaves the other metrics intact
+	fake.setFault("Win32_PerfRawData_Tcpip_NetworkInterface")
+	c, err := NewWMICollector(cfg)
+	if err != nil {
+		t.Fatalf("NewWMICollector() error = %v", err)
+	}
+	result, err := c.Collect(context.Background(), "127.0.0.1")
+	if err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	for _, m := range result {
+		if m.Name == "network_traffic" {
+			t.Error("Expected no network metrics after a fault")
+		}
+	}
+
+	// Bad credentials fail the whole collection
+	cfg.Password = "wrong"
+	c, _ = NewWMICollector(cfg)
+	if _, err := c.Collect(context.Background(), "127.0.0.1"); err == nil || !strings.Contains(err.Error(), "authentication") {
+		t.Errorf("Expected an authentication error, got %v", err)
+	}
+
+	if _, err := NewWMICollector(config.WMIConfig{AuthMethod: "kerberos"}); err == nil {
+		t.Error("Expected an error for an unsupported auth method")
+	}
+}
+
+func TestWinRMSession_Pull(t *testing.T) {
+	fake, cfg := newFakeWinRM(t, config.WinRMAuthBasic)
+	fake.pageSize = 1
+	fake.setClass("Win32_LogicalDisk", append(fake.classes["Win32_LogicalDisk"],
+		map[string]string{"DeviceID": "E:", "Size": "1000", "FreeSpace": "500"})...)
+
+	c, err := NewWMICollector(cfg)
+	if err != nil {
+		t.Fatalf("NewWMICollector() error = %v", err)
+	}
+	session := &winrmSession{client: c.client, endpoint: winrmEndpoint("127.0.0.1", cfg), username: cfg.Username, password: cfg.Password}
+
+	disks, err := session.Query(context.Background(), "SELECT Size, FreeSpace, DeviceID FROM Win32_LogicalDisk WHERE DriveType = 3")
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if len(disks) != 3 || disks[2]["DeviceID"] != "E:" {
+		t.Errorf("Expected 3 disks across pages, got %v", disks)
+	}
+	if fake.pulls != 2 {
+		t.Errorf("Expected 2 pulls, got %d", fake.pulls)
+	}
+
+	fake.setFault("Win32_Service")
+	_, err = session.Query(context.Background(), "SELECT Name FROM Win32_Service")
+	if !isWinRMFault(err) || !strings.Contains(err.Error(), "Invalid class Win32_Service") {
+		t.Errorf("Expected a WinRM fault, got %v", err)
+	}
+}
+
+func TestEnumerationResult_Objects(t *testing.T) {
+	// Queries for whole instances return class elements
+	response := `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:n="http://schemas.xmlsoap.org/ws/2004/09/enumeration" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+<s:Body><n:EnumerateResponse><w:Items>
+<p:Win32_NetworkAdapterConfiguration xmlns:p="http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/Win32_NetworkAdapterConfiguration">
+<p:Description>vmxnet3</p:Description>
+<p:DNSDomain xsi:nil="true"/>
+<p:IPAddress>10.0.0.5</p:IPAddress>
+<p:IPAddress>fe80::1</p:IPAddress>
+</p:Win32_NetworkAdapterConfiguration>
+</w:Items><w:EndOfSequence/></n:EnumerateResponse></s:Body></s:Envelope>`
+
+	var envelope wsmanEnvelope
+	if err := xml.Unmarshal([]byte(response), &envelope); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	objects := envelope.Body.EnumerateResponse.objects()
+	if len(objects) != 1 {
+		t.Fatalf("Expected 1 object, got %d", len(objects))
+	}
+	if objects[0]["Description"] != "vmxnet3" || objects[0]["IPAddress"] != "10.0.0.5" {
+		t.Errorf("Unexpected object %v", objects[0])
+	}
+	if _, ok := objects[0]["DNSDomain"]; ok {
+		t.Error("Expected nil properties to be absent")
+	}
+}
+
+func TestParseWMIDatetime(t *testing.T) {
+	want := time.Date(2024, 4, 10, 15, 0, 0, 500000000, time.UTC)
+	tests := []struct {
+		value   string
+		wantErr bool
+	}{
+		{value: "2024-04-10T08:00:00.5-07:00"},
+		{value: "2024-04-10T15:00:00.5Z"},
+		{value: "20240410080000.500000-420"},
+		{value: "20240410170000.500000+120"},
+		{value: "yesterday", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseWMIDatetime(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseWMIDatetime() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !got.Equal(want) {
+				t.Errorf("parseWMIDatetime() = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -3,90 +3,174 @@ package metrics
 import (
 	"context"
 	"collector/internal/config"
+	"collector/internal/secrets"
 	"fmt"
 	"log"
+	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
-
-	"github.com/go-ole/go-ole"
-	"github.com/go-ole/go-ole/oleutil"
 )
 
-// WMICollector implements the MetricCollector interface for WMI-based metric collection
+// WMICollector implements the MetricCollector interface for WMI-based metric
+// collection. Queries are sent over WinRM, so Windows hosts can be monitored
+// from any platform.
 type WMICollector struct {
-	config config.WMIConfig
+	config  config.WMIConfig
+	client  *http.Client
+	secrets *secrets.Resolver
+
+	// Where each event log was last counted up to, and when marks of
+	// checks no longer run were last removed
+	eventMu     sync.Mutex
+	eventMarks  map[string]eventMark
+	marksPruned time.Time
 }
 
 // NewWMICollector creates a new WMICollector
 func NewWMICollector(cfg config.WMIConfig) (*WMICollector, error) {
-	return &WMICollector{config: cfg}, nil
+	switch cfg.AuthMethod {
+	case "", config.WinRMAuthNTLM, config.WinRMAuthBasic:
+	default:
+		return nil, fmt.Errorf("unsupported WinRM auth method %q", cfg.AuthMethod)
+	}
+
+	return &WMICollector{
+		config:     cfg,
+		client:     &http.Client{Transport: newWinRMTransport(cfg), Timeout: cfg.Timeout},
+		eventMarks: make(map[string]eventMark),
+	}, nil
 }
 
 // Collect performs WMI metric collection for the given IP address
 func (c *WMICollector) Collect(ctx context.Context, ipAddress string) ([]Metric, error) {
-	// Initialize OLE
-	err := ole.CoInitialize(0)
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize OLE: %w", err)
+	return c.collect(ctx, ipAddress, c.credentials(), wmiChecks{})
+}
+
+// credentials returns the globally configured WMI credentials
+func (c *WMICollector) credentials() config.WMICredentials {
+	return config.WMICredentials{
+		Username: c.config.Username,
+		Password: c.config.Password,
 	}
-	defer ole.CoUninitialize()
+}
 
-	// Connect to WMI service
-	wmiService, err := c.connectToWMI(ipAddress)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to WMI service: %w", err)
+// ForDevice returns a MetricCollector that also runs the service, event log
+// and counter checks whose selector matches the device
+func (c *WMICollector) ForDevice(deviceID, deviceType, hostname string, tags []string) MetricCollector {
+	checks := matchWMIChecks(c.config, deviceID, deviceType, tags)
+	if checks.empty() {
+		return c
 	}
-	defer wmiService.Release()
+	return &boundWMI{collector: c, creds: c.credentials(), checks: checks}
+}
 
-	var metrics []Metric
-	var errors []string
-	timestamp := time.Now()
+// UseSecrets resolves usernames and passwords given as secret references
+// with r
+func (c *WMICollector) UseSecrets(r *secrets.Resolver) {
+	c.secrets = r
+}
 
-	// Collect CPU metrics
-	cpuMetrics, err := c.collectCPUMetrics(wmiService, timestamp)
-	if err != nil {
-		errors = append(errors, fmt.Sprintf("CPU metrics: %v", err))
-		log.Printf("WMI collector failed to collect CPU metrics from %s: %v", ipAddress, err)
-	} else {
-		metrics = append(metrics, cpuMetrics...)
+// WithCredentials returns a MetricCollector that connects with the WMI
+// credentials of a profile, or nil if the profile has none
+func (c *WMICollector) WithCredentials(profile config.CredentialProfile) MetricCollector {
+	if !profile.WMI.Configured() {
+		return nil
 	}
+	return &boundWMI{collector: c, creds: profile.WMI}
+}
 
-	// Collect memory metrics
-	memMetrics, err := c.collectMemoryMetrics(wmiService, timestamp)
-	if err != nil {
-		errors = append(errors, fmt.Sprintf("Memory metrics: %v", err))
-		log.Printf("WMI collector failed to collect memory metrics from %s: %v", ipAddress, err)
-	} else {
-		metrics = append(metrics, memMetrics...)
+// boundWMI is a WMICollector bound to one set of credentials and the checks
+// of a device
+type boundWMI struct {
+	collector *WMICollector
+	creds     config.WMICredentials
+	checks    wmiChecks
+}
+
+// Collect collects with the bound credentials
+func (b *boundWMI) Collect(ctx context.Context, ipAddress string) ([]Metric, error) {
+	return b.collector.collect(ctx, ipAddress, b.creds, b.checks)
+}
+
+// WithCredentials returns a MetricCollector that runs the bound checks with
+// the WMI credentials of a profile, or nil if the profile has none
+func (b *boundWMI) WithCredentials(profile config.CredentialProfile) MetricCollector {
+	if !profile.WMI.Configured() {
+		return nil
 	}
+	return &boundWMI{collector: b.collector, creds: profile.WMI, checks: b.checks}
+}
 
-	// Collect disk metrics
-	diskMetrics, err := c.collectDiskMetrics(wmiService, timestamp)
-	if err != nil {
-		errors = append(errors, fmt.Sprintf("Disk metrics: %v", err))
-		log.Printf("WMI collector failed to collect disk metrics from %s: %v", ipAddress, err)
-	} else {
-		metrics = append(metrics, diskMetrics...)
+// collect performs WMI metric collection with the given credentials,
+// running the given checks in the same session
+func (c *WMICollector) collect(ctx context.Context, ipAddress string, creds config.WMICredentials, checks wmiChecks) ([]Metric, error) {
+	// Validate input
+	if ipAddress == "" {
+		return nil, fmt.Errorf("IP address cannot be empty")
 	}
 
-	// Collect network metrics
-	netMetrics, err := c.collectNetworkMetrics(wmiService, timestamp)
-	if err != nil {
-		errors = append(errors, fmt.Sprintf("Network metrics: %v", err))
-		log.Printf("WMI collector failed to collect network metrics from %s: %v", ipAddress, err)
-	} else {
-		metrics = append(metrics, netMetrics...)
+	var err error
+	if creds.Username, err = c.secrets.Resolve(ctx, creds.Username); err != nil {
+		return nil, err
+	}
+	if creds.Password, err = c.secrets.Resolve(ctx, creds.Password); err != nil {
+		return nil, err
 	}
 
-	// Collect system uptime
-	uptimeMetrics, err := c.collectUptimeMetrics(wmiService, timestamp)
-	if err != nil {
-		errors = append(errors, fmt.Sprintf("Uptime metrics: %v", err))
-		log.Printf("WMI collector failed to collect uptime metrics from %s: %v", ipAddress, err)
-	} else {
-		metrics = append(metrics, uptimeMetrics...)
+	session := &winrmSession{
+		client:   c.client,
+		endpoint: winrmEndpoint(ipAddress, c.config),
+		username: creds.Username,
+		password: creds.Password,
+		timeout:  c.config.Timeout,
 	}
 
+	var metrics []Metric
+	var errors []string
+	timestamp := time.Now()
+
+	collectors := []wmiCollection{
+		{"CPU", "CPU", c.collectCPUMetrics},
+		{"memory", "Memory", c.collectMemoryMetrics},
+		{"disk", "Disk", c.collectDiskMetrics},
+		{"network", "Network", c.collectNetworkMetrics},
+		{"uptime", "Uptime", c.collectUptimeMetrics},
+	}
+	if len(checks.services) > 0 {
+		collectors = append(collectors, wmiCollection{"service", "Service", func(ctx context.Context, session *winrmSession, timestamp time.Time) ([]Metric, error) {
+			return c.collectServiceMetrics(ctx, session, checks.services, timestamp)
+		}})
+	}
+	if len(checks.eventLogs) > 0 {
+		collectors = append(collectors, wmiCollection{"event log", "Event log", func(ctx context.Context, session *winrmSession, timestamp time.Time) ([]Metric, error) {
+			return c.collectEventLogMetrics(ctx, session, ipAddress, checks.eventLogs, timestamp)
+		}})
+	}
+	for _, counter := range checks.counters {
+		counter := counter
+		collectors = append(collectors, wmiCollection{counter.Class, counter.Class, func(ctx context.Context, session *winrmSession, timestamp time.Time) ([]Metric, error) {
+			return c.collectCounterMetrics(ctx, session, counter, timestamp)
+		}})
+	}
+	for _, collector := range collectors {
+		collected, err := collector.collect(ctx, session, timestamp)
+		if err != nil {
+			// Only a fault means the service answered; anything else, such
+			// as a refused connection or failed login, affects every query
+			if !isWinRMFault(err) {
+				return nil, fmt.Errorf("failed to connect to WMI service: %w", err)
+			}
+			errors = append(errors, fmt.Sprintf("%s metrics: %v", collector.label, err))
+			log.Printf("WMI collector failed to collect %s metrics from %s: %v", collector.name, ipAddress, err)
+			continue
+		}
+		metrics = append(metrics, collected...)
+	}
+
+	c.pruneEventMarks(timestamp)
+
 	// Return metrics even if some collection failed, but log errors
 	if len(errors) > 0 {
 		log.Printf("WMI collector completed with %d errors for %s: %v", len(errors), ipAddress, errors)
@@ -100,87 +184,34 @@ func (c *WMICollector) Collect(ctx context.Context, ipAddress string) ([]Metric,
 	return metrics, nil
 }
 
-// connectToWMI establishes a connection to the WMI service
-func (c *WMICollector) connectToWMI(ipAddress string) (*ole.IDispatch, error) {
-	// Create WMI locator
-	unknown, err := oleutil.CreateObject("WbemScripting.SWbemLocator")
-	if err != nil {
-		return nil, fmt.Errorf("failed to create WMI locator: %w", err)
-	}
-	defer unknown.Release()
-
-	wmiLocator, err := unknown.QueryInterface(ole.IID_IDispatch)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query WMI locator interface: %w", err)
-	}
-	defer wmiLocator.Release()
-
-	// Connect to WMI service
-	serviceRaw, err := oleutil.CallMethod(wmiLocator, "ConnectServer", ipAddress, "root\\cimv2", c.config.Username, c.config.Password)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to WMI service: %w", err)
-	}
-
-	service := serviceRaw.ToIDispatch()
-	return service, nil
+// wmiCollection is a group of WMI queries that succeeds or fails together
+type wmiCollection struct {
+	name    string
+	label   string
+	collect func(context.Context, *winrmSession, time.Time) ([]Metric, error)
 }
 
-// executeWMIQuery executes a WMI query and returns the result set
-func (c *WMICollector) executeWMIQuery(service *ole.IDispatch, query string) (*ole.IDispatch, error) {
-	resultRaw, err := oleutil.CallMethod(service, "ExecQuery", query)
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute WMI query '%s': %w", query, err)
-	}
-
-	result := resultRaw.ToIDispatch()
-	return result, nil
+// wmiFloat parses a numeric property, which WMI also returns for 64-bit
+// integers as text
+func wmiFloat(object wmiObject, property string) (float64, bool) {
+	v, err := strconv.ParseFloat(object[property], 64)
+	return v, err == nil
 }
 
 // collectCPUMetrics collects CPU utilization metrics
-func (c *WMICollector) collectCPUMetrics(service *ole.IDispatch, timestamp time.Time) ([]Metric, error) {
-	query := "SELECT LoadPercentage FROM Win32_Processor"
-	result, err := c.executeWMIQuery(service, query)
+func (c *WMICollector) collectCPUMetrics(ctx context.Context, session *winrmSession, timestamp time.Time) ([]Metric, error) {
+	processors, err := session.Query(ctx, "SELECT LoadPercentage FROM Win32_Processor")
 	if err != nil {
 		return nil, fmt.Errorf("failed to get CPU metrics: %w", err)
 	}
-	defer result.Release()
-
-	// Get enumerator
-	enumRaw, err := oleutil.CallMethod(result, "_NewEnum")
-	if err != nil {
-		return nil, fmt.Errorf("failed to get CPU result enumerator: %w", err)
-	}
-	defer enumRaw.Clear()
-
-	enum, err := enumRaw.ToIUnknown().IEnumVARIANT(ole.IID_IEnumVariant)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get CPU enumerator interface: %w", err)
-	}
-	defer enum.Release()
 
 	var totalCPU float64
 	var cpuCount int
-
-	for {
-		item, _, err := enum.Next(1)
-		if err != nil {
-			break
+	for _, processor := range processors {
+		if load, ok := wmiFloat(processor, "LoadPercentage"); ok {
+			totalCPU += load
+			cpuCount++
 		}
-		if item.VT == ole.VT_NULL {
-			break
-		}
-
-		processor := item.ToIDispatch()
-		defer processor.Release()
-		loadRaw, err := oleutil.GetProperty(processor, "LoadPercentage")
-		if err == nil && loadRaw.Value() != nil {
-			if load, ok := loadRaw.Value().(uint16); ok {
-				totalCPU += float64(load)
-				cpuCount++
-			}
-		}
-		processor.Release()
-		item.Clear()
 	}
 
 	var metrics []Metric
@@ -202,36 +233,36 @@ func (c *WMICollector) collectCPUMetrics(service *ole.IDispatch, timestamp time.
 	return metrics, nil
 }
 
-// collectMemoryMetrics collects memory utilization metrics
-func (c *WMICollector) collectMemoryMetrics(service *ole.IDispatch, timestamp time.Time) ([]Metric, error) {
-	// Get total physical memory
-	totalQuery := "SELECT TotalPhysicalMemory FROM Win32_ComputerSystem"
-	totalResult, err := c.executeWMIQuery(service, totalQuery)
+// collectMemoryMetrics collects memory utilization metrics in megabytes
+func (c *WMICollector) collectMemoryMetrics(ctx context.Context, session *winrmSession, timestamp time.Time) ([]Metric, error) {
+	systems, err := session.Query(ctx, "SELECT TotalPhysicalMemory FROM Win32_ComputerSystem")
 	if err != nil {
 		return nil, fmt.Errorf("failed to get total memory: %w", err)
 	}
-	defer totalResult.Release()
-
-	// Get available memory
-	availQuery := "SELECT AvailableBytes FROM Win32_PerfRawData_PerfOS_Memory"
-	availResult, err := c.executeWMIQuery(service, availQuery)
+	memory, err := session.Query(ctx, "SELECT AvailableBytes FROM Win32_PerfRawData_PerfOS_Memory")
 	if err != nil {
 		return nil, fmt.Errorf("failed to get available memory: %w", err)
 	}
-	defer availResult.Release()
+	if len(systems) == 0 || len(memory) == 0 {
+		return nil, fmt.Errorf("no memory information returned")
+	}
 
-	// Parse total memory (simplified implementation)
-	var totalMem, availMem float64
+	totalBytes, ok1 := wmiFloat(systems[0], "TotalPhysicalMemory")
+	availBytes, ok2 := wmiFloat(memory[0], "AvailableBytes")
+	if !ok1 || !ok2 || totalBytes == 0 {
+		return nil, fmt.Errorf("unexpected memory values %q and %q", systems[0]["TotalPhysicalMemory"], memory[0]["AvailableBytes"])
+	}
 
-	// This is a simplified implementation - in production, you'd properly enumerate the results
-	// For now, we'll return a basic memory metric
+	const bytesPerMB = 1024 * 1024
+	usedBytes := totalBytes - availBytes
 	metrics := []Metric{
 		{
 			Name: "memory_utilization",
 			Value: map[string]interface{}{
-				"memory_percent": 50.0, // Placeholder - would be calculated from actual WMI data
-				"memory_total":   totalMem,
-				"memory_used":    totalMem - availMem,
+				"memory_percent":   usedBytes / totalBytes * 100,
+				"memory_total":     totalBytes / bytesPerMB,
+				"memory_used":      usedBytes / bytesPerMB,
+				"memory_available": availBytes / bytesPerMB,
 			},
 			Timestamp: timestamp,
 			Tags: map[string]string{
@@ -244,80 +275,98 @@ func (c *WMICollector) collectMemoryMetrics(service *ole.IDispatch, timestamp ti
 	return metrics, nil
 }
 
-// collectDiskMetrics collects disk utilization metrics
-func (c *WMICollector) collectDiskMetrics(service *ole.IDispatch, timestamp time.Time) ([]Metric, error) {
-	query := "SELECT Size, FreeSpace, DeviceID FROM Win32_LogicalDisk WHERE DriveType = 3"
-	result, err := c.executeWMIQuery(service, query)
+// collectDiskMetrics collects the utilization of every local disk in bytes
+func (c *WMICollector) collectDiskMetrics(ctx context.Context, session *winrmSession, timestamp time.Time) ([]Metric, error) {
+	disks, err := session.Query(ctx, "SELECT Size, FreeSpace, DeviceID FROM Win32_LogicalDisk WHERE DriveType = 3")
 	if err != nil {
 		return nil, fmt.Errorf("failed to get disk metrics: %w", err)
 	}
-	defer result.Release()
 
-	// Simplified implementation - return placeholder metrics
-	metrics := []Metric{
-		{
+	var metrics []Metric
+	for _, disk := range disks {
+		size, ok1 := wmiFloat(disk, "Size")
+		free, ok2 := wmiFloat(disk, "FreeSpace")
+		if !ok1 || !ok2 || size == 0 {
+			continue
+		}
+		used := size - free
+
+		metrics = append(metrics, Metric{
 			Name: "disk_utilization",
 			Value: map[string]interface{}{
-				"disk_percent": 60.0, // Placeholder - would be calculated from actual WMI data
-				"disk_total":   "100GB",
-				"disk_used":    "60GB",
-				"disk_free":    "40GB",
+				"disk_percent": used / size * 100,
+				"disk_total":   size,
+				"disk_used":    used,
+				"disk_free":    free,
 			},
 			Timestamp: timestamp,
 			Tags: map[string]string{
 				"metric_type": "disk",
 				"source":      "wmi",
-				"drive":       "C:",
+				"drive":       disk["DeviceID"],
 			},
-		},
+		})
 	}
 
 	return metrics, nil
 }
 
-// collectNetworkMetrics collects network traffic metrics
-func (c *WMICollector) collectNetworkMetrics(service *ole.IDispatch, timestamp time.Time) ([]Metric, error) {
-	query := "SELECT BytesReceivedPerSec, BytesSentPerSec FROM Win32_PerfRawData_Tcpip_NetworkInterface WHERE Name != 'Loopback'"
-	result, err := c.executeWMIQuery(service, query)
+// collectNetworkMetrics collects the traffic counters of every network
+// interface
+func (c *WMICollector) collectNetworkMetrics(ctx context.Context, session *winrmSession, timestamp time.Time) ([]Metric, error) {
+	query := "SELECT Name, BytesReceivedPerSec, BytesSentPerSec FROM Win32_PerfRawData_Tcpip_NetworkInterface WHERE Name != 'Loopback'"
+	interfaces, err := session.Query(ctx, query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get network metrics: %w", err)
 	}
-	defer result.Release()
 
-	// Simplified implementation - return placeholder metrics
-	metrics := []Metric{
-		{
+	var metrics []Metric
+	for _, iface := range interfaces {
+		// The raw PerSec counters are cumulative byte counts
+		bytesIn, ok1 := wmiFloat(iface, "BytesReceivedPerSec")
+		bytesOut, ok2 := wmiFloat(iface, "BytesSentPerSec")
+		if !ok1 || !ok2 {
+			continue
+		}
+
+		metrics = append(metrics, Metric{
 			Name: "network_traffic",
 			Value: map[string]interface{}{
-				"bytes_in":  1000000.0, // Placeholder
-				"bytes_out": 500000.0,  // Placeholder
+				"bytes_in":  bytesIn,
+				"bytes_out": bytesOut,
 			},
 			Timestamp: timestamp,
 			Tags: map[string]string{
 				"metric_type": "network",
 				"source":      "wmi",
+				"interface":   iface["Name"],
 			},
-		},
+		})
 	}
 
 	return metrics, nil
 }
 
 // collectUptimeMetrics collects system uptime metrics
-func (c *WMICollector) collectUptimeMetrics(service *ole.IDispatch, timestamp time.Time) ([]Metric, error) {
-	query := "SELECT LastBootUpTime FROM Win32_OperatingSystem"
-	result, err := c.executeWMIQuery(service, query)
+func (c *WMICollector) collectUptimeMetrics(ctx context.Context, session *winrmSession, timestamp time.Time) ([]Metric, error) {
+	systems, err := session.Query(ctx, "SELECT LastBootUpTime FROM Win32_OperatingSystem")
 	if err != nil {
 		return nil, fmt.Errorf("failed to get uptime metrics: %w", err)
 	}
-	defer result.Release()
+	if len(systems) == 0 {
+		return nil, fmt.Errorf("no operating system information returned")
+	}
+
+	boot, err := parseWMIDatetime(systems[0]["LastBootUpTime"])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse last boot time: %w", err)
+	}
 
-	// Simplified implementation - return placeholder metrics
 	metrics := []Metric{
 		{
 			Name: "system_uptime",
 			Value: map[string]interface{}{
-				"uptime_seconds": 86400.0, // Placeholder - 1 day
+				"uptime_seconds": timestamp.Sub(boot).Seconds(),
 			},
 			Timestamp: timestamp,
 			Tags: map[string]string{
@@ -329,3 +378,24 @@ func (c *WMICollector) collectUptimeMetrics(service *ole.IDispatch, timestamp ti
 
 	return metrics, nil
 }
+
+// parseWMIDatetime parses a datetime as returned over WinRM, either
+// xs:dateTime or the DMTF form "yyyymmddHHMMSS.mmmmmm+UUU" with the UTC
+// offset in minutes
+func parseWMIDatetime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+
+	if len(value) != 25 || value[14] != '.' || (value[21] != '+' && value[21] != '-') {
+		return time.Time{}, fmt.Errorf("unexpected datetime %q", value)
+	}
+	offset, err := strconv.Atoi(value[22:])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unexpected datetime %q", value)
+	}
+	if value[21] == '-' {
+		offset = -offset
+	}
+	return time.ParseInLocation("20060102150405.000000", value[:21], time.FixedZone("", offset*60))
+}
@@ -0,0 +1,375 @@
+package metrics
+
+import (
+	"collector/internal/config"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// wmiChecks are the service, event log and counter checks of a device
+type wmiChecks struct {
+	services  []string
+	eventLogs []config.WMIEventLog
+	counters  []config.WMICounter
+}
+
+// empty reports whether there is nothing to check
+func (c wmiChecks) empty() bool {
+	return len(c.services) == 0 && len(c.eventLogs) == 0 && len(c.counters) == 0
+}
+
+// matchWMIChecks returns the checks of cfg whose selector matches a device
+func matchWMIChecks(cfg config.WMIConfig, deviceID, deviceType string, tags []string) wmiChecks {
+	var checks wmiChecks
+	seen := make(map[string]bool)
+	for _, check := range cfg.Services {
+		if !check.Matches(deviceID, deviceType, tags) {
+			continue
+		}
+		for _, name := range check.Names {
+			// Service names are case-insensitive
+			if key := strings.ToLower(name); !seen[key] {
+				seen[key] = true
+				checks.services = append(checks.services, name)
+			}
+		}
+	}
+	for _, eventLog := range cfg.EventLogs {
+		if eventLog.Matches(deviceID, deviceType, tags) {
+			checks.eventLogs = append(checks.eventLogs, eventLog)
+		}
+	}
+	for _, counter := range cfg.Counters {
+		if counter.Matches(deviceID, deviceType, tags) {
+			checks.counters = append(checks.counters, counter)
+		}
+	}
+	return checks
+}
+
+// wqlString quotes a value for a WQL condition
+func wqlString(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+// wqlAnyOf returns a condition matching property against any of values
+func wqlAnyOf(property string, values []string) string {
+	conditions := make([]string, len(values))
+	for i, value := range values {
+		conditions[i] = property + " = " + value
+	}
+	return "(" + strings.Join(conditions, " OR ") + ")"
+}
+
+// wmiDatetime formats t as a DMTF datetime in UTC for WQL comparisons
+func wmiDatetime(t time.Time) string {
+	return t.UTC().Format("20060102150405.000000") + "+000"
+}
+
+// snakeCase converts a WMI property name such as CurrentDiskQueueLength or
+// IOReadBytesPersec to current_disk_queue_length or io_read_bytes_persec
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// collectServiceMetrics reports the state and start mode of the named
+// services. A service that does not exist is reported with the state
+// "NotFound", so that its removal is noticed.
+func (c *WMICollector) collectServiceMetrics(ctx context.Context, session *winrmSession, names []string, timestamp time.Time) ([]Metric, error) {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = wqlString(name)
+	}
+	query := "SELECT Name, DisplayName, State, StartMode, Status FROM Win32_Service WHERE " + wqlAnyOf("Name", quoted)
+	services, err := session.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get services: %w", err)
+	}
+
+	metrics := make([]Metric, 0, len(names))
+	for _, name := range names {
+		value := map[string]interface{}{
+			"state":   "NotFound",
+			"running": 0.0,
+		}
+		for _, service := range services {
+			if !strings.EqualFold(service["Name"], name) {
+				continue
+			}
+			value["state"] = service["State"]
+			value["start_mode"] = service["StartMode"]
+			value["status"] = service["Status"]
+			value["display_name"] = service["DisplayName"]
+			if service["State"] == "Running" {
+				value["running"] = 1.0
+			}
+			break
+		}
+
+		metrics = append(metrics, Metric{
+			Name:      "windows_service",
+			Value:     value,
+			Timestamp: timestamp,
+			Tags: map[string]string{
+				"metric_type": "service",
+				"source":      "wmi",
+				"service":     name,
+			},
+		})
+	}
+
+	return metrics, nil
+}
+
+// eventLevelTypes maps Win32_NTLogEvent event types to levels
+var eventLevelTypes = map[string]string{
+	"1": config.EventLevelError,
+	"2": config.EventLevelWarning,
+	"3": config.EventLevelInformation,
+	"4": config.EventLevelAuditSuccess,
+	"5": config.EventLevelAuditFailure,
+}
+
+// eventMark is the host time an event log check was last counted up to,
+// and the local time it was last polled
+type eventMark struct {
+	hostTime time.Time
+	polled   time.Time
+}
+
+// eventMarkRetention is how long the mark of an event log check is kept
+// after its last poll. Marks outlive a few missed polls so that entries
+// written while a host was unreachable are still counted.
+const eventMarkRetention = sampleDefaultRetention
+
+// eventLogKey identifies an event log check of a host, whose entries are
+// counted from the end of the previous poll
+func eventLogKey(address string, eventLog config.WMIEventLog) string {
+	return strings.Join([]string{address, eventLog.Log, strings.Join(eventLog.Levels, ","), strings.Join(eventLog.Sources, ",")}, "\x00")
+}
+
+// collectEventLogMetrics counts the entries written to each event log since
+// the previous poll, by level and source. Polls are delimited by the clock
+// of the host, so clock skew cannot drop or repeat entries. The first poll
+// of a host only records where counting starts.
+func (c *WMICollector) collectEventLogMetrics(ctx context.Context, session *winrmSession, address string, eventLogs []config.WMIEventLog, timestamp time.Time) ([]Metric, error) {
+	systems, err := session.Query(ctx, "SELECT LocalDateTime FROM Win32_OperatingSystem")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get host time: %w", err)
+	}
+	if len(systems) == 0 {
+		return nil, fmt.Errorf("no operating system information returned")
+	}
+	now, err := parseWMIDatetime(systems[0]["LocalDateTime"])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse host time: %w", err)
+	}
+
+	var metrics []Metric
+	for _, eventLog := range eventLogs {
+		key := eventLogKey(address, eventLog)
+		c.eventMu.Lock()
+		mark, ok := c.eventMarks[key]
+		c.eventMu.Unlock()
+		since := mark.hostTime
+		// Start over when the host clock went backwards
+		if !ok || !now.After(since) {
+			c.eventMu.Lock()
+			c.eventMarks[key] = eventMark{hostTime: now, polled: timestamp}
+			c.eventMu.Unlock()
+			continue
+		}
+
+		conditions := []string{
+			"Logfile = " + wqlString(eventLog.Log),
+			"TimeWritten > " + wqlString(wmiDatetime(since)),
+			"TimeWritten <= " + wqlString(wmiDatetime(now)),
+		}
+		if len(eventLog.Levels) > 0 {
+			var types []string
+			for eventType, level := range eventLevelTypes {
+				if containsLevel(eventLog.Levels, level) {
+					types = append(types, eventType)
+				}
+			}
+			sort.Strings(types)
+			conditions = append(conditions, wqlAnyOf("EventType", types))
+		}
+		if len(eventLog.Sources) > 0 {
+			quoted := make([]string, len(eventLog.Sources))
+			for i, source := range eventLog.Sources {
+				quoted[i] = wqlString(source)
+			}
+			conditions = append(conditions, wqlAnyOf("SourceName", quoted))
+		}
+
+		query := "SELECT EventType, SourceName FROM Win32_NTLogEvent WHERE " + strings.Join(conditions, " AND ")
+		entries, err := session.Query(ctx, query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s event log entries: %w", eventLog.Log, err)
+		}
+		c.eventMu.Lock()
+		c.eventMarks[key] = eventMark{hostTime: now, polled: timestamp}
+		c.eventMu.Unlock()
+
+		metrics = append(metrics, eventLogMetrics(eventLog, entries, timestamp)...)
+	}
+
+	return metrics, nil
+}
+
+// pruneEventMarks removes the marks of event log checks that were not
+// polled for eventMarkRetention, such as checks whose selectors or levels
+// changed and retired hosts. It does the work at most once per
+// samplePruneInterval.
+func (c *WMICollector) pruneEventMarks(now time.Time) {
+	c.eventMu.Lock()
+	defer c.eventMu.Unlock()
+
+	if now.Sub(c.marksPruned) < samplePruneInterval {
+		return
+	}
+	c.marksPruned = now
+
+	for key, mark := range c.eventMarks {
+		if now.Sub(mark.polled) > eventMarkRetention {
+			delete(c.eventMarks, key)
+		}
+	}
+}
+
+// containsLevel reports whether levels contains level
+func containsLevel(levels []string, level string) bool {
+	for _, l := range levels {
+		if l == level {
+			return true
+		}
+	}
+	return false
+}
+
+// eventLogMetrics counts entries by level, for the whole log and for each
+// source. The whole log is always reported, so quiet periods show as zero.
+func eventLogMetrics(eventLog config.WMIEventLog, entries []wmiObject, timestamp time.Time) []Metric {
+	newCounts := func() map[string]interface{} {
+		counts := map[string]interface{}{"total": 0.0}
+		for _, level := range eventLevelTypes {
+			if len(eventLog.Levels) == 0 || containsLevel(eventLog.Levels, level) {
+				counts[level] = 0.0
+			}
+		}
+		return counts
+	}
+
+	total := newCounts()
+	bySource := make(map[string]map[string]interface{})
+	for _, entry := range entries {
+		level, ok := eventLevelTypes[entry["EventType"]]
+		if !ok {
+			continue
+		}
+		source := entry["SourceName"]
+		if bySource[source] == nil {
+			bySource[source] = newCounts()
+		}
+		for _, counts := range []map[string]interface{}{total, bySource[source]} {
+			counts[level] = counts[level].(float64) + 1
+			counts["total"] = counts["total"].(float64) + 1
+		}
+	}
+
+	metrics := []Metric{
+		{
+			Name:      "eventlog_entries",
+			Value:     total,
+			Timestamp: timestamp,
+			Tags: map[string]string{
+				"metric_type": "eventlog",
+				"source":      "wmi",
+				"log":         eventLog.Log,
+			},
+		},
+	}
+	for source, counts := range bySource {
+		metrics = append(metrics, Metric{
+			Name:      "eventlog_entries",
+			Value:     counts,
+			Timestamp: timestamp,
+			Tags: map[string]string{
+				"metric_type":  "eventlog",
+				"source":       "wmi",
+				"log":          eventLog.Log,
+				"event_source": source,
+			},
+		})
+	}
+	return metrics
+}
+
+// collectCounterMetrics collects a performance counter class, with one
+// metric per instance
+func (c *WMICollector) collectCounterMetrics(ctx context.Context, session *winrmSession, counter config.WMICounter, timestamp time.Time) ([]Metric, error) {
+	properties := append(append([]string(nil), counter.Fields...), counter.Tags...)
+	query := "SELECT " + strings.Join(properties, ", ") + " FROM " + counter.Class
+	if counter.Where != "" {
+		query += " WHERE " + counter.Where
+	}
+	instances, err := session.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s counters: %w", counter.Class, err)
+	}
+
+	measurement := counter.Measurement
+	if measurement == "" {
+		measurement = strings.ToLower(strings.TrimPrefix(counter.Class, config.WMICounterPrefix))
+	}
+
+	var metrics []Metric
+	for _, instance := range instances {
+		value := make(map[string]interface{}, len(counter.Fields))
+		for _, field := range counter.Fields {
+			if v, ok := wmiFloat(instance, field); ok {
+				value[snakeCase(field)] = v
+			}
+		}
+		if len(value) == 0 {
+			continue
+		}
+
+		tags := map[string]string{
+			"metric_type": "counter",
+			"source":      "wmi",
+			"class":       counter.Class,
+		}
+		for _, tag := range counter.Tags {
+			if v, ok := instance[tag]; ok {
+				tags[snakeCase(tag)] = v
+			}
+		}
+
+		metrics = append(metrics, Metric{
+			Name:      measurement,
+			Value:     value,
+			Timestamp: timestamp,
+			Tags:      tags,
+		})
+	}
+
+	return metrics, nil
+}
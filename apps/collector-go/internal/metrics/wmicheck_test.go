@@ -0,0 +1,197 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"collector/internal/config"
+)
+
+func TestWMICollector_Checks(t *testing.T) {
+	fake, cfg := newFakeWinRM(t, config.WinRMAuthBasic)
+	windows := config.DeviceSelector{DeviceTypes: []string{"windows"}}
+	cfg.Services = []config.WMIServiceCheck{
+		{DeviceSelector: windows, Names: []string{"W3SVC", "Spooler", "MSSQL$SQLEXPRESS"}},
+	}
+	cfg.EventLogs = []config.WMIEventLog{
+		{DeviceSelector: windows, Log: "System", Levels: []string{config.EventLevelError, config.EventLevelWarning}},
+	}
+	cfg.Counters = []config.WMICounter{
+		{
+			DeviceSelector: windows,
+			Class:          "Win32_PerfFormattedData_PerfDisk_PhysicalDisk",
+			Fields:         []string{"CurrentDiskQueueLength", "AvgDisksecPerRead"},
+			Tags:           []string{"Name"},
+			Where:          "Name != '_Total'",
+		},
+	}
+
+	fake.setClass("Win32_Service",
+		map[string]string{"Name": "W3SVC", "DisplayName": "World Wide Web Publishing Service", "State": "Running", "StartMode": "Auto", "Status": "OK"},
+		map[string]string{"Name": "Spooler", "DisplayName": "Print Spooler", "State": "Stopped", "StartMode": "Auto", "Status": "OK"},
+	)
+	fake.setClass("Win32_PerfFormattedData_PerfDisk_PhysicalDisk",
+		map[string]string{"Name": "0 C:", "CurrentDiskQueueLength": "3", "AvgDisksecPerRead": "0.004"},
+		map[string]string{"Name": "1 D:", "CurrentDiskQueueLength": "0", "AvgDisksecPerRead": ""},
+	)
+	fake.setClass("Win32_NTLogEvent",
+		map[string]string{"EventType": "1", "SourceName": "Service Control Manager"},
+		map[string]string{"EventType": "1", "SourceName": "Service Control Manager"},
+		map[string]string{"EventType": "2", "SourceName": "Disk"},
+	)
+	boot := time.Date(2024, 4, 10, 6, 0, 0, 0, time.UTC)
+	setHostTime := func(now time.Time) {
+		fake.setClass("Win32_OperatingSystem", map[string]string{
+			"LastBootUpTime": boot.Format(time.RFC3339),
+			"LocalDateTime":  now.Format(time.RFC3339),
+		})
+	}
+	setHostTime(time.Date(2024, 4, 10, 8, 0, 0, 0, time.FixedZone("", -7*3600)))
+
+	c, err := NewWMICollector(cfg)
+	if err != nil {
+		t.Fatalf("NewWMICollector() error = %v", err)
+	}
+	if got := c.ForDevice("dc1", "linux", "", nil); got != c {
+		t.Fatalf("Expected the base collector without matching checks, got %T", got)
+	}
+	collector := c.ForDevice("web1", "windows", "", nil)
+	if _, ok := collector.(credentialScopedTest); !ok {
+		t.Fatalf("Expected a collector accepting credentials, got %T", collector)
+	}
+
+	// The first poll only marks where event log counting starts
+	result, err := collector.Collect(context.Background(), "127.0.0.1")
+	if err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	byName := make(map[string][]Metric)
+	for _, m := range result {
+		byName[m.Name] = append(byName[m.Name], m)
+	}
+	if len(byName["eventlog_entries"]) != 0 {
+		t.Errorf("Expected no event log metrics on the first poll, got %+v", byName["eventlog_entries"])
+	}
+
+	services := make(map[string]map[string]interface{})
+	for _, m := range byName["windows_service"] {
+		services[m.Tags["service"]] = m.Value
+	}
+	if len(services) != 3 {
+		t.Fatalf("Expected 3 services, got %v", services)
+	}
+	if services["W3SVC"]["state"] != "Running" || !approx(services["W3SVC"]["running"], 1) || services["W3SVC"]["start_mode"] != "Auto" {
+		t.Errorf("Unexpected W3SVC metric %v", services["W3SVC"])
+	}
+	if services["Spooler"]["state"] != "Stopped" || !approx(services["Spooler"]["running"], 0) {
+		t.Errorf("Unexpected Spooler metric %v", services["Spooler"])
+	}
+	if services["MSSQL$SQLEXPRESS"]["state"] != "NotFound" {
+		t.Errorf("Expected a missing service, got %v", services["MSSQL$SQLEXPRESS"])
+	}
+
+	disks := byName["perfdisk_physicaldisk"]
+	if len(disks) != 2 {
+		t.Fatalf("Expected 2 disk counter metrics, got %+v", disks)
+	}
+	if disks[0].Tags["name"] != "0 C:" || !approx(disks[0].Value["current_disk_queue_length"], 3) || !approx(disks[0].Value["avg_disksec_per_read"], 0.004) {
+		t.Errorf("Unexpected disk counter metric %+v", disks[0])
+	}
+	if _, ok := disks[1].Value["avg_disksec_per_read"]; ok {
+		t.Errorf("Expected null counters to be skipped, got %+v", disks[1])
+	}
+
+	// The second poll counts the entries written in between
+	setHostTime(time.Date(2024, 4, 10, 8, 5, 0, 0, time.FixedZone("", -7*3600)))
+	result, err = collector.Collect(context.Background(), "127.0.0.1")
+	if err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+
+	var total map[string]interface{}
+	bySource := make(map[string]map[string]interface{})
+	for _, m := range result {
+		if m.Name != "eventlog_entries" || m.Tags["log"] != "System" {
+			continue
+		}
+		if source, ok := m.Tags["event_source"]; ok {
+			bySource[source] = m.Value
+		} else {
+			total = m.Value
+		}
+	}
+	if !approx(total["total"], 3) || !approx(total["error"], 2) || !approx(total["warning"], 1) {
+		t.Errorf("Unexpected event log totals %v", total)
+	}
+	if _, ok := total["information"]; ok {
+		t.Errorf("Expected only the configured levels, got %v", total)
+	}
+	if !approx(bySource["Service Control Manager"]["error"], 2) || !approx(bySource["Disk"]["warning"], 1) {
+		t.Errorf("Unexpected event log counts by source %v", bySource)
+	}
+
+	var eventQuery string
+	fake.mu.Lock()
+	for _, query := range fake.queries {
+		if strings.Contains(query, "Win32_NTLogEvent") {
+			eventQuery = query
+		}
+	}
+	fake.mu.Unlock()
+	want := "Logfile = 'System' AND TimeWritten > '20240410150000.000000+000' AND TimeWritten <= '20240410150500.000000+000' AND (EventType = 1 OR EventType = 2)"
+	if !strings.Contains(eventQuery, want) {
+		t.Errorf("Unexpected event log query %q", eventQuery)
+	}
+}
+
+// credentialScopedTest is implemented by collectors that take credential
+// profiles
+type credentialScopedTest interface {
+	WithCredentials(profile config.CredentialProfile) MetricCollector
+}
+
+func TestWQLString(t *testing.T) {
+	if got := wqlString(`O'Brien\svc`); got != `'O\'Brien\\svc'` {
+		t.Errorf("wqlString() = %s", got)
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	tests := map[string]string{
+		"CurrentDiskQueueLength":       "current_disk_queue_length",
+		"IOReadBytesPersec":            "io_read_bytes_persec",
+		"Name":                         "name",
+		"PercentProcessorTime":         "percent_processor_time",
+		"CurrentConnections":           "current_connections",
+		"ISAPIExtensionRequestsPersec": "isapi_extension_requests_persec",
+		"Frames_Per_Sec2":              "frames_per_sec2",
+	}
+	for name, want := range tests {
+		if got := snakeCase(name); got != want {
+			t.Errorf("snakeCase(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestWMICollector_PruneEventMarks(t *testing.T) {
+	c, err := NewWMICollector(config.WMIConfig{})
+	if err != nil {
+		t.Fatalf("NewWMICollector() error = %v", err)
+	}
+
+	now := time.Now()
+	system := eventLogKey("192.0.2.1:5985", config.WMIEventLog{Log: "System", Levels: []string{config.EventLevelError}})
+	retired := eventLogKey("192.0.2.2:5985", config.WMIEventLog{Log: "System"})
+	c.eventMarks[system] = eventMark{hostTime: now, polled: now.Add(-time.Hour)}
+	c.eventMarks[retired] = eventMark{hostTime: now, polled: now.Add(-eventMarkRetention - time.Minute)}
+
+	c.pruneEventMarks(now)
+	if _, ok := c.eventMarks[system]; !ok {
+		t.Error("Expected the mark of a recently polled check to be kept")
+	}
+	if _, ok := c.eventMarks[retired]; ok {
+		t.Error("Expected the mark of a check no longer polled to be removed")
+	}
+}
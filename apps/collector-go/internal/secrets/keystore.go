@@ -0,0 +1,229 @@
+package secrets
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"collector/internal/config"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// keystoreVersion is the version of the keystore file format
+const keystoreVersion = 1
+
+// scrypt parameters used to derive the keystore key from its passphrase
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	keystoreSalt = 16
+)
+
+// keystoreFile is the on-disk format of a keystore: a JSON object of secret
+// names to values, sealed with AES-256-GCM under a key derived with scrypt
+type keystoreFile struct {
+	Version    int    `json:"version"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// SealKeystore encrypts secrets with passphrase
+func SealKeystore(secrets map[string]string, passphrase string) ([]byte, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("keystore passphrase is required")
+	}
+
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	file := keystoreFile{Version: keystoreVersion, Salt: make([]byte, keystoreSalt)}
+	if _, err := rand.Read(file.Salt); err != nil {
+		return nil, err
+	}
+
+	aead, err := keystoreCipher(passphrase, file.Salt)
+	if err != nil {
+		return nil, err
+	}
+	file.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(file.Nonce); err != nil {
+		return nil, err
+	}
+	file.Ciphertext = aead.Seal(nil, file.Nonce, plaintext, nil)
+
+	return json.MarshalIndent(file, "", "  ")
+}
+
+// OpenKeystore decrypts a keystore sealed by SealKeystore
+func OpenKeystore(data []byte, passphrase string) (map[string]string, error) {
+	var file keystoreFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid keystore file: %w", err)
+	}
+	if file.Version != keystoreVersion {
+		return nil, fmt.Errorf("unsupported keystore version %d", file.Version)
+	}
+
+	aead, err := keystoreCipher(passphrase, file.Salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(file.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid keystore nonce")
+	}
+
+	plaintext, err := aead.Open(nil, file.Nonce, file.Ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt keystore, wrong passphrase?")
+	}
+
+	secrets := make(map[string]string)
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, fmt.Errorf("invalid keystore contents: %w", err)
+	}
+	return secrets, nil
+}
+
+// keystoreCipher derives the keystore key and returns its AEAD
+func keystoreCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive keystore key: %w", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// LoadKeystore reads and decrypts a keystore file
+func LoadKeystore(path, passphrase string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return OpenKeystore(data, passphrase)
+}
+
+// SaveKeystore encrypts secrets and atomically replaces the keystore file,
+// readable by its owner only
+func SaveKeystore(path, passphrase string, secrets map[string]string) error {
+	data, err := SealKeystore(secrets, passphrase)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".keystore-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// KeystorePassphrase returns the keystore passphrase from the configured
+// file or environment variable
+func KeystorePassphrase(cfg config.KeystoreConfig) (string, error) {
+	if cfg.PassphraseFile != "" {
+		data, err := os.ReadFile(cfg.PassphraseFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read keystore passphrase: %w", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	passphrase := os.Getenv(cfg.PassphraseEnv)
+	if passphrase == "" {
+		return "", fmt.Errorf("keystore passphrase not set in %s", cfg.PassphraseEnv)
+	}
+	return passphrase, nil
+}
+
+// KeystoreProvider resolves "keystore:<name>" references from an encrypted
+// keystore file. The file is decrypted again whenever it changes on disk.
+type KeystoreProvider struct {
+	path       string
+	passphrase string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	secrets map[string]string
+}
+
+// NewKeystoreProvider creates a keystore provider and checks that the
+// keystore can be decrypted
+func NewKeystoreProvider(cfg config.KeystoreConfig) (*KeystoreProvider, error) {
+	passphrase, err := KeystorePassphrase(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &KeystoreProvider{path: cfg.Path, passphrase: passphrase}
+	if _, err := p.load(); err != nil {
+		return nil, fmt.Errorf("failed to open keystore %s: %w", cfg.Path, err)
+	}
+	return p, nil
+}
+
+// load returns the keystore contents, decrypting the file again if it
+// changed since it was last read
+func (p *KeystoreProvider) load() (map[string]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return nil, err
+	}
+	if p.secrets != nil && info.ModTime().Equal(p.modTime) && info.Size() == p.size {
+		return p.secrets, nil
+	}
+
+	secrets, err := LoadKeystore(p.path, p.passphrase)
+	if err != nil {
+		return nil, err
+	}
+	p.secrets, p.modTime, p.size = secrets, info.ModTime(), info.Size()
+	return secrets, nil
+}
+
+// Resolve implements SecretProvider
+func (p *KeystoreProvider) Resolve(ctx context.Context, ref string) (string, error) {
+	secrets, err := p.load()
+	if err != nil {
+		return "", err
+	}
+
+	secret, ok := secrets[ref]
+	if !ok {
+		return "", fmt.Errorf("keystore has no secret %q", ref)
+	}
+	return secret, nil
+}
@@ -0,0 +1,85 @@
+package secrets
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"collector/internal/config"
+)
+
+func TestKeystore_SealOpen(t *testing.T) {
+	secrets := map[string]string{"snmp": "s3cret", "ssh": "hunter2"}
+
+	data, err := SealKeystore(secrets, "passphrase")
+	if err != nil {
+		t.Fatalf("SealKeystore() error = %v", err)
+	}
+
+	opened, err := OpenKeystore(data, "passphrase")
+	if err != nil {
+		t.Fatalf("OpenKeystore() error = %v", err)
+	}
+	if len(opened) != 2 || opened["snmp"] != "s3cret" || opened["ssh"] != "hunter2" {
+		t.Errorf("OpenKeystore() = %v, want %v", opened, secrets)
+	}
+
+	if _, err := OpenKeystore(data, "wrong"); err == nil {
+		t.Error("Expected an error for a wrong passphrase")
+	}
+	if _, err := SealKeystore(secrets, ""); err == nil {
+		t.Error("Expected an error for an empty passphrase")
+	}
+}
+
+func TestKeystoreProvider_ReloadsOnChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keystore.json")
+	t.Setenv("TEST_KEYSTORE_PASSPHRASE", "passphrase")
+	cfg := config.KeystoreConfig{Path: path, PassphraseEnv: "TEST_KEYSTORE_PASSPHRASE"}
+
+	if err := SaveKeystore(path, "passphrase", map[string]string{"snmp": "v1"}); err != nil {
+		t.Fatalf("SaveKeystore() error = %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected a keystore readable by its owner only, got %v, %v", info.Mode(), err)
+	}
+
+	p, err := NewKeystoreProvider(cfg)
+	if err != nil {
+		t.Fatalf("NewKeystoreProvider() error = %v", err)
+	}
+	if got, err := p.Resolve(context.Background(), "snmp"); err != nil || got != "v1" {
+		t.Fatalf("Resolve() = %q, %v; want v1", got, err)
+	}
+	if _, err := p.Resolve(context.Background(), "missing"); err == nil {
+		t.Error("Expected an error for an unknown secret")
+	}
+
+	if err := SaveKeystore(path, "passphrase", map[string]string{"snmp": "v2-rotated"}); err != nil {
+		t.Fatalf("SaveKeystore() error = %v", err)
+	}
+	// Make sure the modification time differs on coarse filesystems
+	later := time.Now().Add(time.Second)
+	os.Chtimes(path, later, later)
+
+	if got, err := p.Resolve(context.Background(), "snmp"); err != nil || got != "v2-rotated" {
+		t.Errorf("Resolve() = %q, %v; want the rotated value", got, err)
+	}
+}
+
+func TestNewKeystoreProvider_Errors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keystore.json")
+	if err := SaveKeystore(path, "passphrase", map[string]string{}); err != nil {
+		t.Fatalf("SaveKeystore() error = %v", err)
+	}
+
+	t.Setenv("TEST_KEYSTORE_PASSPHRASE", "wrong")
+	if _, err := NewKeystoreProvider(config.KeystoreConfig{Path: path, PassphraseEnv: "TEST_KEYSTORE_PASSPHRASE"}); err == nil {
+		t.Error("Expected an error for a wrong passphrase")
+	}
+	if _, err := NewKeystoreProvider(config.KeystoreConfig{Path: path, PassphraseEnv: "TEST_KEYSTORE_UNSET"}); err == nil {
+		t.Error("Expected an error for a missing passphrase")
+	}
+}
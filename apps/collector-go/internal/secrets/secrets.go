@@ -0,0 +1,163 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"collector/internal/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SecretProvider resolves secret references of one scheme. ref is the part
+// of the reference after "<scheme>:".
+type SecretProvider interface {
+	Resolve(ctx context.Context, ref string) (string, error)
+}
+
+// Resolver turns configuration values into secrets. Values of the form
+// "<scheme>:<ref>" whose scheme has a registered provider are resolved by
+// that provider; any other value is returned as is, so plain credentials
+// keep working. Resolved secrets are cached and re-read after the refresh
+// interval, which is how rotated secrets are picked up.
+type Resolver struct {
+	refresh time.Duration
+
+	mu        sync.Mutex
+	providers map[string]SecretProvider
+	cache     map[string]cachedSecret
+}
+
+// cachedSecret is a resolved secret and when it was read
+type cachedSecret struct {
+	value  string
+	readAt time.Time
+}
+
+// NewResolver creates a resolver with the file, env and, when configured,
+// keystore and Vault providers registered
+func NewResolver(cfg config.SecretsConfig) (*Resolver, error) {
+	r := &Resolver{
+		refresh:   cfg.RefreshInterval,
+		providers: make(map[string]SecretProvider),
+		cache:     make(map[string]cachedSecret),
+	}
+
+	r.Register("file", FileProvider{})
+	r.Register("env", EnvProvider{})
+
+	if cfg.Keystore.Path != "" {
+		keystore, err := NewKeystoreProvider(cfg.Keystore)
+		if err != nil {
+			return nil, err
+		}
+		r.Register("keystore", keystore)
+	}
+
+	if cfg.Vault.Address != "" || os.Getenv("VAULT_ADDR") != "" {
+		vault, err := NewVaultProvider(cfg.Vault)
+		if err != nil {
+			return nil, err
+		}
+		r.Register("vault", vault)
+	}
+
+	return r, nil
+}
+
+// Register adds or replaces the provider of a scheme
+func (r *Resolver) Register(scheme string, provider SecretProvider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.providers[scheme] = provider
+}
+
+// provider returns the provider and reference of value, or nil if value is
+// not a reference
+func (r *Resolver) provider(value string) (SecretProvider, string) {
+	scheme, ref, ok := strings.Cut(value, ":")
+	if !ok {
+		return nil, ""
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.providers[scheme], ref
+}
+
+// Resolve returns the secret value refers to, or value itself if it is not
+// a reference. A nil Resolver returns every value as is. When re-reading a
+// cached secret fails, the previous value is kept so that a provider outage
+// does not break collection.
+func (r *Resolver) Resolve(ctx context.Context, value string) (string, error) {
+	if r == nil || value == "" {
+		return value, nil
+	}
+
+	provider, ref := r.provider(value)
+	if provider == nil {
+		return value, nil
+	}
+
+	now := time.Now()
+	r.mu.Lock()
+	cached, ok := r.cache[value]
+	r.mu.Unlock()
+	if ok && now.Sub(cached.readAt) < r.refresh {
+		return cached.value, nil
+	}
+
+	secret, err := provider.Resolve(ctx, ref)
+	if err != nil {
+		if ok {
+			logrus.WithError(err).WithField("secret", value).Warn("Failed to refresh secret, using previous value")
+			return cached.value, nil
+		}
+		return "", fmt.Errorf("failed to resolve secret %s: %w", value, err)
+	}
+
+	r.mu.Lock()
+	r.cache[value] = cachedSecret{value: secret, readAt: now}
+	r.mu.Unlock()
+
+	return secret, nil
+}
+
+// FileProvider reads secrets from files, as mounted by Docker and
+// Kubernetes, e.g. "file:///run/secrets/snmp_community". A single trailing
+// newline is removed.
+type FileProvider struct{}
+
+// Resolve implements SecretProvider
+func (FileProvider) Resolve(ctx context.Context, ref string) (string, error) {
+	path := strings.TrimPrefix(ref, "//")
+	if path == "" {
+		return "", fmt.Errorf("empty file path")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	secret := strings.TrimSuffix(string(data), "\n")
+	return strings.TrimSuffix(secret, "\r"), nil
+}
+
+// EnvProvider reads secrets from environment variables, e.g. "env:SNMP_COMMUNITY"
+type EnvProvider struct{}
+
+// Resolve implements SecretProvider
+func (EnvProvider) Resolve(ctx context.Context, ref string) (string, error) {
+	secret, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", ref)
+	}
+	return secret, nil
+}
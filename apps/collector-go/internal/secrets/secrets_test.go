@@ -0,0 +1,109 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"collector/internal/config"
+)
+
+// stubProvider returns a fixed value or error and counts calls
+type stubProvider struct {
+	value string
+	err   error
+	calls int
+}
+
+func (s *stubProvider) Resolve(ctx context.Context, ref string) (string, error) {
+	s.calls++
+	return s.value, s.err
+}
+
+func TestResolver_Resolve(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "community")
+	if err := os.WriteFile(secretFile, []byte("from-file\n"), 0600); err != nil {
+		t.Fatalf("Failed to write secret file: %v", err)
+	}
+	t.Setenv("COLLECTOR_TEST_SECRET", "from-env")
+
+	r, err := NewResolver(config.SecretsConfig{RefreshInterval: time.Minute})
+	if err != nil {
+		t.Fatalf("NewResolver() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{name: "plain value", value: "public", want: "public"},
+		{name: "empty value", value: "", want: ""},
+		{name: "unknown scheme is a plain value", value: "pass:word", want: "pass:word"},
+		{name: "file", value: "file://" + secretFile, want: "from-file"},
+		{name: "missing file", value: "file://" + filepath.Join(dir, "missing"), wantErr: true},
+		{name: "env", value: "env:COLLECTOR_TEST_SECRET", want: "from-env"},
+		{name: "unset env", value: "env:COLLECTOR_TEST_UNSET", wantErr: true},
+		{name: "keystore without keystore configured", value: "keystore:snmp", want: "keystore:snmp"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.Resolve(context.Background(), tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Resolve() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolver_NilReturnsValue(t *testing.T) {
+	var r *Resolver
+	if got, err := r.Resolve(context.Background(), "env:ANYTHING"); err != nil || got != "env:ANYTHING" {
+		t.Errorf("Resolve() = %q, %v; want the value unchanged", got, err)
+	}
+}
+
+func TestResolver_Refresh(t *testing.T) {
+	r, err := NewResolver(config.SecretsConfig{RefreshInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("NewResolver() error = %v", err)
+	}
+	provider := &stubProvider{value: "v1"}
+	r.Register("stub", provider)
+
+	if got, _ := r.Resolve(context.Background(), "stub:x"); got != "v1" {
+		t.Fatalf("Resolve() = %q, want v1", got)
+	}
+
+	// Cached until the refresh interval passes
+	provider.value = "v2"
+	if got, _ := r.Resolve(context.Background(), "stub:x"); got != "v1" || provider.calls != 1 {
+		t.Errorf("Expected the cached value, got %q after %d calls", got, provider.calls)
+	}
+
+	// A rotated secret is read once the cache entry is stale
+	r.refresh = 0
+	if got, _ := r.Resolve(context.Background(), "stub:x"); got != "v2" {
+		t.Errorf("Resolve() = %q, want the rotated value v2", got)
+	}
+
+	// Refresh failures keep the previous value
+	provider.err = errors.New("backend down")
+	if got, err := r.Resolve(context.Background(), "stub:x"); err != nil || got != "v2" {
+		t.Errorf("Resolve() = %q, %v; want the previous value", got, err)
+	}
+
+	// Without a previous value the failure is returned
+	if _, err := r.Resolve(context.Background(), "stub:y"); err == nil {
+		t.Error("Expected an error for a secret that was never resolved")
+	}
+}
@@ -0,0 +1,110 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"collector/internal/config"
+)
+
+// defaultVaultField is read when a reference names no field
+const defaultVaultField = "value"
+
+// VaultProvider resolves "vault:<path>#<field>" references from the KV
+// version 2 secrets engine of a Vault-compatible server. The field defaults
+// to "value".
+type VaultProvider struct {
+	address string
+	token   string
+	mount   string
+	client  *http.Client
+}
+
+// NewVaultProvider creates a Vault provider. The address and token fall
+// back to the VAULT_ADDR and VAULT_TOKEN environment variables.
+func NewVaultProvider(cfg config.VaultConfig) (*VaultProvider, error) {
+	address := cfg.Address
+	if address == "" {
+		address = os.Getenv("VAULT_ADDR")
+	}
+	token := cfg.Token
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	if address == "" || token == "" {
+		return nil, fmt.Errorf("vault address and token are required")
+	}
+	if _, err := url.Parse(address); err != nil {
+		return nil, fmt.Errorf("invalid vault address: %w", err)
+	}
+
+	mount := cfg.Mount
+	if mount == "" {
+		mount = "secret"
+	}
+
+	return &VaultProvider{
+		address: strings.TrimRight(address, "/"),
+		token:   token,
+		mount:   strings.Trim(mount, "/"),
+		client:  &http.Client{Timeout: cfg.Timeout},
+	}, nil
+}
+
+// vaultKVResponse is the body of a KV version 2 read
+type vaultKVResponse struct {
+	Data struct {
+		Data map[string]interface{} `json:"data"`
+	} `json:"data"`
+}
+
+// Resolve implements SecretProvider
+func (p *VaultProvider) Resolve(ctx context.Context, ref string) (string, error) {
+	path, field, ok := strings.Cut(ref, "#")
+	if !ok || field == "" {
+		field = defaultVaultField
+	}
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return "", fmt.Errorf("empty vault path")
+	}
+
+	endpoint := fmt.Sprintf("%s/v1/%s/data/%s", p.address, p.mount, path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", p.token)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("vault request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return "", fmt.Errorf("vault returned status %d for %s", resp.StatusCode, path)
+	}
+
+	var body vaultKVResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("invalid vault response: %w", err)
+	}
+
+	value, ok := body.Data.Data[field]
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no field %q", path, field)
+	}
+	secret, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("vault secret %s field %q is not a string", path, field)
+	}
+	return secret, nil
+}
@@ -0,0 +1,65 @@
+package secrets
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"collector/internal/config"
+)
+
+func TestVaultProvider_Resolve(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "test-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/kv/data/network/snmp":
+			w.Write([]byte(`{"data":{"data":{"value":"s3cret","community":"site-a","port":161}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	p, err := NewVaultProvider(config.VaultConfig{Address: server.URL + "/", Token: "test-token", Mount: "kv", Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("NewVaultProvider() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		ref     string
+		want    string
+		wantErr bool
+	}{
+		{name: "default field", ref: "network/snmp", want: "s3cret"},
+		{name: "named field", ref: "network/snmp#community", want: "site-a"},
+		{name: "missing field", ref: "network/snmp#password", wantErr: true},
+		{name: "non-string field", ref: "network/snmp#port", wantErr: true},
+		{name: "missing secret", ref: "network/ssh", wantErr: true},
+		{name: "empty path", ref: "#value", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.Resolve(context.Background(), tt.ref)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Resolve() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewVaultProvider_RequiresToken(t *testing.T) {
+	t.Setenv("VAULT_TOKEN", "")
+	if _, err := NewVaultProvider(config.VaultConfig{Address: "http://127.0.0.1:8200"}); err == nil {
+		t.Error("Expected an error without a token")
+	}
+}